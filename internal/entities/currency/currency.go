@@ -0,0 +1,52 @@
+// Package currency lists the currencies supported by payments, shared by the
+// collection schema and the hub's payment handling.
+package currency
+
+// Currency describes how amounts in a currency are stored and displayed
+type Currency struct {
+	// ISO 4217 code
+	Code string
+	// number of decimals in the minor unit
+	Decimals int
+	// symbol written next to formatted amounts
+	Symbol string
+	// locale whose number format is used when none is requested
+	Locale string
+}
+
+// All holds the supported currencies. Adding one here makes it available
+// everywhere; existing databases pick it up with a migration that calls
+// the migrations package's syncCurrencyFields.
+var All = []Currency{
+	{Code: "RUB", Decimals: 2, Symbol: "₽", Locale: "ru"},
+	{Code: "USD", Decimals: 2, Symbol: "$", Locale: "en"},
+	{Code: "EUR", Decimals: 2, Symbol: "€", Locale: "de"},
+	{Code: "GBP", Decimals: 2, Symbol: "£", Locale: "en"},
+	{Code: "JPY", Decimals: 0, Symbol: "¥", Locale: "en"},
+	{Code: "CNY", Decimals: 2, Symbol: "CN¥", Locale: "en"},
+	{Code: "CHF", Decimals: 2, Symbol: "CHF", Locale: "de"},
+	{Code: "CAD", Decimals: 2, Symbol: "CA$", Locale: "en"},
+	{Code: "AUD", Decimals: 2, Symbol: "A$", Locale: "en"},
+	{Code: "PLN", Decimals: 2, Symbol: "zł", Locale: "fr"},
+	{Code: "TRY", Decimals: 2, Symbol: "₺", Locale: "de"},
+	{Code: "KZT", Decimals: 2, Symbol: "₸", Locale: "ru"},
+}
+
+// Codes returns the codes of all supported currencies, in the order of All
+func Codes() []string {
+	codes := make([]string, len(All))
+	for i, c := range All {
+		codes[i] = c.Code
+	}
+	return codes
+}
+
+// Find returns the currency with the given code
+func Find(code string) (Currency, bool) {
+	for _, c := range All {
+		if c.Code == code {
+			return c, true
+		}
+	}
+	return Currency{}, false
+}
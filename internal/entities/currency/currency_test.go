@@ -0,0 +1,33 @@
+//go:build testing
+
+package currency_test
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/henrygd/beszel/internal/entities/currency"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCurrencies(t *testing.T) {
+	code := regexp.MustCompile(`^[A-Z]{3}$`)
+	seen := make(map[string]bool)
+	for _, c := range currency.All {
+		assert.Regexp(t, code, c.Code)
+		assert.False(t, seen[c.Code], "duplicate %s", c.Code)
+		seen[c.Code] = true
+		assert.NotEmpty(t, c.Symbol, c.Code)
+		assert.Contains(t, []string{"en", "ru", "de", "fr"}, c.Locale, c.Code)
+	}
+	assert.Equal(t, []string{"RUB", "USD", "EUR"}, currency.Codes()[:3])
+}
+
+func TestFind(t *testing.T) {
+	jpy, ok := currency.Find("JPY")
+	assert.True(t, ok)
+	assert.Equal(t, 0, jpy.Decimals)
+
+	_, ok = currency.Find("jpy")
+	assert.False(t, ok)
+}
@@ -15,7 +15,9 @@ import (
 	"github.com/henrygd/beszel"
 	"github.com/henrygd/beszel/internal/alerts"
 	"github.com/henrygd/beszel/internal/hub/config"
+	"github.com/henrygd/beszel/internal/hub/requestid"
 	"github.com/henrygd/beszel/internal/hub/systems"
+	"github.com/henrygd/beszel/internal/payments"
 	"github.com/henrygd/beszel/internal/records"
 	"github.com/henrygd/beszel/internal/users"
 
@@ -32,9 +34,12 @@ type Hub struct {
 	um     *users.UserManager
 	rm     *records.RecordManager
 	sm     *systems.SystemManager
+	pm     *payments.PaymentManager
 	pubKey string
 	signer ssh.Signer
 	appURL string
+	// limits requests to the custom api routes per user, nil if unlimited
+	limiter *rateLimiter
 }
 
 // NewHub creates a new Hub instance with default configuration
@@ -46,7 +51,9 @@ func NewHub(app core.App) *Hub {
 	hub.um = users.NewUserManager(hub)
 	hub.rm = records.NewRecordManager(hub)
 	hub.sm = systems.NewSystemManager(hub)
+	hub.pm = payments.NewPaymentManager(hub)
 	hub.appURL, _ = GetEnv("APP_URL")
+	hub.limiter = newRateLimiterFromEnv(app)
 	return hub
 }
 
@@ -202,6 +209,16 @@ func (h *Hub) registerCronJobs(_ *core.ServeEvent) error {
 	h.Cron().MustAdd("delete old records", "8 * * * *", h.rm.DeleteOldRecords)
 	// create longer records every 10 minutes
 	h.Cron().MustAdd("create longer records", "*/10 * * * *", h.rm.CreateLongerRecords)
+	// roll forward payments whose due date has passed once a day
+	h.Cron().MustAdd("advance payments", "5 0 * * *", h.pm.AdvancePayments)
+	// remind users of payments coming due every hour, so each user's timezone gets its own midnight
+	h.Cron().MustAdd("notify due payments", "15 * * * *", h.pm.NotifyDuePayments)
+	// send payment digests every hour, so each user's timezone gets its own midnight
+	h.Cron().MustAdd("send payment digests", "20 * * * *", h.pm.SendDigests)
+	// record each user's monthly spend once a day and notify them of sudden increases
+	h.Cron().MustAdd("snapshot spend", "30 0 * * *", h.pm.SnapshotSpend)
+	// refresh exchange rates once a day
+	h.Cron().MustAdd("fetch exchange rates", "0 3 * * *", h.pm.FetchRates)
 	return nil
 }
 
@@ -240,9 +257,14 @@ func (h *Hub) registerMiddlewares(se *core.ServeEvent) {
 func (h *Hub) registerApiRoutes(se *core.ServeEvent) error {
 	// auth protected routes
 	apiAuth := se.Router.Group("/api/beszel")
+	apiAuth.BindFunc(requestid.Middleware)
 	apiAuth.Bind(apis.RequireAuth())
+	if h.limiter != nil {
+		apiAuth.BindFunc(h.limiter.middleware)
+	}
 	// auth optional routes
 	apiNoAuth := se.Router.Group("/api/beszel")
+	apiNoAuth.BindFunc(requestid.Middleware)
 
 	// create first user endpoint only needed if no users exist
 	if totalUsers, _ := se.App.CountRecords("users"); totalUsers == 0 {
@@ -272,6 +294,105 @@ func (h *Hub) registerApiRoutes(se *core.ServeEvent) error {
 	apiAuth.POST("/smart/refresh", h.refreshSmartData)
 	// get systemd service details
 	apiAuth.GET("/systemd/info", h.getSystemdInfo)
+	// list the user's payments with simple filters
+	apiAuth.GET("/payments", h.pm.ListPayments)
+	// get monthly payment totals per currency
+	apiAuth.GET("/payments/summary", h.pm.GetSummary)
+	// get payments due within the next days
+	apiAuth.GET("/payments/upcoming", h.pm.GetUpcoming)
+	// get all active payments, overdue first and then soonest due
+	apiAuth.GET("/payments/by-urgency", h.pm.GetByUrgency)
+	// get payments due this week grouped by day
+	apiAuth.GET("/payments/week", h.pm.GetWeek)
+	// get the user's most and least expensive payments by monthly cost
+	apiAuth.GET("/payments/leaderboard", h.pm.GetLeaderboard)
+	// get projected charges per month for the coming months
+	apiAuth.GET("/payments/cashflow", h.pm.GetCashflow)
+	// get payments still charged for systems that stopped reporting
+	apiAuth.GET("/payments/zombies", h.pm.GetZombiePayments)
+	// get token for the payments calendar feed
+	apiAuth.GET("/payments/calendar-token", h.pm.GetCalendarToken)
+	// payments calendar feed (authenticated with token param)
+	apiNoAuth.GET("/payments/calendar.ics", h.pm.GetCalendar)
+	// search the user's payments by notes, provider and system name
+	apiAuth.GET("/payments/search", h.pm.SearchPayments)
+	// export the user's payments as CSV
+	apiAuth.GET("/payments/export.csv", h.pm.ExportCSV)
+	// export the user's payment history as CSV
+	apiAuth.GET("/payments/history/export.csv", h.pm.ExportHistoryCSV)
+	// create payments in bulk from a JSON array
+	apiAuth.POST("/payments/import", h.pm.ImportPayments)
+	// advance all due payments now instead of waiting for the cron job
+	apiAuth.POST("/payments/recalculate", h.pm.RecalculatePayments).Bind(apis.RequireSuperuserAuth())
+	// get and change the payments config that applies to every user (superuser only)
+	apiAuth.GET("/payments/config", h.pm.GetConfig).Bind(apis.RequireSuperuserAuth())
+	apiAuth.PATCH("/payments/config", h.pm.UpdateConfig).Bind(apis.RequireSuperuserAuth())
+	// report the state of the payment cron jobs, rates and webhooks (superuser only)
+	apiAuth.GET("/health", h.pm.GetHealth).Bind(apis.RequireSuperuserAuth())
+	// list the payments the reminder job would remind of now, without sending anything (superuser only)
+	apiAuth.POST("/reminders/preview", h.pm.PreviewReminders).Bind(apis.RequireSuperuserAuth())
+	// view a payment or provider with its notes decrypted
+	apiAuth.GET("/payments/{id}", h.pm.ViewPayment)
+	apiAuth.GET("/providers/{id}", h.pm.ViewProvider)
+	// archive / unarchive a payment
+	apiAuth.POST("/payments/{id}/archive", h.pm.ArchivePayment)
+	apiAuth.POST("/payments/{id}/unarchive", h.pm.UnarchivePayment)
+	// create a payment with the fields of an existing one
+	apiAuth.POST("/payments/{id}/clone", h.pm.ClonePayment)
+	// move a payment to another of the user's systems
+	apiAuth.POST("/payments/{id}/reassign-system", h.pm.ReassignSystem)
+	// preview the next due dates of a payment
+	apiAuth.GET("/payments/{id}/schedule", h.pm.GetPaymentSchedule)
+	// get how much was paid for a payment so far
+	apiAuth.GET("/payments/{id}/total-paid", h.pm.GetTotalPaid)
+	// compare a payment's expected charges with its recorded history
+	apiAuth.GET("/payments/{id}/reconcile", h.pm.GetReconciliation)
+	// get the changes of a payment's amount and currency
+	apiAuth.GET("/payments/{id}/price-history", h.pm.GetPriceHistory)
+	// hold back a payment's reminders for a number of days
+	apiAuth.POST("/payments/{id}/snooze", h.pm.SnoozePayment)
+	// pause, resume or cancel a payment, and reactivate a cancelled one
+	apiAuth.POST("/payments/{id}/status", h.pm.SetPaymentStatus)
+	apiAuth.POST("/payments/{id}/reactivate", h.pm.ReactivatePayment)
+	// record the charge of a due payment and advance it by one period
+	apiAuth.POST("/payments/{id}/confirm", h.pm.ConfirmPayment)
+	// list the latest delivery attempts of a webhook
+	apiAuth.GET("/webhooks/{id}/deliveries", h.pm.GetWebhookDeliveries)
+	// send a sample payload to a webhook and return the result
+	apiAuth.POST("/webhooks/{id}/test", h.pm.TestWebhook)
+	// move the payments of a provider to another one and delete it
+	apiAuth.POST("/providers/merge", h.pm.MergeProviders)
+	// list the providers without payments and delete them
+	apiAuth.GET("/providers/unused", h.pm.GetUnusedProviders)
+	apiAuth.POST("/providers/cleanup", h.pm.CleanupProviders)
+	// archive all payments of a provider, optionally deleting the provider
+	apiAuth.POST("/providers/{id}/archive-payments", h.pm.ArchiveProviderPayments)
+	// get the consolidated spend of a provider's payments
+	apiAuth.GET("/providers/{id}/summary", h.pm.GetProviderSummary)
+	// check that a provider's url is still reachable
+	apiAuth.POST("/providers/{id}/check-url", h.pm.CheckProviderURL)
+	// get the cost of a system's payments
+	apiAuth.GET("/systems/{id}/cost", h.pm.GetSystemCost)
+	// list the user's notifications and mark them as read
+	apiAuth.GET("/notifications", h.pm.GetNotifications)
+	apiAuth.POST("/notifications/{id}/read", h.pm.MarkNotificationRead)
+	// preview the user's payment digest for today
+	apiAuth.GET("/digest", h.pm.GetDigest)
+	// format an amount for display in its currency
+	apiAuth.GET("/format", h.pm.FormatAmount)
+	// get the user's spend over a year
+	apiAuth.GET("/reports/annual", h.pm.GetAnnualReport)
+	// compare the user's spend between two years
+	apiAuth.GET("/reports/compare", h.pm.GetCompareReport)
+	// get the user's daily monthly spend snapshots for charting spend over time
+	apiAuth.GET("/reports/spend-trend", h.pm.GetSpendTrend)
+	// get / update the user's payment preferences
+	apiAuth.GET("/settings", h.pm.GetSettings)
+	apiAuth.PATCH("/settings", h.pm.UpdateSettings)
+	// get spend compared to each budget's limit
+	apiAuth.GET("/budgets/status", h.pm.GetBudgetStatus)
+	// get the totals, upcoming payments, budgets and unread notifications of the home page at once
+	apiAuth.GET("/dashboard", h.pm.GetDashboard)
 	// /containers routes
 	if enabled, _ := GetEnv("CONTAINER_DETAILS"); enabled != "false" {
 		// get container logs
@@ -866,3 +866,141 @@ func TestTrustedHeaderMiddleware(t *testing.T) {
 		scenario.Test(t)
 	}
 }
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Setenv("BESZEL_HUB_API_RATE_LIMIT", "2")
+
+	hub, err := beszelTests.NewTestHub(t.TempDir())
+	require.NoError(t, err)
+	defer hub.Cleanup()
+	hub.StartHub()
+
+	user, err := beszelTests.CreateUser(hub, "limited@example.com", "password123")
+	require.NoError(t, err)
+	token, err := user.NewAuthToken()
+	require.NoError(t, err)
+	other, err := beszelTests.CreateUser(hub, "other@example.com", "password123")
+	require.NoError(t, err)
+	otherToken, err := other.NewAuthToken()
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "first request within the limit",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/getkey",
+			Headers:         map[string]string{"Authorization": token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"\"key\":"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "second request within the limit",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/getkey",
+			Headers:         map[string]string{"Authorization": token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"\"key\":"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "request over the limit",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/getkey",
+			Headers:         map[string]string{"Authorization": token},
+			ExpectedStatus:  429,
+			ExpectedContent: []string{"too many requests"},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Equal(t, "30", res.Header.Get("Retry-After"))
+			},
+		},
+		{
+			Name:            "other users have their own limit",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/getkey",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"\"key\":"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	hub, err := beszelTests.NewTestHub(t.TempDir())
+	require.NoError(t, err)
+	defer hub.Cleanup()
+	hub.StartHub()
+
+	user, err := beszelTests.CreateUser(hub, "user@example.com", "password123")
+	require.NoError(t, err)
+	token, err := user.NewAuthToken()
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "generated for authenticated routes",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/getkey",
+			Headers:         map[string]string{"Authorization": token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"\"key\":"},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Len(t, res.Header.Get("X-Request-Id"), 16)
+			},
+		},
+		{
+			Name:            "set when auth fails",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/getkey",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.NotEmpty(t, res.Header.Get("X-Request-Id"))
+			},
+		},
+		{
+			Name:            "client id is kept",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/first-run",
+			Headers:         map[string]string{"X-Request-Id": "proxy-1234.abc"},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"\"firstRun\":"},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Equal(t, "proxy-1234.abc", res.Header.Get("X-Request-Id"))
+			},
+		},
+		{
+			Name:            "unsafe client id is replaced",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/first-run",
+			Headers:         map[string]string{"X-Request-Id": "bad id\" injected=1"},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"\"firstRun\":"},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Len(t, res.Header.Get("X-Request-Id"), 16)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -3,13 +3,21 @@
 
 package hub
 
-import "github.com/henrygd/beszel/internal/hub/systems"
+import (
+	"github.com/henrygd/beszel/internal/hub/systems"
+	"github.com/henrygd/beszel/internal/payments"
+)
 
 // TESTING ONLY: GetSystemManager returns the system manager
 func (h *Hub) GetSystemManager() *systems.SystemManager {
 	return h.sm
 }
 
+// TESTING ONLY: GetPaymentManager returns the payment manager
+func (h *Hub) GetPaymentManager() *payments.PaymentManager {
+	return h.pm
+}
+
 // TESTING ONLY: GetPubkey returns the public key
 func (h *Hub) GetPubkey() string {
 	return h.pubKey
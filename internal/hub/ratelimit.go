@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// rateLimiter throttles requests per user with a token bucket for each user.
+// Buckets hold up to a minute's worth of requests and refill continuously.
+type rateLimiter struct {
+	mu sync.Mutex
+	// tokens added per second
+	rate float64
+	// maximum number of tokens in a bucket
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter allowing perMinute requests per minute to each user
+func newRateLimiter(perMinute int) *rateLimiter {
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// newRateLimiterFromEnv creates the limiter configured with API_RATE_LIMIT (requests per minute per user).
+// Returns nil if it isn't set, which leaves the API unlimited.
+func newRateLimiterFromEnv(app core.App) *rateLimiter {
+	value, exists := GetEnv("API_RATE_LIMIT")
+	if !exists || value == "" {
+		return nil
+	}
+	perMinute, err := strconv.Atoi(value)
+	if err != nil || perMinute < 1 {
+		app.Logger().Warn("Invalid API_RATE_LIMIT, rate limiting disabled", "value", value)
+		return nil
+	}
+	return newRateLimiter(perMinute)
+}
+
+// allow takes a token from the key's bucket. If the bucket is empty it returns false
+// with the time until the next token is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// sweep removes the buckets that have refilled, at most once a minute, so idle users don't use memory
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// middleware rejects requests of users over their limit with 429 and a Retry-After header in seconds
+func (l *rateLimiter) middleware(e *core.RequestEvent) error {
+	if e.Auth == nil {
+		return e.Next()
+	}
+	ok, wait := l.allow(e.Auth.Id, time.Now())
+	if !ok {
+		e.Response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		return e.JSON(http.StatusTooManyRequests, map[string]string{"error": "too many requests"})
+	}
+	return e.Next()
+}
@@ -0,0 +1,78 @@
+// Package requestid tags requests to the custom API routes with a correlation ID
+// that is added to their log lines and returned to the client.
+package requestid
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Header is the request and response header carrying the ID
+const Header = "X-Request-Id"
+
+// maximum length of an ID supplied by the client, longer ones are replaced
+const maxLength = 64
+
+// log attribute holding the ID
+const logKey = "requestId"
+
+type contextKey struct{}
+
+// New returns a random ID
+func New() string {
+	return security.RandomString(16)
+}
+
+// WithID returns a copy of ctx carrying id
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the ID carried by ctx, or an empty string if it has none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Middleware attaches an ID to the request context and sets it in the response header.
+// An ID sent by the client is kept so a proxy in front of the hub can correlate its own logs.
+func Middleware(e *core.RequestEvent) error {
+	id := e.Request.Header.Get(Header)
+	if !valid(id) {
+		id = New()
+	}
+	e.Response.Header().Set(Header, id)
+	e.Request = e.Request.WithContext(WithID(e.Request.Context(), id))
+	return e.Next()
+}
+
+// Logger returns the app logger with the ID of the request added to every line
+func Logger(e *core.RequestEvent) *slog.Logger {
+	logger := e.App.Logger()
+	if id := FromContext(e.Request.Context()); id != "" {
+		return logger.With(logKey, id)
+	}
+	return logger
+}
+
+// RunLogger returns the app logger with a new ID added to every line, for work done
+// outside of a request such as a cron job
+func RunLogger(app core.App, job string) *slog.Logger {
+	return app.Logger().With("job", job, logKey, New())
+}
+
+// valid reports whether a client supplied ID is safe to log and echo back
+func valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
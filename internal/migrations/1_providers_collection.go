@@ -1,24 +1,32 @@
 package migrations
 
 import (
+	"github.com/henrygd/beszel/internal/entities/currency"
+
 	"github.com/pocketbase/pocketbase/core"
 	m "github.com/pocketbase/pocketbase/migrations"
 )
 
 func init() {
 	m.Register(func(app core.App) error {
-		collection := core.NewBaseCollection("providers")
-		collection.Id = "pbc_providers"
+		// the collection may already exist if its schema was imported, in which case
+		// only the missing fields and indexes are added and its rules are kept
+		collection, isNew, err := findOrNewBaseCollection(app, "providers", "pbc_providers")
+		if err != nil {
+			return err
+		}
 
-		// Set rules - use @request.auth.id for filtering user's records
-		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
-		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
-		collection.CreateRule = strPtr(`@request.auth.id != ""`)
-		collection.UpdateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
-		collection.DeleteRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		if isNew {
+			// Set rules - use @request.auth.id for filtering user's records
+			collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+			collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+			collection.CreateRule = strPtr(`@request.auth.id != ""`)
+			collection.UpdateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+			collection.DeleteRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		}
 
 		// Add fields
-		collection.Fields.Add(&core.RelationField{
+		addMissingField(collection, &core.RelationField{
 			Name:          "user",
 			Required:      true,
 			CollectionId:  "_pb_users_auth_",
@@ -26,7 +34,7 @@ func init() {
 			MaxSelect:     1,
 		})
 
-		collection.Fields.Add(&core.TextField{
+		addMissingField(collection, &core.TextField{
 			Name:        "name",
 			Required:    true,
 			Min:         1,
@@ -34,29 +42,32 @@ func init() {
 			Presentable: true,
 		})
 
-		collection.Fields.Add(&core.URLField{
+		addMissingField(collection, &core.URLField{
 			Name:     "url",
 			Required: true,
 		})
 
-		collection.Fields.Add(&core.SelectField{
+		addMissingField(collection, &core.SelectField{
 			Name:      "currencyDefault",
 			Required:  false,
 			MaxSelect: 1,
-			Values:    []string{"RUB", "USD", "EUR"},
+			Values:    currency.Codes(),
 		})
 
-		collection.Fields.Add(&core.TextField{
+		addMissingField(collection, &core.TextField{
 			Name:     "notes",
 			Required: false,
 			Max:      1000,
 		})
 
 		// Add indexes
-		collection.AddIndex("idx_providers_user", false, "user", "")
+		addMissingIndex(collection, "idx_providers_user", false, "user")
 
 		return app.Save(collection)
-	}, nil)
+	}, func(app core.App) error {
+		// reverted after the payments collection, which references it
+		return deleteCollection(app, "providers")
+	})
 }
 
 func strPtr(s string) *string {
@@ -1,24 +1,32 @@
 package migrations
 
 import (
+	"github.com/henrygd/beszel/internal/entities/currency"
+
 	"github.com/pocketbase/pocketbase/core"
 	m "github.com/pocketbase/pocketbase/migrations"
 )
 
 func init() {
 	m.Register(func(app core.App) error {
-		collection := core.NewBaseCollection("payments")
-		collection.Id = "pbc_payments"
-
-		// Set rules - use @request.auth.id for filtering user's records
-		collection.ListRule = strPtr2(`@request.auth.id != "" && user = @request.auth.id`)
-		collection.ViewRule = strPtr2(`@request.auth.id != "" && user = @request.auth.id`)
-		collection.CreateRule = strPtr2(`@request.auth.id != ""`)
-		collection.UpdateRule = strPtr2(`@request.auth.id != "" && user = @request.auth.id`)
-		collection.DeleteRule = strPtr2(`@request.auth.id != "" && user = @request.auth.id`)
+		// the collection may already exist if its schema was imported, in which case
+		// only the missing fields and indexes are added and its rules are kept
+		collection, isNew, err := findOrNewBaseCollection(app, "payments", "pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		if isNew {
+			// Set rules - use @request.auth.id for filtering user's records
+			collection.ListRule = strPtr2(`@request.auth.id != "" && user = @request.auth.id`)
+			collection.ViewRule = strPtr2(`@request.auth.id != "" && user = @request.auth.id`)
+			collection.CreateRule = strPtr2(`@request.auth.id != ""`)
+			collection.UpdateRule = strPtr2(`@request.auth.id != "" && user = @request.auth.id`)
+			collection.DeleteRule = strPtr2(`@request.auth.id != "" && user = @request.auth.id`)
+		}
 
 		// Add fields
-		collection.Fields.Add(&core.RelationField{
+		addMissingField(collection, &core.RelationField{
 			Name:          "user",
 			Required:      true,
 			CollectionId:  "_pb_users_auth_",
@@ -26,7 +34,7 @@ func init() {
 			MaxSelect:     1,
 		})
 
-		collection.Fields.Add(&core.RelationField{
+		addMissingField(collection, &core.RelationField{
 			Name:          "system",
 			Required:      true,
 			CollectionId:  "2hz5ncl8tizk5nx", // systems collection ID
@@ -34,7 +42,7 @@ func init() {
 			MaxSelect:     1,
 		})
 
-		collection.Fields.Add(&core.RelationField{
+		addMissingField(collection, &core.RelationField{
 			Name:          "provider",
 			Required:      true,
 			CollectionId:  "pbc_providers",
@@ -42,58 +50,60 @@ func init() {
 			MaxSelect:     1,
 		})
 
-		collection.Fields.Add(&core.SelectField{
+		addMissingField(collection, &core.SelectField{
 			Name:      "period",
 			Required:  true,
 			MaxSelect: 1,
 			Values:    []string{"daily", "weekly", "monthly", "quarterly", "semiannual", "annual"},
 		})
 
-		collection.Fields.Add(&core.DateField{
+		addMissingField(collection, &core.DateField{
 			Name:     "nextPayment",
 			Required: true,
 		})
 
-		collection.Fields.Add(&core.NumberField{
+		addMissingField(collection, &core.NumberField{
 			Name:     "amount",
 			Required: true,
 			Min:      floatPtr(0),
 		})
 
-		collection.Fields.Add(&core.SelectField{
+		addMissingField(collection, &core.SelectField{
 			Name:      "currency",
 			Required:  true,
 			MaxSelect: 1,
-			Values:    []string{"RUB", "USD", "EUR"},
+			Values:    currency.Codes(),
 		})
 
-		collection.Fields.Add(&core.TextField{
+		addMissingField(collection, &core.TextField{
 			Name:     "country",
 			Required: false,
 			Max:      2,
 			Pattern:  `^[A-Z]{0,2}$`,
 		})
 
-		collection.Fields.Add(&core.URLField{
+		addMissingField(collection, &core.URLField{
 			Name:     "providerUrlOverride",
 			Required: false,
 		})
 
-		collection.Fields.Add(&core.TextField{
+		addMissingField(collection, &core.TextField{
 			Name:     "notes",
 			Required: false,
 			Max:      1000,
 		})
 
 		// Add indexes
-		collection.AddIndex("idx_pmt_user", false, "user", "")
-		collection.AddIndex("idx_pmt_system", false, "system", "")
-		collection.AddIndex("idx_pmt_provider", false, "provider", "")
-		collection.AddIndex("idx_pmt_user_system", true, "user, system", "")
-		collection.AddIndex("idx_pmt_next_payment", false, "nextPayment", "")
+		addMissingIndex(collection, "idx_pmt_user", false, "user")
+		addMissingIndex(collection, "idx_pmt_system", false, "system")
+		addMissingIndex(collection, "idx_pmt_provider", false, "provider")
+		addMissingIndex(collection, "idx_pmt_user_system", true, "user, system")
+		addMissingIndex(collection, "idx_pmt_next_payment", false, "nextPayment")
 
 		return app.Save(collection)
-	}, nil)
+	}, func(app core.App) error {
+		return deleteCollection(app, "payments")
+	})
 }
 
 func strPtr2(s string) *string {
@@ -0,0 +1,93 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// findOrNewBaseCollection returns the collection named name if it already exists, e.g. from a
+// schema imported before running the migrations, or a new base collection with the given id.
+// The second return value reports whether the collection is new.
+func findOrNewBaseCollection(app core.App, name, id string) (*core.Collection, bool, error) {
+	collection, err := app.FindCollectionByNameOrId(name)
+	if err == nil {
+		return collection, false, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, false, err
+	}
+	collection = core.NewBaseCollection(name)
+	collection.Id = id
+	return collection, true, nil
+}
+
+// addMissingField adds the field unless the collection already has one with the same name,
+// which is left as it is
+func addMissingField(collection *core.Collection, field core.Field) {
+	if collection.Fields.GetByName(field.GetName()) == nil {
+		collection.Fields.Add(field)
+	}
+}
+
+// addMissingIndex adds the index unless the collection already has one with the same name
+func addMissingIndex(collection *core.Collection, name string, unique bool, columnsExpr string) {
+	if collection.GetIndex(name) == "" {
+		collection.AddIndex(name, unique, columnsExpr, "")
+	}
+}
+
+// deleteCollection deletes the collection named name if it exists, along with the collections
+// that still reference it. Those are added by later migrations that can't be reverted on their own.
+func deleteCollection(app core.App, name string) error {
+	collection, err := app.FindCollectionByNameOrId(name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	references, err := app.FindCollectionReferences(collection, collection.Id)
+	if err != nil {
+		return err
+	}
+	for reference := range references {
+		if err := deleteCollection(app, reference.Id); err != nil {
+			return err
+		}
+	}
+	return app.Delete(collection)
+}
+
+// currencyFields lists the select fields holding a currency code, by collection id
+var currencyFields = map[string][]string{
+	"pbc_providers":       {"currencyDefault"},
+	"pbc_payments":        {"currency"},
+	"pbc_exchange_rates":  {"base", "quote"},
+	"pbc_payment_history": {"currency"},
+	"pbc_budgets":         {"currency"},
+	"pbc_payment_methods": {"currency"},
+	"pbc_price_changes":   {"oldCurrency", "newCurrency"},
+}
+
+// syncCurrencyFields sets the values of every currency select field to the supported currencies
+func syncCurrencyFields(app core.App) error {
+	for id, names := range currencyFields {
+		collection, err := app.FindCollectionByNameOrId(id)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if field, ok := collection.Fields.GetByName(name).(*core.SelectField); ok {
+				field.Values = currency.Codes()
+			}
+		}
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// set by the auto-advance cron job each time nextPayment is rolled forward
+		collection.Fields.Add(&core.DateField{
+			Name:     "lastAdvancedAt",
+			Required: false,
+		})
+
+		// original billing day of month, so monthly payments return to the 31st after short months
+		collection.Fields.Add(&core.NumberField{
+			Name:     "billingDay",
+			Required: false,
+			Min:      floatPtr(0),
+			Max:      floatPtr(31),
+			OnlyInt:  true,
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("exchange_rates")
+		collection.Id = "pbc_exchange_rates"
+
+		// Set rules - readable by any authenticated user, writable only by superusers
+		collection.ListRule = strPtr(`@request.auth.id != ""`)
+		collection.ViewRule = strPtr(`@request.auth.id != ""`)
+		collection.CreateRule = nil
+		collection.UpdateRule = nil
+		collection.DeleteRule = nil
+
+		// Add fields - one unit of base is worth rate units of quote
+		collection.Fields.Add(&core.SelectField{
+			Name:      "base",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    currency.Codes(),
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "quote",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    currency.Codes(),
+		})
+
+		collection.Fields.Add(&core.NumberField{
+			Name:     "rate",
+			Required: true,
+			Min:      floatPtr(0),
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "fetchedAt",
+			Required: false,
+		})
+
+		// Add indexes - at most one current rate per pair
+		collection.AddIndex("idx_exchange_rates_pair", true, "base, quote", "")
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,69 @@
+package migrations
+
+import (
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("payment_history")
+		collection.Id = "pbc_payment_history"
+
+		// Set rules - use @request.auth.id for filtering user's records
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.CreateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id && payment.user = @request.auth.id`)
+		// the update rule sees the stored record, so a new payment from the request body is checked too
+		collection.UpdateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id && payment.user = @request.auth.id && (@request.body.payment:isset = false || @request.body.payment.user = @request.auth.id)`)
+		collection.DeleteRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "payment",
+			Required:      true,
+			CollectionId:  "pbc_payments",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.NumberField{
+			Name:     "amount",
+			Required: false,
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "currency",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    currency.Codes(),
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "paidAt",
+			Required: true,
+		})
+
+		collection.Fields.Add(&core.TextField{
+			Name:     "note",
+			Required: false,
+			Max:      500,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_pmt_history_payment", false, "payment", "")
+		collection.AddIndex("idx_pmt_history_paid_at", false, "paidAt", "")
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// end of a free trial; the first real charge happens on this date
+		collection.Fields.Add(&core.DateField{
+			Name:     "trialEndsAt",
+			Required: false,
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
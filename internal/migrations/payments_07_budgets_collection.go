@@ -0,0 +1,64 @@
+package migrations
+
+import (
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("budgets")
+		collection.Id = "pbc_budgets"
+
+		// Set rules - use @request.auth.id for filtering user's records
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.CreateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.UpdateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.DeleteRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.TextField{
+			Name:        "name",
+			Required:    true,
+			Min:         1,
+			Max:         255,
+			Presentable: true,
+		})
+
+		collection.Fields.Add(&core.NumberField{
+			Name:     "limit",
+			Required: false,
+			Min:      floatPtr(0),
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "currency",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    currency.Codes(),
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "period",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"daily", "weekly", "monthly", "quarterly", "semiannual", "annual"},
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_budgets_user", false, "user", "")
+
+		return app.Save(collection)
+	}, nil)
+}
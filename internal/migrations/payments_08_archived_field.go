@@ -0,0 +1,27 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// archived payments keep their history but are left out of lists and totals
+		collection.Fields.Add(&core.DateField{
+			Name:     "archivedAt",
+			Required: false,
+		})
+
+		// hide archived payments from lists unless ?includeArchived=true is passed.
+		// the view rule is unchanged so an archived payment can still be opened directly.
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id && (archivedAt = "" || @request.query.includeArchived = "true")`)
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,66 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("categories")
+		collection.Id = "pbc_categories"
+
+		// Set rules - use @request.auth.id for filtering user's records
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.CreateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.UpdateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.DeleteRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.TextField{
+			Name:        "name",
+			Required:    true,
+			Min:         1,
+			Max:         255,
+			Presentable: true,
+		})
+
+		collection.Fields.Add(&core.TextField{
+			Name:     "color",
+			Required: false,
+			Pattern:  `^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_categories_user_name", true, "user, name", "")
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		payments, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// deleting a category only removes it from its payments
+		payments.Fields.Add(&core.RelationField{
+			Name:          "categories",
+			Required:      false,
+			CollectionId:  collection.Id,
+			CascadeDelete: false,
+			MaxSelect:     5,
+		})
+
+		return app.Save(payments)
+	}, nil)
+}
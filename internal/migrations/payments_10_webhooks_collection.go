@@ -0,0 +1,59 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("webhooks")
+		collection.Id = "pbc_webhooks"
+
+		// Set rules - use @request.auth.id for filtering user's records
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.CreateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.UpdateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.DeleteRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.URLField{
+			Name:     "url",
+			Required: true,
+		})
+
+		collection.Fields.Add(&core.BoolField{
+			Name: "enabled",
+		})
+
+		// used to sign delivered payloads so receivers can verify them
+		collection.Fields.Add(&core.TextField{
+			Name:     "secret",
+			Required: false,
+			Max:      255,
+		})
+
+		// number of days before a payment is due to start notifying
+		collection.Fields.Add(&core.NumberField{
+			Name:     "leadDays",
+			Required: false,
+			Min:      floatPtr(0),
+			Max:      floatPtr(365),
+			OnlyInt:  true,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_webhooks_user", false, "user", "")
+
+		return app.Save(collection)
+	}, nil)
+}
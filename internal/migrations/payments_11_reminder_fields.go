@@ -0,0 +1,48 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// number of days before nextPayment to send a reminder
+		collection.Fields.Add(&core.NumberField{
+			Name:     "reminderDays",
+			Required: false,
+			Min:      floatPtr(0),
+			Max:      floatPtr(365),
+			OnlyInt:  true,
+		})
+
+		// set when a reminder is sent so each cycle is only reminded once
+		collection.Fields.Add(&core.DateField{
+			Name:     "lastReminderSentAt",
+			Required: false,
+		})
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// existing payments get the default lead time
+		if _, err := app.DB().NewQuery("UPDATE payments SET reminderDays = 3").Execute(); err != nil {
+			return err
+		}
+
+		// the lead time now comes from each payment instead of the webhook
+		webhooks, err := app.FindCollectionByNameOrId("pbc_webhooks")
+		if err != nil {
+			return err
+		}
+		webhooks.Fields.RemoveByName("leadDays")
+
+		return app.Save(webhooks)
+	}, nil)
+}
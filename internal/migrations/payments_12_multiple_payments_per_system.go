@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// a system can have several payments (eg. compute and block storage),
+		// so keep the composite index for lookups but allow duplicates
+		collection.RemoveIndex("idx_pmt_user_system")
+		collection.AddIndex("idx_pmt_user_system", false, "user, system", "")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// fails if a system has more than one payment by now
+		collection.RemoveIndex("idx_pmt_user_system")
+		collection.AddIndex("idx_pmt_user_system", true, "user, system", "")
+
+		return app.Save(collection)
+	})
+}
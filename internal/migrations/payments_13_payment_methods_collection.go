@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("payment_methods")
+		collection.Id = "pbc_payment_methods"
+
+		// Set rules - use @request.auth.id for filtering user's records
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.CreateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.UpdateRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.DeleteRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.TextField{
+			Name:        "label",
+			Required:    true,
+			Min:         1,
+			Max:         255,
+			Presentable: true,
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "type",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"card", "bank", "paypal", "crypto"},
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "currency",
+			Required:  false,
+			MaxSelect: 1,
+			Values:    currency.Codes(),
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_payment_methods_user", false, "user", "")
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		payments, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// deleting a payment method only clears it from its payments
+		payments.Fields.Add(&core.RelationField{
+			Name:          "paymentMethod",
+			Required:      false,
+			CollectionId:  collection.Id,
+			CascadeDelete: false,
+			MaxSelect:     1,
+		})
+
+		return app.Save(payments)
+	}, nil)
+}
@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// promotional discount subtracted from amount until discountEndsAt
+		collection.Fields.Add(&core.NumberField{
+			Name:     "discountAmount",
+			Required: false,
+			Min:      floatPtr(0),
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "discountEndsAt",
+			Required: false,
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// tax in percent added on top of the net amount
+		collection.Fields.Add(&core.NumberField{
+			Name:     "taxRate",
+			Required: false,
+			Min:      floatPtr(0),
+			Max:      floatPtr(100),
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// paused payments stay listed but aren't charged, cancelled payments behave like archived ones
+		collection.Fields.Add(&core.SelectField{
+			Name:      "status",
+			Required:  false,
+			MaxSelect: 1,
+			Values:    []string{"active", "paused", "cancelled"},
+		})
+
+		// cancelled payments are hidden from lists like archived ones
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id && ((archivedAt = "" && status != "cancelled") || @request.query.includeArchived = "true")`)
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		_, err = app.DB().NewQuery("UPDATE payments SET status = 'active'").Execute()
+		return err
+	}, nil)
+}
@@ -0,0 +1,26 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// how the payment was created, set by the server.
+		// existing payments are left empty since it isn't known.
+		collection.Fields.Add(&core.SelectField{
+			Name:      "source",
+			Required:  false,
+			MaxSelect: 1,
+			Values:    []string{"manual", "import", "api", "cron"},
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
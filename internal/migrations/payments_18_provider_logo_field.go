@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_providers")
+		if err != nil {
+			return err
+		}
+
+		// logo shown next to the provider, defaults to the favicon of its url
+		collection.Fields.Add(&core.URLField{
+			Name:     "logoUrl",
+			Required: false,
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,72 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("notifications")
+		collection.Id = "pbc_notifications"
+
+		// Set rules - notifications are created by the server, users can only read and delete them
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.CreateRule = nil
+		collection.UpdateRule = nil
+		collection.DeleteRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "type",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"payment_due"},
+		})
+
+		collection.Fields.Add(&core.TextField{
+			Name:        "title",
+			Required:    true,
+			Max:         255,
+			Presentable: true,
+		})
+
+		collection.Fields.Add(&core.TextField{
+			Name:     "body",
+			Required: false,
+			Max:      1000,
+		})
+
+		collection.Fields.Add(&core.RelationField{
+			Name:          "relatedPayment",
+			Required:      false,
+			CollectionId:  "pbc_payments",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "readAt",
+			Required: false,
+		})
+
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "created",
+			OnCreate: true,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_notifications_user", false, "user, created", "")
+
+		return app.Save(collection)
+	}, nil)
+}
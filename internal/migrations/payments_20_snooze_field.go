@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// reminders are held back until this date, without changing the schedule
+		collection.Fields.Add(&core.DateField{
+			Name:     "snoozeUntil",
+			Required: false,
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,73 @@
+package migrations
+
+import (
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("price_changes")
+		collection.Id = "pbc_price_changes"
+
+		// Set rules - changes are recorded by the server when a payment is updated
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.CreateRule = nil
+		collection.UpdateRule = nil
+		collection.DeleteRule = nil
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "payment",
+			Required:      true,
+			CollectionId:  "pbc_payments",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.NumberField{
+			Name:     "oldAmount",
+			Required: false,
+		})
+
+		collection.Fields.Add(&core.NumberField{
+			Name:     "newAmount",
+			Required: false,
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "oldCurrency",
+			Required:  false,
+			MaxSelect: 1,
+			Values:    currency.Codes(),
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "newCurrency",
+			Required:  false,
+			MaxSelect: 1,
+			Values:    currency.Codes(),
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "changedAt",
+			Required: true,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_price_changes_payment", false, "payment, changedAt", "")
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// a custom period is billed every customIntervalDays or every customIntervalMonths
+		collection.Fields.Add(&core.SelectField{
+			Name:      "period",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"daily", "weekly", "monthly", "quarterly", "semiannual", "annual", "custom"},
+		})
+
+		collection.Fields.Add(&core.NumberField{
+			Name:     "customIntervalDays",
+			Required: false,
+			OnlyInt:  true,
+			Min:      floatPtr(1),
+			Max:      floatPtr(3650),
+		})
+
+		collection.Fields.Add(&core.NumberField{
+			Name:     "customIntervalMonths",
+			Required: false,
+			OnlyInt:  true,
+			Min:      floatPtr(1),
+			Max:      floatPtr(120),
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
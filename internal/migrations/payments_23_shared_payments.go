@@ -0,0 +1,38 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// users a payment is shared with, who can view it and pay part of it
+		collection.Fields.Add(&core.RelationField{
+			Name:          "sharedWith",
+			Required:      false,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: false,
+			MaxSelect:     20,
+		})
+
+		// percent of a shared payment paid by its owner. The rest is split evenly
+		// between the users it is shared with. Zero splits it evenly between everyone.
+		collection.Fields.Add(&core.NumberField{
+			Name:     "splitPercent",
+			Required: false,
+			Min:      floatPtr(0),
+			Max:      floatPtr(100),
+		})
+
+		// users a payment is shared with can open it, only the owner can change it
+		collection.ViewRule = strPtr(`@request.auth.id != "" && (user = @request.auth.id || sharedWith.id ?= @request.auth.id)`)
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,13 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// extend the currency select fields from RUB, USD and EUR to the shared currency list
+		return syncCurrencyFields(app)
+	}, nil)
+}
@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// currency given to legacy payments when neither their provider nor their user has a default.
+// RUB was the only currency of the first payment records.
+const fallbackCurrency = "RUB"
+
+func init() {
+	m.Register(func(app core.App) error {
+		// payments created before the currency defaults may have no currency, which fails validation
+		// on their next save. Fill it from the provider's currencyDefault, then the user's default
+		// currency, then fallbackCurrency. Re-running only picks up payments still without one.
+		payments, err := app.FindAllRecords("payments", dbx.HashExp{"currency": ""})
+		if err != nil {
+			return err
+		}
+		providerCurrencies := make(map[string]string)
+		userCurrencies := make(map[string]string)
+		for _, payment := range payments {
+			providerID := payment.GetString("provider")
+			code, ok := providerCurrencies[providerID]
+			if !ok {
+				if provider, err := app.FindRecordById("providers", providerID); err == nil {
+					code = provider.GetString("currencyDefault")
+				}
+				providerCurrencies[providerID] = code
+			}
+			if code == "" {
+				code = userDefaultCurrency(app, userCurrencies, payment.GetString("user"))
+			}
+			if code == "" {
+				code = fallbackCurrency
+			}
+			// updated directly so the payment hooks, like the price change log, don't run
+			if _, err := app.DB().Update("payments", dbx.Params{"currency": code}, dbx.HashExp{"id": payment.Id}).Execute(); err != nil {
+				return err
+			}
+		}
+		if len(payments) > 0 {
+			app.Logger().Info("Backfilled payment currencies", "count", len(payments))
+		}
+		return nil
+	}, nil)
+}
+
+// userDefaultCurrency returns the user's default currency setting, or an empty string if
+// it isn't set or supported, caching the result in cache
+func userDefaultCurrency(app core.App, cache map[string]string, userID string) string {
+	if code, ok := cache[userID]; ok {
+		return code
+	}
+	var settings struct {
+		DefaultCurrency string `json:"defaultCurrency"`
+	}
+	if record, err := app.FindFirstRecordByData("user_settings", "user", userID); err == nil {
+		_ = record.UnmarshalJSONField("settings", &settings)
+	}
+	if _, ok := currency.Find(settings.DefaultCurrency); !ok {
+		settings.DefaultCurrency = ""
+	}
+	cache[userID] = settings.DefaultCurrency
+	return settings.DefaultCurrency
+}
@@ -0,0 +1,32 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// default size limit of a payment history attachment. It can be changed in the
+// field's settings from the dashboard.
+const historyFileMaxSize = 10 << 20
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payment_history")
+		if err != nil {
+			return err
+		}
+
+		// invoice or receipt of the charge. Protected files are only served with a file token
+		// of a user allowed to view the record by the view rule.
+		collection.Fields.Add(&core.FileField{
+			Name:      "file",
+			Required:  false,
+			MaxSelect: 1,
+			MaxSize:   historyFileMaxSize,
+			MimeTypes: []string{"application/pdf", "image/png", "image/jpeg"},
+			Protected: true,
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// free payments have a zero amount and are left out of spend totals, but are
+		// still advanced and reminded of. Other payments need an amount above zero.
+		collection.Fields.Add(&core.BoolField{
+			Name: "isFree",
+		})
+		// a required number field rejects zero, so the amount is checked by the payment validation instead
+		if amount, ok := collection.Fields.GetByName("amount").(*core.NumberField); ok {
+			amount.Required = false
+		}
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// payments with a zero amount, e.g. created before the amount was required, are free tiers
+		_, err = app.DB().Update("payments", dbx.Params{"isFree": true}, dbx.HashExp{"amount": 0}).Execute()
+		return err
+	}, nil)
+}
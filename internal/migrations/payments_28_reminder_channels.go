@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// channels the reminder of a payment is sent to, new payments default to the in-app inbox
+		collection.Fields.Add(&core.SelectField{
+			Name:      "reminderChannels",
+			Required:  false,
+			MaxSelect: 3,
+			Values:    []string{"inApp", "webhook", "email"},
+		})
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// existing payments were reminded in the inbox and by webhook, so they keep both
+		_, err = app.DB().NewQuery(`UPDATE payments SET reminderChannels = '["inApp","webhook"]'`).Execute()
+		return err
+	}, nil)
+}
@@ -0,0 +1,102 @@
+package migrations
+
+import (
+	"errors"
+
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("payment_digests")
+		collection.Id = "pbc_payment_digests"
+
+		// Set rules - digests are recorded by the server when they are sent
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.CreateRule = nil
+		collection.UpdateRule = nil
+		collection.DeleteRule = nil
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "frequency",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"daily", "weekly"},
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "periodStart",
+			Required: true,
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "periodEnd",
+			Required: true,
+		})
+
+		// payments listed in the digest, kept when a payment is deleted later
+		collection.Fields.Add(&core.RelationField{
+			Name:          "payments",
+			Required:      false,
+			CollectionId:  "pbc_payments",
+			CascadeDelete: false,
+			MaxSelect:     1000,
+		})
+
+		// upcoming spend per currency
+		collection.Fields.Add(&core.JSONField{
+			Name:     "totals",
+			Required: false,
+		})
+
+		// upcoming spend converted into currency, which is left empty when the totals couldn't be converted
+		collection.Fields.Add(&core.NumberField{
+			Name:     "total",
+			Required: false,
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "currency",
+			Required:  false,
+			MaxSelect: 1,
+			Values:    currency.Codes(),
+		})
+
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "created",
+			OnCreate: true,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_payment_digests_user_period", true, "user, periodStart", "")
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// digests are delivered to the in-app inbox as their own notification type
+		notifications, err := app.FindCollectionByNameOrId("pbc_notifications")
+		if err != nil {
+			return err
+		}
+		notificationType, ok := notifications.Fields.GetByName("type").(*core.SelectField)
+		if !ok {
+			return errors.New("notifications type field not found")
+		}
+		notificationType.Values = append(notificationType.Values, "payment_digest")
+		return app.Save(notifications)
+	}, nil)
+}
@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_providers")
+		if err != nil {
+			return err
+		}
+
+		// result of the last reachability check of the provider's url, only stored when asked for
+		collection.Fields.Add(&core.DateField{
+			Name:     "lastChecked",
+			Required: false,
+		})
+
+		// HTTP status of the last check, 0 if the url couldn't be reached
+		collection.Fields.Add(&core.NumberField{
+			Name:     "lastStatus",
+			Required: false,
+			OnlyInt:  true,
+		})
+
+		return app.Save(collection)
+	}, nil)
+}
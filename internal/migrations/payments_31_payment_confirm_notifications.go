@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"errors"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_notifications")
+		if err != nil {
+			return err
+		}
+
+		// users who confirm charges themselves are asked to when a payment falls due
+		notificationType, ok := collection.Fields.GetByName("type").(*core.SelectField)
+		if !ok {
+			return errors.New("notifications type field not found")
+		}
+		notificationType.Values = append(notificationType.Values, "payment_confirm")
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"errors"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// one-time payments are charged once on nextPayment and archived afterwards
+		period, ok := collection.Fields.GetByName("period").(*core.SelectField)
+		if !ok {
+			return errors.New("payments period field not found")
+		}
+		period.Values = append(period.Values, "once")
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,74 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("webhook_deliveries")
+		collection.Id = "pbc_webhook_deliveries"
+
+		// Set rules - deliveries are recorded by the server for each attempt
+		collection.ListRule = strPtr(`@request.auth.id != "" && webhook.user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && webhook.user = @request.auth.id`)
+		collection.CreateRule = nil
+		collection.UpdateRule = nil
+		collection.DeleteRule = nil
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "webhook",
+			Required:      true,
+			CollectionId:  "pbc_webhooks",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		// payment the reminder was about, kept empty once the payment is deleted
+		collection.Fields.Add(&core.RelationField{
+			Name:          "payment",
+			Required:      false,
+			CollectionId:  "pbc_payments",
+			CascadeDelete: false,
+			MaxSelect:     1,
+		})
+
+		// status of the response, zero if the request failed before one was received
+		collection.Fields.Add(&core.NumberField{
+			Name:     "statusCode",
+			Required: false,
+			OnlyInt:  true,
+		})
+
+		collection.Fields.Add(&core.BoolField{
+			Name: "success",
+		})
+
+		// 1 for the first attempt, counting up with each retry
+		collection.Fields.Add(&core.NumberField{
+			Name:     "attempt",
+			Required: true,
+			Min:      floatPtr(1),
+			OnlyInt:  true,
+		})
+
+		// start of the response body, or the error if no response was received
+		collection.Fields.Add(&core.TextField{
+			Name:     "responseSnippet",
+			Required: false,
+			Max:      1000,
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "sentAt",
+			Required: true,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_webhook_deliveries_webhook_sent", false, "webhook, sentAt", "")
+
+		return app.Save(collection)
+	}, nil)
+}
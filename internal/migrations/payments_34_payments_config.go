@@ -0,0 +1,44 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("payments_config")
+		collection.Id = "pbc_payments_config"
+
+		// Set rules - only superusers can read and change the config
+		collection.ListRule = nil
+		collection.ViewRule = nil
+		collection.CreateRule = nil
+		collection.UpdateRule = nil
+		collection.DeleteRule = nil
+
+		// Add fields
+		// how daily and weekly amounts are turned into monthly equivalents
+		collection.Fields.Add(&core.SelectField{
+			Name:      "normalization",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"average", "calendar"},
+		})
+
+		collection.Fields.Add(&core.AutodateField{
+			Name:     "updated",
+			OnCreate: true,
+			OnUpdate: true,
+		})
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// the config is a single record
+		record := core.NewRecord(collection)
+		record.Set("normalization", "average")
+		return app.Save(record)
+	}, nil)
+}
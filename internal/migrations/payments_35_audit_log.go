@@ -0,0 +1,67 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("audit_log")
+		collection.Id = "pbc_audit_log"
+
+		// Set rules - the log is written by the server and only superusers can read it
+		collection.ListRule = nil
+		collection.ViewRule = nil
+		collection.CreateRule = nil
+		collection.UpdateRule = nil
+		collection.DeleteRule = nil
+
+		// Add fields
+		// user who made the change, empty for changes made by superusers and background jobs
+		collection.Fields.Add(&core.RelationField{
+			Name:          "actor",
+			Required:      false,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: false,
+			MaxSelect:     1,
+		})
+
+		collection.Fields.Add(&core.TextField{
+			Name:     "collection",
+			Required: true,
+			Max:      255,
+		})
+
+		// kept as text so entries outlive the record they describe
+		collection.Fields.Add(&core.TextField{
+			Name:     "recordId",
+			Required: true,
+			Max:      255,
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "action",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    []string{"create", "update", "delete"},
+		})
+
+		// old and new value of each changed field
+		collection.Fields.Add(&core.JSONField{
+			Name:     "changedFields",
+			Required: false,
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "at",
+			Required: true,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_audit_log_record", false, "collection, recordId", "")
+		collection.AddIndex("idx_audit_log_at", false, "at", "")
+
+		return app.Save(collection)
+	}, nil)
+}
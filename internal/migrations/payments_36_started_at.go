@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// when the subscription started, used to estimate the total paid before its history was kept
+		addMissingField(collection, &core.DateField{
+			Name:     "startedAt",
+			Required: false,
+		})
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// days a charge can post after nextPayment before the payment is overdue
+		addMissingField(collection, &core.NumberField{
+			Name:     "graceDays",
+			Required: false,
+			Min:      floatPtr(0),
+			Max:      floatPtr(90),
+			OnlyInt:  true,
+		})
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_payments")
+		if err != nil {
+			return err
+		}
+
+		// when a paused payment becomes active again, resumed by the daily advance job
+		addMissingField(collection, &core.DateField{
+			Name:     "pauseUntil",
+			Required: false,
+		})
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,76 @@
+package migrations
+
+import (
+	"errors"
+
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("spend_snapshots")
+		collection.Id = "pbc_spend_snapshots"
+
+		// Set rules - snapshots are taken by the server once a day
+		collection.ListRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.ViewRule = strPtr(`@request.auth.id != "" && user = @request.auth.id`)
+		collection.CreateRule = nil
+		collection.UpdateRule = nil
+		collection.DeleteRule = nil
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		// normalized monthly total of the user's payments, converted into currency
+		collection.Fields.Add(&core.NumberField{
+			Name:     "total",
+			Required: false,
+		})
+
+		collection.Fields.Add(&core.SelectField{
+			Name:      "currency",
+			Required:  true,
+			MaxSelect: 1,
+			Values:    currency.Codes(),
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "takenAt",
+			Required: true,
+		})
+
+		// set on the snapshots that notified the user of a jump in spend
+		collection.Fields.Add(&core.BoolField{
+			Name:     "alerted",
+			Required: false,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_spend_snapshots_user_taken", false, "user, takenAt", "")
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// users are told about a jump in spend with its own notification type
+		notifications, err := app.FindCollectionByNameOrId("pbc_notifications")
+		if err != nil {
+			return err
+		}
+		notificationType, ok := notifications.Fields.GetByName("type").(*core.SelectField)
+		if !ok {
+			return errors.New("notifications type field not found")
+		}
+		notificationType.Values = append(notificationType.Values, "spend_increase")
+		return app.Save(notifications)
+	}, nil)
+}
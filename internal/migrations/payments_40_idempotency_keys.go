@@ -0,0 +1,60 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection := core.NewBaseCollection("idempotency_keys")
+		collection.Id = "pbc_idempotency_keys"
+
+		// Set rules - keys are only used by the server to replay requests
+		collection.ListRule = nil
+		collection.ViewRule = nil
+		collection.CreateRule = nil
+		collection.UpdateRule = nil
+		collection.DeleteRule = nil
+
+		// Add fields
+		collection.Fields.Add(&core.RelationField{
+			Name:          "user",
+			Required:      true,
+			CollectionId:  "_pb_users_auth_",
+			CascadeDelete: true,
+			MaxSelect:     1,
+		})
+
+		// value of the Idempotency-Key header
+		collection.Fields.Add(&core.TextField{
+			Name:     "key",
+			Required: true,
+			Max:      255,
+		})
+
+		// SHA-256 of the request body, so a key can't be reused for a different request
+		collection.Fields.Add(&core.TextField{
+			Name:     "requestHash",
+			Required: true,
+			Max:      64,
+		})
+
+		// the response returned on replay
+		collection.Fields.Add(&core.JSONField{
+			Name:     "response",
+			Required: false,
+		})
+
+		collection.Fields.Add(&core.DateField{
+			Name:     "expiresAt",
+			Required: true,
+		})
+
+		// Add indexes
+		collection.AddIndex("idx_idempotency_keys_user_key", true, "user, `key`", "")
+		collection.AddIndex("idx_idempotency_keys_expires", false, "expiresAt", "")
+
+		return app.Save(collection)
+	}, nil)
+}
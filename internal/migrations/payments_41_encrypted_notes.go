@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// notes encrypted at rest are longer than the notes themselves, whose length is now
+		// checked by the server before encrypting
+		for _, id := range []string{"pbc_payments", "pbc_providers"} {
+			collection, err := app.FindCollectionByNameOrId(id)
+			if err != nil {
+				return err
+			}
+			notes, ok := collection.Fields.GetByName("notes").(*core.TextField)
+			if !ok {
+				return fmt.Errorf("%s notes field not found", id)
+			}
+			notes.Max = 6000
+			if err := app.Save(collection); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil)
+}
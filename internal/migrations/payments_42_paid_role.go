@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"errors"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("users")
+		if err != nil {
+			return err
+		}
+
+		// users with the paid role aren't held to PAYMENT_LIMIT
+		role, ok := collection.Fields.GetByName("role").(*core.SelectField)
+		if !ok {
+			return errors.New("users role field not found")
+		}
+		role.Values = append(role.Values, "paid")
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_notifications")
+		if err != nil {
+			return err
+		}
+
+		// the due date a payment confirmation asks about, so the user is asked once per due date
+		addMissingField(collection, &core.DateField{
+			Name:     "dueDate",
+			Required: false,
+		})
+		return app.Save(collection)
+	}, nil)
+}
@@ -0,0 +1,288 @@
+// Package payments handles recurring payment schedules for providers and systems.
+package payments
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Payment sources, recording how a payment was created
+const (
+	// created through the collection API by a user, usually from the web UI
+	SourceManual = "manual"
+	// created by the import endpoint
+	SourceImport = "import"
+	// created through the collection API by a superuser
+	SourceAPI = "api"
+	// created by a background job
+	SourceCron = "cron"
+)
+
+type PaymentManager struct {
+	app    core.App
+	rates  rateCache
+	health jobHealth
+	// authors of the changes being saved through the collection API, by record
+	auditActors sync.Map
+	// payments updated through the collection API that keep their nextPayment when the period changes
+	keepNextPayment sync.Map
+	// encrypts notes at rest, nil when NOTES_ENCRYPTION_KEY isn't set
+	notes *notesCipher
+	// maximum number of payments per user set with PAYMENT_LIMIT, 0 for no limit
+	paymentLimit int
+}
+
+// NewPaymentManager creates a new PaymentManager instance.
+func NewPaymentManager(app core.App) *PaymentManager {
+	pm := &PaymentManager{app: app}
+	notes, err := loadNotesCipher()
+	if err != nil {
+		app.Logger().Error("Failed to load notes encryption key", "err", err)
+	}
+	pm.notes = notes
+	limit, err := loadPaymentLimit()
+	if err != nil {
+		app.Logger().Error("Failed to load payment limit", "err", err)
+	}
+	pm.paymentLimit = limit
+	pm.bindEvents()
+	return pm
+}
+
+// Bind events to the payments collection lifecycle
+func (pm *PaymentManager) bindEvents() {
+	pm.app.OnRecordCreateRequest("payments").BindFunc(pm.handlePaymentCreateRequest)
+	pm.app.OnRecordUpdateRequest("payments").BindFunc(pm.handlePaymentUpdateRequest)
+	pm.app.OnRecordUpdateRequest("payments").BindFunc(pm.trackRecomputeNext)
+	pm.app.OnRecordValidate("payments").BindFunc(validatePayment)
+	pm.app.OnRecordCreateRequest("budgets").BindFunc(pm.handleBudgetCreateRequest)
+	pm.app.OnRecordCreate("providers").BindFunc(checkProviderName)
+	pm.app.OnRecordCreate("providers").BindFunc(defaultProviderLogo)
+	pm.app.OnRecordUpdate("providers").BindFunc(checkProviderName)
+	// runs for every save, including the ones made by cron jobs and imports
+	pm.app.OnRecordCreate("payments", "payment_history").BindFunc(roundStoredAmount)
+	pm.app.OnRecordUpdate("payments", "payment_history").BindFunc(roundStoredAmount)
+	pm.app.OnRecordUpdate("payments").BindFunc(recordPriceChange)
+	pm.app.OnRecordCreate(encryptedNotesCollections...).BindFunc(pm.encryptNotes)
+	pm.app.OnRecordUpdate(encryptedNotesCollections...).BindFunc(pm.encryptNotes)
+	pm.app.OnRecordUpdate("payments").BindFunc(pm.recomputeOnPeriodChange)
+	pm.app.OnRecordAfterCreateSuccess("exchange_rates").BindFunc(pm.invalidateRates)
+	pm.app.OnRecordAfterUpdateSuccess("exchange_rates").BindFunc(pm.invalidateRates)
+	pm.app.OnRecordAfterDeleteSuccess("exchange_rates").BindFunc(pm.invalidateRates)
+	pm.bindAudit()
+}
+
+// handlePaymentCreateRequest runs before a payment is created through the API
+func (pm *PaymentManager) handlePaymentCreateRequest(e *core.RecordRequestEvent) error {
+	if err := validateRelationOwners(e.App, e.Record); err != nil {
+		return e.BadRequestError("Failed to create payment.", err)
+	}
+	if err := checkAmountPrecision(e.Record); err != nil {
+		return e.BadRequestError("Failed to create payment.", err)
+	}
+	if err := pm.checkPaymentLimit(e.RequestEvent, 1); err != nil {
+		return limitError(e.RequestEvent, err)
+	}
+	info, err := e.RequestInfo()
+	if err != nil {
+		return err
+	}
+	_, hasReminderDays := info.Body["reminderDays"]
+	_, hasGraceDays := info.Body["graceDays"]
+	source := SourceManual
+	if e.HasSuperuserAuth() {
+		source = SourceAPI
+	}
+	prepareNewPayment(e.App, e.Record, source, hasReminderDays, hasGraceDays)
+	addPaymentWarnings(e.App, e.Record)
+	return e.Next()
+}
+
+// handlePaymentUpdateRequest runs before a payment is updated through the API
+func (pm *PaymentManager) handlePaymentUpdateRequest(e *core.RecordRequestEvent) error {
+	if err := validateRelationOwners(e.App, e.Record); err != nil {
+		return e.BadRequestError("Failed to update payment.", err)
+	}
+	if err := checkAmountPrecision(e.Record); err != nil {
+		return e.BadRequestError("Failed to update payment.", err)
+	}
+	resetScheduleAnchor(e.Record)
+	// the source is set by the server when the payment is created
+	e.Record.Set("source", e.Record.Original().GetString("source"))
+	from := paymentStatus(e.Record.Original())
+	if err := checkStatusTransition(from, paymentStatus(e.Record), false); err != nil {
+		return e.BadRequestError("Failed to update payment.", err)
+	}
+	if err := applyStatusChange(e.Record, from, time.Now().UTC()); err != nil {
+		return e.BadRequestError("Failed to update payment.", err)
+	}
+	addPaymentWarnings(e.App, e.Record)
+	return e.Next()
+}
+
+// how far nextPayment may be from today, to catch mistyped dates
+const (
+	maxNextPaymentPastYears   = 10
+	maxNextPaymentFutureYears = 20
+)
+
+// validatePayment checks rules the collection schema can't express, like ones spanning several fields
+// the set of known country codes, or the range of plausible due dates.
+func validatePayment(e *core.RecordEvent) error {
+	errs := validation.Errors{}
+	if isFree(e.Record) && e.Record.GetFloat("amount") != 0 {
+		errs["amount"] = validation.NewError("validation_free_amount", "A free payment must have an amount of zero.")
+	} else if !isFree(e.Record) && e.Record.GetFloat("amount") <= 0 {
+		errs["amount"] = validation.NewError("validation_amount_required", "Amount must be greater than zero unless the payment is free.")
+	}
+	if e.Record.GetFloat("discountAmount") > e.Record.GetFloat("amount") {
+		errs["discountAmount"] = validation.NewError("validation_discount_exceeds_amount", "Discount can't be more than the amount.")
+	}
+	if next := e.Record.GetDateTime("nextPayment"); !next.IsZero() {
+		now := time.Now().UTC()
+		if next.Time().Before(now.AddDate(-maxNextPaymentPastYears, 0, 0)) {
+			errs["nextPayment"] = validation.NewError("validation_next_payment_too_old",
+				fmt.Sprintf("Next payment can't be more than %d years in the past.", maxNextPaymentPastYears))
+		} else if next.Time().After(now.AddDate(maxNextPaymentFutureYears, 0, 0)) {
+			errs["nextPayment"] = validation.NewError("validation_next_payment_too_far",
+				fmt.Sprintf("Next payment can't be more than %d years in the future.", maxNextPaymentFutureYears))
+		}
+	}
+	if e.Record.GetString("period") == PeriodCustom {
+		if _, _, ok := customInterval(e.Record); !ok {
+			errs["customIntervalDays"] = validation.NewError("validation_invalid_custom_interval",
+				"A custom period needs either customIntervalDays or customIntervalMonths.")
+		}
+	}
+	if !e.Record.GetDateTime("pauseUntil").IsZero() && !isPaused(e.Record) {
+		errs["pauseUntil"] = validation.NewError("validation_pause_until_not_paused", "Only paused payments can have a pause end date.")
+	}
+	if slices.Contains(e.Record.GetStringSlice("sharedWith"), e.Record.GetString("user")) {
+		errs["sharedWith"] = validation.NewError("validation_shared_with_owner", "A payment can't be shared with its owner.")
+	}
+	if country := e.Record.GetString("country"); country != "" && !isCountry(country) {
+		errs["country"] = validation.NewError("validation_unknown_country", "Unknown ISO 3166-1 alpha-2 country code.")
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return e.Next()
+}
+
+// validateRelationOwners checks that the payment's provider, system, payment method and categories belong to the payment's user.
+// The collection rules only check who owns the payment, not the records it points to.
+// Relations that can't be found are left to regular validation.
+func validateRelationOwners(app core.App, record *core.Record) error {
+	userID := record.GetString("user")
+	errs := validation.Errors{}
+	if providerID := record.GetString("provider"); providerID != "" {
+		provider, err := app.FindRecordById("providers", providerID)
+		if err == nil && provider.GetString("user") != userID {
+			errs["provider"] = validation.NewError("validation_provider_not_owned", "Provider doesn't belong to the payment's user.")
+		}
+	}
+	if systemID := record.GetString("system"); systemID != "" {
+		system, err := app.FindRecordById("systems", systemID)
+		if err == nil && !slices.Contains(system.GetStringSlice("users"), userID) {
+			errs["system"] = validation.NewError("validation_system_not_owned", "System doesn't belong to the payment's user.")
+		}
+	}
+	if methodID := record.GetString("paymentMethod"); methodID != "" {
+		method, err := app.FindRecordById("payment_methods", methodID)
+		if err == nil && method.GetString("user") != userID {
+			errs["paymentMethod"] = validation.NewError("validation_payment_method_not_owned", "Payment method doesn't belong to the payment's user.")
+		}
+	}
+	if categoryIDs := record.GetStringSlice("categories"); len(categoryIDs) > 0 {
+		categories, err := app.FindRecordsByIds("categories", categoryIDs)
+		if err == nil && slices.ContainsFunc(categories, func(category *core.Record) bool {
+			return category.GetString("user") != userID
+		}) {
+			errs["categories"] = validation.NewError("validation_category_not_owned", "Categories must belong to the payment's user.")
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// defaultCurrencyFromProvider fills an empty currency with the provider's currencyDefault.
+// If the provider can't be found the record is left as is and regular validation reports the error.
+func defaultCurrencyFromProvider(app core.App, record *core.Record) {
+	if record.GetString("currency") != "" {
+		return
+	}
+	providerID := record.GetString("provider")
+	if providerID == "" {
+		return
+	}
+	provider, err := app.FindRecordById("providers", providerID)
+	if err != nil {
+		return
+	}
+	if currency := provider.GetString("currencyDefault"); currency != "" {
+		record.Set("currency", currency)
+	}
+}
+
+// prepareNewPayment fills in the defaults of a payment created by a user and
+// records how it was created, replacing any source sent by the client.
+// The currency falls back to the provider's and then the user's default currency.
+// An empty country is filled with the user's default country.
+// Zero is a valid lead time and grace period, so reminderDays and graceDays are only defaulted
+// if they weren't provided.
+func prepareNewPayment(app core.App, record *core.Record, source string, hasReminderDays, hasGraceDays bool) {
+	record.Set("source", source)
+	resetScheduleAnchor(record)
+	defaultCurrencyFromProvider(app, record)
+	settings := loadPaymentSettings(app, record.GetString("user"))
+	if record.GetString("currency") == "" && settings.DefaultCurrency != "" {
+		record.Set("currency", settings.DefaultCurrency)
+	}
+	if record.GetString("country") == "" && settings.DefaultCountry != "" {
+		record.Set("country", settings.DefaultCountry)
+	}
+	if record.GetString("status") == "" {
+		record.Set("status", StatusActive)
+	}
+	if !hasReminderDays {
+		record.Set("reminderDays", settings.DefaultReminderDays)
+	}
+	if !hasGraceDays {
+		record.Set("graceDays", settings.DefaultGraceDays)
+	}
+	if len(record.GetStringSlice("reminderChannels")) == 0 {
+		record.Set("reminderChannels", []string{ChannelInApp})
+	}
+}
+
+// handleBudgetCreateRequest fills an empty budget currency with the user's default currency
+func (pm *PaymentManager) handleBudgetCreateRequest(e *core.RecordRequestEvent) error {
+	if e.Record.GetString("currency") == "" {
+		if currency := loadPaymentSettings(e.App, e.Record.GetString("user")).DefaultCurrency; currency != "" {
+			e.Record.Set("currency", currency)
+		}
+	}
+	return e.Next()
+}
+
+// resetScheduleAnchor updates the billing day anchor when nextPayment is set by the user,
+// and clears lastAdvancedAt and lastReminderSentAt so the cron jobs treat the new date as a fresh cycle.
+func resetScheduleAnchor(record *core.Record) {
+	nextPayment := record.GetDateTime("nextPayment")
+	if nextPayment.IsZero() {
+		return
+	}
+	if !record.IsNew() && record.Original().GetDateTime("nextPayment").Equal(nextPayment) {
+		return
+	}
+	record.Set("billingDay", nextPayment.Time().Day())
+	record.Set("lastAdvancedAt", "")
+	record.Set("lastReminderSentAt", "")
+}
@@ -0,0 +1,313 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// AdvancePayments rolls nextPayment forward for every payment whose due date has passed.
+// Archived, paused and cancelled payments are skipped, and paused payments whose pauseUntil
+// has passed are resumed. Runs once a day as a cron job.
+func (pm *PaymentManager) AdvancePayments() {
+	logger := requestid.RunLogger(pm.app, jobAdvancePayments)
+	count, err := pm.advanceDuePayments(logger, time.Now().UTC())
+	pm.health.jobRan(jobAdvancePayments, time.Now().UTC(), err)
+	if err != nil {
+		logger.Error("Failed to advance payments", "err", err)
+		return
+	}
+	if count > 0 {
+		logger.Info("Advanced payments", "count", count)
+	}
+}
+
+// findDuePayments returns the payments due before now that can be advanced,
+// optionally only the ones of the user with userID
+func findDuePayments(app core.App, now time.Time, userID string) ([]*core.Record, error) {
+	nowStr, err := types.ParseDateTime(now)
+	if err != nil {
+		return nil, err
+	}
+	exprs := []dbx.Expression{
+		dbx.NewExp("nextPayment != '' AND nextPayment < {:now} AND archivedAt = '' AND status NOT IN ('paused', 'cancelled')", dbx.Params{"now": nowStr.String()}),
+	}
+	if userID != "" {
+		exprs = append(exprs, dbx.HashExp{"user": userID})
+	}
+	return app.FindAllRecords("payments", exprs...)
+}
+
+// splitManualAdvance separates the payments of users who turned autoAdvance off, and confirm each charge
+// themselves, from the ones the job advances
+func splitManualAdvance(app core.App, records []*core.Record) (auto, manual []*core.Record) {
+	autoAdvance := make(map[string]bool)
+	for _, record := range records {
+		userID := record.GetString("user")
+		enabled, ok := autoAdvance[userID]
+		if !ok {
+			enabled = loadPaymentSettings(app, userID).AutoAdvance
+			autoAdvance[userID] = enabled
+		}
+		if enabled {
+			auto = append(auto, record)
+		} else {
+			manual = append(manual, record)
+		}
+	}
+	return auto, manual
+}
+
+// advanceDuePayments advances all payments due before now and returns the number updated.
+// Users with autoAdvance off are instead asked to confirm each due payment with a notification.
+// Paused payments whose pauseUntil has passed are resumed first.
+func (pm *PaymentManager) advanceDuePayments(logger *slog.Logger, now time.Time) (int, error) {
+	if _, err := resumePausedPayments(pm.app, logger, now); err != nil {
+		return 0, err
+	}
+	due, err := findDuePayments(pm.app, now, "")
+	if err != nil {
+		return 0, err
+	}
+	records, manual := splitManualAdvance(pm.app, due)
+	pm.askToConfirm(logger, manual, now)
+
+	var count int
+	for _, record := range records {
+		charges, err := advancePayment(record, now)
+		if err != nil {
+			logger.Error("Failed to advance payment", "id", record.Id, "err", err)
+			continue
+		}
+		if len(charges) == 0 {
+			continue
+		}
+		// save the new schedule and its charges together so a failure can't record one without the other
+		err = pm.app.RunInTransaction(func(txApp core.App) error {
+			if err := txApp.SaveNoValidate(record); err != nil {
+				return err
+			}
+			return recordCharges(txApp, record, charges)
+		})
+		if err != nil {
+			logger.Error("Failed to save advanced payment", "id", record.Id, "err", err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// askToConfirm notifies the users of the due payments that they should confirm the charges.
+// Payments in a free trial or their grace period have nothing to confirm yet.
+func (pm *PaymentManager) askToConfirm(logger *slog.Logger, records []*core.Record, now time.Time) {
+	if errs := pm.app.ExpandRecords(records, []string{"provider"}, nil); len(errs) > 0 {
+		logger.Warn("Failed to expand payment providers", "errs", errs)
+	}
+	for _, record := range records {
+		graceEnds := record.GetDateTime("nextPayment").Time().AddDate(0, 0, record.GetInt("graceDays"))
+		if inTrial(record, now) || graceEnds.After(now) {
+			continue
+		}
+		settings := loadPaymentSettings(pm.app, record.GetString("user"))
+		if err := createConfirmNotification(pm.app, record, settings.location(), settings.Locale); err != nil {
+			logger.Error("Failed to create payment confirmation notification", "payment", record.Id, "err", err)
+		}
+	}
+}
+
+// maximum number of periods a payment is advanced by in one run, so a corrupt
+// record (like a daily payment due in 1970) can't stall the job
+const maxAdvancePeriods = 10000
+
+var errTooManyPeriods = errors.New("payment is too many periods behind")
+
+// paymentSchedule holds the fields of a payment that decide when it is charged
+type paymentSchedule struct {
+	Period               string
+	CustomIntervalDays   int
+	CustomIntervalMonths int
+	NextPayment          time.Time
+	// day of month month based periods are charged on, zero for the day of NextPayment
+	BillingDay     int
+	TrialEndsAt    time.Time
+	LastAdvancedAt time.Time
+	// days a charge can post after its due date before it is recorded
+	GraceDays int
+}
+
+// newPaymentSchedule reads the billing schedule of a payment
+func newPaymentSchedule(record *core.Record) paymentSchedule {
+	return paymentSchedule{
+		Period:               record.GetString("period"),
+		CustomIntervalDays:   record.GetInt("customIntervalDays"),
+		CustomIntervalMonths: record.GetInt("customIntervalMonths"),
+		NextPayment:          record.GetDateTime("nextPayment").Time(),
+		BillingDay:           record.GetInt("billingDay"),
+		TrialEndsAt:          record.GetDateTime("trialEndsAt").Time(),
+		LastAdvancedAt:       record.GetDateTime("lastAdvancedAt").Time(),
+		GraceDays:            record.GetInt("graceDays"),
+	}
+}
+
+// addPeriod returns t advanced by one billing period of the schedule like addPeriod,
+// using its interval if the period is custom. Custom month intervals keep anchorDay.
+func (s paymentSchedule) addPeriod(t time.Time, anchorDay int) (time.Time, error) {
+	if s.Period != PeriodCustom {
+		return addPeriod(t, s.Period, anchorDay)
+	}
+	days, months, ok := validInterval(s.CustomIntervalDays, s.CustomIntervalMonths)
+	if !ok {
+		return t, errors.New("custom period without a valid interval")
+	}
+	if days > 0 {
+		return t.AddDate(0, 0, days), nil
+	}
+	return addMonths(t, months, anchorDay), nil
+}
+
+// advanceSchedule moves nextPayment forward by whole periods until it is after now minus the
+// schedule's grace days, so a charge is only recorded once its grace period is over.
+// Returns the advanced schedule and the due dates that elapsed, or the schedule unchanged and
+// no dates if it was already advanced today, is in a trial or is not yet due.
+func advanceSchedule(s paymentSchedule, now time.Time) (paymentSchedule, []time.Time, error) {
+	// only advance a schedule once per day so re-running the job is a no-op
+	if !s.LastAdvancedAt.IsZero() && !s.LastAdvancedAt.Before(startOfDay(now)) {
+		return s, nil, nil
+	}
+	// nothing is charged until the trial is over
+	if s.TrialEndsAt.After(now) {
+		return s, nil, nil
+	}
+	due := now.AddDate(0, 0, -s.GraceDays)
+	next := s.NextPayment
+	if next.IsZero() || next.After(due) {
+		return s, nil, nil
+	}
+
+	anchorDay := s.BillingDay
+	// the schedule resumes from the end of the trial
+	if !s.TrialEndsAt.IsZero() && next.Before(s.TrialEndsAt) {
+		next = s.TrialEndsAt
+		anchorDay = s.TrialEndsAt.Day()
+	}
+	if anchorDay == 0 {
+		anchorDay = next.Day()
+	}
+	// one-time payments are charged on their date and never again
+	if s.Period == PeriodOnce {
+		if !s.LastAdvancedAt.IsZero() && !s.LastAdvancedAt.Before(next) {
+			return s, nil, nil
+		}
+		s.NextPayment = next
+		s.LastAdvancedAt = now
+		return s, []time.Time{next}, nil
+	}
+	var charges []time.Time
+	for !next.After(due) {
+		if len(charges) == maxAdvancePeriods {
+			return s, nil, errTooManyPeriods
+		}
+		charges = append(charges, next)
+		following, err := s.addPeriod(next, anchorDay)
+		if err != nil {
+			return s, nil, err
+		}
+		// guards against a period that doesn't move the date forward
+		if !following.After(next) {
+			return s, nil, fmt.Errorf("period %q doesn't advance %s", s.Period, next)
+		}
+		next = following
+	}
+
+	s.NextPayment = next
+	s.BillingDay = anchorDay
+	s.LastAdvancedAt = now
+	return s, charges, nil
+}
+
+// confirmSchedule moves nextPayment forward by a single period, for a charge the user confirmed.
+// Returns the advanced schedule and the due date that was confirmed. Unlike advanceSchedule
+// the payment doesn't have to be due yet, so a charge can be confirmed when it is paid early.
+// One-time payments keep their date, see completeOneTime.
+func confirmSchedule(s paymentSchedule, now time.Time) (paymentSchedule, time.Time, error) {
+	next := s.NextPayment
+	if next.IsZero() {
+		return s, time.Time{}, errors.New("payment has no due date")
+	}
+	if s.Period == PeriodOnce {
+		s.LastAdvancedAt = now
+		return s, next, nil
+	}
+	anchorDay := s.BillingDay
+	if anchorDay == 0 {
+		anchorDay = next.Day()
+	}
+	following, err := s.addPeriod(next, anchorDay)
+	if err != nil {
+		return s, time.Time{}, err
+	}
+	if !following.After(next) {
+		return s, time.Time{}, fmt.Errorf("period %q doesn't advance %s", s.Period, next)
+	}
+	s.NextPayment = following
+	s.BillingDay = anchorDay
+	s.LastAdvancedAt = now
+	return s, next, nil
+}
+
+// advancePayment moves the record's nextPayment forward by whole periods until it is after now.
+// Returns the due dates that elapsed, or nothing if the record was already advanced today or is not yet due.
+// A one-time payment is completed instead, see completeOneTime.
+func advancePayment(record *core.Record, now time.Time) ([]time.Time, error) {
+	schedule, charges, err := advanceSchedule(newPaymentSchedule(record), now)
+	if err != nil || len(charges) == 0 {
+		return nil, err
+	}
+	record.Set("nextPayment", schedule.NextPayment)
+	record.Set("billingDay", schedule.BillingDay)
+	record.Set("lastAdvancedAt", schedule.LastAdvancedAt)
+	completeOneTime(record, now)
+	return charges, nil
+}
+
+// completeOneTime archives a one-time payment once its charge is recorded, so it drops out of
+// the active payments and isn't reminded of again. Its nextPayment stays the date it was charged on.
+func completeOneTime(record *core.Record, now time.Time) {
+	if record.GetString("period") == PeriodOnce {
+		record.Set("archivedAt", now)
+	}
+}
+
+// recordCharges inserts a payment_history row for each elapsed due date
+func recordCharges(app core.App, payment *core.Record, charges []time.Time) error {
+	collection, err := app.FindCachedCollectionByNameOrId("payment_history")
+	if err != nil {
+		return err
+	}
+	for _, paidAt := range charges {
+		history := core.NewRecord(collection)
+		history.Set("payment", payment.Id)
+		history.Set("user", payment.GetString("user"))
+		history.Set("amount", effectiveAmount(payment, paidAt))
+		history.Set("currency", payment.GetString("currency"))
+		history.Set("paidAt", paidAt)
+		if err := app.SaveNoValidate(history); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startOfDay returns midnight of the day containing t
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,240 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/henrygd/beszel/internal/payments"
+
+	"github.com/pocketbase/dbx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestAddPeriod(t *testing.T) {
+	tests := []struct {
+		name      string
+		start     time.Time
+		period    string
+		anchorDay int
+		expected  time.Time
+	}{
+		{"daily", date(2025, 1, 31), payments.PeriodDaily, 0, date(2025, 2, 1)},
+		{"weekly", date(2025, 12, 29), payments.PeriodWeekly, 0, date(2026, 1, 5)},
+		{"monthly", date(2025, 1, 15), payments.PeriodMonthly, 15, date(2025, 2, 15)},
+		{"monthly clamps to end of february", date(2025, 1, 31), payments.PeriodMonthly, 31, date(2025, 2, 28)},
+		{"monthly returns to anchor after february", date(2025, 2, 28), payments.PeriodMonthly, 31, date(2025, 3, 31)},
+		{"monthly clamps to 30 day month", date(2025, 3, 31), payments.PeriodMonthly, 31, date(2025, 4, 30)},
+		{"monthly leap year february", date(2024, 1, 31), payments.PeriodMonthly, 31, date(2024, 2, 29)},
+		{"monthly without anchor uses current day", date(2025, 2, 28), payments.PeriodMonthly, 0, date(2025, 3, 28)},
+		{"quarterly crosses year", date(2025, 11, 30), payments.PeriodQuarterly, 30, date(2026, 2, 28)},
+		{"semiannual", date(2025, 8, 31), payments.PeriodSemiannual, 31, date(2026, 2, 28)},
+		{"annual", date(2025, 6, 1), payments.PeriodAnnual, 1, date(2026, 6, 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := payments.AddPeriod(tt.start, tt.period, tt.anchorDay)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+
+	_, err := payments.AddPeriod(date(2025, 1, 1), "fortnightly", 0)
+	assert.Error(t, err, "unknown period should return an error")
+}
+
+func TestAdvanceSchedule(t *testing.T) {
+	monthly := func(next time.Time, billingDay int) payments.PaymentSchedule {
+		return payments.PaymentSchedule{Period: payments.PeriodMonthly, NextPayment: next, BillingDay: billingDay}
+	}
+	tests := []struct {
+		name           string
+		schedule       payments.PaymentSchedule
+		now            time.Time
+		expectedNext   time.Time
+		expectedDay    int
+		expectedCharge []time.Time
+	}{
+		{"not yet due", monthly(date(2025, 2, 15), 15), date(2025, 2, 14), date(2025, 2, 15), 15, nil},
+		{"due today", monthly(date(2025, 2, 15), 15), date(2025, 2, 15), date(2025, 3, 15), 15, []time.Time{date(2025, 2, 15)}},
+		{"several periods behind", monthly(date(2025, 1, 15), 15), date(2025, 3, 20), date(2025, 4, 15), 15, []time.Time{date(2025, 1, 15), date(2025, 2, 15), date(2025, 3, 15)}},
+		{"month end clamps and returns", monthly(date(2025, 1, 31), 31), date(2025, 3, 1), date(2025, 3, 31), 31, []time.Time{date(2025, 1, 31), date(2025, 2, 28)}},
+		{"leap year february", monthly(date(2024, 1, 31), 31), date(2024, 2, 29), date(2024, 3, 31), 31, []time.Time{date(2024, 1, 31), date(2024, 2, 29)}},
+		{"annual from leap day", payments.PaymentSchedule{Period: payments.PeriodAnnual, NextPayment: date(2024, 2, 29), BillingDay: 29}, date(2024, 3, 1), date(2025, 2, 28), 29, []time.Time{date(2024, 2, 29)}},
+		{"annual returns to leap day", payments.PaymentSchedule{Period: payments.PeriodAnnual, NextPayment: date(2027, 2, 28), BillingDay: 29}, date(2027, 3, 1), date(2028, 2, 29), 29, []time.Time{date(2027, 2, 28)}},
+		{"without billing day uses next payment", monthly(date(2025, 1, 20), 0), date(2025, 1, 21), date(2025, 2, 20), 20, []time.Time{date(2025, 1, 20)}},
+		{"custom days", payments.PaymentSchedule{Period: payments.PeriodCustom, CustomIntervalDays: 10, NextPayment: date(2025, 1, 1)}, date(2025, 1, 15), date(2025, 1, 21), 1, []time.Time{date(2025, 1, 1), date(2025, 1, 11)}},
+		{"already advanced today", payments.PaymentSchedule{Period: payments.PeriodMonthly, NextPayment: date(2025, 1, 10), LastAdvancedAt: date(2025, 1, 10).Add(time.Hour)}, date(2025, 1, 10).Add(2 * time.Hour), date(2025, 1, 10), 0, nil},
+		{"in trial", payments.PaymentSchedule{Period: payments.PeriodMonthly, NextPayment: date(2025, 1, 1), TrialEndsAt: date(2025, 1, 20)}, date(2025, 1, 10), date(2025, 1, 1), 0, nil},
+		{"resumes from end of trial", payments.PaymentSchedule{Period: payments.PeriodMonthly, NextPayment: date(2025, 1, 1), BillingDay: 1, TrialEndsAt: date(2025, 1, 20)}, date(2025, 1, 25), date(2025, 2, 20), 20, []time.Time{date(2025, 1, 20)}},
+		{"one-time payment is charged once", payments.PaymentSchedule{Period: payments.PeriodOnce, NextPayment: date(2025, 1, 10)}, date(2025, 3, 1), date(2025, 1, 10), 0, []time.Time{date(2025, 1, 10)}},
+		{"within grace period", payments.PaymentSchedule{Period: payments.PeriodMonthly, NextPayment: date(2025, 2, 15), BillingDay: 15, GraceDays: 2}, date(2025, 2, 16), date(2025, 2, 15), 15, nil},
+		{"after grace period", payments.PaymentSchedule{Period: payments.PeriodMonthly, NextPayment: date(2025, 2, 15), BillingDay: 15, GraceDays: 2}, date(2025, 2, 17), date(2025, 3, 15), 15, []time.Time{date(2025, 2, 15)}},
+		{"one-time payment already charged", payments.PaymentSchedule{Period: payments.PeriodOnce, NextPayment: date(2025, 1, 10), LastAdvancedAt: date(2025, 1, 11)}, date(2025, 3, 1), date(2025, 1, 10), 0, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, charges, err := payments.AdvanceSchedule(tt.schedule, tt.now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedNext, got.NextPayment)
+			assert.Equal(t, tt.expectedDay, got.BillingDay)
+			assert.Equal(t, tt.expectedCharge, charges)
+			if len(charges) > 0 {
+				assert.Equal(t, tt.now, got.LastAdvancedAt)
+			}
+		})
+	}
+
+	_, _, err := payments.AdvanceSchedule(payments.PaymentSchedule{Period: payments.PeriodCustom, NextPayment: date(2025, 1, 1)}, date(2025, 2, 1))
+	assert.Error(t, err, "custom period without an interval should return an error")
+}
+
+func TestAdvanceDuePayments(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+
+	overdue := f.createPayment(t, map[string]any{
+		"nextPayment": "2025-01-31 00:00:00.000Z",
+		"billingDay":  31,
+	})
+	weekly := f.createPayment(t, map[string]any{
+		"period":      "weekly",
+		"nextPayment": "2025-03-01 12:00:00.000Z",
+	})
+	future := f.createPayment(t, map[string]any{
+		"nextPayment": "2025-04-10 00:00:00.000Z",
+	})
+
+	now := time.Date(2025, 3, 15, 0, 5, 0, 0, time.UTC)
+	count, err := pm.AdvanceDuePayments(now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	overdue, _ = f.hub.FindRecordById("payments", overdue.Id)
+	assert.Equal(t, date(2025, 3, 31), overdue.GetDateTime("nextPayment").Time(), "should keep the 31st after february")
+	assert.Equal(t, now, overdue.GetDateTime("lastAdvancedAt").Time())
+
+	weekly, _ = f.hub.FindRecordById("payments", weekly.Id)
+	assert.Equal(t, time.Date(2025, 3, 15, 12, 0, 0, 0, time.UTC), weekly.GetDateTime("nextPayment").Time())
+
+	future, _ = f.hub.FindRecordById("payments", future.Id)
+	assert.Equal(t, date(2025, 4, 10), future.GetDateTime("nextPayment").Time(), "future payment should be untouched")
+	assert.True(t, future.GetDateTime("lastAdvancedAt").IsZero())
+
+	// one history row per elapsed charge: jan 31, feb 28, mar 31 is still in the future
+	history, err := f.hub.FindAllRecords("payment_history", dbx.HashExp{"payment": overdue.Id})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	paidDates := []time.Time{history[0].GetDateTime("paidAt").Time(), history[1].GetDateTime("paidAt").Time()}
+	assert.ElementsMatch(t, []time.Time{date(2025, 1, 31), date(2025, 2, 28)}, paidDates)
+	assert.Equal(t, 10.0, history[0].GetFloat("amount"))
+	assert.Equal(t, "USD", history[0].GetString("currency"))
+	assert.Equal(t, f.user.Id, history[0].GetString("user"))
+
+	weeklyHistory, err := f.hub.CountRecords("payment_history", dbx.HashExp{"payment": weekly.Id})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, weeklyHistory, "mar 1 and mar 8 should be recorded")
+
+	// running again on the same day should not advance anything
+	count, err = pm.AdvanceDuePayments(now.Add(12 * time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestAdvanceAnnualPaymentFromLeapDay(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+
+	// without a billing day the first advance anchors the schedule on the 29th
+	payment := f.createPayment(t, map[string]any{"period": "annual", "nextPayment": "2024-02-29 00:00:00.000Z"})
+
+	due := date(2024, 2, 29)
+	for _, expected := range []time.Time{date(2025, 2, 28), date(2026, 2, 28), date(2027, 2, 28), date(2028, 2, 29), date(2029, 2, 28)} {
+		// a day after the previous due date
+		count, err := pm.AdvanceDuePayments(due.AddDate(0, 0, 1))
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+		record, err := f.hub.FindRecordById("payments", payment.Id)
+		require.NoError(t, err)
+		assert.Equal(t, expected, record.GetDateTime("nextPayment").Time())
+		assert.Equal(t, 29, record.GetInt("billingDay"))
+		due = expected
+	}
+
+	history, err := f.hub.FindRecordsByFilter("payment_history", "payment = {:payment}", "paidAt", 0, 0, dbx.Params{"payment": payment.Id})
+	require.NoError(t, err)
+	paidDates := make([]time.Time, len(history))
+	for i, record := range history {
+		paidDates[i] = record.GetDateTime("paidAt").Time()
+	}
+	assert.Equal(t, []time.Time{date(2024, 2, 29), date(2025, 2, 28), date(2026, 2, 28), date(2027, 2, 28), date(2028, 2, 29)}, paidDates)
+}
+
+func TestAdvanceDuePaymentsWithTrial(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+
+	// signed up jan 10 with the first charge scheduled a month later, but the trial runs until mar 20
+	trialActive := f.createPayment(t, map[string]any{
+		"nextPayment": "2025-02-10 00:00:00.000Z",
+		"trialEndsAt": "2025-03-20 00:00:00.000Z",
+	})
+	trialEnded := f.createPayment(t, map[string]any{
+		"nextPayment": "2025-01-10 00:00:00.000Z",
+		"trialEndsAt": "2025-02-05 00:00:00.000Z",
+	})
+
+	count, err := pm.AdvanceDuePayments(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	trialActive, _ = f.hub.FindRecordById("payments", trialActive.Id)
+	assert.Equal(t, date(2025, 2, 10), trialActive.GetDateTime("nextPayment").Time(), "payment in trial should not advance")
+
+	trialEnded, _ = f.hub.FindRecordById("payments", trialEnded.Id)
+	assert.Equal(t, date(2025, 3, 5), trialEnded.GetDateTime("nextPayment").Time(), "schedule should resume from trial end")
+	history, err := f.hub.FindAllRecords("payment_history", dbx.HashExp{"payment": trialEnded.Id})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, date(2025, 2, 5), history[0].GetDateTime("paidAt").Time(), "first charge should be on trial end")
+}
+
+func TestAdvanceSkipsMalformedPayments(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	due := date(2030, 1, 15)
+	healthy := f.createPayment(t, map[string]any{"nextPayment": due})
+	// records that validation would reject, as they could come from an older version or a manual edit
+	epoch := f.createPayment(t, map[string]any{"nextPayment": due, "period": payments.PeriodDaily})
+	epoch.Set("nextPayment", date(1970, 1, 1))
+	require.NoError(t, f.hub.SaveNoValidate(epoch))
+	noPeriod := f.createPayment(t, map[string]any{"nextPayment": due})
+	noPeriod.Set("period", "")
+	require.NoError(t, f.hub.SaveNoValidate(noPeriod))
+
+	count, err := f.hub.GetPaymentManager().AdvanceDuePayments(date(2030, 2, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	record, err := f.hub.FindRecordById("payments", healthy.Id)
+	require.NoError(t, err)
+	assert.Equal(t, date(2030, 2, 15), record.GetDateTime("nextPayment").Time())
+
+	for id, expected := range map[string]time.Time{epoch.Id: date(1970, 1, 1), noPeriod.Id: due} {
+		record, err := f.hub.FindRecordById("payments", id)
+		require.NoError(t, err)
+		assert.Equal(t, expected, record.GetDateTime("nextPayment").Time())
+		history, err := f.hub.FindAllRecords("payment_history", dbx.HashExp{"payment": id})
+		require.NoError(t, err)
+		assert.Empty(t, history)
+	}
+}
@@ -0,0 +1,63 @@
+package payments
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// parseIntParam reads a positive integer query parameter, returning def if it is absent
+func parseIntParam(e *core.RequestEvent, name string, def, max int) (int, error) {
+	value := e.Request.URL.Query().Get(name)
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > max {
+		return 0, fmt.Errorf("%s must be an integer between 1 and %d", name, max)
+	}
+	return n, nil
+}
+
+// parseDateRange reads the optional from and to query parameters, YYYY-MM-DD dates in UTC.
+// Both are inclusive, so to is returned as the last millisecond of its day. A missing bound is zero.
+func parseDateRange(e *core.RequestEvent) (from, to time.Time, err error) {
+	query := e.Request.URL.Query()
+	if value := query.Get("from"); value != "" {
+		if from, err = time.Parse(time.DateOnly, value); err != nil {
+			return from, to, fmt.Errorf("from must be a date in YYYY-MM-DD format")
+		}
+	}
+	if value := query.Get("to"); value != "" {
+		if to, err = time.Parse(time.DateOnly, value); err != nil {
+			return from, to, fmt.Errorf("to must be a date in YYYY-MM-DD format")
+		}
+		to = to.AddDate(0, 0, 1).Add(-time.Millisecond)
+	}
+	if !from.IsZero() && !to.IsZero() && from.After(to) {
+		return from, to, fmt.Errorf("from must not be after to")
+	}
+	return from, to, nil
+}
+
+// includeArchived reports whether the request asked for archived payments with ?includeArchived=true
+func includeArchived(e *core.RequestEvent) bool {
+	return e.Request.URL.Query().Get("includeArchived") == "true"
+}
+
+// requestBase returns the base currency query parameter, or the user's default currency if it is absent.
+// An empty base parameter opts out of the default.
+func requestBase(e *core.RequestEvent) string {
+	if e.Request.URL.Query().Has("base") {
+		return e.Request.URL.Query().Get("base")
+	}
+	return loadPaymentSettings(e.App, e.Auth.Id).DefaultCurrency
+}
+
+// findUserPayment returns the payment with the id if it belongs to the user
+func findUserPayment(app core.App, userID, id string) (*core.Record, error) {
+	return app.FindFirstRecordByFilter("payments", "id = {:id} && user = {:user}", dbx.Params{"id": id, "user": userID})
+}
@@ -0,0 +1,77 @@
+package payments
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ArchivePayment handles POST /api/beszel/payments/{id}/archive requests.
+// Archiving keeps the payment and its history but excludes it from totals and reminders.
+func (pm *PaymentManager) ArchivePayment(e *core.RequestEvent) error {
+	return setArchived(e, true)
+}
+
+// UnarchivePayment handles POST /api/beszel/payments/{id}/unarchive requests
+func (pm *PaymentManager) UnarchivePayment(e *core.RequestEvent) error {
+	return setArchived(e, false)
+}
+
+func setArchived(e *core.RequestEvent, archived bool) error {
+	record, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	if archived {
+		record.Set("archivedAt", time.Now().UTC())
+	} else {
+		record.Set("archivedAt", "")
+	}
+	if err := e.App.SaveWithContext(withActor(e), record); err != nil {
+		return e.BadRequestError("Failed to update payment", err)
+	}
+	return e.JSON(http.StatusOK, record)
+}
+
+// ArchiveProviderPayments handles POST /api/beszel/providers/{id}/archive-payments requests.
+// Archives all payments of the user's provider in a single transaction and returns how many
+// were archived; payments that were already archived keep their archivedAt.
+// With deleteProvider=true the provider is deleted too. Payments are deleted along with their
+// provider, so this also removes the payments and their history.
+func (pm *PaymentManager) ArchiveProviderPayments(e *core.RequestEvent) error {
+	provider, err := e.App.FindFirstRecordByFilter("providers", "id = {:id} && user = {:user}",
+		dbx.Params{"id": e.Request.PathValue("id"), "user": e.Auth.Id})
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	records, err := e.App.FindAllRecords("payments", dbx.HashExp{"provider": provider.Id, "user": e.Auth.Id})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	deleteProvider := e.Request.URL.Query().Get("deleteProvider") == "true"
+
+	now := time.Now().UTC()
+	archived := 0
+	err = e.App.RunInTransaction(func(txApp core.App) error {
+		for _, record := range records {
+			if !record.GetDateTime("archivedAt").IsZero() {
+				continue
+			}
+			record.Set("archivedAt", now)
+			if err := txApp.SaveWithContext(withActor(e), record); err != nil {
+				return err
+			}
+			archived++
+		}
+		if deleteProvider {
+			return txApp.DeleteWithContext(withActor(e), provider)
+		}
+		return nil
+	})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, map[string]any{"archived": archived, "providerDeleted": deleteProvider})
+}
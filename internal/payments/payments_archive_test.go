@@ -0,0 +1,263 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArchiveApi(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	payment := f.createPayment(t, map[string]any{"amount": 10})
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/archive",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user can't archive",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/archive",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", payment.Id)
+				require.NoError(t, err)
+				assert.True(t, record.GetDateTime("archivedAt").IsZero())
+			},
+		},
+		{
+			Name:            "archive",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/archive",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"id":"` + payment.Id + `"`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", payment.Id)
+				require.NoError(t, err)
+				assert.False(t, record.GetDateTime("archivedAt").IsZero())
+			},
+		},
+		{
+			Name:               "archived payments are hidden from the list",
+			Method:             http.MethodGet,
+			URL:                "/api/collections/payments/records",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"totalItems":0`},
+			NotExpectedContent: []string{payment.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "archived payments are listed with includeArchived",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/payments/records?includeArchived=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{payment.Id},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "archived payments can still be viewed",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{payment.Id},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "archived payments are left out of the summary",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "summary with includeArchived",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?includeArchived=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"USD":10`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "unarchive",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/unarchive",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"archivedAt":""`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", payment.Id)
+				require.NoError(t, err)
+				assert.True(t, record.GetDateTime("archivedAt").IsZero())
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestArchiveProviderPayments(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	first := f.createPayment(t, nil)
+	second := f.createPayment(t, map[string]any{"status": "cancelled"})
+	archivedAt := time.Date(2029, 6, 1, 0, 0, 0, 0, time.UTC)
+	archived := f.createPayment(t, map[string]any{"archivedAt": archivedAt})
+	otherProvider := createProvider(t, f.hub, f.user, "OVH")
+	other := f.createPayment(t, map[string]any{"provider": otherProvider.Id})
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + f.provider.Id + "/archive-payments",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + f.provider.Id + "/archive-payments",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "archive",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + f.provider.Id + "/archive-payments",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"archived":2`, `"providerDeleted":false`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				for _, id := range []string{first.Id, second.Id} {
+					record, err := app.FindRecordById("payments", id)
+					require.NoError(t, err)
+					assert.False(t, record.GetDateTime("archivedAt").IsZero())
+				}
+				record, err := app.FindRecordById("payments", archived.Id)
+				require.NoError(t, err)
+				assert.Equal(t, archivedAt, record.GetDateTime("archivedAt").Time())
+
+				record, err = app.FindRecordById("payments", other.Id)
+				require.NoError(t, err)
+				assert.True(t, record.GetDateTime("archivedAt").IsZero())
+			},
+		},
+		{
+			Name:            "archive and delete the provider",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + otherProvider.Id + "/archive-payments?deleteProvider=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"archived":1`, `"providerDeleted":true`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				_, err := app.FindRecordById("providers", otherProvider.Id)
+				assert.Error(t, err)
+				_, err = app.FindRecordById("payments", other.Id)
+				assert.Error(t, err, "payments are deleted along with their provider")
+				_, err = app.FindRecordById("providers", f.provider.Id)
+				assert.NoError(t, err)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestUpcomingExcludesArchived(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Now().UTC()
+	active := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 2)})
+	archived := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 3), "archivedAt": now})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:               "archived excluded by default",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/upcoming",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{active.Id},
+			NotExpectedContent: []string{archived.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "includeArchived",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/upcoming?includeArchived=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{active.Id, archived.Id},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestAdvanceSkipsArchived(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	due := time.Date(2030, 1, 15, 0, 0, 0, 0, time.UTC)
+	archived := f.createPayment(t, map[string]any{"nextPayment": due, "archivedAt": due.AddDate(0, 0, -1)})
+
+	count, err := f.hub.GetPaymentManager().AdvanceDuePayments(due.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	record, err := f.hub.FindRecordById("payments", archived.Id)
+	require.NoError(t, err)
+	assert.Equal(t, due, record.GetDateTime("nextPayment").Time())
+}
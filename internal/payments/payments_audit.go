@@ -0,0 +1,149 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// collections whose changes are recorded in the audit log
+var auditedCollections = []string{"payments", "providers"}
+
+// context key carrying the id of the user a change is made by
+type auditActorKey struct{}
+
+// withActor returns the request context marked with the authenticated user as the author of
+// the changes saved with it. Superusers aren't users, so their changes have no actor.
+func withActor(e *core.RequestEvent) context.Context {
+	if e.Auth == nil || e.Auth.Collection().Name != "users" {
+		return e.Request.Context()
+	}
+	return context.WithValue(e.Request.Context(), auditActorKey{}, e.Auth.Id)
+}
+
+// fieldChange is the old and new value of a field in an audit entry.
+// Old is left out for created records and New for deleted ones.
+type fieldChange struct {
+	Old any `json:"old,omitempty"`
+	New any `json:"new,omitempty"`
+}
+
+// bindAudit records every save and delete of the audited collections in the audit log,
+// including the ones made by cron jobs. Updates that don't change any field aren't recorded, and
+// neither are direct database updates.
+func (pm *PaymentManager) bindAudit() {
+	// the collection API saves without the request context, so its author is tracked by record
+	pm.app.OnRecordCreateRequest(auditedCollections...).BindFunc(pm.trackAuditActor)
+	pm.app.OnRecordUpdateRequest(auditedCollections...).BindFunc(pm.trackAuditActor)
+	pm.app.OnRecordDeleteRequest(auditedCollections...).BindFunc(pm.trackAuditActor)
+
+	pm.app.OnRecordCreate(auditedCollections...).BindFunc(func(e *core.RecordEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+		pm.audit(e, AuditCreate, nil, e.Record)
+		return nil
+	})
+	pm.app.OnRecordUpdate(auditedCollections...).BindFunc(func(e *core.RecordEvent) error {
+		original := e.Record.Original()
+		if err := e.Next(); err != nil {
+			return err
+		}
+		pm.audit(e, AuditUpdate, original, e.Record)
+		return nil
+	})
+	pm.app.OnRecordDelete(auditedCollections...).BindFunc(func(e *core.RecordEvent) error {
+		if err := e.Next(); err != nil {
+			return err
+		}
+		pm.audit(e, AuditDelete, e.Record, nil)
+		return nil
+	})
+}
+
+// trackAuditActor remembers the user of a collection API request as the author of the record's change
+func (pm *PaymentManager) trackAuditActor(e *core.RecordRequestEvent) error {
+	if e.Auth == nil || e.Auth.Collection().Name != "users" {
+		return e.Next()
+	}
+	pm.auditActors.Store(e.Record, e.Auth.Id)
+	defer pm.auditActors.Delete(e.Record)
+	return e.Next()
+}
+
+// auditActor returns the id of the user making the change, or an empty string if it
+// wasn't made on behalf of a user
+func (pm *PaymentManager) auditActor(e *core.RecordEvent) string {
+	if actor, ok := pm.auditActors.Load(e.Record); ok {
+		return actor.(string)
+	}
+	if e.Context == nil {
+		return ""
+	}
+	actor, _ := e.Context.Value(auditActorKey{}).(string)
+	return actor
+}
+
+// auditChanges returns the fields that differ between the old and new version of a record.
+// Either can be nil for created and deleted records. The id and autodate fields are left out.
+func auditChanges(collection *core.Collection, old, new *core.Record) map[string]fieldChange {
+	changes := make(map[string]fieldChange)
+	for _, field := range collection.Fields {
+		name := field.GetName()
+		if name == core.FieldNameId || field.Type() == core.FieldTypeAutodate {
+			continue
+		}
+		var change fieldChange
+		if old != nil {
+			change.Old = old.Get(name)
+		}
+		if new != nil {
+			change.New = new.Get(name)
+		}
+		if old != nil && new != nil && sameValue(change.Old, change.New) {
+			continue
+		}
+		changes[name] = change
+	}
+	return changes
+}
+
+// sameValue reports whether two field values are stored the same way
+func sameValue(a, b any) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aJSON) == string(bJSON)
+}
+
+// audit adds an entry for a change of the event's record to the audit log.
+// Failing to record it is logged without failing the change.
+func (pm *PaymentManager) audit(e *core.RecordEvent, action string, old, new *core.Record) {
+	changes := auditChanges(e.Record.Collection(), old, new)
+	if len(changes) == 0 {
+		return
+	}
+	collection, err := e.App.FindCachedCollectionByNameOrId("audit_log")
+	if err != nil {
+		e.App.Logger().Error("Failed to record audit entry", "record", e.Record.Id, "err", err)
+		return
+	}
+	entry := core.NewRecord(collection)
+	entry.Set("actor", pm.auditActor(e))
+	entry.Set("collection", e.Record.Collection().Name)
+	entry.Set("recordId", e.Record.Id)
+	entry.Set("action", action)
+	entry.Set("changedFields", changes)
+	entry.Set("at", time.Now().UTC())
+	if err := e.App.Save(entry); err != nil {
+		e.App.Logger().Error("Failed to record audit entry", "record", e.Record.Id, "err", err)
+	}
+}
@@ -0,0 +1,134 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findAuditEntries returns the audit entries of a record, oldest first
+func findAuditEntries(t testing.TB, app core.App, recordID string) []*core.Record {
+	entries, err := app.FindRecordsByFilter("audit_log", "recordId = {:id}", "at", 0, 0, dbx.Params{"id": recordID})
+	require.NoError(t, err)
+	return entries
+}
+
+func TestAuditLog(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	superuser, err := beszelTests.CreateRecord(f.hub, core.CollectionNameSuperusers, map[string]any{
+		"email":    "superuser@example.com",
+		"password": "password123",
+	})
+	require.NoError(t, err)
+	superuserToken, err := superuser.NewAuthToken()
+	require.NoError(t, err)
+
+	payment := f.createPayment(t, nil)
+	provider := createProvider(t, f.hub, f.user, "OVH")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "users can't read the audit log",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/audit_log/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"Only superusers"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "update through the collection api",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Body:            jsonReader(map[string]any{"amount": 12.5, "notes": "new plan"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"amount":12.5`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				// the first entry is the creation by the test
+				entries := findAuditEntries(t, app, payment.Id)
+				require.Len(t, entries, 2)
+				assert.Equal(t, f.user.Id, entries[1].GetString("actor"))
+				assert.Equal(t, "payments", entries[1].GetString("collection"))
+				assert.Equal(t, "update", entries[1].GetString("action"))
+				assert.JSONEq(t,
+					`{"amount":{"old":10,"new":12.5},"notes":{"old":"","new":"new plan"}}`,
+					entries[1].GetString("changedFields"))
+			},
+		},
+		{
+			Name:            "archive through a custom route",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/archive",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"archivedAt":"20`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				entries := findAuditEntries(t, app, payment.Id)
+				require.Len(t, entries, 3)
+				assert.Equal(t, f.user.Id, entries[2].GetString("actor"))
+				assert.Contains(t, entries[2].GetString("changedFields"), `"archivedAt":{"old":"","new":"20`)
+			},
+		},
+		{
+			Name:           "delete a provider",
+			Method:         http.MethodDelete,
+			URL:            "/api/collections/providers/records/" + provider.Id,
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 204,
+			TestAppFactory: testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				entries := findAuditEntries(t, app, provider.Id)
+				require.Len(t, entries, 2)
+				// created by the test directly, so without an actor
+				assert.Equal(t, "create", entries[0].GetString("action"))
+				assert.Empty(t, entries[0].GetString("actor"))
+				assert.Contains(t, entries[0].GetString("changedFields"), `"name":{"new":"OVH"}`)
+
+				assert.Equal(t, "delete", entries[1].GetString("action"))
+				assert.Equal(t, f.user.Id, entries[1].GetString("actor"))
+				assert.Contains(t, entries[1].GetString("changedFields"), `"name":{"old":"OVH"}`)
+			},
+		},
+		{
+			Name:            "superusers can read the audit log",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/audit_log/records?filter=" + "recordId='" + payment.Id + "'",
+			Headers:         map[string]string{"Authorization": superuserToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":3`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestAuditLogSkipsUnchangedSaves(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	provider, err := f.hub.FindRecordById("providers", f.provider.Id)
+	require.NoError(t, err)
+	before := findAuditEntries(t, f.hub, provider.Id)
+	require.NoError(t, f.hub.Save(provider))
+	assert.Len(t, findAuditEntries(t, f.hub, provider.Id), len(before))
+}
@@ -0,0 +1,87 @@
+package payments
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// budgetStatus is the spend of a budget's period compared to its limit
+type budgetStatus struct {
+	Id       string  `json:"id"`
+	Name     string  `json:"name"`
+	Limit    float64 `json:"limit"`
+	Currency string  `json:"currency"`
+	Period   string  `json:"period"`
+	Spent    float64 `json:"spent"`
+	Over     bool    `json:"over"`
+	OverBy   float64 `json:"overBy"`
+}
+
+// evaluateBudget compares the monthly totals, scaled to the budget's period with norm, against its limit.
+// Totals that couldn't be converted into the budget's currency are recorded by conv.
+func evaluateBudget(budget *core.Record, totals map[string]float64, conv *converter, norm normalization) budgetStatus {
+	status := budgetStatus{
+		Id:       budget.Id,
+		Name:     budget.GetString("name"),
+		Limit:    budget.GetFloat("limit"),
+		Currency: budget.GetString("currency"),
+		Period:   budget.GetString("period"),
+	}
+	monthly := conv.convertTotals(totals, status.Currency)
+	// a monthly factor converts one period to a month, so dividing by it converts a month to one period
+	factor, ok := norm.factor(status.Period)
+	if !ok {
+		factor = 1
+	}
+	status.Spent = roundAmount(monthly/factor, status.Currency)
+	if status.Spent > status.Limit {
+		status.Over = true
+		status.OverBy = roundAmount(status.Spent-status.Limit, status.Currency)
+	}
+	return status
+}
+
+// budgetStatuses evaluates each of the user's budgets against the monthly totals of the user's
+// payments among records, normalized like the summary. Archived payments are left out.
+// Totals that couldn't be converted into a budget's currency are recorded by conv.
+func budgetStatuses(app core.App, userID string, records []*core.Record, now time.Time, conv *converter) ([]budgetStatus, error) {
+	budgets, err := app.FindAllRecords("budgets", dbx.HashExp{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]budgetStatus, 0, len(budgets))
+	if len(budgets) == 0 {
+		return statuses, nil
+	}
+	norm := loadNormalization(app, now)
+	totals := monthlyTotals(withoutArchived(records), now, norm)
+	for _, budget := range budgets {
+		statuses = append(statuses, evaluateBudget(budget, totals, conv, norm))
+	}
+	return statuses, nil
+}
+
+// GetBudgetStatus handles GET /api/beszel/budgets/status requests.
+// Returns each of the user's budgets with the normalized spend for its period and currency,
+// or 422 with the missing pairs if any spend can't be converted into a budget's currency.
+func (pm *PaymentManager) GetBudgetStatus(e *core.RequestEvent) error {
+	records, err := findUserPayments(e.App, e.Auth.Id)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	statuses, err := budgetStatuses(e.App, e.Auth.Id, records, time.Now().UTC(), conv)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	if err := conv.err(); err != nil {
+		return convertError(e, err)
+	}
+	return e.JSON(http.StatusOK, statuses)
+}
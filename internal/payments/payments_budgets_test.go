@@ -0,0 +1,123 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type budgetStatusResponse struct {
+	Id     string  `json:"id"`
+	Spent  float64 `json:"spent"`
+	Over   bool    `json:"over"`
+	OverBy float64 `json:"overBy"`
+}
+
+func TestBudgetStatusApi(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.createPayment(t, map[string]any{"amount": 10, "currency": "USD"})
+	f.createPayment(t, map[string]any{"amount": 3000, "currency": "RUB"})
+	f.createPayment(t, map[string]any{"amount": 5, "currency": "EUR"})
+	setRate(t, f.hub, "USD", "RUB", 100)
+
+	monthlyUsd, err := beszelTests.CreateRecord(f.hub, "budgets", map[string]any{
+		"user": f.user.Id, "name": "Monthly", "limit": 30, "currency": "USD", "period": "monthly",
+	})
+	require.NoError(t, err)
+	annualRub, err := beszelTests.CreateRecord(f.hub, "budgets", map[string]any{
+		"user": f.user.Id, "name": "Yearly", "limit": 100000, "currency": "RUB", "period": "annual",
+	})
+	require.NoError(t, err)
+
+	_, emptyToken := createUserWithToken(t, f.hub, "nobudgets@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/budgets/status",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "no budgets returns empty list",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/budgets/status",
+			Headers:         map[string]string{"Authorization": emptyToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"[]"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "create a budget for another user",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/budgets/records",
+			Headers:         map[string]string{"Authorization": emptyToken},
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "name": "planted", "limit": 1, "currency": "USD", "period": "monthly"}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"Failed to create record"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "spend that can't be converted",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/budgets/status",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"missing":[{"base":"EUR","quote":"RUB"},{"base":"EUR","quote":"USD"}]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "budget statuses",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/budgets/status",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{monthlyUsd.Id, annualRub.Id},
+			TestAppFactory:  testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, f.hub, "EUR", "USD", 2)
+				setRate(t, f.hub, "EUR", "RUB", 200)
+			},
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				var statuses []budgetStatusResponse
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&statuses))
+				require.Len(t, statuses, 2)
+				byId := map[string]budgetStatusResponse{}
+				for _, status := range statuses {
+					byId[status.Id] = status
+				}
+
+				monthly := byId[monthlyUsd.Id]
+				assert.InDelta(t, 50, monthly.Spent, 0.001)
+				assert.True(t, monthly.Over)
+				assert.InDelta(t, 20, monthly.OverBy, 0.001)
+
+				annual := byId[annualRub.Id]
+				assert.InDelta(t, 60000, annual.Spent, 0.001)
+				assert.False(t, annual.Over)
+				assert.Zero(t, annual.OverBy)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
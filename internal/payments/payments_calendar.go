@@ -0,0 +1,188 @@
+package payments
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RRULE frequency for each period
+var periodRecurrence = map[string]string{
+	PeriodDaily:      "FREQ=DAILY",
+	PeriodWeekly:     "FREQ=WEEKLY",
+	PeriodMonthly:    "FREQ=MONTHLY",
+	PeriodQuarterly:  "FREQ=MONTHLY;INTERVAL=3",
+	PeriodSemiannual: "FREQ=MONTHLY;INTERVAL=6",
+	PeriodAnnual:     "FREQ=YEARLY",
+}
+
+// calendarToken returns a token identifying the user for the calendar feed.
+//
+// The signature is keyed with the user's tokenKey, so changing the password
+// (or otherwise invalidating sessions) also revokes existing calendar links.
+func calendarToken(user *core.Record) string {
+	return user.Id + "." + calendarSignature(user)
+}
+
+func calendarSignature(user *core.Record) string {
+	mac := hmac.New(sha256.New, []byte(user.TokenKey()+user.Collection().AuthToken.Secret))
+	mac.Write([]byte("payments-calendar:" + user.Id))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// findCalendarUser returns the user a calendar token belongs to
+func findCalendarUser(app core.App, token string) (*core.Record, error) {
+	userID, signature, ok := strings.Cut(token, ".")
+	if !ok || userID == "" || signature == "" {
+		return nil, errors.New("malformed token")
+	}
+	user, err := app.FindRecordById("users", userID)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(signature), []byte(calendarSignature(user))) {
+		return nil, errors.New("invalid signature")
+	}
+	return user, nil
+}
+
+// recurrenceRule returns the RRULE value for a payment. Month based periods billed after
+// the 28th pick the last existing day up to the anchor, matching how the schedule clamps.
+// One-time payments get an empty rule, as a single event doesn't recur.
+func recurrenceRule(record *core.Record, start time.Time, anchorDay int) (string, bool) {
+	period := record.GetString("period")
+	if period == PeriodOnce {
+		return "", true
+	}
+	rule, ok := periodRecurrence[period]
+	_, monthly := periodMonths[period]
+	if period == PeriodCustom {
+		days, months, valid := customInterval(record)
+		if !valid {
+			return "", false
+		}
+		if days > 0 {
+			return fmt.Sprintf("FREQ=DAILY;INTERVAL=%d", days), true
+		}
+		rule, ok, monthly = fmt.Sprintf("FREQ=MONTHLY;INTERVAL=%d", months), true, true
+	}
+	if !ok {
+		return "", false
+	}
+	if !monthly || anchorDay <= 28 {
+		return rule, true
+	}
+	days := make([]string, 0, 4)
+	for day := 28; day <= anchorDay; day++ {
+		days = append(days, fmt.Sprint(day))
+	}
+	if period == PeriodAnnual {
+		rule += fmt.Sprintf(";BYMONTH=%d", start.Month())
+	}
+	return rule + ";BYMONTHDAY=" + strings.Join(days, ",") + ";BYSETPOS=-1", true
+}
+
+// escapeText escapes a value for use in an iCalendar TEXT property
+func escapeText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\n", `\n`).Replace(s)
+}
+
+// writeLine writes a content line folded at 75 octets as required by RFC 5545
+func writeLine(b *strings.Builder, line string) {
+	for len(line) > 75 {
+		cut := 75
+		// don't split multi-byte characters
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// buildCalendar renders the payments as a VCALENDAR with one recurring event per payment,
+// or a single event for one-time payments
+func buildCalendar(records []*core.Record, now time.Time) string {
+	var b strings.Builder
+	writeLine(&b, "BEGIN:VCALENDAR")
+	writeLine(&b, "VERSION:2.0")
+	writeLine(&b, "PRODID:-//Beszel//Payments//EN")
+	writeLine(&b, "CALSCALE:GREGORIAN")
+	writeLine(&b, "X-WR-CALNAME:Beszel payments")
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, record := range records {
+		start := record.GetDateTime("nextPayment").Time()
+		if start.IsZero() {
+			continue
+		}
+		// the first charge of a payment in trial is at the end of the trial
+		if inTrial(record, now) {
+			start = record.GetDateTime("trialEndsAt").Time()
+		}
+		anchorDay := record.GetInt("billingDay")
+		if anchorDay == 0 {
+			anchorDay = start.Day()
+		}
+		rule, ok := recurrenceRule(record, start, anchorDay)
+		if !ok {
+			continue
+		}
+		name := record.GetString("provider")
+		if provider := record.ExpandedOne("provider"); provider != nil {
+			name = provider.GetString("name")
+		}
+		summary := fmt.Sprintf("%s %.2f %s", name, record.GetFloat("amount"), record.GetString("currency"))
+
+		writeLine(&b, "BEGIN:VEVENT")
+		writeLine(&b, "UID:"+record.Id+"@beszel")
+		writeLine(&b, "DTSTAMP:"+stamp)
+		writeLine(&b, "DTSTART;VALUE=DATE:"+start.Format("20060102"))
+		if rule != "" {
+			writeLine(&b, "RRULE:"+rule)
+		}
+		writeLine(&b, "SUMMARY:"+escapeText(summary))
+		writeLine(&b, "END:VEVENT")
+	}
+	writeLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+// GetCalendarToken handles GET /api/beszel/payments/calendar-token requests.
+// Returns the token to use with the calendar feed, since calendar clients can't send auth headers.
+func (pm *PaymentManager) GetCalendarToken(e *core.RequestEvent) error {
+	if e.Auth.Collection().Name != "users" {
+		return e.ForbiddenError("Calendar feeds are only available to users", nil)
+	}
+	return e.JSON(http.StatusOK, map[string]string{"token": calendarToken(e.Auth)})
+}
+
+// GetCalendar handles GET /api/beszel/payments/calendar.ics requests.
+// Authenticated with the token query parameter instead of the session.
+func (pm *PaymentManager) GetCalendar(e *core.RequestEvent) error {
+	user, err := findCalendarUser(e.App, e.Request.URL.Query().Get("token"))
+	if err != nil {
+		return e.UnauthorizedError("Invalid calendar token", nil)
+	}
+	records, err := findUserPayments(e.App, user.Id)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	records = withoutArchived(records)
+	records = slices.DeleteFunc(records, isPaused)
+	if errs := e.App.ExpandRecords(records, []string{"provider"}, nil); len(errs) > 0 {
+		requestid.Logger(e).Warn("Failed to expand payment providers", "errs", errs)
+	}
+	e.Response.Header().Set("Content-Disposition", `inline; filename="payments.ics"`)
+	return e.Blob(http.StatusOK, "text/calendar; charset=utf-8", []byte(buildCalendar(records, time.Now().UTC())))
+}
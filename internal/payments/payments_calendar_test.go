@@ -0,0 +1,117 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendarApi(t *testing.T) {
+	t.Setenv("BESZEL_HUB_NOTES_ENCRYPTION_KEY", "correct horse battery staple")
+	f := newPaymentFixture(t)
+
+	monthly := f.createPayment(t, map[string]any{"nextPayment": "2030-01-31 00:00:00.000Z", "amount": 5, "notes": "IBAN DE89 3704 0044 0532 0130 00"})
+	quarterly := f.createPayment(t, map[string]any{"nextPayment": "2030-02-10 00:00:00.000Z", "period": "quarterly"})
+	semiannual := f.createPayment(t, map[string]any{"nextPayment": "2030-03-10 00:00:00.000Z", "period": "semiannual"})
+	annual := f.createPayment(t, map[string]any{"nextPayment": "2032-02-29 00:00:00.000Z", "period": "annual", "billingDay": 29})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	// get the token through the api
+	var token string
+	(&beszelTests.ApiScenario{
+		Name:            "get calendar token",
+		Method:          http.MethodGet,
+		URL:             "/api/beszel/payments/calendar-token",
+		Headers:         map[string]string{"Authorization": f.token},
+		ExpectedStatus:  200,
+		ExpectedContent: []string{`"token":"` + f.user.Id + `.`},
+		TestAppFactory:  testAppFactory,
+		AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+			var body struct {
+				Token string `json:"token"`
+			}
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			token = body.Token
+		},
+	}).Test(t)
+	require.NotEmpty(t, token)
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "calendar token requires auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/calendar-token",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "missing token",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/calendar.ics",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"Invalid calendar token"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "tampered token",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/calendar.ics?token=" + f.user.Id + ".deadbeef",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"Invalid calendar token"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "calendar feed",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/calendar.ics?token=" + token,
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				"BEGIN:VCALENDAR\r\n",
+				"UID:" + monthly.Id + "@beszel\r\nDTSTAMP:",
+				"DTSTART;VALUE=DATE:20300131\r\nRRULE:FREQ=MONTHLY;BYMONTHDAY=28,29,30,31;BYSETPOS=-1\r\nSUMMARY:Hetzner 5.00 USD",
+				"UID:" + quarterly.Id + "@beszel",
+				"RRULE:FREQ=MONTHLY;INTERVAL=3\r\n",
+				"UID:" + semiannual.Id + "@beszel",
+				"RRULE:FREQ=MONTHLY;INTERVAL=6\r\n",
+				"UID:" + annual.Id + "@beszel",
+				"RRULE:FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=28,29;BYSETPOS=-1\r\n",
+				"END:VCALENDAR\r\n",
+			},
+			// calendar clients cache the feed, so notes are left out of it
+			NotExpectedContent: []string{"DESCRIPTION:", "IBAN", "enc:v1:"},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Equal(t, "text/calendar; charset=utf-8", res.Header.Get("Content-Type"))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+
+	// changing the password invalidates the token
+	f.user.SetPassword("newpassword123")
+	require.NoError(t, f.hub.Save(f.user))
+	(&beszelTests.ApiScenario{
+		Name:            "token revoked after password change",
+		Method:          http.MethodGet,
+		URL:             "/api/beszel/payments/calendar.ics?token=" + token,
+		ExpectedStatus:  401,
+		ExpectedContent: []string{"Invalid calendar token"},
+		TestAppFactory:  testAppFactory,
+	}).Test(t)
+}
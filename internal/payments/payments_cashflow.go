@@ -0,0 +1,147 @@
+package payments
+
+import (
+	"cmp"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// cashflowRow is the projected outflow of one month in one currency
+type cashflowRow struct {
+	Month    string  `json:"month"`
+	Total    float64 `json:"total"`
+	Currency string  `json:"currency"`
+}
+
+// format of the month keys of the cashflow projection
+const cashflowMonthLayout = "2006-01"
+
+// firstCharge returns the date the payment is charged next and the billing day its
+// schedule keeps, or a zero time if it has no nextPayment. Payments in trial are
+// first charged when the trial ends.
+func firstCharge(record *core.Record) (time.Time, int) {
+	next := record.GetDateTime("nextPayment").Time()
+	if next.IsZero() {
+		return next, 0
+	}
+	anchorDay := record.GetInt("billingDay")
+	if trialEnds := record.GetDateTime("trialEndsAt").Time(); !trialEnds.IsZero() && next.Before(trialEnds) {
+		next = trialEnds
+		anchorDay = trialEnds.Day()
+	}
+	if anchorDay == 0 {
+		anchorDay = next.Day()
+	}
+	return next, anchorDay
+}
+
+// projectCharges returns the dates the payment will be charged from its next
+// charge until before end, skipping the ones before start. One-time payments
+// have at most one charge.
+func projectCharges(record *core.Record, start, end time.Time) ([]time.Time, error) {
+	next, anchorDay := firstCharge(record)
+	if next.IsZero() {
+		return nil, nil
+	}
+	var charges []time.Time
+	for next.Before(end) {
+		if !next.Before(start) {
+			charges = append(charges, next)
+		}
+		var err error
+		if next, err = addPaymentPeriod(record, next, anchorDay); errors.Is(err, errNotRecurring) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return charges, nil
+}
+
+// projectCashflow sums the charges of each payment by month and currency for the
+// given number of months, starting with the month containing now.
+func projectCashflow(logger *slog.Logger, records []*core.Record, now time.Time, months int) map[string]map[string]float64 {
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, months, 0)
+	buckets := make(map[string]map[string]float64, months)
+	for i := range months {
+		buckets[start.AddDate(0, i, 0).Format(cashflowMonthLayout)] = make(map[string]float64)
+	}
+	for _, record := range records {
+		if isPaused(record) {
+			continue
+		}
+		charges, err := projectCharges(record, start, end)
+		if err != nil {
+			logger.Warn("Failed to project payment", "id", record.Id, "err", err)
+			continue
+		}
+		for _, charge := range charges {
+			buckets[charge.UTC().Format(cashflowMonthLayout)][record.GetString("currency")] += effectiveAmount(record, charge)
+		}
+	}
+	return buckets
+}
+
+// GetCashflow handles GET /api/beszel/payments/cashflow requests.
+// Projects the user's charges for the coming months (default 12, max 60) from
+// each payment's nextPayment and returns the total of each month, oldest first.
+// Unlike the summary, a payment is counted in full in the months it is charged.
+// Totals are returned per currency, or converted to the currency in the optional
+// base query parameter (the user's default currency if absent).
+func (pm *PaymentManager) GetCashflow(e *core.RequestEvent) error {
+	months, err := parseIntParam(e, "months", 12, 60)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	base := requestBase(e)
+	if base != "" && !isCurrency(base) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid base currency"})
+	}
+
+	records, err := findUserPayments(e.App, e.Auth.Id)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	buckets := projectCashflow(requestid.Logger(e), withoutArchived(records), time.Now().UTC(), months)
+
+	rows := make([]cashflowRow, 0, len(buckets))
+	if base == "" {
+		// every month gets a row for each currency so charts have no gaps
+		var currencies []string
+		for _, totals := range buckets {
+			for currency := range totals {
+				if !slices.Contains(currencies, currency) {
+					currencies = append(currencies, currency)
+				}
+			}
+		}
+		for month, totals := range buckets {
+			for _, currency := range currencies {
+				rows = append(rows, cashflowRow{Month: month, Total: roundAmount(totals[currency], currency), Currency: currency})
+			}
+		}
+	} else {
+		conv, err := pm.newConverter(e.App)
+		if err != nil {
+			return e.InternalServerError("", err)
+		}
+		for month, totals := range buckets {
+			rows = append(rows, cashflowRow{Month: month, Total: roundAmount(conv.convertTotals(totals, base), base), Currency: base})
+		}
+		if err := conv.err(); err != nil {
+			return convertError(e, err)
+		}
+	}
+	slices.SortFunc(rows, func(a, b cashflowRow) int {
+		return cmp.Or(cmp.Compare(a.Month, b.Month), cmp.Compare(a.Currency, b.Currency))
+	})
+	return e.JSON(http.StatusOK, rows)
+}
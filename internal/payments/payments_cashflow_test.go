@@ -0,0 +1,87 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+)
+
+func TestCashflowApi(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Now().UTC()
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	months := []string{start.Format("2006-01"), start.AddDate(0, 1, 0).Format("2006-01"), start.AddDate(0, 2, 0).Format("2006-01")}
+	f.createPayment(t, map[string]any{"amount": 10, "nextPayment": start.AddDate(0, 1, 14)})
+	f.createPayment(t, map[string]any{"amount": 120, "currency": "EUR", "period": "annual", "nextPayment": start.AddDate(0, 2, 4)})
+	f.createPayment(t, map[string]any{"amount": 1000, "nextPayment": start.AddDate(0, 1, 1), "status": "paused"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "months out of range",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/cashflow?months=61",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"months must be an integer between 1 and 60"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "per currency",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/cashflow?months=3",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`[{"month":"` + months[0] + `","total":0,"currency":"EUR"},` +
+					`{"month":"` + months[0] + `","total":0,"currency":"USD"},` +
+					`{"month":"` + months[1] + `","total":0,"currency":"EUR"},` +
+					`{"month":"` + months[1] + `","total":10,"currency":"USD"},` +
+					`{"month":"` + months[2] + `","total":120,"currency":"EUR"},` +
+					`{"month":"` + months[2] + `","total":10,"currency":"USD"}]`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:            "missing rates",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/cashflow?months=3&base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"missing":[{"base":"EUR","quote":"USD"}]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "converted to base",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/cashflow?months=3&base=USD",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`[{"month":"` + months[0] + `","total":0,"currency":"USD"},` +
+					`{"month":"` + months[1] + `","total":10,"currency":"USD"},` +
+					`{"month":"` + months[2] + `","total":154,"currency":"USD"}]`,
+			},
+			TestAppFactory: testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "EUR", "USD", 1.2)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,153 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryGroupByCategory(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	work, err := beszelTests.CreateRecord(f.hub, "categories", map[string]any{"user": f.user.Id, "name": "work", "color": "#ff0000"})
+	require.NoError(t, err)
+	hosting, err := beszelTests.CreateRecord(f.hub, "categories", map[string]any{"user": f.user.Id, "name": "hosting"})
+	require.NoError(t, err)
+
+	f.createPayment(t, map[string]any{"amount": 10, "categories": []string{work.Id}})
+	f.createPayment(t, map[string]any{"amount": 12, "period": "annual", "categories": []string{work.Id, hosting.Id}})
+	f.createPayment(t, map[string]any{"amount": 300, "currency": "RUB"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "invalid groupBy",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?groupBy=color",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"invalid groupBy"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "grouped by category and currency",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/summary?groupBy=category",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"totals":{`,
+				`"` + work.Id + `":{"USD":11}`,
+				`"` + hosting.Id + `":{"USD":1}`,
+				`"__none__":{"RUB":300}`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:           "grouped by category with base currency",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/summary?groupBy=category&base=USD",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"base":"USD"`,
+				`"total":14`,
+				`"` + work.Id + `":11`,
+				`"__none__":3`,
+			},
+			TestAppFactory: testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "USD", "RUB", 100)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestDeleteCategoryKeepsPayment(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	category, err := beszelTests.CreateRecord(f.hub, "categories", map[string]any{"user": f.user.Id, "name": "personal"})
+	require.NoError(t, err)
+	payment := f.createPayment(t, map[string]any{"categories": []string{category.Id}})
+
+	require.NoError(t, f.hub.Delete(category))
+
+	record, err := f.hub.FindRecordById("payments", payment.Id)
+	require.NoError(t, err)
+	assert.Empty(t, record.GetStringSlice("categories"))
+}
+
+func TestCategoryColorPattern(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	_, err := beszelTests.CreateRecord(f.hub, "categories", map[string]any{"user": f.user.Id, "name": "bad", "color": "red"})
+	assert.Error(t, err)
+}
+
+func TestCategoryMustBelongToUser(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	otherUser, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+	otherCategory, err := beszelTests.CreateRecord(f.hub, "categories", map[string]any{"user": otherUser.Id, "name": "other secret"})
+	require.NoError(t, err)
+	payment := f.createPayment(t, nil)
+	// linked outside of the API, which doesn't check owners
+	f.createPayment(t, map[string]any{"categories": []string{otherCategory.Id}})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "another user's category",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"categories": []string{otherCategory.Id}}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_category_not_owned"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "create a category for another user",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/categories/records",
+			Headers:         map[string]string{"Authorization": otherToken},
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "name": "planted"}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"Failed to create record"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "summary doesn't name another user's category",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/summary?groupBy=category",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"` + otherCategory.Id + `":{"USD":10}`},
+			NotExpectedContent: []string{"other secret"},
+			TestAppFactory:     testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
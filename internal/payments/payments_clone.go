@@ -0,0 +1,55 @@
+package payments
+
+import (
+	"net/http"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// fields copied from a payment to its clone. The schedule state, status, discount,
+// trial and sharing are specific to a subscription and start anew.
+var clonedPaymentFields = []string{
+	"provider", "system", "amount", "currency", "period", "customIntervalDays", "customIntervalMonths",
+	"isFree", "categories", "paymentMethod", "country", "taxRate", "reminderDays", "graceDays", "reminderChannels", "notes",
+}
+
+// ClonePayment handles POST /api/beszel/payments/{id}/clone requests.
+// Creates a new payment of the user with the fields of an existing one and the
+// nextPayment in the body. History and price changes aren't copied.
+func (pm *PaymentManager) ClonePayment(e *core.RequestEvent) error {
+	var body struct {
+		NextPayment string `json:"nextPayment"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	nextPayment, err := types.ParseDateTime(body.NextPayment)
+	if err != nil || nextPayment.IsZero() {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "nextPayment must be a date"})
+	}
+	source, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	if err := pm.checkPaymentLimit(e, 1); err != nil {
+		return limitError(e, err)
+	}
+
+	clone := core.NewRecord(source.Collection())
+	clone.Set("user", source.GetString("user"))
+	for _, field := range clonedPaymentFields {
+		clone.Set(field, source.Get(field))
+	}
+	// the notes are encrypted again for the clone
+	pm.revealNotes(e.App, clone)
+	clone.Set("nextPayment", nextPayment)
+	prepareNewPayment(e.App, clone, SourceManual, true, true)
+	if err := validateRelationOwners(e.App, clone); err != nil {
+		return e.BadRequestError("Failed to clone payment.", err)
+	}
+	if err := e.App.SaveWithContext(withActor(e), clone); err != nil {
+		return e.BadRequestError("Failed to clone payment", err)
+	}
+	return e.JSON(http.StatusOK, clone)
+}
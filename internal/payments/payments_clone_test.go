@@ -0,0 +1,120 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClonePayment(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	category, err := beszelTests.CreateRecord(f.hub, "categories", map[string]any{"user": f.user.Id, "name": "hosting"})
+	require.NoError(t, err)
+	payment := f.createPayment(t, map[string]any{
+		"amount":         25,
+		"currency":       "EUR",
+		"period":         "annual",
+		"categories":     []string{category.Id},
+		"discountAmount": 5,
+		"snoozeUntil":    "2029-12-01 00:00:00.000Z",
+	})
+	// an amount change logs a price change, which the clone doesn't get
+	payment.Set("amount", 30)
+	require.NoError(t, f.hub.Save(payment))
+	_, err = beszelTests.CreateRecord(f.hub, "payment_history", map[string]any{
+		"payment":  payment.Id,
+		"user":     f.user.Id,
+		"amount":   25,
+		"currency": "EUR",
+		"paidAt":   "2029-01-15 00:00:00.000Z",
+	})
+	require.NoError(t, err)
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/clone",
+			Body:            jsonReader(map[string]any{"nextPayment": "2030-03-10 00:00:00.000Z"}),
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user's payment",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/clone",
+			Body:            jsonReader(map[string]any{"nextPayment": "2030-03-10 00:00:00.000Z"}),
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "nextPayment required",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/clone",
+			Body:            jsonReader(map[string]any{}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"nextPayment must be a date"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "clone",
+			Method:         http.MethodPost,
+			URL:            "/api/beszel/payments/" + payment.Id + "/clone",
+			Body:           jsonReader(map[string]any{"nextPayment": "2030-03-10 00:00:00.000Z"}),
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"amount":30`,
+				`"currency":"EUR"`,
+				`"period":"annual"`,
+				`"provider":"` + f.provider.Id + `"`,
+				`"categories":["` + category.Id + `"]`,
+				`"nextPayment":"2030-03-10 00:00:00.000Z"`,
+				`"discountAmount":0`,
+				`"snoozeUntil":""`,
+				`"billingDay":10`,
+			},
+			TestAppFactory: testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				var clone struct {
+					Id string `json:"id"`
+				}
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&clone))
+				assert.NotEqual(t, payment.Id, clone.Id)
+				history, err := app.FindAllRecords("payment_history", dbx.HashExp{"payment": clone.Id})
+				require.NoError(t, err)
+				assert.Empty(t, history)
+				changes, err := app.FindAllRecords("price_changes", dbx.HashExp{"payment": clone.Id})
+				require.NoError(t, err)
+				assert.Empty(t, changes)
+				changes, err = app.FindAllRecords("price_changes", dbx.HashExp{"payment": payment.Id})
+				require.NoError(t, err)
+				assert.Len(t, changes, 1)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,67 @@
+package payments
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// paymentsConfig holds the settings of the payments that apply to every user
+type paymentsConfig struct {
+	// how daily and weekly amounts are turned into monthly equivalents, one of the Normalization constants
+	Normalization string `json:"normalization"`
+}
+
+// findConfigRecord returns the single payments_config record
+func findConfigRecord(app core.App) (*core.Record, error) {
+	return app.FindFirstRecordByFilter("payments_config", "")
+}
+
+// loadConfig returns the payments config, with defaults if there is no config record
+func loadConfig(app core.App) paymentsConfig {
+	config := paymentsConfig{Normalization: NormalizationAverage}
+	if record, err := findConfigRecord(app); err == nil {
+		config.Normalization = record.GetString("normalization")
+	}
+	return config
+}
+
+// loadNormalization returns the normalization configured by a superuser, for the month containing now
+func loadNormalization(app core.App, now time.Time) normalization {
+	return newNormalization(loadConfig(app).Normalization, now)
+}
+
+// GetConfig handles GET /api/beszel/payments/config requests (superusers only).
+// Returns the payments config that applies to every user.
+func (pm *PaymentManager) GetConfig(e *core.RequestEvent) error {
+	return e.JSON(http.StatusOK, loadConfig(e.App))
+}
+
+// UpdateConfig handles PATCH /api/beszel/payments/config requests (superusers only).
+// Updates the settings present in the body. normalization is either "average", which counts
+// every month as 30.44 days, or "calendar", which uses the length of the current month.
+func (pm *PaymentManager) UpdateConfig(e *core.RequestEvent) error {
+	var body struct {
+		Normalization *string `json:"normalization"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	record, err := findConfigRecord(e.App)
+	if err != nil {
+		collection, err := e.App.FindCachedCollectionByNameOrId("payments_config")
+		if err != nil {
+			return e.InternalServerError("", err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("normalization", NormalizationAverage)
+	}
+	if body.Normalization != nil {
+		record.Set("normalization", *body.Normalization)
+	}
+	if err := e.App.Save(record); err != nil {
+		return e.BadRequestError("Failed to update config", err)
+	}
+	return e.JSON(http.StatusOK, loadConfig(e.App))
+}
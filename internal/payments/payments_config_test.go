@@ -0,0 +1,168 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentsConfigNormalization(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	superuser, err := beszelTests.CreateRecord(f.hub, core.CollectionNameSuperusers, map[string]any{
+		"email":    "superuser@example.com",
+		"password": "password123",
+	})
+	require.NoError(t, err)
+	superuserToken, err := superuser.NewAuthToken()
+	require.NoError(t, err)
+
+	payment := f.createPayment(t, map[string]any{"period": "daily", "amount": 1})
+	_, err = beszelTests.CreateRecord(f.hub, "budgets", map[string]any{
+		"user": f.user.Id, "name": "Monthly", "limit": 100, "currency": "USD", "period": "monthly",
+	})
+	require.NoError(t, err)
+	now := time.Now().UTC()
+	daysThisMonth := time.Date(now.Year(), now.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "users can't read the config",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/config",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"not allowed"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "users can't change the config",
+			Method:          http.MethodPatch,
+			URL:             "/api/beszel/payments/config",
+			Body:            jsonReader(map[string]any{"normalization": "calendar"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"not allowed"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "average by default",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/config",
+			Headers:         map[string]string{"Authorization": superuserToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"normalization":"average"}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "summary with average months",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"USD":30.44}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "unknown mode",
+			Method:          http.MethodPatch,
+			URL:             "/api/beszel/payments/config",
+			Body:            jsonReader(map[string]any{"normalization": "lunar"}),
+			Headers:         map[string]string{"Authorization": superuserToken},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"normalization"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "switch to calendar months",
+			Method:          http.MethodPatch,
+			URL:             "/api/beszel/payments/config",
+			Body:            jsonReader(map[string]any{"normalization": "calendar"}),
+			Headers:         map[string]string{"Authorization": superuserToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"normalization":"calendar"}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "summary with the length of the current month",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{fmt.Sprintf(`{"USD":%d}`, daysThisMonth)},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "system cost with the length of the current month",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/systems/" + payment.GetString("system") + "/cost",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{fmt.Sprintf(`"monthly":{"USD":%d}`, daysThisMonth)},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "provider summary with the length of the current month",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/providers/" + f.provider.Id + "/summary",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{fmt.Sprintf(`"monthly":{"USD":%d}`, daysThisMonth)},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "budgets with the length of the current month",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/budgets/status",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{fmt.Sprintf(`"spent":%d`, daysThisMonth)},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "leaderboard with the length of the current month",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/leaderboard?base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{fmt.Sprintf(`"monthly":%d}`, daysThisMonth)},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "export subtotals with the length of the current month",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/export.csv",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{fmt.Sprintf("SUBTOTAL,,%d.00,USD,monthly", daysThisMonth)},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+
+	// spend snapshots are taken with the length of the current month too
+	_, err = f.hub.GetPaymentManager().SnapshotSpendAt(now)
+	require.NoError(t, err)
+	snapshot, err := f.hub.FindFirstRecordByFilter("spend_snapshots", "user = {:user}", dbx.Params{"user": f.user.Id})
+	require.NoError(t, err)
+	assert.Equal(t, float64(daysThisMonth), snapshot.GetFloat("total"))
+}
@@ -0,0 +1,64 @@
+package payments
+
+import (
+	"net/http"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ConfirmPayment handles POST /api/beszel/payments/{id}/confirm requests.
+// Records the charge at the current due date and advances the payment by one period, the way
+// the daily job does for users with autoAdvance on. The payment's unread confirmation
+// notifications are marked as read. Only active payments outside a free trial can be confirmed.
+// A one-time payment is archived once confirmed.
+func (pm *PaymentManager) ConfirmPayment(e *core.RequestEvent) error {
+	record, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	now := time.Now().UTC()
+	if isArchived(record) || paymentStatus(record) != StatusActive {
+		return e.BadRequestError("Failed to confirm payment",
+			validation.Errors{"status": validation.NewError("validation_payment_inactive", "Only active payments can be confirmed.")})
+	}
+	if inTrial(record, now) {
+		return e.BadRequestError("Failed to confirm payment",
+			validation.Errors{"trialEndsAt": validation.NewError("validation_in_trial", "Payments in a free trial have nothing to confirm.")})
+	}
+	schedule, paidAt, err := confirmSchedule(newPaymentSchedule(record), now)
+	if err != nil {
+		return e.BadRequestError("Failed to confirm payment", err)
+	}
+
+	err = e.App.RunInTransaction(func(txApp core.App) error {
+		record.Set("nextPayment", schedule.NextPayment)
+		record.Set("billingDay", schedule.BillingDay)
+		record.Set("lastAdvancedAt", schedule.LastAdvancedAt)
+		completeOneTime(record, now)
+		if err := txApp.SaveNoValidateWithContext(withActor(e), record); err != nil {
+			return err
+		}
+		if err := recordCharges(txApp, record, []time.Time{paidAt}); err != nil {
+			return err
+		}
+		unread, err := txApp.FindAllRecords("notifications",
+			dbx.HashExp{"relatedPayment": record.Id, "type": NotificationPaymentConfirm, "readAt": ""})
+		if err != nil {
+			return err
+		}
+		for _, notification := range unread {
+			notification.Set("readAt", now)
+			if err := txApp.Save(notification); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, record)
+}
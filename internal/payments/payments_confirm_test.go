@@ -0,0 +1,149 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdvanceAsksManualUsersToConfirm(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+	setPaymentSettings(t, f, map[string]any{"autoAdvance": false})
+
+	due := f.createPayment(t, map[string]any{"system": f.system.Id, "nextPayment": "2030-01-15 00:00:00.000Z"})
+	f.createPayment(t, map[string]any{"nextPayment": "2030-01-14 00:00:00.000Z", "trialEndsAt": "2030-02-01 00:00:00.000Z"})
+	f.createPayment(t, map[string]any{"nextPayment": "2030-02-15 00:00:00.000Z"})
+
+	now := time.Date(2030, 1, 16, 0, 5, 0, 0, time.UTC)
+	count, err := pm.AdvanceDuePayments(now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	due, _ = f.hub.FindRecordById("payments", due.Id)
+	assert.Equal(t, date(2030, 1, 15), due.GetDateTime("nextPayment").Time())
+	history, err := f.hub.CountRecords("payment_history", dbx.HashExp{"payment": due.Id})
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, history)
+
+	// the payment in a free trial has nothing to confirm
+	notifications, err := f.hub.FindAllRecords("notifications", dbx.HashExp{"user": f.user.Id})
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, "payment_confirm", notifications[0].GetString("type"))
+	assert.Equal(t, due.Id, notifications[0].GetString("relatedPayment"))
+	assert.Equal(t, "Hetzner payment due — confirm?", notifications[0].GetString("title"))
+	assert.Equal(t, "$10.00 was due on 2030-01-15. Confirm it once paid to move to the next due date.", notifications[0].GetString("body"))
+
+	assert.Equal(t, date(2030, 1, 15), notifications[0].GetDateTime("dueDate").Time())
+
+	// the user is only asked once per due date, even when the amount or locale change the body
+	due.Set("amount", 12)
+	require.NoError(t, f.hub.Save(due))
+	settings, err := f.hub.FindFirstRecordByData("user_settings", "user", f.user.Id)
+	require.NoError(t, err)
+	settings.Set("settings", map[string]any{"autoAdvance": false, "locale": "de"})
+	require.NoError(t, f.hub.Save(settings))
+	_, err = pm.AdvanceDuePayments(now.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	asked, err := f.hub.CountRecords("notifications", dbx.HashExp{"user": f.user.Id})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, asked)
+}
+
+func TestConfirmPayment(t *testing.T) {
+	f := newPaymentFixture(t)
+	setPaymentSettings(t, f, map[string]any{"autoAdvance": false})
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	payment := f.createPayment(t, map[string]any{"nextPayment": "2030-01-31 00:00:00.000Z"})
+	paused := f.createPayment(t, map[string]any{"status": "paused"})
+	trial := f.createPayment(t, map[string]any{"trialEndsAt": "2031-01-01 00:00:00.000Z"})
+	_, err := f.hub.GetPaymentManager().AdvanceDuePayments(time.Date(2030, 2, 1, 0, 5, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/confirm",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/confirm",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "paused payment",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + paused.Id + "/confirm",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_payment_inactive"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "payment in a free trial",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + trial.Id + "/confirm",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_in_trial"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "confirm",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/confirm",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"nextPayment":"2030-02-28 00:00:00.000Z"`, `"billingDay":31`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				history, err := app.FindAllRecords("payment_history", dbx.HashExp{"payment": payment.Id})
+				require.NoError(t, err)
+				require.Len(t, history, 1)
+				assert.Equal(t, date(2030, 1, 31), history[0].GetDateTime("paidAt").Time())
+				assert.Equal(t, 10.0, history[0].GetFloat("amount"))
+
+				notification, err := app.FindFirstRecordByData("notifications", "relatedPayment", payment.Id)
+				require.NoError(t, err)
+				assert.False(t, notification.GetDateTime("readAt").IsZero(), "the confirmation should be marked as read")
+			},
+		},
+		{
+			Name:            "confirming again moves a single period ahead",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/confirm",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"nextPayment":"2030-03-31 00:00:00.000Z"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,96 @@
+package payments
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// number of days of upcoming payments shown on the dashboard
+const dashboardUpcomingDays = 30
+
+// dashboard holds the sections of the home page
+type dashboard struct {
+	Base string `json:"base"`
+	// monthly equivalent spend per currency, as returned by the summary
+	Totals map[string]float64 `json:"totals"`
+	// monthly and yearly equivalent spend converted into base, nil without a base
+	Monthly *float64 `json:"monthly"`
+	Annual  *float64 `json:"annual"`
+	// payments due within the next 30 days, soonest first
+	Upcoming []upcomingPayment `json:"upcoming"`
+	// amount of the upcoming payments converted into base, nil without a base
+	UpcomingTotal       *float64       `json:"upcomingTotal"`
+	Budgets             []budgetStatus `json:"budgets"`
+	UnreadNotifications int64          `json:"unreadNotifications"`
+}
+
+// GetDashboard handles GET /api/beszel/dashboard requests.
+// Returns in one response what the summary, upcoming (30 days), budget status and unread
+// notifications endpoints return for the home page, computed with a single set of exchange rates.
+// Totals are converted into the base query parameter or the user's default currency;
+// as with the summary an empty base opts out. Archived payments are left out.
+// Returns 422 with the missing pairs if any amount can't be converted.
+func (pm *PaymentManager) GetDashboard(e *core.RequestEvent) error {
+	base := requestBase(e)
+	if base != "" && !isCurrency(base) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid base currency"})
+	}
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	records, err := findOwnAndSharedPayments(e.App, e.Auth.Id)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	records = withoutArchived(records)
+	now := time.Now().UTC()
+
+	totals, _ := monthlyNetAndGross(records, now, userShare(e.Auth.Id), loadNormalization(e.App, now))
+	result := dashboard{Base: base}
+	if base != "" {
+		monthly := conv.convertTotals(totals, base)
+		rounded, annual := roundAmount(monthly, base), roundAmount(monthly*monthsPerYear, base)
+		result.Monthly, result.Annual = &rounded, &annual
+	}
+	result.Totals = roundTotals(totals)
+
+	local := now.In(loadPaymentSettings(e.App, e.Auth.Id).location())
+	result.Upcoming, err = findUpcoming(e.App, requestid.Logger(e), e.Auth.Id, local, dashboardUpcomingDays, false)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	if base != "" {
+		var upcomingTotal float64
+		for _, item := range result.Upcoming {
+			converted, _ := conv.convert(item.Amount, item.Currency, base)
+			upcomingTotal += converted
+		}
+		upcomingTotal = roundAmount(upcomingTotal, base)
+		result.UpcomingTotal = &upcomingTotal
+	}
+
+	// budgets only count the user's own payments
+	own := slices.DeleteFunc(slices.Clone(records), func(record *core.Record) bool {
+		return record.GetString("user") != e.Auth.Id
+	})
+	result.Budgets, err = budgetStatuses(e.App, e.Auth.Id, own, now, conv)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	if err := conv.err(); err != nil {
+		return convertError(e, err)
+	}
+
+	result.UnreadNotifications, err = e.App.CountRecords("notifications", dbx.HashExp{"user": e.Auth.Id, "readAt": ""})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,108 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDashboard(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	soon := time.Now().UTC().AddDate(0, 0, 5)
+	due := f.createPayment(t, map[string]any{"amount": 10, "currency": "USD", "nextPayment": soon})
+	f.createPayment(t, map[string]any{"amount": 120, "currency": "EUR", "period": "annual"})
+	// archived payments are left out
+	f.createPayment(t, map[string]any{"amount": 50, "currency": "USD", "nextPayment": soon, "archivedAt": "2029-01-01 00:00:00.000Z"})
+	_, err := beszelTests.CreateRecord(f.hub, "budgets", map[string]any{
+		"user": f.user.Id, "name": "Hosting", "limit": 15, "currency": "USD", "period": "monthly",
+	})
+	require.NoError(t, err)
+	for _, readAt := range []any{"", "2030-01-01 00:00:00.000Z", ""} {
+		_, err := beszelTests.CreateRecord(f.hub, "notifications", map[string]any{
+			"user": f.user.Id, "type": "payment_due", "title": "Hetzner payment due", "readAt": readAt,
+		})
+		require.NoError(t, err)
+	}
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/dashboard",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid base",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/dashboard?base=XXX",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"invalid base currency"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "budgets need rates without a base",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/dashboard",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"missing":[{"base":"EUR","quote":"USD"}]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "per currency totals without a base",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/dashboard",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"base":""`,
+				`"totals":{"EUR":10,"USD":10}`,
+				`"monthly":null`,
+				`"upcomingTotal":null`,
+				`"upcoming":[{"id":"` + due.Id + `"`,
+				`"budgets":[{"id":`,
+				`"spent":30,"over":true,"overBy":15`,
+				`"unreadNotifications":2`,
+			},
+			TestAppFactory: testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, f.hub, "EUR", "USD", 2)
+			},
+		},
+		{
+			Name:           "totals in the base currency",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/dashboard?base=EUR",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"base":"EUR"`,
+				`"monthly":15`,
+				`"annual":180`,
+				`"upcomingTotal":5`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
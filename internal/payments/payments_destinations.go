@@ -0,0 +1,72 @@
+package payments
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// errPrivateDestination is returned when the hub is asked to connect to a private address on a user's behalf
+var errPrivateDestination = errors.New("destination is a private, loopback or link-local address")
+
+// shared address space used by carrier-grade NAT, which netip doesn't count as private
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// allowPrivateDestinations reports whether ALLOW_PRIVATE_DESTINATIONS is set to true, letting
+// webhooks and url checks reach hosts on the hub's own network, like a notification service
+// running next to it. Only set it if every user of the hub is trusted.
+func allowPrivateDestinations() bool {
+	value, _ := getEnv("ALLOW_PRIVATE_DESTINATIONS")
+	return value == "true"
+}
+
+// isPublicAddr reports whether addr can be reached by requests made on a user's behalf
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() &&
+		!addr.IsLoopback() &&
+		!addr.IsPrivate() &&
+		!addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() &&
+		!addr.IsInterfaceLocalMulticast() &&
+		!addr.IsMulticast() &&
+		!addr.IsUnspecified() &&
+		!sharedAddressSpace.Contains(addr)
+}
+
+// checkDestination rejects connections to addresses that aren't public. It runs after the host
+// name is resolved, for every address dialed, so a name resolving to a private address is caught too.
+func checkDestination(network, address string, _ syscall.RawConn) error {
+	if allowPrivateDestinations() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddr(addr) {
+		return errPrivateDestination
+	}
+	return nil
+}
+
+// newPublicTransport returns a transport for requests made on a user's behalf, such as webhook
+// deliveries and url checks, that only connects to public addresses. Proxies aren't used, since
+// the proxy's own address would be checked instead of the destination's.
+func newPublicTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   checkDestination,
+	}).DialContext
+	return transport
+}
@@ -0,0 +1,314 @@
+package payments
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Digest frequencies a user can choose
+const (
+	DigestNone   = "none"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// isDigestFrequency reports whether frequency is one of the Digest constants
+func isDigestFrequency(frequency string) bool {
+	return frequency == DigestNone || frequency == DigestDaily || frequency == DigestWeekly
+}
+
+// digestActive reports whether the user gets a digest instead of individual reminders
+func (s userPaymentSettings) digestActive() bool {
+	return s.DigestFrequency == DigestDaily || s.DigestFrequency == DigestWeekly
+}
+
+// digestDays returns the number of days a digest covers, which runs until the next digest is sent
+func digestDays(frequency string) int {
+	if frequency == DigestWeekly {
+		return 7
+	}
+	return 1
+}
+
+// digestPayment is a payment listed in a digest
+type digestPayment struct {
+	Id           string         `json:"id"`
+	Provider     string         `json:"provider"`
+	ProviderName string         `json:"providerName"`
+	System       string         `json:"system"`
+	Amount       float64        `json:"amount"`
+	Currency     string         `json:"currency"`
+	NextPayment  types.DateTime `json:"nextPayment"`
+}
+
+// paymentDigest lists a user's payments due within a digest period and their total
+type paymentDigest struct {
+	Frequency   string          `json:"frequency"`
+	PeriodStart types.DateTime  `json:"periodStart"`
+	PeriodEnd   types.DateTime  `json:"periodEnd"`
+	Payments    []digestPayment `json:"payments"`
+	// upcoming spend per currency
+	Totals map[string]float64 `json:"totals"`
+	// upcoming spend converted into Currency, nil if some totals couldn't be converted
+	Total    *float64 `json:"total"`
+	Currency string   `json:"currency"`
+	// the listed payments with provider and system expanded
+	records []*core.Record
+	// locale tag the digest's amounts are formatted for
+	locale string
+}
+
+// buildDigest collects the user's payments due from the start of the day of now, in the user's
+// timezone, until the next digest of the frequency. Archived, paused, cancelled and trial payments
+// are left out. Totals are converted into the user's default currency, or into the only currency
+// of the payments if there is no default; pairs missing from conv leave Total nil.
+func buildDigest(app core.App, logger *slog.Logger, conv *converter, userID string, settings userPaymentSettings, frequency string, now time.Time) (paymentDigest, error) {
+	now = now.In(settings.location())
+	start := startOfDay(now)
+	end := start.AddDate(0, 0, digestDays(frequency)).Add(-time.Millisecond)
+	records, err := findPaymentsDueBetween(app, logger, userID, start, end)
+	if err != nil {
+		return paymentDigest{}, err
+	}
+	records = slices.DeleteFunc(withoutArchived(records), func(record *core.Record) bool {
+		return inTrial(record, now) || isPaused(record) || paymentStatus(record) == StatusCancelled
+	})
+	if errs := app.ExpandRecords(records, []string{"system"}, nil); len(errs) > 0 {
+		logger.Warn("Failed to expand payment systems", "errs", errs)
+	}
+
+	digest := paymentDigest{
+		Frequency: frequency,
+		Payments:  make([]digestPayment, len(records)),
+		Totals:    make(map[string]float64),
+		records:   records,
+		locale:    settings.Locale,
+	}
+	digest.PeriodStart, _ = types.ParseDateTime(start)
+	digest.PeriodEnd, _ = types.ParseDateTime(end)
+	for i, record := range records {
+		nextPayment := record.GetDateTime("nextPayment")
+		item := digestPayment{
+			Id:          record.Id,
+			Provider:    record.GetString("provider"),
+			System:      record.GetString("system"),
+			Amount:      effectiveAmount(record, nextPayment.Time()),
+			Currency:    record.GetString("currency"),
+			NextPayment: nextPayment,
+		}
+		if provider := record.ExpandedOne("provider"); provider != nil {
+			item.ProviderName = provider.GetString("name")
+		}
+		digest.Payments[i] = item
+		digest.Totals[item.Currency] += item.Amount
+	}
+	roundTotals(digest.Totals)
+
+	base := settings.DefaultCurrency
+	if base == "" && len(digest.Totals) == 1 {
+		for currency := range digest.Totals {
+			base = currency
+		}
+	}
+	if base != "" {
+		total := roundAmount(conv.convertTotals(digest.Totals, base), base)
+		if conv.err() == nil {
+			digest.Total = &total
+			digest.Currency = base
+		}
+	}
+	return digest, nil
+}
+
+// title is the notification title and email subject of the digest
+func (d paymentDigest) title() string {
+	if d.Frequency == DigestWeekly {
+		return "Weekly payment digest"
+	}
+	return "Daily payment digest"
+}
+
+// summary describes the digest in a line, e.g. "3 payments due from 2030-01-14 to 2030-01-20, 45,00 € in total".
+// Without a converted total the totals of each currency are listed. Amounts are formatted for the
+// locale of the user the digest was built for.
+func (d paymentDigest) summary(loc *time.Location) string {
+	var due string
+	start := d.PeriodStart.Time().In(loc).Format(time.DateOnly)
+	if end := d.PeriodEnd.Time().In(loc).Format(time.DateOnly); end != start {
+		due = fmt.Sprintf("due from %s to %s", start, end)
+	} else {
+		due = "due on " + start
+	}
+	count := "1 payment"
+	if len(d.Payments) != 1 {
+		count = fmt.Sprintf("%d payments", len(d.Payments))
+	}
+
+	var total string
+	if d.Total != nil {
+		total = formatAmount(*d.Total, d.Currency, d.locale)
+	} else {
+		amounts := make([]string, 0, len(d.Totals))
+		for _, currency := range slices.Sorted(maps.Keys(d.Totals)) {
+			amounts = append(amounts, formatAmount(d.Totals[currency], currency, d.locale))
+		}
+		total = strings.Join(amounts, " + ")
+	}
+	return fmt.Sprintf("%s %s, %s in total", count, due, total)
+}
+
+// SendDigests sends the payment digests that are due.
+// Runs every hour as a cron job, so digests go out soon after midnight in each user's timezone.
+func (pm *PaymentManager) SendDigests() {
+	logger := requestid.RunLogger(pm.app, jobSendDigests)
+	sent, err := pm.sendDigests(logger, time.Now().UTC())
+	pm.health.jobRan(jobSendDigests, time.Now().UTC(), err)
+	if err != nil {
+		logger.Error("Failed to send payment digests", "err", err)
+		return
+	}
+	if sent > 0 {
+		logger.Info("Sent payment digests", "count", sent)
+	}
+}
+
+// sendDigests sends a digest to each user with a digest frequency whose digest day started
+// before now and hasn't had one sent yet, and returns the number of digests sent.
+// A digest goes to the in-app inbox, and by email if any of its payments selects the email channel.
+// Digests without payments are skipped.
+func (pm *PaymentManager) sendDigests(logger *slog.Logger, now time.Time) (int, error) {
+	userSettings, err := pm.app.FindAllRecords("user_settings")
+	if err != nil {
+		return 0, err
+	}
+	rates, err := pm.rates.get(pm.app)
+	if err != nil {
+		return 0, err
+	}
+
+	var sent int
+	for _, record := range userSettings {
+		userID := record.GetString("user")
+		settings := loadPaymentSettings(pm.app, userID)
+		if !settings.digestActive() {
+			continue
+		}
+		loc := settings.location()
+		local := now.In(loc)
+		if settings.DigestFrequency == DigestWeekly && local.Weekday() != settings.DigestWeekday {
+			continue
+		}
+		start, _ := types.ParseDateTime(startOfDay(local))
+		count, err := pm.app.CountRecords("payment_digests", dbx.HashExp{"user": userID, "periodStart": start.String()})
+		if err != nil {
+			return sent, err
+		}
+		if count > 0 {
+			continue
+		}
+
+		conv := newConverter(rates)
+		digest, err := buildDigest(pm.app, logger, conv, userID, settings, settings.DigestFrequency, now)
+		if err != nil {
+			logger.Error("Failed to build payment digest", "user", userID, "err", err)
+			continue
+		}
+		if len(digest.Payments) == 0 {
+			continue
+		}
+		if err := conv.err(); err != nil {
+			logger.Warn("Payment digest sent without a converted total", "user", userID, "err", err)
+		}
+		if err := saveDigest(pm.app, userID, digest, loc); err != nil {
+			logger.Error("Failed to save payment digest", "user", userID, "err", err)
+			continue
+		}
+		sent++
+		emailed := slices.ContainsFunc(digest.records, func(record *core.Record) bool {
+			return slices.Contains(reminderChannels(record), ChannelEmail)
+		})
+		if emailed {
+			if err := sendDigestEmail(pm.app, userID, digest, loc); err != nil {
+				logger.Warn("Failed to send digest email", "user", userID, "err", err)
+			}
+		}
+	}
+	return sent, nil
+}
+
+// saveDigest records the digest and adds its in-app notification in a single transaction,
+// so a digest is only recorded as sent once the user can see it
+func saveDigest(app core.App, userID string, digest paymentDigest, loc *time.Location) error {
+	return app.RunInTransaction(func(txApp core.App) error {
+		collection, err := txApp.FindCachedCollectionByNameOrId("payment_digests")
+		if err != nil {
+			return err
+		}
+		record := core.NewRecord(collection)
+		record.Set("user", userID)
+		record.Set("frequency", digest.Frequency)
+		record.Set("periodStart", digest.PeriodStart)
+		record.Set("periodEnd", digest.PeriodEnd)
+		ids := make([]string, len(digest.Payments))
+		for i, payment := range digest.Payments {
+			ids[i] = payment.Id
+		}
+		record.Set("payments", ids)
+		record.Set("totals", digest.Totals)
+		if digest.Total != nil {
+			record.Set("total", *digest.Total)
+			record.Set("currency", digest.Currency)
+		}
+		if err := txApp.Save(record); err != nil {
+			return err
+		}
+
+		notifications, err := txApp.FindCachedCollectionByNameOrId("notifications")
+		if err != nil {
+			return err
+		}
+		notification := core.NewRecord(notifications)
+		notification.Set("user", userID)
+		notification.Set("type", NotificationPaymentDigest)
+		notification.Set("title", digest.title())
+		notification.Set("body", digest.summary(loc))
+		return txApp.Save(notification)
+	})
+}
+
+// GetDigest handles GET /api/beszel/digest requests.
+// Returns the digest the user would get today without sending or recording it, for the frequency
+// query parameter or, if absent, the user's digest frequency setting.
+func (pm *PaymentManager) GetDigest(e *core.RequestEvent) error {
+	settings := loadPaymentSettings(e.App, e.Auth.Id)
+	frequency := settings.DigestFrequency
+	if e.Request.URL.Query().Has("frequency") {
+		frequency = e.Request.URL.Query().Get("frequency")
+	}
+	if frequency != DigestDaily && frequency != DigestWeekly {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "frequency must be daily or weekly"})
+	}
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	digest, err := buildDigest(e.App, requestid.Logger(e), conv, e.Auth.Id, settings, frequency, time.Now().UTC())
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	if err := conv.err(); err != nil {
+		return convertError(e, err)
+	}
+	return e.JSON(http.StatusOK, digest)
+}
@@ -0,0 +1,181 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setPaymentSettings stores payment preferences for the user.
+// Settings of a new record are reset to defaults on create, so they are set afterwards.
+func setPaymentSettings(t *testing.T, f *paymentFixture, settings map[string]any) {
+	record, err := beszelTests.CreateRecord(f.hub, "user_settings", map[string]any{"user": f.user.Id})
+	require.NoError(t, err)
+	record.Set("settings", settings)
+	require.NoError(t, f.hub.Save(record))
+}
+
+func TestSendDigests(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+	setPaymentSettings(t, f, map[string]any{"digestFrequency": "weekly", "digestWeekday": 1, "defaultCurrency": "EUR"})
+	setRate(t, f.hub, "USD", "EUR", 0.5)
+
+	// Monday
+	now := time.Date(2030, 1, 14, 0, 20, 0, 0, time.UTC)
+	f.createPayment(t, map[string]any{"system": f.system.Id, "nextPayment": "2030-01-16 00:00:00.000Z", "amount": 10, "currency": "USD", "reminderDays": 3})
+	f.createPayment(t, map[string]any{"provider": createProvider(t, f.hub, f.user, "OVH").Id, "nextPayment": "2030-01-20 12:00:00.000Z", "amount": 9.5, "currency": "EUR", "reminderDays": 3, "reminderChannels": []string{"email"}})
+	// due after the digest period, and archived
+	f.createPayment(t, map[string]any{"nextPayment": "2030-01-21 00:00:00.000Z", "reminderDays": 10})
+	f.createPayment(t, map[string]any{"nextPayment": "2030-01-15 00:00:00.000Z", "archivedAt": "2030-01-01 00:00:00.000Z"})
+
+	// individual reminders are suppressed while a digest is active
+	reminded, err := pm.NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, reminded)
+
+	// not the digest day yet
+	sent, err := pm.SendDigestsAt(now.AddDate(0, 0, -1))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	sent, err = pm.SendDigestsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	digests, err := f.hub.FindAllRecords("payment_digests", dbx.HashExp{"user": f.user.Id})
+	require.NoError(t, err)
+	require.Len(t, digests, 1)
+	assert.Equal(t, "weekly", digests[0].GetString("frequency"))
+	assert.Equal(t, time.Date(2030, 1, 14, 0, 0, 0, 0, time.UTC), digests[0].GetDateTime("periodStart").Time())
+	assert.Len(t, digests[0].GetStringSlice("payments"), 2)
+	assert.Equal(t, 14.5, digests[0].GetFloat("total"))
+	assert.Equal(t, "EUR", digests[0].GetString("currency"))
+
+	notifications, err := f.hub.FindAllRecords("notifications", dbx.HashExp{"user": f.user.Id})
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, "payment_digest", notifications[0].GetString("type"))
+	assert.Equal(t, "Weekly payment digest", notifications[0].GetString("title"))
+	assert.Equal(t, "2 payments due from 2030-01-14 to 2030-01-20, 14,50 € in total", notifications[0].GetString("body"))
+
+	// one of the payments selects the email channel
+	require.EqualValues(t, 1, f.hub.TestMailer.TotalSend())
+	message := f.hub.TestMailer.LastMessage()
+	assert.Equal(t, "Weekly payment digest", message.Subject)
+	assert.Contains(t, message.Text, "2 payments due from 2030-01-14 to 2030-01-20, 14,50 € in total")
+	assert.Contains(t, message.Text, "- Hetzner (server-1): $10.00 due on 2030-01-16")
+	assert.Contains(t, message.Text, "- OVH (server-")
+
+	// the same day only gets one digest
+	sent, err = pm.SendDigestsAt(now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.EqualValues(t, 1, f.hub.TestMailer.TotalSend())
+}
+
+func TestSendDailyDigest(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+	// 00:20 on Jan 11 in Moscow, UTC+3, but still Jan 10 in UTC
+	setPaymentSettings(t, f, map[string]any{"digestFrequency": "daily", "timezone": "Europe/Moscow"})
+	now := time.Date(2030, 1, 10, 21, 20, 0, 0, time.UTC)
+
+	// no payments due that day, so nothing is sent
+	sent, err := pm.SendDigestsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	f.createPayment(t, map[string]any{"nextPayment": time.Date(2030, 1, 11, 20, 0, 0, 0, time.UTC), "amount": 10, "currency": "USD"})
+	f.createPayment(t, map[string]any{"nextPayment": time.Date(2030, 1, 11, 22, 0, 0, 0, time.UTC), "currency": "EUR"})
+	sent, err = pm.SendDigestsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	notification, err := f.hub.FindFirstRecordByFilter("notifications", "user = {:user}", dbx.Params{"user": f.user.Id})
+	require.NoError(t, err)
+	assert.Equal(t, "Daily payment digest", notification.GetString("title"))
+	// without a default currency a single currency is totalled in that currency
+	assert.Equal(t, "1 payment due on 2030-01-11, $10.00 in total", notification.GetString("body"))
+	// no payment selects the email channel
+	assert.EqualValues(t, 0, f.hub.TestMailer.TotalSend())
+}
+
+func TestGetDigest(t *testing.T) {
+	f := newPaymentFixture(t)
+	setPaymentSettings(t, f, map[string]any{"defaultCurrency": "USD"})
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, time.UTC)
+	f.createPayment(t, map[string]any{"nextPayment": today, "amount": 10, "currency": "USD"})
+	f.createPayment(t, map[string]any{"nextPayment": today.AddDate(0, 0, 3), "amount": 20, "currency": "EUR"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/digest",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "no digest configured",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/digest",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"frequency must be daily or weekly"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "missing rate to the base",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/digest?frequency=weekly",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"missing":[{"base":"EUR","quote":"USD"}]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "weekly digest",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/digest?frequency=weekly",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"frequency":"weekly"`, `"totals":{"EUR":20,"USD":10}`, `"total":50`, `"currency":"USD"`},
+			TestAppFactory:  testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, f.hub, "EUR", "USD", 2)
+			},
+		},
+		{
+			Name:               "daily digest leaves out later payments",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/digest?frequency=daily",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"totals":{"USD":10}`, `"total":10`},
+			NotExpectedContent: []string{"EUR"},
+			TestAppFactory:     testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,103 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscounts(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Now().UTC()
+	// discount ends in 5 days, after the payment is due
+	active := f.createPayment(t, map[string]any{
+		"amount":         20,
+		"discountAmount": 5,
+		"discountEndsAt": now.AddDate(0, 0, 5),
+		"nextPayment":    now.AddDate(0, 0, 2),
+	})
+	// discount ended yesterday
+	expired := f.createPayment(t, map[string]any{
+		"amount":         10,
+		"discountAmount": 4,
+		"discountEndsAt": now.AddDate(0, 0, -1),
+		"nextPayment":    now.AddDate(0, 0, 3),
+	})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "summary uses discounted amount while active",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"USD":25}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "upcoming warns about the price increase",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/upcoming",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"id":"` + active.Id + `"`,
+				`"amount":15`,
+				`"priceIncreasingOn":"` + active.GetDateTime("discountEndsAt").String() + `"`,
+				`"id":"` + expired.Id + `"`,
+				`"amount":10`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:            "discount can't exceed amount",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + active.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"discountAmount": 25}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_discount_exceeds_amount"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestAdvanceRecordsDiscountedCharge(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	due := time.Date(2030, 1, 15, 0, 0, 0, 0, time.UTC)
+	payment := f.createPayment(t, map[string]any{
+		"amount":         20,
+		"discountAmount": 5,
+		"discountEndsAt": due.AddDate(0, 1, -1),
+		"nextPayment":    due,
+	})
+
+	// two cycles elapse, the second after the discount ended
+	_, err := f.hub.GetPaymentManager().AdvanceDuePayments(due.AddDate(0, 1, 1))
+	require.NoError(t, err)
+
+	history, err := f.hub.FindRecordsByFilter("payment_history", "payment = {:id}", "paidAt", 0, 0, map[string]any{"id": payment.Id})
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, 15.0, history[0].GetFloat("amount"))
+	assert.Equal(t, 20.0, history[1].GetFloat("amount"))
+}
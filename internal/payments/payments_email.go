@@ -0,0 +1,124 @@
+package payments
+
+import (
+	"fmt"
+	"net/mail"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+// reminderEmailTemplate is the text of an email listing the payments a user is reminded of
+var reminderEmailTemplate = template.Must(template.New("reminder").Parse(
+	`The following payments are due soon:
+{{range .}}
+- {{.Provider}}{{if .System}} ({{.System}}){{end}}: {{.Amount}} due on {{.DueDate}}{{end}}
+`))
+
+// reminderEmailPayment is a payment as listed in a reminder email
+type reminderEmailPayment struct {
+	Provider string
+	System   string
+	Amount   string
+	DueDate  string
+}
+
+// digestEmailTemplate is the text of a digest email listing the upcoming payments
+var digestEmailTemplate = template.Must(template.New("digest").Parse(
+	`{{.Summary}}
+{{range .Payments}}
+- {{.Provider}}{{if .System}} ({{.System}}){{end}}: {{.Amount}} due on {{.DueDate}}{{end}}
+`))
+
+// reminderEmailPayments lists the payments, with their provider and system expanded, for an email.
+// Dates are shown in loc, the user's timezone, and amounts formatted for the user's locale.
+func reminderEmailPayments(records []*core.Record, loc *time.Location, locale string) []reminderEmailPayment {
+	payments := make([]reminderEmailPayment, len(records))
+	for i, record := range records {
+		payload := newWebhookPayload(record, locale)
+		payments[i] = reminderEmailPayment{
+			Provider: payload.ProviderName,
+			Amount:   payload.Formatted,
+			DueDate:  payload.NextPayment.Time().In(loc).Format(time.DateOnly),
+		}
+		if payments[i].Provider == "" {
+			payments[i].Provider = "Payment"
+		}
+		if system := record.ExpandedOne("system"); system != nil {
+			payments[i].System = system.GetString("name")
+		}
+	}
+	return payments
+}
+
+// newReminderEmail builds the reminder email for the payments, with their provider and system expanded.
+// Dates are shown in loc, the user's timezone, and amounts formatted for the user's locale.
+func newReminderEmail(records []*core.Record, loc *time.Location, locale string) (subject, text string, err error) {
+	payments := reminderEmailPayments(records, loc, locale)
+	if len(payments) == 1 {
+		subject = fmt.Sprintf("%s payment due on %s", payments[0].Provider, payments[0].DueDate)
+	} else {
+		subject = fmt.Sprintf("%d payments due soon", len(payments))
+	}
+	var body strings.Builder
+	if err := reminderEmailTemplate.Execute(&body, payments); err != nil {
+		return "", "", err
+	}
+	return subject, body.String(), nil
+}
+
+// newDigestEmail builds the email for a digest, with the provider and system of its payments expanded
+func newDigestEmail(digest paymentDigest, loc *time.Location) (subject, text string, err error) {
+	var body strings.Builder
+	err = digestEmailTemplate.Execute(&body, map[string]any{
+		"Summary":  digest.summary(loc),
+		"Payments": reminderEmailPayments(digest.records, loc, digest.locale),
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return digest.title(), body.String(), nil
+}
+
+// sendReminderEmail sends the user a single email listing all the payments they are reminded of.
+// Fails if the app has no working mail settings.
+func sendReminderEmail(app core.App, userID string, records []*core.Record, loc *time.Location, locale string) error {
+	subject, text, err := newReminderEmail(records, loc, locale)
+	if err != nil {
+		return err
+	}
+	return sendUserEmail(app, userID, subject, text)
+}
+
+// sendDigestEmail sends the user their digest. Fails if the app has no working mail settings.
+func sendDigestEmail(app core.App, userID string, digest paymentDigest, loc *time.Location) error {
+	subject, text, err := newDigestEmail(digest, loc)
+	if err != nil {
+		return err
+	}
+	return sendUserEmail(app, userID, subject, text)
+}
+
+// sendUserEmail sends a plain text email to the user's address
+func sendUserEmail(app core.App, userID, subject, text string) error {
+	user, err := app.FindRecordById("users", userID)
+	if err != nil {
+		return err
+	}
+	if user.Email() == "" {
+		return fmt.Errorf("user %s has no email address", userID)
+	}
+	message := mailer.Message{
+		To:      []mail.Address{{Address: user.Email()}},
+		Subject: subject,
+		Text:    text,
+		From: mail.Address{
+			Address: app.Settings().Meta.SenderAddress,
+			Name:    app.Settings().Meta.SenderName,
+		},
+	}
+	return app.NewMailClient().Send(&message)
+}
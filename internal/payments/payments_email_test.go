@@ -0,0 +1,52 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifyDuePaymentsEmail(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Date(2030, 1, 10, 0, 5, 0, 0, time.UTC)
+	email := []string{"email"}
+	f.createPayment(t, map[string]any{"system": f.system.Id, "nextPayment": now.AddDate(0, 0, 2), "amount": 9.5, "currency": "EUR", "reminderDays": 3, "reminderChannels": email})
+	f.createPayment(t, map[string]any{"provider": createProvider(t, f.hub, f.user, "OVH").Id, "nextPayment": now.AddDate(0, 0, 1), "reminderDays": 3, "reminderChannels": []string{"inApp", "email"}})
+	// in-app only and not yet due payments aren't emailed
+	f.createPayment(t, map[string]any{"provider": createProvider(t, f.hub, f.user, "Linode").Id, "nextPayment": now.AddDate(0, 0, 1), "reminderDays": 3})
+	f.createPayment(t, map[string]any{"provider": createProvider(t, f.hub, f.user, "Vultr").Id, "nextPayment": now.AddDate(0, 0, 10), "reminderDays": 3, "reminderChannels": email})
+
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 3, sent)
+	require.EqualValues(t, 1, f.hub.TestMailer.TotalSend(), "due payments should be batched into one email")
+
+	message := f.hub.TestMailer.LastMessage()
+	require.Len(t, message.To, 1)
+	assert.Equal(t, "payments@example.com", message.To[0].Address)
+	assert.Equal(t, "2 payments due soon", message.Subject)
+	assert.Contains(t, message.Text, "- Hetzner (server-1): 9,50 € due on 2030-01-12")
+	assert.Contains(t, message.Text, "- OVH (server-")
+	assert.Contains(t, message.Text, "due on 2030-01-11")
+	assert.NotContains(t, message.Text, "Linode")
+	assert.NotContains(t, message.Text, "Vultr")
+
+	// the same cycle is only emailed once
+	sent, err = f.hub.GetPaymentManager().NotifyDuePaymentsAt(now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	assert.EqualValues(t, 1, f.hub.TestMailer.TotalSend())
+
+	// a single payment is named in the subject
+	sent, err = f.hub.GetPaymentManager().NotifyDuePaymentsAt(now.AddDate(0, 0, 7))
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	require.EqualValues(t, 2, f.hub.TestMailer.TotalSend())
+	assert.Equal(t, "Vultr payment due on 2030-01-20", f.hub.TestMailer.LastMessage().Subject)
+}
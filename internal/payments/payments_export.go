@@ -0,0 +1,342 @@
+package payments
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// number of payments loaded from the database at a time while exporting
+const exportBatchSize = 500
+
+// exportHeader are the columns of the payments export. Amounts are written as plain numbers so the
+// file can be read back, and formatted repeats the amount for display in the user's locale.
+// The header row is written with the labels of the user's locale, see exportLabels.
+var exportHeader = []string{"provider", "system", "amount", "currency", "period", "nextPayment", "country", "notes", "gross", "source", "customIntervalDays", "customIntervalMonths", "formatted"}
+
+// exportLabels translates the columns of the exports and the summary row labels, by language.
+// Columns without a translation keep their key.
+var exportLabels = map[string]map[string]string{
+	"en": {
+		"provider": "Provider", "system": "System", "amount": "Amount", "currency": "Currency", "period": "Period",
+		"nextPayment": "Next payment", "country": "Country", "notes": "Notes", "gross": "Gross", "source": "Source",
+		"customIntervalDays": "Custom interval days", "customIntervalMonths": "Custom interval months",
+		"formatted": "Formatted", "paidAt": "Paid at", "note": "Note",
+		exportSubtotalLabel: "Subtotal", exportTotalLabel: "Total",
+	},
+	"ru": {
+		"provider": "Провайдер", "system": "Система", "amount": "Сумма", "currency": "Валюта", "period": "Период",
+		"nextPayment": "Следующий платёж", "country": "Страна", "notes": "Заметки", "gross": "С налогом", "source": "Источник",
+		"customIntervalDays": "Интервал (дни)", "customIntervalMonths": "Интервал (месяцы)",
+		"formatted": "Форматированная сумма", "paidAt": "Дата оплаты", "note": "Примечание",
+		exportSubtotalLabel: "Подытог", exportTotalLabel: "Итого",
+	},
+	"de": {
+		"provider": "Anbieter", "system": "System", "amount": "Betrag", "currency": "Währung", "period": "Zeitraum",
+		"nextPayment": "Nächste Zahlung", "country": "Land", "notes": "Notizen", "gross": "Brutto", "source": "Quelle",
+		"customIntervalDays": "Intervall (Tage)", "customIntervalMonths": "Intervall (Monate)",
+		"formatted": "Formatiert", "paidAt": "Bezahlt am", "note": "Notiz",
+		exportSubtotalLabel: "Zwischensumme", exportTotalLabel: "Gesamt",
+	},
+	"fr": {
+		"provider": "Fournisseur", "system": "Système", "amount": "Montant", "currency": "Devise", "period": "Période",
+		"nextPayment": "Prochain paiement", "country": "Pays", "notes": "Notes", "gross": "Brut", "source": "Source",
+		"customIntervalDays": "Intervalle (jours)", "customIntervalMonths": "Intervalle (mois)",
+		"formatted": "Formaté", "paidAt": "Payé le", "note": "Note",
+		exportSubtotalLabel: "Sous-total", exportTotalLabel: "Total",
+	},
+}
+
+// exportLabel returns the label of an export column or summary row in locale, or key itself
+// if locale is empty or has no translation for it
+func exportLabel(key, locale string) string {
+	if label, ok := exportLabels[localeLanguage(locale)][key]; ok {
+		return label
+	}
+	return key
+}
+
+// localizeHeader returns the labels of the columns of header in locale
+func localizeHeader(header []string, locale string) []string {
+	labels := make([]string, len(header))
+	for i, key := range header {
+		labels[i] = exportLabel(key, locale)
+	}
+	return labels
+}
+
+// exportLabelLocale returns the locale the labels of an export are written in: the user's locale,
+// or none with ?headers=raw, which keeps the column keys for tools reading the file back
+func exportLabelLocale(e *core.RequestEvent, locale string) string {
+	if e.Request.URL.Query().Get("headers") == "raw" {
+		return ""
+	}
+	return locale
+}
+
+// exportRow returns the CSV columns for a payment with its provider and system expanded,
+// formatting the amount for locale
+func exportRow(record *core.Record, locale string) []string {
+	var providerName, systemName string
+	if provider := record.ExpandedOne("provider"); provider != nil {
+		providerName = provider.GetString("name")
+	}
+	if system := record.ExpandedOne("system"); system != nil {
+		systemName = system.GetString("name")
+	}
+	return []string{
+		providerName,
+		systemName,
+		strconv.FormatFloat(record.GetFloat("amount"), 'f', 2, 64),
+		record.GetString("currency"),
+		record.GetString("period"),
+		record.GetDateTime("nextPayment").String(),
+		record.GetString("country"),
+		record.GetString("notes"),
+		strconv.FormatFloat(roundAmount(withTax(record, record.GetFloat("amount")), record.GetString("currency")), 'f', 2, 64),
+		record.GetString("source"),
+		exportInt(record.GetInt("customIntervalDays")),
+		exportInt(record.GetInt("customIntervalMonths")),
+		formatAmount(record.GetFloat("amount"), record.GetString("currency"), locale),
+	}
+}
+
+// exportInt writes n, leaving the column empty for zero
+func exportInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// historyExportHeader are the columns of the payment history export
+var historyExportHeader = []string{"provider", "system", "amount", "currency", "paidAt", "note", "formatted"}
+
+// historyExportRow returns the CSV columns for a payment history record with its payment's
+// provider and system expanded, formatting the amount for locale
+func historyExportRow(record *core.Record, locale string) []string {
+	var providerName, systemName string
+	if payment := record.ExpandedOne("payment"); payment != nil {
+		if provider := payment.ExpandedOne("provider"); provider != nil {
+			providerName = provider.GetString("name")
+		}
+		if system := payment.ExpandedOne("system"); system != nil {
+			systemName = system.GetString("name")
+		}
+	}
+	return []string{
+		providerName,
+		systemName,
+		strconv.FormatFloat(record.GetFloat("amount"), 'f', 2, 64),
+		record.GetString("currency"),
+		record.GetDateTime("paidAt").String(),
+		record.GetString("note"),
+		formatAmount(record.GetFloat("amount"), record.GetString("currency"), locale),
+	}
+}
+
+// dateRangeExp bounds field to the range returned by parseDateRange, leaving out the zero bounds
+func dateRangeExp(field string, from, to time.Time) dbx.Expression {
+	var exprs []dbx.Expression
+	if !from.IsZero() {
+		start, _ := types.ParseDateTime(from)
+		exprs = append(exprs, dbx.NewExp("[["+field+"]] >= {:from}", dbx.Params{"from": start.String()}))
+	}
+	if !to.IsZero() {
+		end, _ := types.ParseDateTime(to)
+		exprs = append(exprs, dbx.NewExp("[["+field+"]] <= {:to}", dbx.Params{"to": end.String()}))
+	}
+	return dbx.And(exprs...)
+}
+
+// labels in the provider column that mark the summary rows after the data rows of the payments export.
+// They are translated like the header, see exportLabels.
+const (
+	exportSubtotalLabel = "SUBTOTAL"
+	exportTotalLabel    = "TOTAL"
+)
+
+// exportSummaryRow returns a summary row of the payments export with the amount in the amount,
+// currency, period and formatted columns and label in the provider column
+func exportSummaryRow(label string, amount float64, currency, period, locale string) []string {
+	row := make([]string, len(exportHeader))
+	row[0] = label
+	row[2] = strconv.FormatFloat(amount, 'f', 2, 64)
+	row[3] = currency
+	row[4] = period
+	row[len(row)-1] = formatAmount(amount, currency, locale)
+	return row
+}
+
+// exportSummaryRows returns the subtotal rows of the payments export: the normalized monthly and
+// annual spend of each currency, then the same converted into base as the grand total. The grand
+// total is left out if base is empty or some totals can't be converted into it.
+// Amounts are formatted for locale and the row labels written in labelLocale.
+func exportSummaryRows(totals map[string]float64, base string, conv *converter, locale, labelLocale string) [][]string {
+	subtotalLabel, totalLabel := exportLabel(exportSubtotalLabel, labelLocale), exportLabel(exportTotalLabel, labelLocale)
+	rows := make([][]string, 0, 2*len(totals)+2)
+	for _, currency := range slices.Sorted(maps.Keys(totals)) {
+		monthly := roundAmount(totals[currency], currency)
+		rows = append(rows,
+			exportSummaryRow(subtotalLabel, monthly, currency, PeriodMonthly, locale),
+			exportSummaryRow(subtotalLabel, roundAmount(totals[currency]*12, currency), currency, PeriodAnnual, locale))
+	}
+	if base == "" {
+		return rows
+	}
+	total := conv.convertTotals(totals, base)
+	if conv.err() != nil {
+		return rows
+	}
+	return append(rows,
+		exportSummaryRow(totalLabel, roundAmount(total, base), base, PeriodMonthly, locale),
+		exportSummaryRow(totalLabel, roundAmount(total*12, base), base, PeriodAnnual, locale))
+}
+
+// streamCSV writes header and then a row for each record returned by batch, which is asked for
+// exportBatchSize records at a time from offset until it returns fewer. The response is flushed
+// after each batch, so the export is neither held in memory nor buffered before reaching the client.
+// If footer isn't nil the rows it returns are written after a blank line once all records are.
+func streamCSV(e *core.RequestEvent, filename string, header []string, batch func(offset int) ([]*core.Record, error), row func(record *core.Record) []string, footer func() [][]string) error {
+	e.Response.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	e.Response.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	e.Response.WriteHeader(http.StatusOK)
+
+	w := csv.NewWriter(e.Response)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for offset := 0; ; offset += exportBatchSize {
+		records, err := batch(offset)
+		if err != nil {
+			// the status has already been sent, so the best we can do is cut the file short
+			requestid.Logger(e).Error("Failed to export records", "file", filename, "err", err)
+			return nil
+		}
+		for _, record := range records {
+			if err := w.Write(row(record)); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		if err := e.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if len(records) < exportBatchSize {
+			break
+		}
+	}
+	if footer == nil {
+		return nil
+	}
+	rows := footer()
+	if len(rows) == 0 {
+		return nil
+	}
+	// a blank line, which CSV readers skip, sets the summary apart from the data rows
+	if err := w.Write(nil); err != nil {
+		return err
+	}
+	return w.WriteAll(rows)
+}
+
+// ExportCSV handles GET /api/beszel/payments/export.csv requests.
+// Streams the user's payments as CSV, loading them in batches so large accounts aren't held in memory.
+// The optional from and to dates (YYYY-MM-DD, inclusive) bound the payments' nextPayment.
+// The data rows are followed by a blank line and subtotal rows marked SUBTOTAL in the provider
+// column, with the normalized monthly and annual spend of the exported payments in each currency,
+// counted like the summary. Rows marked TOTAL then convert them into base or the user's default
+// currency, or the only currency exported if there is neither. The header and the summary labels
+// are written in the user's locale, or as the column keys and SUBTOTAL and TOTAL with ?headers=raw
+// or without a locale.
+func (pm *PaymentManager) ExportCSV(e *core.RequestEvent) error {
+	from, to, err := parseDateRange(e)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	locale := loadPaymentSettings(e.App, e.Auth.Id).Locale
+	labelLocale := exportLabelLocale(e, locale)
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	now := time.Now().UTC()
+	norm := loadNormalization(e.App, now)
+	totals := make(map[string]float64)
+	return streamCSV(e, "payments.csv", localizeHeader(exportHeader, labelLocale), func(offset int) ([]*core.Record, error) {
+		var records []*core.Record
+		err := e.App.RecordQuery("payments").
+			AndWhere(dbx.HashExp{"user": e.Auth.Id}).
+			AndWhere(dateRangeExp("nextPayment", from, to)).
+			OrderBy("nextPayment ASC", "id ASC").
+			Limit(exportBatchSize).
+			Offset(int64(offset)).
+			All(&records)
+		if err != nil {
+			return nil, err
+		}
+		if errs := e.App.ExpandRecords(records, []string{"provider", "system"}, nil); len(errs) > 0 {
+			requestid.Logger(e).Warn("Failed to expand payment relations", "errs", errs)
+		}
+		pm.revealNotes(e.App, records...)
+		for currency, monthly := range monthlyTotals(withoutArchived(records), now, norm) {
+			totals[currency] += monthly
+		}
+		return records, nil
+	}, func(record *core.Record) []string {
+		return exportRow(record, locale)
+	}, func() [][]string {
+		base := requestBase(e)
+		if base == "" && len(totals) == 1 {
+			for currency := range totals {
+				base = currency
+			}
+		}
+		return exportSummaryRows(totals, base, conv, locale, labelLocale)
+	})
+}
+
+// ExportHistoryCSV handles GET /api/beszel/payments/history/export.csv requests.
+// Streams the user's payment history as CSV, oldest first, loading it in batches like ExportCSV.
+// The optional from and to dates (YYYY-MM-DD, inclusive) bound paidAt. The header is written in
+// the user's locale like the payments export.
+func (pm *PaymentManager) ExportHistoryCSV(e *core.RequestEvent) error {
+	from, to, err := parseDateRange(e)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	locale := loadPaymentSettings(e.App, e.Auth.Id).Locale
+	return streamCSV(e, "payment_history.csv", localizeHeader(historyExportHeader, exportLabelLocale(e, locale)), func(offset int) ([]*core.Record, error) {
+		var records []*core.Record
+		err := e.App.RecordQuery("payment_history").
+			AndWhere(dbx.HashExp{"user": e.Auth.Id}).
+			AndWhere(dateRangeExp("paidAt", from, to)).
+			OrderBy("paidAt ASC", "id ASC").
+			Limit(exportBatchSize).
+			Offset(int64(offset)).
+			All(&records)
+		if err != nil {
+			return nil, err
+		}
+		if errs := e.App.ExpandRecords(records, []string{"payment.provider", "payment.system"}, nil); len(errs) > 0 {
+			requestid.Logger(e).Warn("Failed to expand payment history relations", "errs", errs)
+		}
+		return records, nil
+	}, func(record *core.Record) []string {
+		return historyExportRow(record, locale)
+	}, nil)
+}
@@ -0,0 +1,325 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportCSV(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.createPayment(t, map[string]any{
+		"system":      createSystem(t, f.hub, f.user, "web-1").Id,
+		"amount":      12.5,
+		"currency":    "EUR",
+		"nextPayment": "2030-02-01 00:00:00.000Z",
+		"country":     "DE",
+		"notes":       "includes backups, \"daily\"",
+		"taxRate":     19,
+	})
+	f.createPayment(t, map[string]any{
+		"system":      createSystem(t, f.hub, f.user, "db-1").Id,
+		"nextPayment": "2030-01-01 00:00:00.000Z",
+		"source":      "import",
+	})
+
+	otherUser, _ := createUserWithToken(t, f.hub, "other@example.com")
+	_, err := beszelTests.CreateRecord(f.hub, "payments", map[string]any{
+		"user":        otherUser.Id,
+		"system":      createSystem(t, f.hub, otherUser, "other").Id,
+		"provider":    createProvider(t, f.hub, otherUser, "Other").Id,
+		"period":      "monthly",
+		"nextPayment": "2030-01-01 00:00:00.000Z",
+		"amount":      1,
+		"currency":    "EUR",
+	})
+	require.NoError(t, err)
+	setRate(t, f.hub, "USD", "EUR", 0.5)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/export.csv",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "exports own payments with names resolved",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/export.csv?base=EUR",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{"provider,system,amount"},
+			NotExpectedContent: []string{"Other", f.provider.Id},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Equal(t, "text/csv; charset=utf-8", res.Header.Get("Content-Type"))
+				assert.Equal(t, `attachment; filename="payments.csv"`, res.Header.Get("Content-Disposition"))
+
+				raw, err := io.ReadAll(res.Body)
+				require.NoError(t, err)
+				body := string(raw)
+				rows, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+				require.NoError(t, err)
+				assert.Equal(t, [][]string{
+					{"provider", "system", "amount", "currency", "period", "nextPayment", "country", "notes", "gross", "source", "customIntervalDays", "customIntervalMonths", "formatted"},
+					{"Hetzner", "db-1", "10.00", "USD", "monthly", "2030-01-01 00:00:00.000Z", "", "", "10.00", "import", "", "", "$10.00"},
+					{"Hetzner", "web-1", "12.50", "EUR", "monthly", "2030-02-01 00:00:00.000Z", "DE", `includes backups, "daily"`, "14.88", "", "", "", "12,50 €"},
+					{"SUBTOTAL", "", "12.50", "EUR", "monthly", "", "", "", "", "", "", "", "12,50 €"},
+					{"SUBTOTAL", "", "150.00", "EUR", "annual", "", "", "", "", "", "", "", "150,00 €"},
+					{"SUBTOTAL", "", "10.00", "USD", "monthly", "", "", "", "", "", "", "", "$10.00"},
+					{"SUBTOTAL", "", "120.00", "USD", "annual", "", "", "", "", "", "", "", "$120.00"},
+					{"TOTAL", "", "17.50", "EUR", "monthly", "", "", "", "", "", "", "", "17,50 €"},
+					{"TOTAL", "", "210.00", "EUR", "annual", "", "", "", "", "", "", "", "210,00 €"},
+				}, rows)
+				assert.Contains(t, body, "\n\nSUBTOTAL,", "the summary should follow a blank line")
+			},
+		},
+		{
+			Name:               "from and to bound nextPayment",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/export.csv?from=2030-01-15&to=2030-02-01",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{"web-1"},
+			NotExpectedContent: []string{"db-1"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "invalid date range",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/export.csv?from=2030-02-01&to=2030-01-01",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"from must not be after to"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestExportHistoryCSV(t *testing.T) {
+	f := newPaymentFixture(t)
+	payment := f.createPayment(t, map[string]any{"system": f.system.Id})
+	for _, paidAt := range []string{"2029-12-15 00:00:00.000Z", "2030-01-15 00:00:00.000Z", "2030-02-15 00:00:00.000Z"} {
+		_, err := beszelTests.CreateRecord(f.hub, "payment_history", map[string]any{
+			"payment": payment.Id, "user": f.user.Id, "amount": 10, "currency": "USD", "paidAt": paidAt, "note": "paid " + paidAt[:10],
+		})
+		require.NoError(t, err)
+	}
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/history/export.csv",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "history of another user isn't exported",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/history/export.csv",
+			Headers:            map[string]string{"Authorization": otherToken},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{"provider,system,amount,currency,paidAt,note"},
+			NotExpectedContent: []string{"Hetzner"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "invalid from",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/history/export.csv?from=15.01.2030",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"from must be a date in YYYY-MM-DD format"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "date range is inclusive",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/history/export.csv?from=2030-01-01&to=2030-02-15",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{"paid 2030-02-15"},
+			NotExpectedContent: []string{"2029-12-15"},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Equal(t, `attachment; filename="payment_history.csv"`, res.Header.Get("Content-Disposition"))
+				rows, err := csv.NewReader(res.Body).ReadAll()
+				require.NoError(t, err)
+				assert.Equal(t, [][]string{
+					{"provider", "system", "amount", "currency", "paidAt", "note", "formatted"},
+					{"Hetzner", "server-1", "10.00", "USD", "2030-01-15 00:00:00.000Z", "paid 2030-01-15", "$10.00"},
+					{"Hetzner", "server-1", "10.00", "USD", "2030-02-15 00:00:00.000Z", "paid 2030-02-15", "$10.00"},
+				}, rows)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestExportLocalizedHeaders(t *testing.T) {
+	f := newPaymentFixture(t)
+	setPaymentSettings(t, f, map[string]any{"locale": "ru-RU"})
+	payment := f.createPayment(t, map[string]any{"system": f.system.Id})
+	_, err := beszelTests.CreateRecord(f.hub, "payment_history", map[string]any{
+		"payment": payment.Id, "user": f.user.Id, "amount": 10, "currency": "USD", "paidAt": "2030-01-15 00:00:00.000Z",
+	})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "payments export in the user's locale",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/export.csv",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"Hetzner,server-1,10.00,USD"},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				reader := csv.NewReader(res.Body)
+				reader.FieldsPerRecord = -1
+				rows, err := reader.ReadAll()
+				require.NoError(t, err)
+				require.Len(t, rows, 6)
+				assert.Equal(t, []string{"Провайдер", "Система", "Сумма", "Валюта", "Период", "Следующий платёж", "Страна", "Заметки",
+					"С налогом", "Источник", "Интервал (дни)", "Интервал (месяцы)", "Форматированная сумма"}, rows[0])
+				assert.Equal(t, "Подытог", rows[2][0])
+				assert.Equal(t, "Итого", rows[4][0])
+			},
+		},
+		{
+			Name:               "raw payments export headers",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/export.csv?headers=raw",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{"provider,system,amount,currency,period,nextPayment", "\nSUBTOTAL,", "\nTOTAL,", `"10,00 $"`},
+			NotExpectedContent: []string{"Провайдер", "Подытог"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "history export in the user's locale",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/history/export.csv",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"Провайдер,Система,Сумма,Валюта,Дата оплаты,Примечание,Форматированная сумма"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+// flushRecorder is a response writer that counts the written rows and the live heap at each
+// flush without keeping the body
+type flushRecorder struct {
+	header http.Header
+	rows   int
+	// bytes written since the last flush, and the most seen at a flush
+	pending, maxPending int
+	heap                []uint64
+}
+
+func (r *flushRecorder) Header() http.Header { return r.header }
+
+func (r *flushRecorder) WriteHeader(int) {}
+
+func (r *flushRecorder) Write(p []byte) (int, error) {
+	r.rows += bytes.Count(p, []byte("\n"))
+	r.pending += len(p)
+	return len(p), nil
+}
+
+func (r *flushRecorder) Flush() {
+	r.maxPending = max(r.maxPending, r.pending)
+	r.pending = 0
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	r.heap = append(r.heap, stats.HeapAlloc)
+}
+
+func TestExportHistoryStreamsLargeAccounts(t *testing.T) {
+	f := newPaymentFixture(t)
+	payment := f.createPayment(t, nil)
+
+	const rows = 10000
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := f.hub.RunInTransaction(func(txApp core.App) error {
+		for i := range rows {
+			_, err := txApp.DB().Insert("payment_history", dbx.Params{
+				"id":       core.GenerateDefaultRandomId(),
+				"payment":  payment.Id,
+				"user":     f.user.Id,
+				"amount":   10,
+				"currency": "USD",
+				"paidAt":   start.Add(time.Duration(i) * time.Hour).Format(types.DefaultDateLayout),
+			}).Execute()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	recorder := &flushRecorder{header: http.Header{}}
+	e := &core.RequestEvent{App: f.hub, Auth: f.user}
+	e.Request = httptest.NewRequest(http.MethodGet, "/api/beszel/payments/history/export.csv", nil)
+	e.Response = recorder
+	require.NoError(t, f.hub.GetPaymentManager().ExportHistoryCSV(e))
+
+	assert.Equal(t, rows+1, recorder.rows)
+	// written a batch at a time rather than all at once at the end
+	require.GreaterOrEqual(t, len(recorder.heap), rows/500)
+	assert.Less(t, recorder.maxPending, 100*1024)
+	// the live heap doesn't grow with the number of rows exported
+	first, last := recorder.heap[0], recorder.heap[len(recorder.heap)-1]
+	assert.Less(t, int64(last)-int64(first), int64(8*1024*1024), "heap grew from %d to %d bytes", first, last)
+}
@@ -0,0 +1,109 @@
+package payments
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// numberFormat describes how amounts are written in a locale
+type numberFormat struct {
+	group   string
+	decimal string
+	// whether the currency symbol follows the number, separated by a space
+	symbolAfter bool
+}
+
+// supported locales for formatted amounts
+var localeFormats = map[string]numberFormat{
+	"en": {group: ",", decimal: "."},
+	"ru": {group: " ", decimal: ",", symbolAfter: true},
+	"de": {group: ".", decimal: ",", symbolAfter: true},
+	"fr": {group: " ", decimal: ",", symbolAfter: true},
+}
+
+// locale used for unsupported currencies
+const defaultLocale = "en"
+
+// localeLanguage returns the language of a locale tag such as "ru-RU" or "en_US", which
+// selects its number format
+func localeLanguage(locale string) string {
+	language, _, _ := strings.Cut(strings.ReplaceAll(locale, "_", "-"), "-")
+	return strings.ToLower(language)
+}
+
+// isLocale reports whether amounts can be formatted for the locale tag
+func isLocale(locale string) bool {
+	_, ok := localeFormats[localeLanguage(locale)]
+	return ok
+}
+
+// formatAmount writes an amount rounded to its currency's minor unit with the currency's
+// symbol, grouping and decimal separator, e.g. "1 234,50 ₽" or "$1,234.50".
+// Only the language of locale is used, so "ru-RU" and "ru" format the same.
+// An empty locale uses the currency's own.
+func formatAmount(amount float64, currency, locale string) string {
+	symbol := currency
+	if c, ok := findCurrency(currency); ok {
+		symbol = c.Symbol
+		if locale == "" {
+			locale = c.Locale
+		}
+	}
+	format, ok := localeFormats[localeLanguage(locale)]
+	if !ok {
+		format = localeFormats[defaultLocale]
+	}
+	decimals := currencyDecimals(currency)
+
+	amount = roundAmount(amount, currency)
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	digits := strconv.FormatFloat(amount, 'f', decimals, 64)
+	whole, fraction, _ := strings.Cut(digits, ".")
+
+	var b strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(format.group)
+		}
+		b.WriteRune(digit)
+	}
+	if fraction != "" {
+		b.WriteString(format.decimal)
+		b.WriteString(fraction)
+	}
+	if format.symbolAfter {
+		return sign + b.String() + " " + symbol
+	}
+	return sign + symbol + b.String()
+}
+
+// FormatAmount handles GET /api/beszel/format requests.
+// Returns amount formatted for display in currency, in the optional locale (e.g. en-US or ru-RU,
+// in English, Russian, German or French) or else the user's locale setting. Without either
+// the currency's own locale is used.
+func (pm *PaymentManager) FormatAmount(e *core.RequestEvent) error {
+	query := e.Request.URL.Query()
+	amount, err := strconv.ParseFloat(query.Get("amount"), 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid amount"})
+	}
+	currency := query.Get("currency")
+	if !isCurrency(currency) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid currency"})
+	}
+	locale := query.Get("locale")
+	if locale == "" {
+		locale = loadPaymentSettings(e.App, e.Auth.Id).Locale
+	} else if !isLocale(locale) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid locale"})
+	}
+	return e.JSON(http.StatusOK, map[string]string{"formatted": formatAmount(amount, currency, locale)})
+}
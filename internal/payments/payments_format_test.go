@@ -0,0 +1,177 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/henrygd/beszel/internal/payments"
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		locale   string
+		want     string
+	}{
+		{1234.5, "RUB", "", "1 234,50 ₽"},
+		{1234.5, "USD", "", "$1,234.50"},
+		{1234.5, "EUR", "", "1.234,50 €"},
+		{1234567.891, "USD", "", "$1,234,567.89"},
+		{999, "USD", "", "$999.00"},
+		{0, "RUB", "", "0,00 ₽"},
+		{-1234.5, "USD", "", "-$1,234.50"},
+		{-5, "RUB", "", "-5,00 ₽"},
+		{1234.5, "RUB", "en", "₽1,234.50"},
+		{1234.5, "USD", "fr", "1 234,50 $"},
+		// only the language of a locale tag is used
+		{1234.5, "RUB", "ru-RU", "1 234,50 ₽"},
+		{1234.5, "RUB", "en-US", "₽1,234.50"},
+		{1234.5, "USD", "de_DE", "1.234,50 $"},
+		{1234.5, "GBP", "", "£1,234.50"},
+		{1234.5, "JPY", "", "¥1,235"},
+		{1234.5, "PLN", "", "1 234,50 zł"},
+		// unknown currencies use their code
+		{1234.5, "XXX", "", "XXX1,234.50"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, payments.FormatAmount(tt.amount, tt.currency, tt.locale), "%v %s %s", tt.amount, tt.currency, tt.locale)
+	}
+}
+
+func TestFormatApi(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/format?amount=1&currency=USD",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "currency defaults",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/format?amount=1234.5&currency=RUB",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"formatted":"1 234,50 ₽"}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "locale override",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/format?amount=1234.5&currency=EUR&locale=en",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"formatted":"€1,234.50"}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid amount",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/format?amount=NaN&currency=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"invalid amount"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid currency",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/format?amount=1&currency=XXX",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"invalid currency"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "locale tag",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/format?amount=1234.5&currency=RUB&locale=en-US",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"formatted":"₽1,234.50"}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid locale",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/format?amount=1&currency=USD&locale=xx",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"invalid locale"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestLocaleSetting(t *testing.T) {
+	f := newPaymentFixture(t)
+	setPaymentSettings(t, f, map[string]any{"locale": "ru-RU", "autoAdvance": false})
+	payment := f.createPayment(t, map[string]any{"amount": 1234.5, "nextPayment": "2030-01-15 00:00:00.000Z"})
+
+	// messages are formatted for the user's locale
+	_, err := f.hub.GetPaymentManager().AdvanceDuePayments(time.Date(2030, 1, 16, 0, 5, 0, 0, time.UTC))
+	require.NoError(t, err)
+	notification, err := f.hub.FindFirstRecordByData("notifications", "relatedPayment", payment.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "1 234,50 $ was due on 2030-01-15. Confirm it once paid to move to the next due date.", notification.GetString("body"))
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "format uses the locale setting",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/format?amount=1234.5&currency=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"formatted":"1 234,50 $"}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "query locale overrides the setting",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/format?amount=1234.5&currency=USD&locale=en-US",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"formatted":"$1,234.50"}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "exports",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/export.csv",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"1234.50,USD", `"1 234,50 $"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
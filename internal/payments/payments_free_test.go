@@ -0,0 +1,106 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFreePayments(t *testing.T) {
+	f := newPaymentFixture(t)
+	f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 10})
+	f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 0, "isFree": true})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	paymentBody := func(fields map[string]any) map[string]any {
+		body := map[string]any{
+			"user":        f.user.Id,
+			"system":      f.system.Id,
+			"provider":    f.provider.Id,
+			"period":      "monthly",
+			"nextPayment": "2030-01-15 00:00:00.000Z",
+			"currency":    "USD",
+		}
+		for k, v := range fields {
+			body[k] = v
+		}
+		return body
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "zero amount needs isFree",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"amount": 0})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"amount":{"code":"validation_amount_required"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "free payment with an amount",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"amount": 5, "isFree": true})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"amount":{"code":"validation_free_amount"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "free payment",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"amount": 0, "isFree": true})),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"isFree":true`, `"amount":0`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "free payments are left out of the summary",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?groupBy=system",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"counts":{"` + f.system.Id + `":1}`, `"totals":{"USD":10}`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestFreePaymentsAreReminded(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Date(2030, 1, 10, 0, 5, 0, 0, time.UTC)
+	free := f.createPayment(t, map[string]any{"amount": 0, "isFree": true, "nextPayment": now.AddDate(0, 0, 2), "reminderDays": 3})
+
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	notifications, err := f.hub.FindAllRecords("notifications", dbx.HashExp{"relatedPayment": free.Id})
+	require.NoError(t, err)
+	assert.Len(t, notifications, 1)
+
+	count, err := f.hub.GetPaymentManager().AdvanceDuePayments(now.AddDate(0, 0, 3))
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,120 @@
+package payments
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// names of the payment cron jobs in the health report
+const (
+	jobAdvancePayments   = "advancePayments"
+	jobNotifyDuePayments = "notifyDuePayments"
+	jobFetchRates        = "fetchRates"
+	jobSendDigests       = "sendDigests"
+	jobSnapshotSpend     = "snapshotSpend"
+)
+
+// window of the webhook delivery failures counted in the health report
+const webhookFailureWindow = 24 * time.Hour
+
+// payments due longer ago than this, after their grace days, without being advanced count as overdue.
+// The advance job runs daily, so anything older was missed by it.
+const overdueAdvanceAge = 24 * time.Hour
+
+// jobRun is the outcome of the last run of a cron job
+type jobRun struct {
+	LastRun types.DateTime `json:"lastRun"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// jobHealth tracks the cron job runs and webhook failures since the hub started
+type jobHealth struct {
+	mu              sync.Mutex
+	runs            map[string]jobRun
+	webhookFailures []time.Time
+}
+
+// jobRan records a finished run of the named job
+func (h *jobHealth) jobRan(name string, now time.Time, err error) {
+	run := jobRun{}
+	run.LastRun, _ = types.ParseDateTime(now)
+	if err != nil {
+		run.Error = err.Error()
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.runs == nil {
+		h.runs = make(map[string]jobRun)
+	}
+	h.runs[name] = run
+}
+
+// webhookFailed records a webhook delivery that failed after all retries
+func (h *jobHealth) webhookFailed(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.webhookFailures = append(h.pruneFailures(now), now)
+}
+
+// pruneFailures drops the failures older than webhookFailureWindow. Must be called with mu held.
+func (h *jobHealth) pruneFailures(now time.Time) []time.Time {
+	cutoff := now.Add(-webhookFailureWindow)
+	i := 0
+	for i < len(h.webhookFailures) && !h.webhookFailures[i].After(cutoff) {
+		i++
+	}
+	h.webhookFailures = h.webhookFailures[i:]
+	return h.webhookFailures
+}
+
+// snapshot returns the last run of each job, with empty runs for jobs that haven't run,
+// and the number of webhook failures in the window
+func (h *jobHealth) snapshot(now time.Time) (map[string]jobRun, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	runs := map[string]jobRun{jobAdvancePayments: {}, jobNotifyDuePayments: {}, jobFetchRates: {}, jobSendDigests: {}, jobSnapshotSpend: {}}
+	for name, run := range h.runs {
+		runs[name] = run
+	}
+	return runs, len(h.pruneFailures(now))
+}
+
+// GetHealth handles GET /api/beszel/health requests.
+// Reports the last run of the payment cron jobs, the number of payments overdue for
+// advancement, the age of the exchange rates and the webhook deliveries that failed
+// in the last 24 hours. Job runs and failures are kept in memory since the hub started.
+func (pm *PaymentManager) GetHealth(e *core.RequestEvent) error {
+	now := time.Now().UTC()
+	due, err := findDuePayments(e.App, now.Add(-overdueAdvanceAge), "")
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	// payments waiting for their user to confirm them aren't missed by the job
+	overdue, _ := splitManualAdvance(e.App, due)
+	overdue = slices.DeleteFunc(overdue, func(record *core.Record) bool {
+		graceEnds := record.GetDateTime("nextPayment").Time().AddDate(0, 0, record.GetInt("graceDays"))
+		return !graceEnds.Before(now.Add(-overdueAdvanceAge))
+	})
+	oldest, err := oldestRateFetch(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	rates := map[string]any{"oldestFetchedAt": "", "ageSeconds": nil, "stale": true}
+	if !oldest.IsZero() {
+		rates["oldestFetchedAt"], _ = types.ParseDateTime(oldest)
+		rates["ageSeconds"] = int(now.Sub(oldest).Seconds())
+		rates["stale"] = now.Sub(oldest) > staleRatesAge
+	}
+	jobs, failures := pm.health.snapshot(now)
+	return e.JSON(http.StatusOK, map[string]any{
+		"jobs":               jobs,
+		"overduePayments":    len(overdue),
+		"rates":              rates,
+		"webhookFailures24h": failures,
+	})
+}
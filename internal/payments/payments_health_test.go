@@ -0,0 +1,106 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/henrygd/beszel/internal/payments"
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealth(t *testing.T) {
+	// the test servers listen on loopback
+	t.Setenv("BESZEL_HUB_ALLOW_PRIVATE_DESTINATIONS", "true")
+	f := newPaymentFixture(t)
+	payments.SetWebhookBackoff(time.Millisecond)
+
+	superuser, err := beszelTests.CreateRecord(f.hub, core.CollectionNameSuperusers, map[string]any{
+		"email":    "superuser@example.com",
+		"password": "password123",
+	})
+	require.NoError(t, err)
+	superuserToken, err := superuser.NewAuthToken()
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, -3)})
+	// due today, so not yet missed by the advance job; both are reminded of below
+	f.createPayment(t, map[string]any{"nextPayment": now.Add(-time.Hour), "reminderChannels": webhookChannels})
+	f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 1), "reminderDays": 3, "reminderChannels": webhookChannels})
+	// rates entered by hand don't count towards the age
+	setRate(t, f.hub, "USD", "EUR", 0.9)
+	_, err = beszelTests.CreateRecord(f.hub, "exchange_rates", map[string]any{"base": "USD", "quote": "RUB", "rate": 90, "fetchedAt": now.Add(-72 * time.Hour)})
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	_, err = beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": server.URL, "enabled": true})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/health",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "users can't see health",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/health",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"not allowed"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "before any job ran",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/health",
+			Headers:        map[string]string{"Authorization": superuserToken},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"notifyDuePayments":{"lastRun":""}`,
+				`"overduePayments":1`,
+				`"ageSeconds":2592`,
+				`"stale":true`,
+				`"webhookFailures24h":0`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:    "after a run with a failed webhook",
+			Method:  http.MethodGet,
+			URL:     "/api/beszel/health",
+			Headers: map[string]string{"Authorization": superuserToken},
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				f.hub.GetPaymentManager().NotifyDuePayments()
+			},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"webhookFailures24h":2`, `"fetchRates":{"lastRun":""}`},
+			NotExpectedContent: []string{`"notifyDuePayments":{"lastRun":""}`},
+			TestAppFactory:     testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,215 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+const testPDF = "%PDF-1.4\n1 0 obj\n<<>>\nendobj\ntrailer\n<<>>\n%%EOF\n"
+
+func TestPaymentHistoryFile(t *testing.T) {
+	f := newPaymentFixture(t)
+	payment := f.createPayment(t, nil)
+	otherUser, _ := createUserWithToken(t, f.hub, "other@example.com")
+
+	history, err := beszelTests.CreateRecord(f.hub, "payment_history", map[string]any{
+		"payment":  payment.Id,
+		"user":     f.user.Id,
+		"amount":   10,
+		"currency": "USD",
+		"paidAt":   "2030-01-01 00:00:00.000Z",
+	})
+	require.NoError(t, err)
+	file, err := filesystem.NewFileFromBytes([]byte(testPDF), "invoice.pdf")
+	require.NoError(t, err)
+	history.Set("file", file)
+	require.NoError(t, f.hub.Save(history))
+	fileURL := "/api/files/payment_history/" + history.Id + "/" + history.GetString("file")
+
+	ownerFileToken, err := f.user.NewFileToken()
+	require.NoError(t, err)
+	otherFileToken, err := otherUser.NewFileToken()
+	require.NoError(t, err)
+
+	upload := func(name, content string) (*bytes.Buffer, string) {
+		body := new(bytes.Buffer)
+		mp := multipart.NewWriter(body)
+		for k, v := range map[string]string{
+			"payment":  payment.Id,
+			"user":     f.user.Id,
+			"amount":   "10",
+			"currency": "USD",
+			"paidAt":   "2030-02-01 00:00:00.000Z",
+		} {
+			require.NoError(t, mp.WriteField(k, v))
+		}
+		w, err := mp.CreateFormFile("file", name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, mp.Close())
+		return body, mp.FormDataContentType()
+	}
+	pdfBody, pdfType := upload("receipt.pdf", testPDF)
+	textBody, textType := upload("receipt.txt", "not a receipt")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "upload a pdf",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payment_history/records",
+			Headers:         map[string]string{"Authorization": f.token, "Content-Type": pdfType},
+			Body:            pdfBody,
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"file":"receipt_`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other file types are rejected",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payment_history/records",
+			Headers:         map[string]string{"Authorization": f.token, "Content-Type": textType},
+			Body:            textBody,
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"file":{"code":"validation_invalid_mime_type"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "download without a token",
+			Method:          http.MethodGet,
+			URL:             fileURL,
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "download with another user's token",
+			Method:          http.MethodGet,
+			URL:             fileURL + "?token=" + otherFileToken,
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "download with the owner's token",
+			Method:          http.MethodGet,
+			URL:             fileURL + "?token=" + ownerFileToken,
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"%PDF-1.4"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestPaymentHistoryOwnership(t *testing.T) {
+	f := newPaymentFixture(t)
+	payment := f.createPayment(t, nil)
+	otherUser, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+	otherPayment, err := beszelTests.CreateRecord(f.hub, "payments", map[string]any{
+		"name":        "Other",
+		"user":        otherUser.Id,
+		"system":      createSystem(t, f.hub, otherUser, "other-system").Id,
+		"provider":    createProvider(t, f.hub, otherUser, "Other provider").Id,
+		"amount":      5,
+		"currency":    "USD",
+		"period":      "monthly",
+		"nextPayment": "2030-01-01 00:00:00.000Z",
+	})
+	require.NoError(t, err)
+	otherHistory, err := beszelTests.CreateRecord(f.hub, "payment_history", map[string]any{
+		"payment":  otherPayment.Id,
+		"user":     otherUser.Id,
+		"amount":   5,
+		"currency": "USD",
+		"paidAt":   "2030-01-01 00:00:00.000Z",
+	})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:    "create for another user's payment",
+			Method:  http.MethodPost,
+			URL:     "/api/collections/payment_history/records",
+			Headers: map[string]string{"Authorization": otherToken},
+			Body: jsonReader(map[string]any{
+				"payment":  payment.Id,
+				"user":     f.user.Id,
+				"amount":   10,
+				"currency": "USD",
+				"paidAt":   "2030-02-01 00:00:00.000Z",
+			}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"Failed to create record"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:    "create as yourself for another user's payment",
+			Method:  http.MethodPost,
+			URL:     "/api/collections/payment_history/records",
+			Headers: map[string]string{"Authorization": otherToken},
+			Body: jsonReader(map[string]any{
+				"payment":  payment.Id,
+				"user":     otherUser.Id,
+				"amount":   10,
+				"currency": "USD",
+				"paidAt":   "2030-02-01 00:00:00.000Z",
+			}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"Failed to create record"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "move a charge to another user's payment",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payment_history/records/" + otherHistory.Id,
+			Headers:         map[string]string{"Authorization": otherToken},
+			Body:            jsonReader(map[string]any{"payment": payment.Id}),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:    "create for your own payment",
+			Method:  http.MethodPost,
+			URL:     "/api/collections/payment_history/records",
+			Headers: map[string]string{"Authorization": f.token},
+			Body: jsonReader(map[string]any{
+				"payment":  payment.Id,
+				"user":     f.user.Id,
+				"amount":   10,
+				"currency": "USD",
+				"paidAt":   "2030-02-01 00:00:00.000Z",
+			}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"amount":10`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,540 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreatePaymentDefaultsCurrencyFromProvider(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.provider.Set("currencyDefault", "EUR")
+	require.NoError(t, f.hub.Save(f.provider))
+	noDefaultProvider := createProvider(t, f.hub, f.user, "No default")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	paymentBody := func(fields map[string]any) map[string]any {
+		body := map[string]any{
+			"user":        f.user.Id,
+			"system":      createSystem(t, f.hub, f.user, "server").Id,
+			"provider":    f.provider.Id,
+			"period":      "monthly",
+			"nextPayment": "2030-01-15 00:00:00.000Z",
+			"amount":      10,
+		}
+		for k, v := range fields {
+			body[k] = v
+		}
+		return body
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "currency defaults from provider",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(nil)),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"currency":"EUR"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "explicit currency is kept",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"currency": "RUB"})),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"currency":"RUB"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "reminded in-app by default",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(nil)),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"reminderChannels":["inApp"]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "selected reminder channels are kept",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"reminderChannels": []string{"webhook", "email"}})),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"reminderChannels":["webhook","email"]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "unknown reminder channel fails validation",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"reminderChannels": []string{"sms"}})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"reminderChannels":{"code":"validation_invalid_value"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "any supported currency is accepted",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"currency": "JPY", "amount": 1200})),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"currency":"JPY"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "unsupported currency fails validation",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"currency": "XXX"})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"currency":{"code":"validation_invalid_value"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "no currency and no provider default fails validation",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"provider": noDefaultProvider.Id})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"currency":{"code":"validation_required"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "missing provider fails validation",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"provider": "doesnotexist123"})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"provider":{`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestPaymentRelationsMustBelongToUser(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	otherUser, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+	otherProvider := createProvider(t, f.hub, otherUser, "Other")
+	otherSystem := createSystem(t, f.hub, otherUser, "other")
+	payment := f.createPayment(t, nil)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	paymentBody := func(fields map[string]any) map[string]any {
+		body := map[string]any{
+			"user":        f.user.Id,
+			"system":      createSystem(t, f.hub, f.user, "server").Id,
+			"provider":    f.provider.Id,
+			"period":      "monthly",
+			"nextPayment": "2030-01-15 00:00:00.000Z",
+			"amount":      10,
+			"currency":    "USD",
+		}
+		for k, v := range fields {
+			body[k] = v
+		}
+		return body
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "create with another user's provider",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"provider": otherProvider.Id})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"provider":{"code":"validation_provider_not_owned"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "create with another user's system",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"system": otherSystem.Id})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"system":{"code":"validation_system_not_owned"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "create for another user with own relations",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": otherToken},
+			Body:            jsonReader(paymentBody(map[string]any{"user": otherUser.Id})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_provider_not_owned", "validation_system_not_owned"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "update to another user's provider",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"provider": otherProvider.Id}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_provider_not_owned"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "create with own relations",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(nil)),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"provider":"` + f.provider.Id + `"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestCreatePaymentDefaultsReminderDays(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	paymentBody := func(fields map[string]any) map[string]any {
+		body := map[string]any{
+			"user":        f.user.Id,
+			"system":      createSystem(t, f.hub, f.user, "server").Id,
+			"provider":    f.provider.Id,
+			"period":      "monthly",
+			"nextPayment": "2030-01-15 00:00:00.000Z",
+			"amount":      10,
+			"currency":    "USD",
+		}
+		for k, v := range fields {
+			body[k] = v
+		}
+		return body
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "reminderDays defaults to 3",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(nil)),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"reminderDays":3`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "explicit zero is kept",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"reminderDays": 0})),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"reminderDays":0`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "reminderDays above 365",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"reminderDays": 400})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"reminderDays"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestMultiplePaymentsPerSystem(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	system := createSystem(t, f.hub, f.user, "web-1")
+	compute := f.createPayment(t, map[string]any{"system": system.Id, "amount": 10})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:    "second payment for the same system",
+			Method:  http.MethodPost,
+			URL:     "/api/collections/payments/records",
+			Headers: map[string]string{"Authorization": f.token},
+			Body: jsonReader(map[string]any{
+				"user":        f.user.Id,
+				"system":      system.Id,
+				"provider":    f.provider.Id,
+				"period":      "monthly",
+				"nextPayment": "2030-01-15 00:00:00.000Z",
+				"amount":      5,
+				"currency":    "USD",
+			}),
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"system":"` + system.Id + `"`},
+			NotExpectedContent: []string{compute.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "system breakdown sums both payments",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?groupBy=system",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"` + system.Id + `":{"USD":15}`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestPaymentCountryMustBeKnown(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	payment := f.createPayment(t, map[string]any{"country": "DE"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "unknown country",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"country": "ZZ"}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"country":{"code":"validation_unknown_country"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "known country",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"country": "FI"}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"country":"FI"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "empty country",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"country": ""}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"country":""`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+
+	// saves outside of the API are validated too
+	_, err := beszelTests.CreateRecord(f.hub, "payments", map[string]any{
+		"user":        f.user.Id,
+		"system":      f.system.Id,
+		"provider":    f.provider.Id,
+		"period":      "monthly",
+		"nextPayment": "2030-01-15 00:00:00.000Z",
+		"amount":      10,
+		"currency":    "USD",
+		"country":     "XX",
+	})
+	require.ErrorContains(t, err, "country")
+}
+
+func TestNextPaymentBounds(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	payment := f.createPayment(t, nil)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "too far in the past",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"nextPayment": "1970-01-01 00:00:00.000Z"}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_next_payment_too_old", "more than 10 years in the past"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "too far in the future",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"nextPayment": time.Now().UTC().AddDate(21, 0, 0)}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_next_payment_too_far", "more than 20 years in the future"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "within bounds",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"nextPayment": time.Now().UTC().AddDate(-9, 0, 0)}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"id":"` + payment.Id + `"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestPaymentSource(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	superuser, err := beszelTests.CreateRecord(f.hub, core.CollectionNameSuperusers, map[string]any{
+		"email":    "superuser@example.com",
+		"password": "password123",
+	})
+	require.NoError(t, err)
+	superuserToken, err := superuser.NewAuthToken()
+	require.NoError(t, err)
+
+	newPayment := func(fields map[string]any) io.Reader {
+		data := map[string]any{
+			"user":        f.user.Id,
+			"system":      f.system.Id,
+			"provider":    f.provider.Id,
+			"period":      "monthly",
+			"nextPayment": "2030-01-15 00:00:00.000Z",
+			"amount":      10,
+			"currency":    "USD",
+		}
+		for k, v := range fields {
+			data[k] = v
+		}
+		return jsonReader(data)
+	}
+
+	var manualID string
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "created by a user",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            newPayment(map[string]any{"source": "import"}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"source":"manual"`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				var record struct {
+					Id string `json:"id"`
+				}
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&record))
+				manualID = record.Id
+			},
+		},
+		{
+			Name:            "created by a superuser",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": superuserToken},
+			Body:            newPayment(nil),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"source":"api"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "imported",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/import",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader([]map[string]any{{"system": f.system.Id, "provider": f.provider.Id, "period": "monthly", "nextPayment": "2030-01-15 00:00:00.000Z", "amount": 5, "currency": "USD", "source": "manual"}}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"count":1`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				records, err := app.FindAllRecords("payments", dbx.HashExp{"amount": 5})
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Equal(t, "import", records[0].GetString("source"))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+
+	// the id of the payment created above is only known now
+	scenario := beszelTests.ApiScenario{
+		Name:            "source can't be changed",
+		Method:          http.MethodPatch,
+		URL:             "/api/collections/payments/records/" + manualID,
+		Headers:         map[string]string{"Authorization": f.token},
+		Body:            jsonReader(map[string]any{"source": "cron"}),
+		ExpectedStatus:  200,
+		ExpectedContent: []string{`"source":"manual"`},
+		TestAppFactory:  testAppFactory,
+	}
+	scenario.Test(t)
+}
@@ -0,0 +1,80 @@
+package payments
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// header clients set to make a request safe to retry
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// header set on responses replayed for an idempotency key
+const idempotentReplayedHeader = "Idempotent-Replayed"
+
+// how long a processed idempotency key is replayed for
+const idempotencyKeyTTL = 24 * time.Hour
+
+// maximum length of an idempotency key
+const maxIdempotencyKeyLength = 255
+
+// errIdempotencyKeyReused is returned when a key is sent again with a different request body
+var errIdempotencyKeyReused = errors.New("Idempotency-Key was already used for a different request")
+
+// requestHash returns the hex SHA-256 of a request body
+func requestHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// findIdempotencyKey returns the user's unexpired record for key, or nil if the key wasn't processed.
+// Expired keys of every user are deleted first.
+func findIdempotencyKey(app core.App, userID, key string, now time.Time) (*core.Record, error) {
+	nowStr, err := types.ParseDateTime(now)
+	if err != nil {
+		return nil, err
+	}
+	_, err = app.DB().Delete("idempotency_keys", dbx.NewExp("expiresAt <= {:now}", dbx.Params{"now": nowStr.String()})).Execute()
+	if err != nil {
+		return nil, err
+	}
+	record, err := app.FindFirstRecordByFilter("idempotency_keys", "user = {:user} && key = {:key}",
+		dbx.Params{"user": userID, "key": key})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return record, err
+}
+
+// saveIdempotencyKey stores the response of a request processed with key, to be replayed for
+// idempotencyKeyTTL
+func saveIdempotencyKey(app core.App, userID, key, hash string, response any, now time.Time) error {
+	collection, err := app.FindCachedCollectionByNameOrId("idempotency_keys")
+	if err != nil {
+		return err
+	}
+	record := core.NewRecord(collection)
+	record.Set("user", userID)
+	record.Set("key", key)
+	record.Set("requestHash", hash)
+	record.Set("response", response)
+	record.Set("expiresAt", now.Add(idempotencyKeyTTL))
+	return app.Save(record)
+}
+
+// replayIdempotencyKey responds with the response stored for an idempotency key, or with 422 if
+// the key was processed for a different request body
+func replayIdempotencyKey(e *core.RequestEvent, record *core.Record, hash string) error {
+	if record.GetString("requestHash") != hash {
+		return e.JSON(http.StatusUnprocessableEntity, map[string]string{"error": errIdempotencyKeyReused.Error()})
+	}
+	e.Response.Header().Set(idempotentReplayedHeader, "true")
+	return e.JSON(http.StatusOK, record.Get("response"))
+}
@@ -0,0 +1,192 @@
+package payments
+
+import (
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maximum number of payments accepted by a single import
+const maxImportRows = 1000
+
+// fields managed by the server that are ignored when importing
+var importIgnoredFields = []string{"id", "user", "created", "updated", "billingDay", "lastAdvancedAt", "lastReminderSentAt", "source"}
+
+// importRowError holds the validation errors of one row of an import, by index in the request
+type importRowError struct {
+	Row    int `json:"row"`
+	Errors any `json:"errors"`
+}
+
+// newImportRowError keeps field validation errors as is and falls back to the error message
+func newImportRowError(row int, err error) importRowError {
+	if errs, ok := err.(validation.Errors); ok {
+		return importRowError{Row: row, Errors: errs}
+	}
+	return importRowError{Row: row, Errors: err.Error()}
+}
+
+// resolveUserRecord finds the user's record in collection by id or by its name field
+func resolveUserRecord(app core.App, collection, ownerFilter, userID, ref string) (*core.Record, error) {
+	return app.FindFirstRecordByFilter(collection,
+		ownerFilter+" && (id = {:ref} || name = {:ref})",
+		dbx.Params{"user": userID, "ref": ref},
+	)
+}
+
+// newImportedPayment builds an unsaved payment for the user from an import row.
+// Provider and system may be given by id or by name. Payment methods and categories are given
+// by id and must belong to the user, and amounts can't have more decimals than their currency,
+// like when creating a payment through the API.
+func newImportedPayment(app core.App, collection *core.Collection, userID string, row map[string]any) (*core.Record, error) {
+	record := core.NewRecord(collection)
+	errs := validation.Errors{}
+	for key, value := range row {
+		if slices.Contains(importIgnoredFields, key) || collection.Fields.GetByName(key) == nil {
+			continue
+		}
+		switch key {
+		case "provider":
+			ref, _ := value.(string)
+			provider, err := resolveUserRecord(app, "providers", "user = {:user}", userID, ref)
+			if err != nil {
+				errs[key] = validation.NewError("validation_provider_not_found", "Provider not found.")
+				continue
+			}
+			value = provider.Id
+		case "system":
+			ref, _ := value.(string)
+			system, err := resolveUserRecord(app, "systems", "users.id ?= {:user}", userID, ref)
+			if err != nil {
+				errs[key] = validation.NewError("validation_system_not_found", "System not found.")
+				continue
+			}
+			value = system.Id
+		}
+		record.Set(key, value)
+	}
+	record.Set("user", userID)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	_, hasReminderDays := row["reminderDays"]
+	_, hasGraceDays := row["graceDays"]
+	prepareNewPayment(app, record, SourceImport, hasReminderDays, hasGraceDays)
+	// checked here since imports are saved without the API's create request hooks
+	if ownerErrs, ok := validateRelationOwners(app, record).(validation.Errors); ok {
+		maps.Copy(errs, ownerErrs)
+	}
+	if precisionErrs, ok := checkAmountPrecision(record).(validation.Errors); ok {
+		maps.Copy(errs, precisionErrs)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if err := app.Validate(record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// ImportPayments handles POST /api/beszel/payments/import requests.
+// Creates every payment in the JSON array body in a single transaction. If any
+// row is invalid nothing is created and the errors of each row are returned with 422.
+// A successful import sent with an Idempotency-Key header is stored with the key for 24 hours,
+// and sending the same body with the key again returns the original result without creating
+// anything. Reusing a key for a different body is rejected with 422. An import that would take the
+// user over PAYMENT_LIMIT is rejected with 403 as a whole.
+func (pm *PaymentManager) ImportPayments(e *core.RequestEvent) error {
+	body, err := io.ReadAll(e.Request.Body)
+	if err != nil {
+		return e.BadRequestError("Failed to read request body", err)
+	}
+	now := time.Now().UTC()
+	key, hash := e.Request.Header.Get(idempotencyKeyHeader), requestHash(body)
+	if len(key) > maxIdempotencyKeyLength {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "Idempotency-Key must be at most 255 characters"})
+	}
+	if key != "" {
+		processed, err := findIdempotencyKey(e.App, e.Auth.Id, key, now)
+		if err != nil {
+			return e.InternalServerError("", err)
+		}
+		if processed != nil {
+			return replayIdempotencyKey(e, processed, hash)
+		}
+	}
+
+	var rows []map[string]any
+	if err := json.Unmarshal(body, &rows); err != nil {
+		return e.BadRequestError("Body must be a JSON array of payments", err)
+	}
+	if len(rows) == 0 || len(rows) > maxImportRows {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "import must contain between 1 and 1000 payments"})
+	}
+
+	collection, err := e.App.FindCachedCollectionByNameOrId("payments")
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+
+	// validate every row before saving anything
+	records := make([]*core.Record, 0, len(rows))
+	var rowErrors []importRowError
+	for i, row := range rows {
+		record, err := newImportedPayment(e.App, collection, e.Auth.Id, row)
+		if err != nil {
+			rowErrors = append(rowErrors, newImportRowError(i, err))
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(rowErrors) > 0 {
+		return e.JSON(http.StatusUnprocessableEntity, map[string]any{"error": "invalid payments", "rows": rowErrors})
+	}
+	if err := pm.checkPaymentLimit(e, len(records)); err != nil {
+		return limitError(e, err)
+	}
+
+	// saving can still fail on database constraints that validation doesn't check.
+	// The idempotency key is saved along with the payments, so a retry finds either both or neither.
+	failedRow := -1
+	result := map[string]any{"count": len(records)}
+	err = e.App.RunInTransaction(func(txApp core.App) error {
+		ids := make([]string, len(records))
+		for i, record := range records {
+			if err := txApp.SaveWithContext(withActor(e), record); err != nil {
+				failedRow = i
+				return err
+			}
+			ids[i] = record.Id
+		}
+		result["ids"] = ids
+		if key == "" {
+			return nil
+		}
+		return saveIdempotencyKey(txApp, e.Auth.Id, key, hash, result, now)
+	})
+	if err != nil {
+		if failedRow < 0 {
+			// a concurrent request with the same key may have completed first
+			if key != "" {
+				if processed, findErr := findIdempotencyKey(e.App, e.Auth.Id, key, now); findErr == nil && processed != nil {
+					return replayIdempotencyKey(e, processed, hash)
+				}
+			}
+			return e.InternalServerError("", err)
+		}
+		return e.JSON(http.StatusUnprocessableEntity, map[string]any{
+			"error": "invalid payments",
+			"rows":  []importRowError{newImportRowError(failedRow, err)},
+		})
+	}
+	return e.JSON(http.StatusOK, result)
+}
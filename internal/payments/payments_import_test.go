@@ -0,0 +1,235 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportPayments(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	web := createSystem(t, f.hub, f.user, "web-1")
+	db := createSystem(t, f.hub, f.user, "db-1")
+	otherUser, _ := createUserWithToken(t, f.hub, "other@example.com")
+	otherProvider := createProvider(t, f.hub, otherUser, "Other")
+	otherMethod, err := beszelTests.CreateRecord(f.hub, "payment_methods", map[string]any{"user": otherUser.Id, "label": "Other card", "type": "card"})
+	require.NoError(t, err)
+	otherCategory, err := beszelTests.CreateRecord(f.hub, "categories", map[string]any{"user": otherUser.Id, "name": "other"})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	countPayments := func(t testing.TB, app *pbTests.TestApp) int {
+		records, err := app.FindAllRecords("payments", dbx.HashExp{"user": f.user.Id})
+		require.NoError(t, err)
+		return len(records)
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/import",
+			Body:            strings.NewReader(`[]`),
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "not an array",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/import",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            strings.NewReader(`{"amount":1}`),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"JSON array"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "empty array",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/import",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            strings.NewReader(`[]`),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"between 1 and 1000"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:    "invalid rows create nothing",
+			Method:  http.MethodPost,
+			URL:     "/api/beszel/payments/import",
+			Headers: map[string]string{"Authorization": f.token},
+			Body: jsonReader([]map[string]any{
+				{"provider": "Hetzner", "system": "web-1", "period": "monthly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 5, "currency": "USD"},
+				{"provider": "Other", "system": "db-1", "period": "monthly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 5, "currency": "USD"},
+				{"provider": "Hetzner", "system": "db-1", "period": "hourly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 5, "currency": "USD"},
+			}),
+			ExpectedStatus: 422,
+			ExpectedContent: []string{
+				`{"row":1,"errors":{"provider":"Provider not found."}}`,
+				`"row":2,"errors":{"period":`,
+			},
+			NotExpectedContent: []string{`"row":0`},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Equal(t, 0, countPayments(t, app))
+			},
+		},
+		{
+			Name:    "another user's provider id is not resolved",
+			Method:  http.MethodPost,
+			URL:     "/api/beszel/payments/import",
+			Headers: map[string]string{"Authorization": f.token},
+			Body: jsonReader([]map[string]any{
+				{"provider": otherProvider.Id, "system": web.Id, "period": "monthly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 5, "currency": "USD"},
+			}),
+			ExpectedStatus:  422,
+			ExpectedContent: []string{"Provider not found."},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:    "another user's payment method and categories",
+			Method:  http.MethodPost,
+			URL:     "/api/beszel/payments/import",
+			Headers: map[string]string{"Authorization": f.token},
+			Body: jsonReader([]map[string]any{
+				{"provider": f.provider.Id, "system": web.Id, "period": "monthly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 5, "currency": "USD",
+					"paymentMethod": otherMethod.Id, "categories": []string{otherCategory.Id}},
+			}),
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"row":0`, "Categories must belong to the payment's user.", "Payment method doesn't belong to the payment's user."},
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Equal(t, 0, countPayments(t, app))
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:    "amount with more decimals than the currency",
+			Method:  http.MethodPost,
+			URL:     "/api/beszel/payments/import",
+			Headers: map[string]string{"Authorization": f.token},
+			Body: jsonReader([]map[string]any{
+				{"provider": f.provider.Id, "system": web.Id, "period": "monthly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 9.999, "currency": "USD"},
+			}),
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"row":0`, "USD amounts can't have more than 2 decimals."},
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Equal(t, 0, countPayments(t, app))
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:    "imports by name and id",
+			Method:  http.MethodPost,
+			URL:     "/api/beszel/payments/import",
+			Headers: map[string]string{"Authorization": f.token},
+			Body: jsonReader([]map[string]any{
+				{"provider": "Hetzner", "system": "web-1", "period": "monthly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 5, "currency": "USD"},
+				{"provider": f.provider.Id, "system": db.Id, "period": "annual", "nextPayment": "2030-03-31 00:00:00.000Z", "amount": 50, "currency": "EUR", "reminderDays": 30},
+			}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"count":2`, `"ids":["`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				records, err := app.FindAllRecords("payments", dbx.HashExp{"user": f.user.Id})
+				require.NoError(t, err)
+				require.Len(t, records, 2)
+				for _, record := range records {
+					assert.Equal(t, f.provider.Id, record.GetString("provider"))
+					switch record.GetString("system") {
+					case web.Id:
+						assert.Equal(t, 3, record.GetInt("reminderDays"))
+						assert.Equal(t, 1, record.GetInt("billingDay"))
+					case db.Id:
+						assert.Equal(t, 30, record.GetInt("reminderDays"))
+						assert.Equal(t, 31, record.GetInt("billingDay"))
+					default:
+						t.Errorf("unexpected system %s", record.GetString("system"))
+					}
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestImportIdempotencyKey(t *testing.T) {
+	f := newPaymentFixture(t)
+	other, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+	createProvider(t, f.hub, other, "Hetzner")
+	createSystem(t, f.hub, other, "server-1")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+	countPayments := func(t testing.TB, userID string) int {
+		count, err := f.hub.CountRecords("payments", dbx.HashExp{"user": userID})
+		require.NoError(t, err)
+		return int(count)
+	}
+	rows := []map[string]any{{"provider": "Hetzner", "system": "server-1", "period": "monthly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 5, "currency": "USD"}}
+	importScenario := func(name, token, key string, body any, status int, content ...string) *beszelTests.ApiScenario {
+		return &beszelTests.ApiScenario{
+			Name:            name,
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/import",
+			Headers:         map[string]string{"Authorization": token, "Idempotency-Key": key},
+			Body:            jsonReader(body),
+			ExpectedStatus:  status,
+			ExpectedContent: content,
+			TestAppFactory:  testAppFactory,
+		}
+	}
+
+	importScenario("first import", f.token, "import-1", rows, 200, `"count":1`).Test(t)
+	require.Equal(t, 1, countPayments(t, f.user.Id))
+	payment, err := f.hub.FindFirstRecordByFilter("payments", "user = {:user}", dbx.Params{"user": f.user.Id})
+	require.NoError(t, err)
+
+	replay := importScenario("retry returns the original result", f.token, "import-1", rows, 200, `{"count":1,"ids":["`+payment.Id+`"]}`)
+	replay.AfterTestFunc = func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+		assert.Equal(t, "true", res.Header.Get("Idempotent-Replayed"))
+	}
+	replay.Test(t)
+	assert.Equal(t, 1, countPayments(t, f.user.Id), "a retry shouldn't create the payments again")
+
+	changed := []map[string]any{{"provider": "Hetzner", "system": "server-1", "period": "annual", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 50, "currency": "USD"}}
+	importScenario("key reused for a different body", f.token, "import-1", changed, 422, "already used for a different request").Test(t)
+	importScenario("key too long", f.token, strings.Repeat("k", 256), rows, 400, "at most 255 characters").Test(t)
+
+	// keys belong to the user who sent them
+	importScenario("same key of another user", otherToken, "import-1", rows, 200, `"count":1`).Test(t)
+	assert.Equal(t, 1, countPayments(t, other.Id))
+
+	// failed imports aren't stored, so they can be retried with the same key
+	invalid := []map[string]any{{"provider": "Missing", "system": "server-1", "period": "monthly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 5, "currency": "USD"}}
+	importScenario("invalid import", f.token, "import-2", invalid, 422, "Provider not found.").Test(t)
+	importScenario("fixed import with the same key", f.token, "import-2", rows, 200, `"count":1`).Test(t)
+	assert.Equal(t, 2, countPayments(t, f.user.Id))
+
+	// expired keys are processed again
+	_, err = f.hub.DB().Update("idempotency_keys", dbx.Params{"expiresAt": "2020-01-01 00:00:00.000Z"}, dbx.HashExp{"key": "import-1"}).Execute()
+	require.NoError(t, err)
+	importScenario("expired key", f.token, "import-1", rows, 200, `"count":1`).Test(t)
+	assert.Equal(t, 3, countPayments(t, f.user.Id))
+	remaining, err := f.hub.CountRecords("idempotency_keys", dbx.HashExp{"key": "import-1"})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, remaining, "expired keys should be deleted")
+}
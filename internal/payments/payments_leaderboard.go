@@ -0,0 +1,107 @@
+package payments
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// leaderboardPayment is a payment ranked by its monthly cost
+type leaderboardPayment struct {
+	Id           string  `json:"id"`
+	Provider     string  `json:"provider"`
+	ProviderName string  `json:"providerName"`
+	System       string  `json:"system"`
+	SystemName   string  `json:"systemName"`
+	Amount       float64 `json:"amount"`
+	Currency     string  `json:"currency"`
+	Period       string  `json:"period"`
+	// monthly equivalent of the amount, converted to the leaderboard currency
+	Monthly float64 `json:"monthly"`
+}
+
+// GetLeaderboard handles GET /api/beszel/payments/leaderboard requests.
+// Returns the user's most and least expensive payments (limit of each, default 5, max 50)
+// ranked by their monthly equivalent converted to base, the user's default currency if absent.
+// Archived, paused and free payments and payments in a free trial are left out.
+func (pm *PaymentManager) GetLeaderboard(e *core.RequestEvent) error {
+	limit, err := parseIntParam(e, "limit", 5, 50)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	base := requestBase(e)
+	if base == "" {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "base currency is required"})
+	}
+	if !isCurrency(base) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid base currency"})
+	}
+
+	records, err := findUserPayments(e.App, e.Auth.Id)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	now := time.Now().UTC()
+	norm := loadNormalization(e.App, now)
+	ranked := make([]leaderboardPayment, 0, len(records))
+	ranking := make([]*core.Record, 0, len(records))
+	for _, record := range withoutArchived(records) {
+		if inTrial(record, now) || isFree(record) || isPaused(record) {
+			continue
+		}
+		amount := effectiveAmount(record, now)
+		monthly, ok := norm.monthlyAmount(record, amount)
+		if !ok {
+			continue
+		}
+		converted, ok := conv.convert(monthly, record.GetString("currency"), base)
+		if !ok {
+			continue
+		}
+		ranked = append(ranked, leaderboardPayment{
+			Id:       record.Id,
+			Provider: record.GetString("provider"),
+			System:   record.GetString("system"),
+			Amount:   amount,
+			Currency: record.GetString("currency"),
+			Period:   record.GetString("period"),
+			Monthly:  roundAmount(converted, base),
+		})
+		ranking = append(ranking, record)
+	}
+	if err := conv.err(); err != nil {
+		return convertError(e, err)
+	}
+	if errs := e.App.ExpandRecords(ranking, []string{"provider", "system"}, nil); len(errs) > 0 {
+		requestid.Logger(e).Warn("Failed to expand payment relations", "errs", errs)
+	}
+	for i, record := range ranking {
+		if provider := record.ExpandedOne("provider"); provider != nil {
+			ranked[i].ProviderName = provider.GetString("name")
+		}
+		if system := record.ExpandedOne("system"); system != nil {
+			ranked[i].SystemName = system.GetString("name")
+		}
+	}
+
+	slices.SortFunc(ranked, func(a, b leaderboardPayment) int {
+		return cmp.Or(cmp.Compare(b.Monthly, a.Monthly), cmp.Compare(a.Id, b.Id))
+	})
+	most := ranked[:min(limit, len(ranked))]
+	least := slices.Clone(ranked[max(len(ranked)-limit, 0):])
+	slices.Reverse(least)
+	return e.JSON(http.StatusOK, map[string]any{
+		"currency": base,
+		"most":     most,
+		"least":    least,
+	})
+}
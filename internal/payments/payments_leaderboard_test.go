@@ -0,0 +1,89 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+)
+
+func TestLeaderboard(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Now().UTC()
+	web := createSystem(t, f.hub, f.user, "web-1")
+	monthly := f.createPayment(t, map[string]any{"system": web.Id, "amount": 10})
+	annual := f.createPayment(t, map[string]any{"amount": 120, "currency": "EUR", "period": "annual"})
+	quarterly := f.createPayment(t, map[string]any{"amount": 45, "period": "quarterly"})
+	cheap := f.createPayment(t, map[string]any{"amount": 100, "currency": "RUB"})
+	paused := f.createPayment(t, map[string]any{"amount": 1000, "status": "paused"})
+	archived := f.createPayment(t, map[string]any{"amount": 500, "archivedAt": now})
+	trial := f.createPayment(t, map[string]any{"amount": 0.5, "trialEndsAt": now.AddDate(0, 1, 0)})
+	setRate(t, f.hub, "EUR", "USD", 1.2)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	excluded := []string{paused.Id, archived.Id, trial.Id}
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/leaderboard?base=USD",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid limit",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/leaderboard?base=USD&limit=500",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"limit must be an integer between 1 and 50"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "missing rate",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/leaderboard?base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"missing":[{"base":"RUB","quote":"USD"}]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:    "most and least expensive",
+			Method:  http.MethodGet,
+			URL:     "/api/beszel/payments/leaderboard?base=USD&limit=2",
+			Headers: map[string]string{"Authorization": f.token},
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "USD", "RUB", 100)
+			},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"currency":"USD"`,
+				`"most":[{"id":"` + quarterly.Id + `","provider":"` + f.provider.Id + `","providerName":"Hetzner"`,
+				`"amount":45,"currency":"USD","period":"quarterly","monthly":15},{"id":"` + annual.Id + `"`,
+				`"monthly":12}]`,
+				`"least":[{"id":"` + cheap.Id + `"`,
+				`"monthly":1},{"id":"` + monthly.Id + `"`,
+				`"systemName":"web-1"`,
+			},
+			NotExpectedContent: excluded,
+			TestAppFactory:     testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
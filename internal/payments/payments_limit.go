@@ -0,0 +1,72 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// user roles that aren't held to the payment limit
+var paymentLimitExemptRoles = []string{"admin", "paid"}
+
+// paymentLimitError is returned when creating payments would take a user over the payment limit
+type paymentLimitError struct {
+	Count int
+	Limit int
+}
+
+func (err *paymentLimitError) Error() string {
+	return fmt.Sprintf("payment limit reached: %d of %d payments", err.Count, err.Limit)
+}
+
+// loadPaymentLimit returns the maximum number of payments per user set with the PAYMENT_LIMIT
+// environment variable, or 0 if payments aren't limited
+func loadPaymentLimit() (int, error) {
+	value, _ := getEnv("PAYMENT_LIMIT")
+	if value == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("invalid PAYMENT_LIMIT %q: must be a whole number of payments", value)
+	}
+	return limit, nil
+}
+
+// checkPaymentLimit returns a *paymentLimitError if creating n more payments for the authenticated
+// user would go over the payment limit. Superusers and users with an exempt role aren't limited.
+// Archived payments count towards the limit, since they are still stored. Requests without auth
+// are left to the collection rules.
+func (pm *PaymentManager) checkPaymentLimit(e *core.RequestEvent, n int) error {
+	if pm.paymentLimit <= 0 || e.Auth == nil || e.HasSuperuserAuth() ||
+		slices.Contains(paymentLimitExemptRoles, e.Auth.GetString("role")) {
+		return nil
+	}
+	count, err := e.App.CountRecords("payments", dbx.HashExp{"user": e.Auth.Id})
+	if err != nil {
+		return err
+	}
+	if int(count)+n > pm.paymentLimit {
+		return &paymentLimitError{Count: int(count), Limit: pm.paymentLimit}
+	}
+	return nil
+}
+
+// limitError responds to a payment limit check error: 403 with the current count and limit, or 500
+// for any other error
+func limitError(e *core.RequestEvent, err error) error {
+	var limitErr *paymentLimitError
+	if !errors.As(err, &limitErr) {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusForbidden, map[string]any{
+		"error": fmt.Sprintf("You have reached the limit of %d payments.", limitErr.Limit),
+		"count": limitErr.Count,
+		"limit": limitErr.Limit,
+	})
+}
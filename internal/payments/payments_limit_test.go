@@ -0,0 +1,118 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentLimit(t *testing.T) {
+	t.Setenv("BESZEL_HUB_PAYMENT_LIMIT", "2")
+	f := newPaymentFixture(t)
+	payment := f.createPayment(t, nil)
+	f.createPayment(t, nil)
+
+	paid, err := beszelTests.CreateRecord(f.hub, "users", map[string]any{
+		"email":    "paid@example.com",
+		"password": "password123",
+		"role":     "paid",
+	})
+	require.NoError(t, err)
+	paidToken, err := paid.NewAuthToken()
+	require.NoError(t, err)
+	p := &paymentFixture{hub: f.hub, user: paid, token: paidToken, provider: createProvider(t, f.hub, paid, "Paid")}
+	p.createPayment(t, nil)
+	p.createPayment(t, nil)
+
+	superuser, err := beszelTests.CreateRecord(f.hub, core.CollectionNameSuperusers, map[string]any{
+		"email":    "superuser@example.com",
+		"password": "password123",
+	})
+	require.NoError(t, err)
+	superuserToken, err := superuser.NewAuthToken()
+	require.NoError(t, err)
+
+	paymentBody := func(owner *paymentFixture) map[string]any {
+		return map[string]any{
+			"user":        owner.user.Id,
+			"system":      createSystem(t, f.hub, owner.user, "new-"+owner.user.Id).Id,
+			"provider":    owner.provider.Id,
+			"period":      "monthly",
+			"nextPayment": "2030-01-15 00:00:00.000Z",
+			"amount":      10,
+			"currency":    "USD",
+		}
+	}
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "create over the limit",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(f)),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{`"count":2`, `"limit":2`, "limit of 2 payments"},
+			ExpectedEvents:  map[string]int{"OnRecordCreate": 0},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "clone over the limit",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/clone",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"nextPayment": "2030-02-01 00:00:00.000Z"}),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{`"count":2`, `"limit":2`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:    "import over the limit",
+			Method:  http.MethodPost,
+			URL:     "/api/beszel/payments/import",
+			Headers: map[string]string{"Authorization": f.token},
+			Body: jsonReader([]map[string]any{
+				{"provider": f.provider.Id, "system": "server-1", "period": "monthly", "nextPayment": "2030-01-01 00:00:00.000Z", "amount": 5, "currency": "USD"},
+			}),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{`"count":2`, `"limit":2`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "paid role isn't limited",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": paidToken},
+			Body:            jsonReader(paymentBody(p)),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"user":"` + paid.Id + `"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "superusers aren't limited",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": superuserToken},
+			Body:            jsonReader(paymentBody(f)),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"user":"` + f.user.Id + `"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,128 @@
+package payments
+
+import (
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// paymentList is a page of payments in the shape of the PocketBase list API
+type paymentList struct {
+	Page       int            `json:"page"`
+	PerPage    int            `json:"perPage"`
+	TotalItems int            `json:"totalItems"`
+	TotalPages int            `json:"totalPages"`
+	Items      []*core.Record `json:"items"`
+}
+
+// likePattern returns a LIKE pattern matching values containing s, escaped with \
+func likePattern(s string) string {
+	return "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s) + "%"
+}
+
+// paymentListFilters builds the conditions of a payment list request from its query parameters.
+// Returns the name of the first invalid parameter if one can't be used.
+func paymentListFilters(e *core.RequestEvent) ([]dbx.Expression, string) {
+	query := e.Request.URL.Query()
+	exprs := []dbx.Expression{dbx.HashExp{"user": e.Auth.Id}}
+	if !includeArchived(e) {
+		exprs = append(exprs, dbx.HashExp{"archivedAt": ""})
+		// asking for cancelled payments by status lists them without includeArchived
+		if query.Get("status") != StatusCancelled {
+			exprs = append(exprs, dbx.Not(dbx.HashExp{"status": StatusCancelled}))
+		}
+	}
+	if currency := query.Get("currency"); currency != "" {
+		if !isCurrency(currency) {
+			return nil, "currency"
+		}
+		exprs = append(exprs, dbx.HashExp{"currency": currency})
+	}
+	if period := query.Get("period"); period != "" {
+		if !isPeriod(period) {
+			return nil, "period"
+		}
+		exprs = append(exprs, dbx.HashExp{"period": period})
+	}
+	if status := query.Get("status"); status != "" {
+		if _, ok := statusTransitions[status]; !ok {
+			return nil, "status"
+		}
+		// payments created before statuses existed have none and are active
+		if status == StatusActive {
+			exprs = append(exprs, dbx.In("status", StatusActive, ""))
+		} else {
+			exprs = append(exprs, dbx.HashExp{"status": status})
+		}
+	}
+	for _, relation := range []string{"provider", "system"} {
+		if id := query.Get(relation); id != "" {
+			exprs = append(exprs, dbx.HashExp{relation: id})
+		}
+	}
+	for param, op := range map[string]string{"dueBefore": "<", "dueAfter": ">"} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		date, err := types.ParseDateTime(value)
+		if err != nil || date.IsZero() {
+			return nil, param
+		}
+		exprs = append(exprs, dbx.NewExp("nextPayment != '' AND nextPayment "+op+" {:"+param+"}", dbx.Params{param: date.String()}))
+	}
+	if q := strings.TrimSpace(query.Get("q")); q != "" {
+		exprs = append(exprs, dbx.NewExp(`(notes LIKE {:q} ESCAPE '\'`+
+			` OR provider IN (SELECT id FROM providers WHERE name LIKE {:q} ESCAPE '\')`+
+			` OR system IN (SELECT id FROM systems WHERE name LIKE {:q} ESCAPE '\'))`,
+			dbx.Params{"q": likePattern(q)}))
+	}
+	return exprs, ""
+}
+
+// ListPayments handles GET /api/beszel/payments requests.
+// Lists the user's payments, soonest due first, filtered by the optional currency,
+// period, status, provider and system ids, dueBefore / dueAfter dates and q, which
+// matches notes and provider and system names. Archived and cancelled payments are
+// left out unless includeArchived=true is passed. Paginated with page and perPage
+// (default 30, max 200).
+func (pm *PaymentManager) ListPayments(e *core.RequestEvent) error {
+	page, err := parseIntParam(e, "page", 1, math.MaxInt32)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	perPage, err := parseIntParam(e, "perPage", 30, 200)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	exprs, invalid := paymentListFilters(e)
+	if invalid != "" {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid " + invalid})
+	}
+
+	var total int
+	if err := e.App.RecordQuery("payments").Select("COUNT(*)").AndWhere(dbx.And(exprs...)).Row(&total); err != nil {
+		return e.InternalServerError("", err)
+	}
+	items := []*core.Record{}
+	err = e.App.RecordQuery("payments").
+		AndWhere(dbx.And(exprs...)).
+		OrderBy("nextPayment ASC", "id ASC").
+		Limit(int64(perPage)).
+		Offset(int64((page - 1) * perPage)).
+		All(&items)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, paymentList{
+		Page:       page,
+		PerPage:    perPage,
+		TotalItems: total,
+		TotalPages: int(math.Ceil(float64(total) / float64(perPage))),
+		Items:      items,
+	})
+}
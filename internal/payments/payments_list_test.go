@@ -0,0 +1,205 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListPayments(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	web := createSystem(t, f.hub, f.user, "web-1")
+	backupProvider := createProvider(t, f.hub, f.user, "BackupCo")
+	monthlyUSD := f.createPayment(t, map[string]any{"nextPayment": "2030-01-01 00:00:00.000Z"})
+	annualEUR := f.createPayment(t, map[string]any{
+		"currency":    "EUR",
+		"period":      "annual",
+		"system":      web.Id,
+		"nextPayment": "2030-03-01 00:00:00.000Z",
+	})
+	backup := f.createPayment(t, map[string]any{"provider": backupProvider.Id, "nextPayment": "2030-02-01 00:00:00.000Z"})
+	paused := f.createPayment(t, map[string]any{"status": "paused", "nextPayment": "2030-04-01 00:00:00.000Z"})
+	cancelled := f.createPayment(t, map[string]any{"status": "cancelled", "nextPayment": "2030-05-01 00:00:00.000Z"})
+	archived := f.createPayment(t, map[string]any{"archivedAt": "2029-01-01 00:00:00.000Z"})
+
+	otherUser, _ := createUserWithToken(t, f.hub, "other@example.com")
+	other, err := beszelTests.CreateRecord(f.hub, "payments", map[string]any{
+		"user":        otherUser.Id,
+		"system":      createSystem(t, f.hub, otherUser, "other").Id,
+		"provider":    createProvider(t, f.hub, otherUser, "Other").Id,
+		"period":      "monthly",
+		"nextPayment": "2030-01-01 00:00:00.000Z",
+		"amount":      1,
+		"currency":    "USD",
+	})
+	require.NoError(t, err)
+
+	// listIds reads the ids of the listed payments in order
+	listIds := func(t testing.TB, res *http.Response) (ids []string, totalItems, totalPages int) {
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		var list struct {
+			TotalItems int `json:"totalItems"`
+			TotalPages int `json:"totalPages"`
+			Items      []struct {
+				Id string `json:"id"`
+			} `json:"items"`
+		}
+		require.NoError(t, json.Unmarshal(body, &list))
+		for _, item := range list.Items {
+			ids = append(ids, item.Id)
+		}
+		return ids, list.TotalItems, list.TotalPages
+	}
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "own active and paused payments by due date",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"page":1`, `"perPage":30`},
+			NotExpectedContent: []string{other.Id, cancelled.Id, archived.Id},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				ids, total, pages := listIds(t, res)
+				assert.Equal(t, []string{monthlyUSD.Id, backup.Id, annualEUR.Id, paused.Id}, ids)
+				assert.Equal(t, 4, total)
+				assert.Equal(t, 1, pages)
+			},
+		},
+		{
+			Name:            "combined filters",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?currency=EUR&period=annual&system=" + web.Id + "&dueAfter=2030-02-15",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":1`, annualEUR.Id},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "provider and due before",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?provider=" + backupProvider.Id + "&dueBefore=2030-03-01",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":1`, backup.Id},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "search matches provider name",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?q=backup",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":1`, backup.Id},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "search treats wildcards literally",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?q=%25",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":0`, `"items":[]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "active status includes payments without one",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?status=active",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":3`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "cancelled status lists cancelled payments",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?status=cancelled",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":1`, cancelled.Id},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "include archived",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?includeArchived=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totalItems":6`, archived.Id, cancelled.Id},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "second page",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?perPage=3&page=2",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"page":2`, `"perPage":3`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				ids, total, pages := listIds(t, res)
+				assert.Equal(t, []string{paused.Id}, ids)
+				assert.Equal(t, 4, total)
+				assert.Equal(t, 2, pages)
+			},
+		},
+		{
+			Name:            "perPage above 200",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?perPage=201",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"perPage must be an integer between 1 and 200"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid period",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?period=fortnightly",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"invalid period"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid due date",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments?dueBefore=soon",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"invalid dueBefore"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,99 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryGroupByMethod(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	visa, err := beszelTests.CreateRecord(f.hub, "payment_methods", map[string]any{"user": f.user.Id, "label": "Visa ending 4242", "type": "card"})
+	require.NoError(t, err)
+
+	f.createPayment(t, map[string]any{"amount": 10, "paymentMethod": visa.Id})
+	f.createPayment(t, map[string]any{"amount": 5, "paymentMethod": visa.Id})
+	f.createPayment(t, map[string]any{"amount": 3})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenario := beszelTests.ApiScenario{
+		Name:           "grouped by payment method",
+		Method:         http.MethodGet,
+		URL:            "/api/beszel/payments/summary?groupBy=method",
+		Headers:        map[string]string{"Authorization": f.token},
+		ExpectedStatus: 200,
+		ExpectedContent: []string{
+			`"` + visa.Id + `":{"USD":15}`,
+			`"__none__":{"USD":3}`,
+			`"` + visa.Id + `":"Visa ending 4242"`,
+		},
+		TestAppFactory: testAppFactory,
+	}
+	scenario.Test(t)
+}
+
+func TestDeletePaymentMethodKeepsPayment(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	method, err := beszelTests.CreateRecord(f.hub, "payment_methods", map[string]any{"user": f.user.Id, "label": "PayPal", "type": "paypal"})
+	require.NoError(t, err)
+	payment := f.createPayment(t, map[string]any{"paymentMethod": method.Id})
+
+	require.NoError(t, f.hub.Delete(method))
+
+	record, err := f.hub.FindRecordById("payments", payment.Id)
+	require.NoError(t, err)
+	assert.Empty(t, record.GetString("paymentMethod"))
+}
+
+func TestPaymentMethodMustBelongToUser(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	otherUser, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+	otherMethod, err := beszelTests.CreateRecord(f.hub, "payment_methods", map[string]any{"user": otherUser.Id, "label": "Other card", "type": "card"})
+	require.NoError(t, err)
+	payment := f.createPayment(t, nil)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "another user's payment method",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"paymentMethod": otherMethod.Id}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_payment_method_not_owned"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "create a payment method for another user",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payment_methods/records",
+			Headers:         map[string]string{"Authorization": otherToken},
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "label": "Planted card", "type": "card"}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"Failed to create record"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,164 @@
+package payments
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// prefix of notes encrypted at rest, followed by the base64 of the nonce and the sealed notes
+const encryptedNotesPrefix = "enc:v1:"
+
+// maximum length of notes in characters. The notes fields allow more to hold the encrypted notes.
+const maxNotesLength = 1000
+
+// collections whose notes field is encrypted at rest when a key is configured
+var encryptedNotesCollections = []string{"payments", "providers"}
+
+// notesCipher encrypts and decrypts notes with AES-256-GCM. A nil notesCipher leaves notes as plain text.
+type notesCipher struct {
+	aead cipher.AEAD
+}
+
+// newNotesCipher returns a cipher keyed with the SHA-256 of secret, or nil if secret is empty
+func newNotesCipher(secret string) (*notesCipher, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &notesCipher{aead: aead}, nil
+}
+
+// loadNotesCipher returns the cipher for the NOTES_ENCRYPTION_KEY environment variable.
+// Without a key notes are stored as plain text. Notes encrypted before the key was removed or
+// changed can't be read anymore and are returned as they are stored.
+func loadNotesCipher() (*notesCipher, error) {
+	secret, _ := getEnv("NOTES_ENCRYPTION_KEY")
+	c, err := newNotesCipher(secret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NOTES_ENCRYPTION_KEY: %w", err)
+	}
+	return c, nil
+}
+
+// encrypt returns notes sealed with a random nonce. Empty notes stay empty.
+func (c *notesCipher) encrypt(notes string) (string, error) {
+	if c == nil || notes == "" {
+		return notes, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(notes), nil)
+	return encryptedNotesPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt returns the plain text of encrypted notes. Notes stored as plain text are returned as is.
+func (c *notesCipher) decrypt(value string) (string, error) {
+	encoded, ok := strings.CutPrefix(value, encryptedNotesPrefix)
+	if !ok {
+		return value, nil
+	}
+	if c == nil {
+		return value, errors.New("notes are encrypted but NOTES_ENCRYPTION_KEY isn't set")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return value, err
+	}
+	size := c.aead.NonceSize()
+	if len(sealed) < size {
+		return value, errors.New("encrypted notes are too short")
+	}
+	plain, err := c.aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return value, err
+	}
+	return string(plain), nil
+}
+
+// encryptNotes checks the length of the notes of a payment or provider being saved and encrypts
+// them when a key is configured. Runs for every save, including the ones made outside of the API.
+// Unchanged notes that are already encrypted are kept as they are. New notes starting with the
+// encrypted prefix are rejected, so ciphertext can't be stored without its length being checked.
+func (pm *PaymentManager) encryptNotes(e *core.RecordEvent) error {
+	notes := e.Record.GetString("notes")
+	if notes == "" {
+		return e.Next()
+	}
+	if strings.HasPrefix(notes, encryptedNotesPrefix) {
+		if !e.Record.IsNew() && notes == e.Record.Original().GetString("notes") {
+			return e.Next()
+		}
+		return validation.Errors{"notes": validation.NewError("validation_notes_encrypted_prefix",
+			fmt.Sprintf("Notes can't start with %q.", encryptedNotesPrefix))}
+	}
+	if utf8.RuneCountInString(notes) > maxNotesLength {
+		return validation.Errors{"notes": validation.NewError("validation_notes_too_long",
+			fmt.Sprintf("Notes can't be longer than %d characters.", maxNotesLength))}
+	}
+	encrypted, err := pm.notes.encrypt(notes)
+	if err != nil {
+		return err
+	}
+	e.Record.Set("notes", encrypted)
+	return e.Next()
+}
+
+// revealNotes replaces the encrypted notes of records with their plain text, for responses only.
+// Notes that can't be decrypted are left encrypted.
+func (pm *PaymentManager) revealNotes(app core.App, records ...*core.Record) {
+	for _, record := range records {
+		notes, err := pm.notes.decrypt(record.GetString("notes"))
+		if err != nil {
+			app.Logger().Warn("Failed to decrypt notes", "collection", record.Collection().Name, "id", record.Id, "err", err)
+			continue
+		}
+		record.Set("notes", notes)
+	}
+}
+
+// ViewPayment handles GET /api/beszel/payments/{id} requests.
+// Returns one of the user's payments, or one shared with them, with its notes decrypted. When
+// NOTES_ENCRYPTION_KEY is set the collection API returns the encrypted notes, so clients should
+// read notes from here.
+func (pm *PaymentManager) ViewPayment(e *core.RequestEvent) error {
+	record, err := findViewablePayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	pm.revealNotes(e.App, record)
+	return e.JSON(http.StatusOK, record)
+}
+
+// ViewProvider handles GET /api/beszel/providers/{id} requests.
+// Returns one of the user's providers with its notes decrypted, like ViewPayment.
+func (pm *PaymentManager) ViewProvider(e *core.RequestEvent) error {
+	record, err := e.App.FindFirstRecordByFilter("providers", "id = {:id} && user = {:user}",
+		dbx.Params{"id": e.Request.PathValue("id"), "user": e.Auth.Id})
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	pm.revealNotes(e.App, record)
+	return e.JSON(http.StatusOK, record)
+}
@@ -0,0 +1,208 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotesWithoutEncryptionKey(t *testing.T) {
+	f := newPaymentFixture(t)
+	payment := f.createPayment(t, map[string]any{"notes": "account 12345"})
+
+	stored, err := f.hub.FindRecordById("payments", payment.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "account 12345", stored.GetString("notes"), "notes should be stored as plain text without a key")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "notes too long",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Body:            jsonReader(map[string]any{"notes": strings.Repeat("n", 1001)}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_notes_too_long"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "notes with the encrypted prefix",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Body:            jsonReader(map[string]any{"notes": "enc:v1:" + strings.Repeat("A", 5000)}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_notes_encrypted_prefix"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "view",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"notes":"account 12345"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestNotesEncryption(t *testing.T) {
+	t.Setenv("BESZEL_HUB_NOTES_ENCRYPTION_KEY", "correct horse battery staple")
+	f := newPaymentFixture(t)
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	payment := f.createPayment(t, map[string]any{"system": f.system.Id, "notes": "IBAN DE89 3704 0044 0532 0130 00"})
+	f.provider.Set("notes", "customer number 4711")
+	require.NoError(t, f.hub.Save(f.provider))
+
+	stored, err := f.hub.FindRecordById("payments", payment.Id)
+	require.NoError(t, err)
+	ciphertext := stored.GetString("notes")
+	assert.True(t, strings.HasPrefix(ciphertext, "enc:v1:"))
+	assert.NotContains(t, ciphertext, "DE89")
+	provider, err := f.hub.FindRecordById("providers", f.provider.Id)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(provider.GetString("notes"), "enc:v1:"))
+
+	// saving other changes keeps the encrypted notes as they are
+	stored.Set("amount", 20)
+	require.NoError(t, f.hub.Save(stored))
+	stored, err = f.hub.FindRecordById("payments", payment.Id)
+	require.NoError(t, err)
+	assert.Equal(t, ciphertext, stored.GetString("notes"))
+
+	sharedUser, sharedToken := createUserWithToken(t, f.hub, "shared@example.com")
+	stored.Set("sharedWith", []string{sharedUser.Id})
+	require.NoError(t, f.hub.Save(stored))
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:               "collection API returns the encrypted notes",
+			Method:             http.MethodGet,
+			URL:                "/api/collections/payments/records/" + payment.Id,
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"notes":"enc:v1:`},
+			NotExpectedContent: []string{"DE89"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "view payment decrypts the notes",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"id":"` + payment.Id + `"`, `"notes":"IBAN DE89 3704 0044 0532 0130 00"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "view provider decrypts the notes",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/providers/" + f.provider.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"notes":"customer number 4711"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "view payment decrypts the notes for a shared viewer",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id,
+			Headers:         map[string]string{"Authorization": sharedToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"notes":"IBAN DE89 3704 0044 0532 0130 00"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id,
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "notes updated through the collection API are encrypted",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Body:            jsonReader(map[string]any{"notes": "new account 999"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"notes":"enc:v1:`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", payment.Id)
+				require.NoError(t, err)
+				assert.NotEqual(t, ciphertext, record.GetString("notes"))
+				assert.NotContains(t, record.GetString("notes"), "999")
+			},
+		},
+		{
+			Name:               "export decrypts the notes",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/export.csv",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{"new account 999"},
+			NotExpectedContent: []string{"enc:v1:"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "another payment's encrypted notes can't be copied",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Body:            jsonReader(map[string]any{"notes": ciphertext}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_notes_encrypted_prefix"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "clone encrypts the notes again",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/clone",
+			Body:            jsonReader(map[string]any{"nextPayment": "2030-02-01 00:00:00.000Z"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"notes":"enc:v1:`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				source, err := app.FindRecordById("payments", payment.Id)
+				require.NoError(t, err)
+				clones, err := app.FindRecordsByFilter("payments", "id != {:id}", "", 0, 0, dbx.Params{"id": payment.Id})
+				require.NoError(t, err)
+				require.Len(t, clones, 1)
+				assert.True(t, strings.HasPrefix(clones[0].GetString("notes"), "enc:v1:"))
+				assert.NotEqual(t, source.GetString("notes"), clones[0].GetString("notes"))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,105 @@
+package payments
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Notification types
+const (
+	NotificationPaymentDue     = "payment_due"
+	NotificationPaymentDigest  = "payment_digest"
+	NotificationPaymentConfirm = "payment_confirm"
+)
+
+// createDueNotification adds an in-app notification for a payment that is due soon.
+// Dates are shown in loc, the user's timezone, and amounts formatted for the user's locale.
+func createDueNotification(app core.App, record *core.Record, loc *time.Location, locale string) error {
+	collection, err := app.FindCachedCollectionByNameOrId("notifications")
+	if err != nil {
+		return err
+	}
+	payload := newWebhookPayload(record, locale)
+	name := payload.ProviderName
+	if name == "" {
+		name = "Payment"
+	}
+	notification := core.NewRecord(collection)
+	notification.Set("user", record.GetString("user"))
+	notification.Set("type", NotificationPaymentDue)
+	notification.Set("title", fmt.Sprintf("%s payment due", name))
+	notification.Set("body", fmt.Sprintf("%s due on %s", payload.Formatted,
+		payload.NextPayment.Time().In(loc).Format(time.DateOnly)))
+	notification.Set("relatedPayment", record.Id)
+	return app.Save(notification)
+}
+
+// createConfirmNotification asks the user to confirm the charge of a due payment that isn't
+// advanced automatically, unless they were already asked about that due date.
+// Dates are shown in loc, the user's timezone, and amounts formatted for the user's locale.
+func createConfirmNotification(app core.App, record *core.Record, loc *time.Location, locale string) error {
+	payload := newWebhookPayload(record, locale)
+	name := payload.ProviderName
+	if name == "" {
+		name = "Payment"
+	}
+	dueDate := record.GetDateTime("nextPayment")
+	asked, err := app.CountRecords("notifications",
+		dbx.HashExp{"relatedPayment": record.Id, "type": NotificationPaymentConfirm, "dueDate": dueDate.String()})
+	if err != nil || asked > 0 {
+		return err
+	}
+	collection, err := app.FindCachedCollectionByNameOrId("notifications")
+	if err != nil {
+		return err
+	}
+	notification := core.NewRecord(collection)
+	notification.Set("user", record.GetString("user"))
+	notification.Set("type", NotificationPaymentConfirm)
+	notification.Set("title", fmt.Sprintf("%s payment due — confirm?", name))
+	notification.Set("body", fmt.Sprintf("%s was due on %s. Confirm it once paid to move to the next due date.",
+		payload.Formatted, dueDate.Time().In(loc).Format(time.DateOnly)))
+	notification.Set("relatedPayment", record.Id)
+	notification.Set("dueDate", dueDate)
+	return app.Save(notification)
+}
+
+// GetNotifications handles GET /api/beszel/notifications requests.
+// Returns the user's latest notifications (limit, default 50, max 200), newest first.
+// With unread=true only the ones not marked as read are returned.
+func (pm *PaymentManager) GetNotifications(e *core.RequestEvent) error {
+	limit, err := parseIntParam(e, "limit", 50, 200)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	filter := "user = {:user}"
+	if e.Request.URL.Query().Get("unread") == "true" {
+		filter += " && readAt = ''"
+	}
+	records, err := e.App.FindRecordsByFilter("notifications", filter, "-created,-id", limit, 0, dbx.Params{"user": e.Auth.Id})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, records)
+}
+
+// MarkNotificationRead handles POST /api/beszel/notifications/{id}/read requests.
+// Marking a notification that was already read keeps its original readAt.
+func (pm *PaymentManager) MarkNotificationRead(e *core.RequestEvent) error {
+	record, err := e.App.FindFirstRecordByFilter("notifications", "id = {:id} && user = {:user}",
+		dbx.Params{"id": e.Request.PathValue("id"), "user": e.Auth.Id})
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	if record.GetDateTime("readAt").IsZero() {
+		record.Set("readAt", time.Now().UTC())
+		if err := e.App.Save(record); err != nil {
+			return e.BadRequestError("Failed to update notification", err)
+		}
+	}
+	return e.JSON(http.StatusOK, record)
+}
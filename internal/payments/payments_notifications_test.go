@@ -0,0 +1,129 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotifications(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Date(2030, 1, 10, 0, 15, 0, 0, time.UTC)
+	due := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 2), "amount": 9.5, "currency": "EUR", "reminderDays": 3})
+	f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 10), "reminderDays": 3})
+
+	// reminders are stored in-app even without webhooks
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	sent, err = f.hub.GetPaymentManager().NotifyDuePaymentsAt(now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	notifications, err := f.hub.FindAllRecords("notifications", dbx.HashExp{"user": f.user.Id})
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	notification := notifications[0]
+	assert.Equal(t, "payment_due", notification.GetString("type"))
+	assert.Equal(t, "Hetzner payment due", notification.GetString("title"))
+	assert.Equal(t, "9,50 € due on 2030-01-12", notification.GetString("body"))
+	assert.Equal(t, due.Id, notification.GetString("relatedPayment"))
+
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/notifications",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "other users don't see them",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/notifications",
+			Headers:            map[string]string{"Authorization": otherToken},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{"[]"},
+			NotExpectedContent: []string{notification.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "unread",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/notifications?unread=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{notification.Id, `"readAt":""`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other users can't mark them as read",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/notifications/" + notification.Id + "/read",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "mark as read",
+			Method:             http.MethodPost,
+			URL:                "/api/beszel/notifications/" + notification.Id + "/read",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{notification.Id},
+			NotExpectedContent: []string{`"readAt":""`},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "read notifications are left out of unread",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/notifications?unread=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"[]"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "all notifications",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/notifications",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{notification.Id},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "users can't create notifications",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/notifications/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "type": "payment_due", "title": "fake"}),
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"superusers"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
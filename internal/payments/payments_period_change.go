@@ -0,0 +1,66 @@
+package payments
+
+import (
+	"errors"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// recomputeOnPeriodChange moves nextPayment onto the new schedule when a payment's period changes,
+// so a payment switched from monthly to annual isn't left due a month out. The new date is the
+// first charge on or after today counted from startedAt, and the billing day becomes the day of
+// startedAt. This is the default for every update, including the ones made outside of the API.
+// nextPayment is kept when it's changed in the same update, when the payment has no startedAt or
+// became one-time, and for collection API updates sent with ?recomputeNext=false.
+func (pm *PaymentManager) recomputeOnPeriodChange(e *core.RecordEvent) error {
+	if _, keep := pm.keepNextPayment.Load(e.Record); !keep {
+		if err := recomputeNextPayment(e.Record, time.Now().UTC()); err != nil {
+			return err
+		}
+	}
+	return e.Next()
+}
+
+// recomputeNextPayment sets the next charge of a payment whose period changed, as described by
+// recomputeOnPeriodChange. The record is left unchanged if there's nothing to recompute.
+func recomputeNextPayment(record *core.Record, now time.Time) error {
+	original := record.Original()
+	if record.GetString("period") == original.GetString("period") ||
+		!record.GetDateTime("nextPayment").Equal(original.GetDateTime("nextPayment")) {
+		return nil
+	}
+	next := record.GetDateTime("startedAt").Time()
+	if next.IsZero() {
+		return nil
+	}
+	anchorDay := next.Day()
+	today := now.Truncate(24 * time.Hour)
+	for periods := 0; next.Before(today); periods++ {
+		if periods == maxAdvancePeriods {
+			return errTooManyPeriods
+		}
+		var err error
+		if next, err = addPaymentPeriod(record, next, anchorDay); errors.Is(err, errNotRecurring) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	record.Set("nextPayment", next)
+	record.Set("billingDay", anchorDay)
+	record.Set("lastAdvancedAt", "")
+	record.Set("lastReminderSentAt", "")
+	return nil
+}
+
+// trackRecomputeNext remembers collection API updates sent with ?recomputeNext=false, whose
+// nextPayment is kept when the period changes
+func (pm *PaymentManager) trackRecomputeNext(e *core.RecordRequestEvent) error {
+	if e.Request.URL.Query().Get("recomputeNext") != "false" {
+		return e.Next()
+	}
+	pm.keepNextPayment.Store(e.Record, true)
+	defer pm.keepNextPayment.Delete(e.Record)
+	return e.Next()
+}
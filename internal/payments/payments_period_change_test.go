@@ -0,0 +1,87 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecomputeNextPaymentOnPeriodChange(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	// started on the 15th two years ago, so the next annual charge is on the 15th of this
+	// month, or of the same month next year once that has passed
+	now := time.Now().UTC()
+	startedAt := time.Date(now.Year()-2, now.Month(), 15, 0, 0, 0, 0, time.UTC)
+	expected := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.UTC)
+	if expected.Before(now.Truncate(24 * time.Hour)) {
+		expected = expected.AddDate(1, 0, 0)
+	}
+	nextPayment := now.AddDate(0, 0, 20).Truncate(24 * time.Hour)
+	fields := map[string]any{"startedAt": startedAt, "nextPayment": nextPayment, "billingDay": nextPayment.Day()}
+
+	recomputed := f.createPayment(t, fields)
+	kept := f.createPayment(t, fields)
+	explicit := f.createPayment(t, fields)
+	notStarted := f.createPayment(t, map[string]any{"nextPayment": nextPayment})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	type test struct {
+		name    string
+		url     string
+		body    map[string]any
+		payment string
+		want    time.Time
+	}
+	tests := []test{
+		{"period change recomputes nextPayment from startedAt", "/api/collections/payments/records/" + recomputed.Id,
+			map[string]any{"period": "annual"}, recomputed.Id, expected},
+		{"recomputeNext=false keeps nextPayment", "/api/collections/payments/records/" + kept.Id + "?recomputeNext=false",
+			map[string]any{"period": "annual"}, kept.Id, nextPayment},
+		{"nextPayment set in the same update is kept", "/api/collections/payments/records/" + explicit.Id,
+			map[string]any{"period": "annual", "nextPayment": "2031-06-01 00:00:00.000Z"}, explicit.Id, date(2031, 6, 1)},
+		{"payment without startedAt keeps nextPayment", "/api/collections/payments/records/" + notStarted.Id,
+			map[string]any{"period": "annual"}, notStarted.Id, nextPayment},
+	}
+
+	for _, test := range tests {
+		scenario := beszelTests.ApiScenario{
+			Name:            test.name,
+			Method:          http.MethodPatch,
+			URL:             test.url,
+			Body:            jsonReader(test.body),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"period":"annual"`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", test.payment)
+				require.NoError(t, err)
+				assert.Equal(t, test.want, record.GetDateTime("nextPayment").Time())
+			},
+		}
+		scenario.Test(t)
+	}
+
+	record, err := f.hub.FindRecordById("payments", recomputed.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 15, record.GetInt("billingDay"), "the billing day should follow startedAt")
+
+	// updates made outside of the API are recomputed too
+	record.Set("period", "quarterly")
+	require.NoError(t, f.hub.Save(record))
+	assert.False(t, record.GetDateTime("nextPayment").Time().Before(now.Truncate(24*time.Hour)))
+	assert.Equal(t, 15, record.GetDateTime("nextPayment").Time().Day())
+}
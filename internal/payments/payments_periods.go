@@ -0,0 +1,183 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Billing periods supported by the payments collection
+const (
+	PeriodDaily      = "daily"
+	PeriodWeekly     = "weekly"
+	PeriodMonthly    = "monthly"
+	PeriodQuarterly  = "quarterly"
+	PeriodSemiannual = "semiannual"
+	PeriodAnnual     = "annual"
+	// billed every customIntervalDays or every customIntervalMonths of the payment
+	PeriodCustom = "custom"
+	// charged a single time on nextPayment and archived afterwards
+	PeriodOnce = "once"
+)
+
+// errNotRecurring is returned when advancing a one-time payment, which has no following charge
+var errNotRecurring = errors.New("one-time payments aren't charged again")
+
+// number of months in each month based period
+var periodMonths = map[string]int{
+	PeriodMonthly:    1,
+	PeriodQuarterly:  3,
+	PeriodSemiannual: 6,
+	PeriodAnnual:     12,
+}
+
+// addPeriod returns t advanced by one billing period.
+//
+// Month based periods keep anchorDay (the original billing day of month) and clamp
+// to the last day of shorter months, so a payment on the 31st lands on Feb 28 and
+// returns to Mar 31. If anchorDay is zero the day of t is used. Months are counted from
+// the first of the month, so an annual payment anchored on Feb 29 renews on Feb 28 in
+// common years and on Feb 29 again in leap years without drifting.
+// One-time payments return errNotRecurring.
+func addPeriod(t time.Time, period string, anchorDay int) (time.Time, error) {
+	switch period {
+	case PeriodDaily:
+		return t.AddDate(0, 0, 1), nil
+	case PeriodWeekly:
+		return t.AddDate(0, 0, 7), nil
+	case PeriodOnce:
+		return t, errNotRecurring
+	}
+	months, ok := periodMonths[period]
+	if !ok {
+		return t, fmt.Errorf("unknown period %q", period)
+	}
+	return addMonths(t, months, anchorDay), nil
+}
+
+// customInterval returns the interval of a payment with a custom period, in days or in months.
+// Exactly one of them must be set, otherwise ok is false.
+func customInterval(record *core.Record) (days, months int, ok bool) {
+	return validInterval(record.GetInt("customIntervalDays"), record.GetInt("customIntervalMonths"))
+}
+
+// validInterval returns a custom interval if exactly one of days and months is set
+func validInterval(days, months int) (int, int, bool) {
+	if (days > 0) == (months > 0) {
+		return 0, 0, false
+	}
+	return days, months, true
+}
+
+// addPaymentPeriod returns t advanced by one billing period of the payment like addPeriod,
+// using the payment's interval if its period is custom. Custom month intervals keep anchorDay.
+func addPaymentPeriod(record *core.Record, t time.Time, anchorDay int) (time.Time, error) {
+	return newPaymentSchedule(record).addPeriod(t, anchorDay)
+}
+
+// isPeriod reports whether period is one of the supported billing periods
+func isPeriod(period string) bool {
+	_, ok := averageNormalization.factor(period)
+	return ok || period == PeriodCustom || period == PeriodOnce
+}
+
+// addMonths adds months to t without overflowing into the following month
+func addMonths(t time.Time, months int, anchorDay int) time.Time {
+	if anchorDay <= 0 {
+		anchorDay = t.Day()
+	}
+	year, month, _ := t.Date()
+	// normalize year and month using the first day, which can't overflow
+	first := time.Date(year, month+time.Month(months), 1, 0, 0, 0, 0, t.Location())
+	day := min(anchorDay, daysInMonth(first.Year(), first.Month()))
+	hour, minute, sec := t.Clock()
+	return time.Date(first.Year(), first.Month(), day, hour, minute, sec, t.Nanosecond(), t.Location())
+}
+
+// daysInMonth returns the number of days in the given month
+func daysInMonth(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// Constants turning amounts billed per period into monthly and yearly equivalents
+const (
+	// average length of a month in days, 365.25 / 12
+	averageDaysPerMonth = 30.44
+	// average number of weeks in a month, 365.25 / 12 / 7
+	averageWeeksPerMonth = 4.348
+	daysPerWeek          = 7
+	// multiplier converting monthly totals into yearly ones
+	monthsPerYear = 12
+)
+
+// Modes of converting daily and weekly amounts into monthly equivalents, chosen by a superuser
+const (
+	// every month lasts averageDaysPerMonth, so totals don't change from month to month
+	NormalizationAverage = "average"
+	// a month lasts as many days as the current calendar month
+	NormalizationCalendar = "calendar"
+)
+
+// normalization holds the length of a month used to convert amounts billed per day or week
+// into monthly equivalents. Month based periods don't depend on it.
+type normalization struct {
+	daysPerMonth  float64
+	weeksPerMonth float64
+}
+
+// averageNormalization is the normalization of the NormalizationAverage mode
+var averageNormalization = normalization{daysPerMonth: averageDaysPerMonth, weeksPerMonth: averageWeeksPerMonth}
+
+// newNormalization returns the normalization of mode, using the month containing now in calendar mode.
+// Unknown modes fall back to the average.
+func newNormalization(mode string, now time.Time) normalization {
+	if mode != NormalizationCalendar {
+		return averageNormalization
+	}
+	days := float64(daysInMonth(now.Year(), now.Month()))
+	return normalization{daysPerMonth: days, weeksPerMonth: days / daysPerWeek}
+}
+
+// factor returns the multiplier converting an amount billed once per period into a monthly equivalent.
+// Custom and one-time periods have none, so one-time payments aren't counted as recurring spend.
+func (n normalization) factor(period string) (float64, bool) {
+	switch period {
+	case PeriodDaily:
+		return n.daysPerMonth, true
+	case PeriodWeekly:
+		return n.weeksPerMonth, true
+	}
+	months, ok := periodMonths[period]
+	if !ok {
+		return 0, false
+	}
+	return 1 / float64(months), true
+}
+
+// monthlyFactor returns the multiplier converting an amount billed once per period of the payment
+// into a monthly equivalent. Custom intervals in days use the same month length as daily payments.
+func (n normalization) monthlyFactor(record *core.Record) (float64, bool) {
+	period := record.GetString("period")
+	if period != PeriodCustom {
+		return n.factor(period)
+	}
+	days, months, ok := customInterval(record)
+	if !ok {
+		return 0, false
+	}
+	if days > 0 {
+		return n.daysPerMonth / float64(days), true
+	}
+	return 1 / float64(months), true
+}
+
+// monthlyAmount returns the monthly equivalent of an amount billed once per period of the payment
+func (n normalization) monthlyAmount(record *core.Record, amount float64) (float64, bool) {
+	factor, ok := n.monthlyFactor(record)
+	if !ok {
+		return 0, false
+	}
+	return amount * factor, true
+}
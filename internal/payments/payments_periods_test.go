@@ -0,0 +1,216 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomPeriodValidation(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	payment := func(fields map[string]any) map[string]any {
+		body := map[string]any{
+			"user":        f.user.Id,
+			"system":      createSystem(t, f.hub, f.user, "custom-"+t.Name()).Id,
+			"provider":    f.provider.Id,
+			"period":      "custom",
+			"nextPayment": "2030-01-15 00:00:00.000Z",
+			"amount":      10,
+			"currency":    "USD",
+		}
+		for key, value := range fields {
+			body[key] = value
+		}
+		return body
+	}
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "custom period without interval",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(payment(nil)),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"customIntervalDays":{"code":"validation_invalid_custom_interval"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "custom period with both intervals",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(payment(map[string]any{"customIntervalDays": 28, "customIntervalMonths": 24})),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_invalid_custom_interval"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "custom period in days",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(payment(map[string]any{"customIntervalDays": 28})),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"period":"custom"`, `"customIntervalDays":28`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestCustomPeriodAdvanceAndSummary(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+
+	everyFourWeeks := f.createPayment(t, map[string]any{
+		"period":             "custom",
+		"customIntervalDays": 28,
+		"amount":             28,
+		"nextPayment":        "2025-01-01 00:00:00.000Z",
+	})
+	biennial := f.createPayment(t, map[string]any{
+		"period":               "custom",
+		"customIntervalMonths": 24,
+		"amount":               240,
+		"currency":             "EUR",
+		"nextPayment":          "2025-01-31 00:00:00.000Z",
+		"billingDay":           31,
+	})
+
+	_, err := pm.AdvanceDuePayments(date(2025, 2, 10))
+	require.NoError(t, err)
+
+	record, err := f.hub.FindRecordById("payments", everyFourWeeks.Id)
+	require.NoError(t, err)
+	assert.Equal(t, date(2025, 2, 26), record.GetDateTime("nextPayment").Time())
+	history, err := f.hub.FindAllRecords("payment_history")
+	require.NoError(t, err)
+	assert.Len(t, history, 3)
+
+	record, err = f.hub.FindRecordById("payments", biennial.Id)
+	require.NoError(t, err)
+	assert.Equal(t, date(2027, 1, 31), record.GetDateTime("nextPayment").Time())
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenario := beszelTests.ApiScenario{
+		Name:            "monthly equivalents of custom periods",
+		Method:          http.MethodGet,
+		URL:             "/api/beszel/payments/summary",
+		Headers:         map[string]string{"Authorization": f.token},
+		ExpectedStatus:  200,
+		ExpectedContent: []string{`"USD":30.44`, `"EUR":10`},
+		TestAppFactory:  testAppFactory,
+	}
+	scenario.Test(t)
+}
+
+func TestOneTimePayment(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+
+	f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 10})
+	once := f.createPayment(t, map[string]any{
+		"period":       "once",
+		"amount":       99,
+		"nextPayment":  "2030-02-10 00:00:00.000Z",
+		"reminderDays": 3,
+	})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:               "left out of the monthly equivalents",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/summary",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"USD":10`},
+			NotExpectedContent: []string{"109"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:               "schedule has a single charge",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/" + once.Id + "/schedule",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"charges":[{"date":"2030-02-10 00:00:00.000Z","amount":99}]`},
+			NotExpectedContent: []string{"2030-03-10"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "counted in the year it occurs",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/annual?year=2030&base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"total":219`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+
+	// reminded of once before its date
+	reminded, err := pm.NotifyDuePaymentsAt(time.Date(2030, 2, 8, 0, 15, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 1, reminded)
+
+	_, err = pm.AdvanceDuePayments(time.Date(2030, 2, 11, 0, 5, 0, 0, time.UTC))
+	require.NoError(t, err)
+	record, err := f.hub.FindRecordById("payments", once.Id)
+	require.NoError(t, err)
+	assert.Equal(t, date(2030, 2, 10), record.GetDateTime("nextPayment").Time(), "should keep the date it was charged on")
+	assert.False(t, record.GetDateTime("archivedAt").IsZero(), "should be archived once charged")
+	history, err := f.hub.FindAllRecords("payment_history", dbx.HashExp{"payment": once.Id})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	assert.Equal(t, date(2030, 2, 10), history[0].GetDateTime("paidAt").Time())
+
+	// never charged or reminded of again
+	_, err = pm.AdvanceDuePayments(time.Date(2030, 3, 11, 0, 5, 0, 0, time.UTC))
+	require.NoError(t, err)
+	count, err := f.hub.CountRecords("payment_history", dbx.HashExp{"payment": once.Id})
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+	reminded, err = pm.NotifyDuePaymentsAt(time.Date(2030, 3, 8, 0, 15, 0, 0, time.UTC))
+	require.NoError(t, err)
+	assert.Equal(t, 0, reminded)
+
+	scenario := beszelTests.ApiScenario{
+		Name:            "charge from the history once recorded",
+		Method:          http.MethodGet,
+		URL:             "/api/beszel/reports/annual?year=2030&base=USD",
+		Headers:         map[string]string{"Authorization": f.token},
+		ExpectedStatus:  200,
+		ExpectedContent: []string{`"total":219`},
+		TestAppFactory:  testAppFactory,
+	}
+	scenario.Test(t)
+}
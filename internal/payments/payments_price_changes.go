@@ -0,0 +1,54 @@
+package payments
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// recordPriceChange stores the old and new price of a payment whose amount or currency changed.
+// Runs for every update, including the ones made outside of the API.
+func recordPriceChange(e *core.RecordEvent) error {
+	original := e.Record.Original()
+	oldAmount, oldCurrency := original.GetFloat("amount"), original.GetString("currency")
+	if err := e.Next(); err != nil {
+		return err
+	}
+	newAmount, newCurrency := e.Record.GetFloat("amount"), e.Record.GetString("currency")
+	if oldAmount == newAmount && oldCurrency == newCurrency {
+		return nil
+	}
+	collection, err := e.App.FindCachedCollectionByNameOrId("price_changes")
+	if err != nil {
+		return err
+	}
+	change := core.NewRecord(collection)
+	change.Set("payment", e.Record.Id)
+	change.Set("user", e.Record.GetString("user"))
+	change.Set("oldAmount", oldAmount)
+	change.Set("newAmount", newAmount)
+	change.Set("oldCurrency", oldCurrency)
+	change.Set("newCurrency", newCurrency)
+	change.Set("changedAt", time.Now().UTC())
+	if err := e.App.Save(change); err != nil {
+		e.App.Logger().Error("Failed to record price change", "payment", e.Record.Id, "err", err)
+	}
+	return nil
+}
+
+// GetPriceHistory handles GET /api/beszel/payments/{id}/price-history requests.
+// Returns the payment's price changes, oldest first.
+func (pm *PaymentManager) GetPriceHistory(e *core.RequestEvent) error {
+	payment, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	changes, err := e.App.FindRecordsByFilter("price_changes", "payment = {:payment}", "changedAt,@rowid", 0, 0,
+		dbx.Params{"payment": payment.Id})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, changes)
+}
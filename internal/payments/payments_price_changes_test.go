@@ -0,0 +1,104 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriceHistory(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	payment := f.createPayment(t, map[string]any{"amount": 5})
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	// each update starts from the stored record, like an API request does
+	update := func(fields map[string]any) {
+		record, err := f.hub.FindRecordById("payments", payment.Id)
+		require.NoError(t, err)
+		for k, v := range fields {
+			record.Set(k, v)
+		}
+		require.NoError(t, f.hub.Save(record))
+	}
+	// notes changes aren't price changes
+	update(map[string]any{"notes": "vps"})
+	update(map[string]any{"amount": 7})
+	update(map[string]any{"amount": 9, "currency": "EUR"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "other user",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id + "/price-history",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "ordered change log",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/" + payment.Id + "/price-history",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"oldAmount":5`,
+				`"newAmount":9`,
+			},
+			TestAppFactory: testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				body, err := io.ReadAll(res.Body)
+				require.NoError(t, err)
+				var changes []struct {
+					OldAmount   float64 `json:"oldAmount"`
+					NewAmount   float64 `json:"newAmount"`
+					OldCurrency string  `json:"oldCurrency"`
+					NewCurrency string  `json:"newCurrency"`
+				}
+				require.NoError(t, json.Unmarshal(body, &changes))
+				require.Len(t, changes, 2)
+				assert.Equal(t, 5.0, changes[0].OldAmount)
+				assert.Equal(t, 7.0, changes[0].NewAmount)
+				assert.Equal(t, "USD", changes[0].NewCurrency)
+				assert.Equal(t, 7.0, changes[1].OldAmount)
+				assert.Equal(t, 9.0, changes[1].NewAmount)
+				assert.Equal(t, "USD", changes[1].OldCurrency)
+				assert.Equal(t, "EUR", changes[1].NewCurrency)
+			},
+		},
+		{
+			Name:            "recorded for updates through the API",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"amount": 11}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"amount":11`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				changes, err := app.FindRecordsByFilter("price_changes", "payment = {:id}", "", 0, 0, map[string]any{"id": payment.Id})
+				require.NoError(t, err)
+				assert.Len(t, changes, 3)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
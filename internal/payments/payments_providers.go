@@ -0,0 +1,306 @@
+package payments
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// faviconURL returns the conventional favicon location of the site, or "" if siteURL isn't an absolute http(s) URL
+func faviconURL(siteURL string) string {
+	u, err := url.Parse(siteURL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/favicon.ico"}).String()
+}
+
+// defaultProviderLogo fills an empty logoUrl with the favicon of the provider's url.
+// The URL is derived without fetching it, so creating a provider never waits on the network;
+// it is left empty if the url can't be parsed.
+func defaultProviderLogo(e *core.RecordEvent) error {
+	if e.Record.GetString("logoUrl") == "" {
+		e.Record.Set("logoUrl", faviconURL(e.Record.GetString("url")))
+	}
+	return e.Next()
+}
+
+// checkProviderName trims the provider's name and rejects it if another provider of the
+// same user has the same name, ignoring case. SQLite can't enforce this with a unique index,
+// so it is checked on every save. Existing duplicates only fail once their name is changed.
+func checkProviderName(e *core.RecordEvent) error {
+	name := strings.TrimSpace(e.Record.GetString("name"))
+	e.Record.Set("name", name)
+	original := e.Record.Original()
+	if !e.Record.IsNew() && original.GetString("user") == e.Record.GetString("user") &&
+		strings.EqualFold(strings.TrimSpace(original.GetString("name")), name) {
+		return e.Next()
+	}
+	providers, err := e.App.FindAllRecords("providers", dbx.HashExp{"user": e.Record.GetString("user")})
+	if err != nil {
+		return err
+	}
+	for _, provider := range providers {
+		if provider.Id != e.Record.Id && strings.EqualFold(strings.TrimSpace(provider.GetString("name")), name) {
+			return validation.Errors{
+				"name": validation.NewError("validation_duplicate_provider", "A provider with this name already exists."),
+			}
+		}
+	}
+	return e.Next()
+}
+
+// providerSystem is a system billed by a provider
+type providerSystem struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// providerSummary is the consolidated spend of all payments linked to a provider
+type providerSummary struct {
+	Id      string             `json:"id"`
+	Name    string             `json:"name"`
+	Count   int                `json:"count"`
+	Monthly map[string]float64 `json:"monthly"`
+	Annual  map[string]float64 `json:"annual"`
+	Systems []providerSystem   `json:"systems"`
+}
+
+// GetProviderSummary handles GET /api/beszel/providers/{id}/summary requests.
+// Returns the monthly and yearly equivalent spend per currency of the provider's
+// payments with the systems they are for. Archived payments are left out unless
+// includeArchived=true is passed.
+func (pm *PaymentManager) GetProviderSummary(e *core.RequestEvent) error {
+	provider, err := e.App.FindFirstRecordByFilter("providers", "id = {:id} && user = {:user}",
+		dbx.Params{"id": e.Request.PathValue("id"), "user": e.Auth.Id})
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+
+	records, err := e.App.FindAllRecords("payments", dbx.HashExp{"user": e.Auth.Id, "provider": provider.Id})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	if !includeArchived(e) {
+		records = withoutArchived(records)
+	}
+
+	now := time.Now().UTC()
+	monthly := monthlyTotals(records, now, loadNormalization(e.App, now))
+	annual := make(map[string]float64, len(monthly))
+	for currency, amount := range monthly {
+		annual[currency] = amount * monthsPerYear
+	}
+	summary := providerSummary{
+		Id:      provider.Id,
+		Name:    provider.GetString("name"),
+		Count:   len(records),
+		Monthly: roundTotals(monthly),
+		Annual:  roundTotals(annual),
+		Systems: []providerSystem{},
+	}
+
+	systemIds := make([]string, 0, len(records))
+	for _, record := range records {
+		if id := record.GetString("system"); id != "" && !slices.Contains(systemIds, id) {
+			systemIds = append(systemIds, id)
+		}
+	}
+	if len(systemIds) > 0 {
+		systems, err := e.App.FindRecordsByIds("systems", systemIds)
+		if err != nil {
+			return e.InternalServerError("", err)
+		}
+		for _, system := range systems {
+			summary.Systems = append(summary.Systems, providerSystem{Id: system.Id, Name: system.GetString("name")})
+		}
+		slices.SortFunc(summary.Systems, func(a, b providerSystem) int {
+			return cmp.Compare(a.Name, b.Name)
+		})
+	}
+	return e.JSON(http.StatusOK, summary)
+}
+
+// MergeProviders handles POST /api/beszel/providers/merge requests.
+// Moves all payments of the sourceId provider to the targetId provider and deletes the source,
+// in a single transaction. Both providers must belong to the user.
+// Returns the number of payments reassigned.
+func (pm *PaymentManager) MergeProviders(e *core.RequestEvent) error {
+	var body struct {
+		SourceID string `json:"sourceId"`
+		TargetID string `json:"targetId"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if body.SourceID == "" || body.TargetID == "" {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "sourceId and targetId are required"})
+	}
+	if body.SourceID == body.TargetID {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "sourceId and targetId must differ"})
+	}
+	var source *core.Record
+	for _, id := range []string{body.SourceID, body.TargetID} {
+		provider, err := e.App.FindFirstRecordByFilter("providers", "id = {:id} && user = {:user}",
+			dbx.Params{"id": id, "user": e.Auth.Id})
+		if err != nil {
+			return e.NotFoundError("", err)
+		}
+		if source == nil {
+			source = provider
+		}
+	}
+
+	var reassigned int
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		// payments are deleted along with their provider, so they are moved first.
+		// Each one is saved on its own so the move is recorded in the audit log.
+		payments, err := txApp.FindAllRecords("payments", dbx.HashExp{"provider": source.Id})
+		if err != nil {
+			return err
+		}
+		for _, payment := range payments {
+			payment.Set("provider", body.TargetID)
+			if err := txApp.SaveWithContext(withActor(e), payment); err != nil {
+				return err
+			}
+		}
+		reassigned = len(payments)
+		return txApp.DeleteWithContext(withActor(e), source)
+	})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, map[string]int{"reassigned": reassigned})
+}
+
+// findUnusedProviders returns the user's providers that no payment references, archived
+// payments included, sorted by name
+func findUnusedProviders(app core.App, userID string) ([]*core.Record, error) {
+	providers, err := app.FindRecordsByFilter("providers", "user = {:user}", "name", 0, 0, dbx.Params{"user": userID})
+	if err != nil {
+		return nil, err
+	}
+	var used []string
+	err = app.DB().Select("provider").Distinct(true).From("payments").
+		Where(dbx.HashExp{"user": userID}).Column(&used)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(providers, func(provider *core.Record) bool {
+		return slices.Contains(used, provider.Id)
+	}), nil
+}
+
+// GetUnusedProviders handles GET /api/beszel/providers/unused requests.
+// Returns the user's providers without any payments, archived ones included, so they can be cleaned up.
+func (pm *PaymentManager) GetUnusedProviders(e *core.RequestEvent) error {
+	providers, err := findUnusedProviders(e.App, e.Auth.Id)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, providers)
+}
+
+// CleanupProviders handles POST /api/beszel/providers/cleanup requests.
+// Deletes all of the user's unused providers, as listed by GetUnusedProviders, in a single
+// transaction and returns how many were deleted.
+func (pm *PaymentManager) CleanupProviders(e *core.RequestEvent) error {
+	var deleted int
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		providers, err := findUnusedProviders(txApp, e.Auth.Id)
+		if err != nil {
+			return err
+		}
+		for _, provider := range providers {
+			if err := txApp.DeleteWithContext(withActor(e), provider); err != nil {
+				return err
+			}
+		}
+		deleted = len(providers)
+		return nil
+	})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, map[string]int{"deleted": deleted})
+}
+
+// urlCheckClient checks provider urls. Redirects are followed on the same host only, so a moved
+// portal is reported by the redirect's status instead of the page it points to on another site.
+// Like webhooks it only connects to public addresses.
+var urlCheckClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: newPublicTransport(),
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		if !strings.EqualFold(req.URL.Hostname(), via[0].URL.Hostname()) {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	},
+}
+
+// urlCheck is the result of a reachability check of a provider's url
+type urlCheck struct {
+	URL string `json:"url"`
+	// HTTP status of the response, 0 if no response was received
+	Status int `json:"status"`
+	// redirect target on another host that wasn't followed
+	Location  string         `json:"location,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	CheckedAt types.DateTime `json:"checkedAt"`
+}
+
+// checkURL makes a HEAD request to rawURL and reports the status of the response
+func checkURL(rawURL string) urlCheck {
+	check := urlCheck{URL: rawURL}
+	check.CheckedAt, _ = types.ParseDateTime(time.Now().UTC())
+	res, err := urlCheckClient.Head(rawURL)
+	if err != nil {
+		check.Error = err.Error()
+		return check
+	}
+	defer res.Body.Close()
+	check.Status = res.StatusCode
+	if res.StatusCode >= 300 && res.StatusCode <= 399 {
+		check.Location = res.Header.Get("Location")
+	}
+	return check
+}
+
+// CheckProviderURL handles POST /api/beszel/providers/{id}/check-url requests.
+// Makes a HEAD request to the provider's url and returns the status it responded with.
+// The result is only recorded in lastChecked and lastStatus when store=true is passed.
+func (pm *PaymentManager) CheckProviderURL(e *core.RequestEvent) error {
+	provider, err := e.App.FindFirstRecordByFilter("providers", "id = {:id} && user = {:user}",
+		dbx.Params{"id": e.Request.PathValue("id"), "user": e.Auth.Id})
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	rawURL := provider.GetString("url")
+	if u, err := url.Parse(rawURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "provider has no http(s) url"})
+	}
+
+	check := checkURL(rawURL)
+	if e.Request.URL.Query().Get("store") == "true" {
+		provider.Set("lastChecked", check.CheckedAt)
+		provider.Set("lastStatus", check.Status)
+		if err := e.App.SaveWithContext(withActor(e), provider); err != nil {
+			return e.BadRequestError("Failed to update provider", err)
+		}
+	}
+	return e.JSON(http.StatusOK, check)
+}
@@ -0,0 +1,512 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProviderSummary(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 10})
+	f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 60, "period": "annual"})
+	f.createPayment(t, map[string]any{"amount": 5, "currency": "EUR"})
+	f.createPayment(t, map[string]any{"amount": 100, "archivedAt": "2030-01-01 00:00:00.000Z"})
+	empty := createProvider(t, f.hub, f.user, "Empty")
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "other user's provider is not found",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/providers/" + f.provider.Id + "/summary",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "totals across the provider's payments",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/providers/" + f.provider.Id + "/summary",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"count":3`,
+				`"monthly":{"EUR":5,"USD":15}`,
+				`"annual":{"EUR":60,"USD":180}`,
+				`"systems":[{"id":"` + f.system.Id + `","name":"server-1"},{"id":`,
+				`"name":"server-2"`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:            "includeArchived",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/providers/" + f.provider.Id + "/summary?includeArchived=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"count":4`, `"USD":115`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "provider without payments",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/providers/" + empty.Id + "/summary",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"count":0`, `"monthly":{}`, `"annual":{}`, `"systems":[]`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestProviderLogo(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "derived from the url",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/providers/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "name": "Hetzner Cloud", "url": "https://www.hetzner.com/cloud?ref=1"}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"logoUrl":"https://www.hetzner.com/favicon.ico"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "supplied logo is kept",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/providers/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "name": "OVH", "url": "https://ovh.com", "logoUrl": "https://cdn.example.com/ovh.png"}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"logoUrl":"https://cdn.example.com/ovh.png"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "supplied logo must be a url",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/providers/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "name": "Bad", "url": "https://bad.example.com", "logoUrl": "not a url"}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"logoUrl":{"code":"validation_invalid_url"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestProviderDuplicateName(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	other := createProvider(t, f.hub, f.user, "DigitalOcean")
+	otherUser, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "same name with other case and whitespace",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/providers/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "name": " digitalocean ", "url": "https://digitalocean.com"}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"name":{"code":"validation_duplicate_provider"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "same name for another user",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/providers/records",
+			Headers:         map[string]string{"Authorization": otherToken},
+			Body:            jsonReader(map[string]any{"user": otherUser.Id, "name": "DigitalOcean", "url": "https://digitalocean.com"}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"name":"DigitalOcean"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "new name is trimmed",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/providers/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "name": "  Linode ", "url": "https://linode.com"}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"name":"Linode"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "renamed to an existing name",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/providers/records/" + f.provider.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"name": "DIGITALOCEAN"}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"name":{"code":"validation_duplicate_provider"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "changing the case of its own name",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/providers/records/" + other.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"name": "Digitalocean"}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"name":"Digitalocean"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestMergeProviders(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	duplicate := createProvider(t, f.hub, f.user, "Hetzner Online")
+	moved := []*core.Record{
+		f.createPayment(t, map[string]any{"provider": duplicate.Id}),
+		f.createPayment(t, map[string]any{"provider": duplicate.Id, "archivedAt": "2029-01-01 00:00:00.000Z"}),
+	}
+	kept := f.createPayment(t, nil)
+
+	otherUser, _ := createUserWithToken(t, f.hub, "other@example.com")
+	otherProvider := createProvider(t, f.hub, otherUser, "Other")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/merge",
+			Body:            jsonReader(map[string]any{"sourceId": duplicate.Id, "targetId": f.provider.Id}),
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "same provider",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/merge",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"sourceId": duplicate.Id, "targetId": duplicate.Id}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"sourceId and targetId must differ"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "provider of another user",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/merge",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"sourceId": duplicate.Id, "targetId": otherProvider.Id}),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				_, err := app.FindRecordById("providers", duplicate.Id)
+				assert.NoError(t, err)
+			},
+		},
+		{
+			Name:            "payments reassigned and source deleted",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/merge",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"sourceId": duplicate.Id, "targetId": f.provider.Id}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"reassigned":2}`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				_, err := app.FindRecordById("providers", duplicate.Id)
+				assert.Error(t, err)
+				for _, payment := range append(moved, kept) {
+					record, err := app.FindRecordById("payments", payment.Id)
+					require.NoError(t, err)
+					assert.Equal(t, f.provider.Id, record.GetString("provider"))
+				}
+				// the move is recorded in the audit log of each moved payment
+				for _, payment := range moved {
+					entries := findAuditEntries(t, app, payment.Id)
+					require.Len(t, entries, 2)
+					assert.Equal(t, f.user.Id, entries[1].GetString("actor"))
+					assert.Equal(t, "update", entries[1].GetString("action"))
+					assert.JSONEq(t,
+						`{"provider":{"old":"`+duplicate.Id+`","new":"`+f.provider.Id+`"}}`,
+						entries[1].GetString("changedFields"))
+				}
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestCheckProviderURL(t *testing.T) {
+	// the test servers listen on loopback
+	t.Setenv("BESZEL_HUB_ALLOW_PRIVATE_DESTINATIONS", "true")
+	f := newPaymentFixture(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		switch r.URL.Path {
+		case "/billing":
+			w.WriteHeader(http.StatusOK)
+		case "/moved":
+			http.Redirect(w, r, "/billing", http.StatusMovedPermanently)
+		case "/away":
+			// same server under another host name
+			http.Redirect(w, r, "http://"+strings.Replace(r.Host, "127.0.0.1", "localhost", 1)+"/billing", http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	providerWithURL := func(name, path string) *core.Record {
+		provider := createProvider(t, f.hub, f.user, name)
+		provider.Set("url", server.URL+path)
+		require.NoError(t, f.hub.Save(provider))
+		return provider
+	}
+	live := providerWithURL("Live", "/billing")
+	gone := providerWithURL("Gone", "/old-portal")
+	moved := providerWithURL("Moved", "/moved")
+	away := providerWithURL("Away", "/away")
+	ftp := createProvider(t, f.hub, f.user, "FTP")
+	ftp.Set("url", "ftp://example.com/billing")
+	require.NoError(t, f.hub.Save(ftp))
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + live.Id + "/check-url",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "provider of another user",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + live.Id + "/check-url",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "provider without an http url",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + ftp.Id + "/check-url",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"provider has no http(s) url"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "reachable url",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + live.Id + "/check-url",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":200`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("providers", live.Id)
+				require.NoError(t, err)
+				assert.True(t, record.GetDateTime("lastChecked").IsZero(), "result should only be stored with store=true")
+			},
+		},
+		{
+			Name:            "dead link is stored",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + gone.Id + "/check-url?store=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":404`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("providers", gone.Id)
+				require.NoError(t, err)
+				assert.False(t, record.GetDateTime("lastChecked").IsZero())
+				assert.Equal(t, 404, record.GetInt("lastStatus"))
+			},
+		},
+		{
+			Name:            "redirect on the same host is followed",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/" + moved.Id + "/check-url",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":200`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "redirect to another host is not followed",
+			Method:             http.MethodPost,
+			URL:                "/api/beszel/providers/" + away.Id + "/check-url",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"status":302`, `"location":"http://localhost:`},
+			NotExpectedContent: []string{`"error"`},
+			TestAppFactory:     testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestCheckProviderURLPrivateDestination(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer server.Close()
+	f.provider.Set("url", server.URL+"/billing")
+	require.NoError(t, f.hub.Save(f.provider))
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenario := beszelTests.ApiScenario{
+		Name:            "loopback url isn't checked",
+		Method:          http.MethodPost,
+		URL:             "/api/beszel/providers/" + f.provider.Id + "/check-url",
+		Headers:         map[string]string{"Authorization": f.token},
+		ExpectedStatus:  200,
+		ExpectedContent: []string{`"status":0`, "private, loopback or link-local address"},
+		TestAppFactory:  testAppFactory,
+		AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+			assert.Empty(t, requests)
+		},
+	}
+	scenario.Test(t)
+}
+
+func TestUnusedProviders(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.createPayment(t, nil)
+	archivedOnly := createProvider(t, f.hub, f.user, "Archived only")
+	f.createPayment(t, map[string]any{"provider": archivedOnly.Id, "archivedAt": "2030-01-01 00:00:00.000Z"})
+	unusedA := createProvider(t, f.hub, f.user, "A unused")
+	unusedB := createProvider(t, f.hub, f.user, "B unused")
+	otherUser, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+	otherProvider := createProvider(t, f.hub, otherUser, "Other")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/providers/unused",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "providers without payments",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/providers/unused",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"id":"` + unusedA.Id + `"`, `"id":"` + unusedB.Id + `"`},
+			NotExpectedContent: []string{f.provider.Id, archivedOnly.Id, otherProvider.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "cleanup",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/providers/cleanup",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"deleted":2}`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				for _, id := range []string{unusedA.Id, unusedB.Id} {
+					_, err := app.FindRecordById("providers", id)
+					assert.Error(t, err)
+				}
+				for _, id := range []string{f.provider.Id, archivedOnly.Id, otherProvider.Id} {
+					_, err := app.FindRecordById("providers", id)
+					assert.NoError(t, err)
+				}
+			},
+		},
+		{
+			Name:            "nothing left to clean up",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/providers/unused",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`[]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user's unused provider",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/providers/unused",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"id":"` + otherProvider.Id + `"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
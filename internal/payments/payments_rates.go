@@ -0,0 +1,159 @@
+package payments
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/henrygd/beszel/internal/entities/currency"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// currencies supported by payments and exchange rates
+var currencies = currency.Codes()
+
+// isCurrency reports whether code is a supported currency
+func isCurrency(code string) bool {
+	_, ok := currency.Find(code)
+	return ok
+}
+
+// findCurrency returns the supported currency with the given code
+func findCurrency(code string) (currency.Currency, bool) {
+	return currency.Find(code)
+}
+
+// rateTable maps "BASE/QUOTE" pairs to the number of quote units per base unit
+type rateTable map[string]float64
+
+// loadRates reads all stored exchange rates
+func loadRates(app core.App) (rateTable, error) {
+	records, err := app.FindAllRecords("exchange_rates")
+	if err != nil {
+		return nil, err
+	}
+	rates := make(rateTable, len(records))
+	for _, record := range records {
+		rates[ratePair(record.GetString("base"), record.GetString("quote"))] = record.GetFloat("rate")
+	}
+	return rates, nil
+}
+
+func ratePair(base, quote string) string {
+	return base + "/" + quote
+}
+
+// rate returns the multiplier converting an amount in from into to.
+// Falls back to the inverse pair if the direct pair is not stored.
+func (rt rateTable) rate(from, to string) (float64, bool) {
+	if from == to {
+		return 1, true
+	}
+	if r, ok := rt[ratePair(from, to)]; ok && r > 0 {
+		return r, true
+	}
+	if r, ok := rt[ratePair(to, from)]; ok && r > 0 {
+		return 1 / r, true
+	}
+	return 0, false
+}
+
+// ErrMissingRate is a conversion from Base to Quote that failed because neither
+// the pair nor its inverse is stored
+type ErrMissingRate struct {
+	Base  string `json:"base"`
+	Quote string `json:"quote"`
+}
+
+func (err ErrMissingRate) Error() string {
+	return "missing exchange rate " + ratePair(err.Base, err.Quote)
+}
+
+// missingRatesError lists every rate a converter was missing
+type missingRatesError []ErrMissingRate
+
+func (err missingRatesError) Error() string {
+	pairs := make([]string, len(err))
+	for i, missing := range err {
+		pairs[i] = ratePair(missing.Base, missing.Quote)
+	}
+	return "missing exchange rates " + strings.Join(pairs, ", ")
+}
+
+// Unwrap lets errors.As find each ErrMissingRate
+func (err missingRatesError) Unwrap() []error {
+	errs := make([]error, len(err))
+	for i, missing := range err {
+		errs[i] = missing
+	}
+	return errs
+}
+
+// converter converts amounts with a rate table and collects the rates it is missing,
+// so aggregations can convert everything first and report all missing pairs at once
+type converter struct {
+	rates   rateTable
+	missing map[ErrMissingRate]struct{}
+}
+
+func newConverter(rates rateTable) *converter {
+	return &converter{rates: rates, missing: make(map[ErrMissingRate]struct{})}
+}
+
+// convert returns amount in from converted into to. If the rate is missing it is
+// recorded and false is returned.
+func (c *converter) convert(amount float64, from, to string) (float64, bool) {
+	r, ok := c.rates.rate(from, to)
+	if !ok {
+		c.missing[ErrMissingRate{Base: from, Quote: to}] = struct{}{}
+		return 0, false
+	}
+	return amount * r, true
+}
+
+// convertTotals collapses per currency totals into a single total in to.
+// Amounts without an available rate are left out of the total.
+func (c *converter) convertTotals(totals map[string]float64, to string) float64 {
+	var total float64
+	for currency, amount := range totals {
+		converted, _ := c.convert(amount, currency, to)
+		total += converted
+	}
+	return total
+}
+
+// err returns a missingRatesError with the sorted pairs that couldn't be converted, or nil
+func (c *converter) err() error {
+	if len(c.missing) == 0 {
+		return nil
+	}
+	missing := make(missingRatesError, 0, len(c.missing))
+	for pair := range c.missing {
+		missing = append(missing, pair)
+	}
+	slices.SortFunc(missing, func(a, b ErrMissingRate) int {
+		return cmp.Or(cmp.Compare(a.Base, b.Base), cmp.Compare(a.Quote, b.Quote))
+	})
+	return missing
+}
+
+// convertError responds to a conversion error: 422 with the missing pairs, or 500 for any other error
+func convertError(e *core.RequestEvent, err error) error {
+	var missing missingRatesError
+	if errors.As(err, &missing) {
+		return e.JSON(http.StatusUnprocessableEntity, map[string]any{"error": "missing exchange rates", "missing": missing})
+	}
+	return e.InternalServerError("", err)
+}
+
+// newConverter returns a converter using the cached exchange rates
+func (pm *PaymentManager) newConverter(app core.App) (*converter, error) {
+	rates, err := pm.rates.get(app)
+	if err != nil {
+		return nil, err
+	}
+	return newConverter(rates), nil
+}
@@ -0,0 +1,57 @@
+package payments
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// how long loaded exchange rates are reused before being read again
+const ratesCacheTTL = 10 * time.Minute
+
+// rateCache keeps the stored exchange rates in memory so conversions don't read them on every request.
+// It is cleared whenever an exchange rate is saved or deleted.
+type rateCache struct {
+	mu       sync.RWMutex
+	rates    rateTable
+	loadedAt time.Time
+}
+
+// get returns the cached rates, loading them from app if they are missing or older than ratesCacheTTL.
+// The returned table is shared and must not be modified.
+func (c *rateCache) get(app core.App) (rateTable, error) {
+	c.mu.RLock()
+	rates, fresh := c.rates, c.rates != nil && time.Since(c.loadedAt) < ratesCacheTTL
+	c.mu.RUnlock()
+	if fresh {
+		return rates, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// another request may have loaded them while waiting for the lock
+	if c.rates != nil && time.Since(c.loadedAt) < ratesCacheTTL {
+		return c.rates, nil
+	}
+	rates, err := loadRates(app)
+	if err != nil {
+		return nil, err
+	}
+	c.rates, c.loadedAt = rates, time.Now()
+	return rates, nil
+}
+
+// invalidate drops the cached rates so the next get reads them again
+func (c *rateCache) invalidate() {
+	c.mu.Lock()
+	c.rates = nil
+	c.mu.Unlock()
+}
+
+// invalidateRates clears the rate cache after an exchange rate is saved or deleted,
+// including the upserts of the rate fetch job once its transaction is committed
+func (pm *PaymentManager) invalidateRates(e *core.RecordEvent) error {
+	pm.rates.invalidate()
+	return e.Next()
+}
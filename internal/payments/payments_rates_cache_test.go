@@ -0,0 +1,75 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRatesCacheInvalidation(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.createPayment(t, map[string]any{"amount": 10, "currency": "EUR"})
+	setRate(t, f.hub, "EUR", "USD", 1.2)
+
+	findRate := func(t testing.TB, app core.App) *core.Record {
+		rate, err := app.FindFirstRecordByFilter("exchange_rates", "base = 'EUR' && quote = 'USD'", dbx.Params{})
+		require.NoError(t, err)
+		return rate
+	}
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "stored rate",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"total":12`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "updated rate is used right away",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"total":15`},
+			TestAppFactory:  testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				rate := findRate(t, app)
+				rate.Set("rate", 1.5)
+				require.NoError(t, app.Save(rate))
+			},
+		},
+		{
+			Name:            "deleted rate is no longer used",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"missing":[{"base":"EUR","quote":"USD"}]`},
+			TestAppFactory:  testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				require.NoError(t, app.Delete(findRate(t, app)))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
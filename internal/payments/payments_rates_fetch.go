@@ -0,0 +1,184 @@
+package payments
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// used when RATES_URL is not set. Responds with {"base_code": "USD", "rates": {...}}
+const defaultRatesURL = "https://open.er-api.com/v6/latest/USD"
+
+// rates older than this are logged as stale
+const staleRatesAge = 48 * time.Hour
+
+var ratesClient = &http.Client{Timeout: 30 * time.Second}
+
+// ratesResponse is the body returned by the rates provider. Rates are the units
+// of each currency per one unit of the base currency.
+type ratesResponse struct {
+	Base     string             `json:"base"`
+	BaseCode string             `json:"base_code"`
+	Rates    map[string]float64 `json:"rates"`
+}
+
+// getEnv retrieves an environment variable with a "BESZEL_HUB_" prefix, or falls back to the unprefixed key.
+func getEnv(key string) (value string, exists bool) {
+	if value, exists = os.LookupEnv("BESZEL_HUB_" + key); exists {
+		return value, exists
+	}
+	// Fallback to the old unprefixed key
+	return os.LookupEnv(key)
+}
+
+// FetchRates updates the stored exchange rates from the rates provider.
+// The provider is set with RATES_URL and an optional RATES_API_KEY, sent as a bearer token.
+// Runs once a day as a cron job. If the fetch fails the previous rates are kept.
+func (pm *PaymentManager) FetchRates() {
+	logger := requestid.RunLogger(pm.app, jobFetchRates)
+	err := pm.fetchRates(logger, time.Now().UTC())
+	if err != nil {
+		logger.Error("Failed to fetch exchange rates", "err", err)
+	}
+	pm.health.jobRan(jobFetchRates, time.Now().UTC(), err)
+	pm.warnStaleRates(logger, time.Now().UTC())
+}
+
+// fetchRates downloads the rates and upserts every ordered pair of supported currencies
+func (pm *PaymentManager) fetchRates(logger *slog.Logger, now time.Time) error {
+	url, _ := getEnv("RATES_URL")
+	if url == "" {
+		url = defaultRatesURL
+	}
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if apiKey, _ := getEnv("RATES_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	res, err := ratesClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	// http.Client doesn't treat non 2xx responses as error
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("rates provider responded with status %d", res.StatusCode)
+	}
+	var body ratesResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return err
+	}
+
+	rates, missing, err := crossRates(body)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		logger.Warn("Rates provider response has no rates for some currencies", "currencies", missing)
+	}
+	return pm.app.RunInTransaction(func(txApp core.App) error {
+		for pair, rate := range rates {
+			if err := upsertRate(txApp, pair[0], pair[1], rate, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// crossRates computes the rate of every ordered pair of supported currencies from base relative rates.
+// Currencies missing from the response are left out and returned, so their previous rates are kept.
+func crossRates(body ratesResponse) (map[[2]string]float64, []string, error) {
+	base := body.Base
+	if base == "" {
+		base = body.BaseCode
+	}
+	perBase := make(map[string]float64, len(currencies))
+	var available, missing []string
+	for _, currency := range currencies {
+		rate, ok := body.Rates[currency]
+		if currency == base {
+			rate, ok = 1, true
+		}
+		if !ok || rate <= 0 {
+			missing = append(missing, currency)
+			continue
+		}
+		perBase[currency] = rate
+		available = append(available, currency)
+	}
+	if len(available) < 2 {
+		return nil, missing, fmt.Errorf("rates provider response has no rates for %s", strings.Join(missing, ", "))
+	}
+
+	rates := make(map[[2]string]float64, len(available)*(len(available)-1))
+	for _, from := range available {
+		for _, to := range available {
+			if from != to {
+				rates[[2]string{from, to}] = perBase[to] / perBase[from]
+			}
+		}
+	}
+	return rates, missing, nil
+}
+
+// upsertRate stores the rate of a pair, updating the existing record if there is one
+func upsertRate(app core.App, base, quote string, rate float64, now time.Time) error {
+	record, err := app.FindFirstRecordByFilter("exchange_rates", "base = {:base} && quote = {:quote}", dbx.Params{"base": base, "quote": quote})
+	if err != nil {
+		collection, err := app.FindCachedCollectionByNameOrId("exchange_rates")
+		if err != nil {
+			return err
+		}
+		record = core.NewRecord(collection)
+		record.Set("base", base)
+		record.Set("quote", quote)
+	}
+	record.Set("rate", rate)
+	record.Set("fetchedAt", now)
+	return app.Save(record)
+}
+
+// oldestRateFetch returns when the least recently fetched stored rate was fetched,
+// or a zero time if no rates were fetched. Rates entered by hand are ignored.
+func oldestRateFetch(app core.App) (time.Time, error) {
+	records, err := app.FindAllRecords("exchange_rates")
+	if err != nil {
+		return time.Time{}, err
+	}
+	var oldest time.Time
+	for _, record := range records {
+		fetchedAt := record.GetDateTime("fetchedAt").Time()
+		if fetchedAt.IsZero() {
+			continue
+		}
+		if oldest.IsZero() || fetchedAt.Before(oldest) {
+			oldest = fetchedAt
+		}
+	}
+	return oldest, nil
+}
+
+// warnStaleRates logs a warning if the oldest stored rate was fetched more than staleRatesAge ago
+func (pm *PaymentManager) warnStaleRates(logger *slog.Logger, now time.Time) {
+	oldest, err := oldestRateFetch(pm.app)
+	if err != nil || oldest.IsZero() {
+		return
+	}
+	if now.Sub(oldest) > staleRatesAge {
+		logger.Warn("Exchange rates are stale", "fetchedAt", oldest)
+	}
+}
@@ -0,0 +1,87 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchRates(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	var authHeader string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeader = r.Header.Get("Authorization")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"result":"success","base_code":"USD","rates":{"USD":1,"EUR":0.8,"RUB":100,"GBP":0.75}}`))
+	}))
+	defer server.Close()
+	t.Setenv("BESZEL_HUB_RATES_URL", server.URL)
+	t.Setenv("BESZEL_HUB_RATES_API_KEY", "key")
+
+	// an existing pair is updated in place
+	setRate(t, f.hub, "USD", "EUR", 0.5)
+
+	now := time.Date(2030, 1, 1, 3, 0, 0, 0, time.UTC)
+	require.NoError(t, f.hub.GetPaymentManager().FetchRatesAt(now))
+	assert.Equal(t, "Bearer key", authHeader)
+
+	// currencies missing from the response are skipped
+	records, err := f.hub.FindAllRecords("exchange_rates")
+	require.NoError(t, err)
+	require.Len(t, records, 12)
+	rates := make(map[string]float64)
+	for _, record := range records {
+		rates[record.GetString("base")+"/"+record.GetString("quote")] = record.GetFloat("rate")
+		assert.Equal(t, now, record.GetDateTime("fetchedAt").Time())
+	}
+	assert.InDelta(t, 0.8, rates["USD/EUR"], 1e-9)
+	assert.InDelta(t, 1.25, rates["EUR/USD"], 1e-9)
+	assert.InDelta(t, 100, rates["USD/RUB"], 1e-9)
+	assert.InDelta(t, 0.01, rates["RUB/USD"], 1e-9)
+	assert.InDelta(t, 125, rates["EUR/RUB"], 1e-9)
+	assert.InDelta(t, 0.008, rates["RUB/EUR"], 1e-9)
+	assert.InDelta(t, 0.9375, rates["EUR/GBP"], 1e-9)
+
+	// a failed fetch keeps the previous rates
+	status = http.StatusServiceUnavailable
+	assert.Error(t, f.hub.GetPaymentManager().FetchRatesAt(now.AddDate(0, 0, 1)))
+	records, err = f.hub.FindAllRecords("exchange_rates")
+	require.NoError(t, err)
+	require.Len(t, records, 12)
+	for _, record := range records {
+		assert.Equal(t, now, record.GetDateTime("fetchedAt").Time())
+	}
+}
+
+func TestFetchRatesMissingCurrency(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	body := `{"base":"EUR","rates":{"GBP":0.85}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	t.Setenv("BESZEL_HUB_RATES_URL", server.URL)
+
+	// only the pairs of the currencies in the response are stored
+	require.NoError(t, f.hub.GetPaymentManager().FetchRatesAt(time.Now()))
+	records, err := f.hub.FindAllRecords("exchange_rates")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+
+	// a response without any other currency than the base fails
+	body = `{"base":"EUR","rates":{"XXX":1.1}}`
+	assert.ErrorContains(t, f.hub.GetPaymentManager().FetchRatesAt(time.Now()), "RUB")
+	records, err = f.hub.FindAllRecords("exchange_rates")
+	require.NoError(t, err)
+	assert.Len(t, records, 2)
+}
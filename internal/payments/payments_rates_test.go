@@ -0,0 +1,53 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingRateResponses(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	nextPayment := time.Now().UTC().AddDate(0, 0, 7)
+	for _, currency := range []string{"USD", "EUR"} {
+		f.createPayment(t, map[string]any{"currency": currency, "nextPayment": nextPayment.Format(time.DateOnly) + " 00:00:00.000Z"})
+	}
+	_, err := beszelTests.CreateRecord(f.hub, "budgets", map[string]any{
+		"user": f.user.Id, "name": "Hosting", "limit": 100, "currency": "USD", "period": "monthly",
+	})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	year := strconv.Itoa(nextPayment.Year())
+	urls := map[string]string{
+		"summary":  "/api/beszel/payments/summary?base=USD",
+		"budgets":  "/api/beszel/budgets/status",
+		"cashflow": "/api/beszel/payments/cashflow?base=USD",
+		"compare":  "/api/beszel/reports/compare?base=USD&to=" + year,
+	}
+	for name, url := range urls {
+		scenario := beszelTests.ApiScenario{
+			Name:            name,
+			Method:          http.MethodGet,
+			URL:             url,
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`{"error":"missing exchange rates","missing":[{"base":"EUR","quote":"USD"}]}`},
+			TestAppFactory:  testAppFactory,
+		}
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,45 @@
+package payments
+
+import (
+	"net/http"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ReassignSystem handles POST /api/beszel/payments/{id}/reassign-system requests.
+// Moves a payment to the user's system in the body, for when a service moves to a new server.
+// The payment keeps its id, so its history, price changes and audit log stay with it.
+func (pm *PaymentManager) ReassignSystem(e *core.RequestEvent) error {
+	var body struct {
+		SystemID string `json:"systemId"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if body.SystemID == "" {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "systemId is required"})
+	}
+	record, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	if record.GetString("system") == body.SystemID {
+		return e.JSON(http.StatusOK, record)
+	}
+
+	_, err = e.App.FindFirstRecordByFilter("systems", "id = {:id} && users.id ?= {:user}",
+		dbx.Params{"id": body.SystemID, "user": e.Auth.Id})
+	if err != nil {
+		return e.BadRequestError("Failed to reassign payment.", validation.Errors{
+			"systemId": validation.NewError("validation_system_not_owned", "System doesn't belong to the payment's user."),
+		})
+	}
+
+	record.Set("system", body.SystemID)
+	if err := e.App.SaveWithContext(withActor(e), record); err != nil {
+		return e.BadRequestError("Failed to reassign payment.", err)
+	}
+	return e.JSON(http.StatusOK, record)
+}
@@ -0,0 +1,124 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReassignSystem(t *testing.T) {
+	f := newPaymentFixture(t)
+	other, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+	otherSystem := createSystem(t, f.hub, other, "other-server")
+
+	payment := f.createPayment(t, map[string]any{"system": f.system.Id})
+	// a price change that should stay with the payment
+	payment.Set("amount", 12)
+	require.NoError(t, f.hub.Save(payment))
+	_, err := beszelTests.CreateRecord(f.hub, "payment_history", map[string]any{
+		"payment":  payment.Id,
+		"user":     f.user.Id,
+		"amount":   10,
+		"currency": "USD",
+		"paidAt":   "2029-12-15 00:00:00.000Z",
+	})
+	require.NoError(t, err)
+	// the recreated system already has a payment of its own
+	newSystem := createSystem(t, f.hub, f.user, "server-new")
+	f.createPayment(t, map[string]any{"system": newSystem.Id})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+	url := "/api/beszel/payments/" + payment.Id + "/reassign-system"
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             url,
+			Body:            jsonReader(map[string]any{"systemId": newSystem.Id}),
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "missing systemId",
+			Method:          http.MethodPost,
+			URL:             url,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"systemId is required"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user's payment",
+			Method:          http.MethodPost,
+			URL:             url,
+			Headers:         map[string]string{"Authorization": otherToken},
+			Body:            jsonReader(map[string]any{"systemId": otherSystem.Id}),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user's system",
+			Method:          http.MethodPost,
+			URL:             url,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"systemId": otherSystem.Id}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"systemId":{"code":"validation_system_not_owned"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "reassign to a system with payments",
+			Method:          http.MethodPost,
+			URL:             url,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"systemId": newSystem.Id}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"id":"` + payment.Id + `"`, `"system":"` + newSystem.Id + `"`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", payment.Id)
+				require.NoError(t, err)
+				assert.Equal(t, newSystem.Id, record.GetString("system"))
+				assert.Equal(t, 12.0, record.GetFloat("amount"))
+				changes, err := app.FindAllRecords("price_changes", dbx.HashExp{"payment": payment.Id})
+				require.NoError(t, err)
+				assert.Len(t, changes, 1, "price changes should be kept")
+				history, err := app.FindAllRecords("payment_history", dbx.HashExp{"payment": payment.Id})
+				require.NoError(t, err)
+				assert.Len(t, history, 1, "history should be kept")
+				count, err := app.CountRecords("payments", dbx.HashExp{"system": newSystem.Id})
+				require.NoError(t, err)
+				assert.EqualValues(t, 2, count)
+			},
+		},
+		{
+			Name:            "same system",
+			Method:          http.MethodPost,
+			URL:             url,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"systemId": newSystem.Id}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"system":"` + newSystem.Id + `"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,63 @@
+package payments
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// RecalculatePayments handles POST /api/beszel/payments/recalculate requests (superusers only).
+// Advances every due payment right away, the same way the daily job does, and
+// returns the number updated. The optional userId in the body limits it to one user.
+// All changes are made in a single transaction.
+func (pm *PaymentManager) RecalculatePayments(e *core.RequestEvent) error {
+	var body struct {
+		UserID string `json:"userId"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if body.UserID != "" {
+		if _, err := e.App.FindRecordById("users", body.UserID); err != nil {
+			return e.JSON(http.StatusBadRequest, map[string]string{"error": "user not found"})
+		}
+	}
+
+	now := time.Now().UTC()
+	logger := requestid.Logger(e)
+	var count int
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		due, err := findDuePayments(txApp, now, body.UserID)
+		if err != nil {
+			return err
+		}
+		// users with autoAdvance off confirm their payments themselves
+		records, _ := splitManualAdvance(txApp, due)
+		for _, record := range records {
+			charges, err := advancePayment(record, now)
+			if err != nil {
+				// a malformed payment is skipped like in the daily job instead of undoing the others
+				logger.Error("Failed to advance payment", "id", record.Id, "err", err)
+				continue
+			}
+			if len(charges) == 0 {
+				continue
+			}
+			if err := txApp.SaveNoValidateWithContext(withActor(e), record); err != nil {
+				return err
+			}
+			if err := recordCharges(txApp, record, charges); err != nil {
+				return err
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return e.InternalServerError("Failed to recalculate payments", err)
+	}
+	return e.JSON(http.StatusOK, map[string]int{"updated": count})
+}
@@ -0,0 +1,109 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecalculatePayments(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	superuser, err := beszelTests.CreateRecord(f.hub, core.CollectionNameSuperusers, map[string]any{
+		"email":    "superuser@example.com",
+		"password": "password123",
+	})
+	require.NoError(t, err)
+	superuserToken, err := superuser.NewAuthToken()
+	require.NoError(t, err)
+
+	past := time.Now().UTC().AddDate(0, -2, 0)
+	mine := f.createPayment(t, map[string]any{"nextPayment": past})
+	otherUser, _ := createUserWithToken(t, f.hub, "other@example.com")
+	other, err := beszelTests.CreateRecord(f.hub, "payments", map[string]any{
+		"user":        otherUser.Id,
+		"system":      createSystem(t, f.hub, otherUser, "other").Id,
+		"provider":    createProvider(t, f.hub, otherUser, "Other").Id,
+		"period":      "monthly",
+		"nextPayment": past,
+		"amount":      1,
+		"currency":    "EUR",
+	})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	advanced := func(t testing.TB, app core.App, id string) bool {
+		record, err := app.FindRecordById("payments", id)
+		require.NoError(t, err)
+		return record.GetDateTime("nextPayment").Time().After(time.Now())
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "users can't recalculate",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/recalculate",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"not allowed"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "unknown user",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/recalculate",
+			Body:            jsonReader(map[string]any{"userId": "missing"}),
+			Headers:         map[string]string{"Authorization": superuserToken},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"user not found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "scoped to one user",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/recalculate",
+			Body:            jsonReader(map[string]any{"userId": f.user.Id}),
+			Headers:         map[string]string{"Authorization": superuserToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"updated":1}`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.True(t, advanced(t, app, mine.Id))
+				assert.False(t, advanced(t, app, other.Id))
+				history, err := app.FindAllRecords("payment_history", dbx.HashExp{"payment": mine.Id})
+				require.NoError(t, err)
+				assert.Len(t, history, 3)
+			},
+		},
+		{
+			Name:            "all users",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/recalculate",
+			Headers:         map[string]string{"Authorization": superuserToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"updated":1}`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.True(t, advanced(t, app, other.Id))
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
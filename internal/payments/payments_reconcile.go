@@ -0,0 +1,218 @@
+package payments
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// expectedCharge is a charge the payment's schedule expects on a date
+type expectedCharge struct {
+	Date   types.DateTime `json:"date"`
+	Amount float64        `json:"amount"`
+}
+
+// matchedCharge is an expected charge with the payment history row recorded for it
+type matchedCharge struct {
+	Date      types.DateTime `json:"date"`
+	HistoryId string         `json:"historyId"`
+	PaidAt    types.DateTime `json:"paidAt"`
+	Amount    float64        `json:"amount"`
+}
+
+// unexpectedCharge is a payment history row without an expected charge, such as a duplicate
+type unexpectedCharge struct {
+	Id       string         `json:"id"`
+	PaidAt   types.DateTime `json:"paidAt"`
+	Amount   float64        `json:"amount"`
+	Currency string         `json:"currency"`
+}
+
+// shiftPeriods returns t moved by n billing periods of the schedule, backwards if n is negative.
+// Periods are counted from t rather than stepped one at a time, so month based periods keep
+// anchorDay without drifting. One-time payments only have the charge at t.
+func (s paymentSchedule) shiftPeriods(t time.Time, n, anchorDay int) (time.Time, error) {
+	switch s.Period {
+	case PeriodDaily:
+		return t.AddDate(0, 0, n), nil
+	case PeriodWeekly:
+		return t.AddDate(0, 0, 7*n), nil
+	case PeriodOnce:
+		if n != 0 {
+			return t, errNotRecurring
+		}
+		return t, nil
+	case PeriodCustom:
+		days, months, ok := validInterval(s.CustomIntervalDays, s.CustomIntervalMonths)
+		if !ok {
+			return t, errors.New("custom period without a valid interval")
+		}
+		if days > 0 {
+			return t.AddDate(0, 0, days*n), nil
+		}
+		return addMonths(t, months*n, anchorDay), nil
+	}
+	months, ok := periodMonths[s.Period]
+	if !ok {
+		return t, fmt.Errorf("unknown period %q", s.Period)
+	}
+	return addMonths(t, months*n, anchorDay), nil
+}
+
+// expectedCharges returns the due dates of the payment's schedule within [from, to], oldest
+// first. The schedule is walked both ways from nextPayment, assuming it was the same in the
+// past. Dates before startedAt or the end of a free trial aren't expected.
+func expectedCharges(record *core.Record, from, to time.Time) ([]time.Time, error) {
+	s := newPaymentSchedule(record)
+	next := s.NextPayment
+	if next.IsZero() {
+		return nil, nil
+	}
+	anchorDay := s.BillingDay
+	if anchorDay == 0 {
+		anchorDay = next.Day()
+	}
+	if startedAt := record.GetDateTime("startedAt").Time(); startedAt.After(from) {
+		from = startedAt
+	}
+	if !s.TrialEndsAt.IsZero() && s.TrialEndsAt.After(from) {
+		from = s.TrialEndsAt
+	}
+
+	var charges []time.Time
+	// walk back from nextPayment, then forward from the period after it
+	for n := 0; n > -maxAdvancePeriods; n-- {
+		date, err := s.shiftPeriods(next, n, anchorDay)
+		if errors.Is(err, errNotRecurring) || (err == nil && date.Before(from)) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if !date.After(to) {
+			charges = append(charges, date)
+		}
+	}
+	for n := 1; n < maxAdvancePeriods; n++ {
+		date, err := s.shiftPeriods(next, n, anchorDay)
+		if errors.Is(err, errNotRecurring) || (err == nil && date.After(to)) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if !date.Before(from) {
+			charges = append(charges, date)
+		}
+	}
+	slices.SortFunc(charges, time.Time.Compare)
+	return charges, nil
+}
+
+// GetReconciliation handles GET /api/beszel/payments/{id}/reconcile requests.
+// Compares the charges the payment's schedule expected from from to to (YYYY-MM-DD, inclusive,
+// default the year up to today) with its payment history. Each expected charge is matched to
+// the closest unmatched history row paid within tolerance days (default 3, max 31) of it.
+// Returns the expected charges without a row as missing and the rows in the range without an
+// expected charge, like duplicates, as unexpected. Charges after today aren't expected yet.
+func (pm *PaymentManager) GetReconciliation(e *core.RequestEvent) error {
+	from, to, err := parseDateRange(e)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	tolerance, err := parseIntParam(e, "tolerance", 3, 31)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	payment, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+
+	now := time.Now().UTC()
+	if to.IsZero() || to.After(now) {
+		to = now
+	}
+	if from.IsZero() {
+		from = to.AddDate(-1, 0, 0)
+	}
+	if from.After(to) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "from must not be in the future"})
+	}
+	expected, err := expectedCharges(payment, from, to)
+	if err != nil {
+		return e.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	}
+
+	// rows just outside the range can still match the expected charges at its edges
+	window := time.Duration(tolerance) * 24 * time.Hour
+	history, err := e.App.FindAllRecords("payment_history",
+		dbx.HashExp{"payment": payment.Id},
+		dateRangeExp("paidAt", from.Add(-window), to.Add(window)),
+	)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	slices.SortFunc(history, func(a, b *core.Record) int {
+		return a.GetDateTime("paidAt").Compare(b.GetDateTime("paidAt"))
+	})
+
+	matched := []matchedCharge{}
+	missing := []expectedCharge{}
+	used := make(map[string]bool, len(history))
+	for _, date := range expected {
+		var closest *core.Record
+		var closestGap time.Duration
+		for _, row := range history {
+			gap := row.GetDateTime("paidAt").Time().Sub(date).Abs()
+			if used[row.Id] || gap > window || (closest != nil && gap >= closestGap) {
+				continue
+			}
+			closest, closestGap = row, gap
+		}
+		expectedDate, _ := types.ParseDateTime(date)
+		if closest == nil {
+			missing = append(missing, expectedCharge{
+				Date:   expectedDate,
+				Amount: roundAmount(effectiveAmount(payment, date), payment.GetString("currency")),
+			})
+			continue
+		}
+		used[closest.Id] = true
+		matched = append(matched, matchedCharge{
+			Date:      expectedDate,
+			HistoryId: closest.Id,
+			PaidAt:    closest.GetDateTime("paidAt"),
+			Amount:    closest.GetFloat("amount"),
+		})
+	}
+	unexpected := []unexpectedCharge{}
+	for _, row := range history {
+		paidAt := row.GetDateTime("paidAt")
+		if used[row.Id] || paidAt.Time().Before(from) || paidAt.Time().After(to) {
+			continue
+		}
+		unexpected = append(unexpected, unexpectedCharge{
+			Id:       row.Id,
+			PaidAt:   paidAt,
+			Amount:   row.GetFloat("amount"),
+			Currency: row.GetString("currency"),
+		})
+	}
+
+	fromDate, _ := types.ParseDateTime(from)
+	toDate, _ := types.ParseDateTime(to)
+	return e.JSON(http.StatusOK, map[string]any{
+		"id":            payment.Id,
+		"from":          fromDate,
+		"to":            toDate,
+		"toleranceDays": tolerance,
+		"matched":       matched,
+		"missing":       missing,
+		"unexpected":    unexpected,
+	})
+}
@@ -0,0 +1,111 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcilePayment(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	// charged monthly on the 15th, with January paid late, April missed and March paid twice
+	payment := f.createPayment(t, map[string]any{"nextPayment": "2025-06-15 00:00:00.000Z", "billingDay": 15})
+	history := map[string]string{}
+	for _, paidAt := range []string{
+		"2025-01-17 00:00:00.000Z",
+		"2025-02-15 00:00:00.000Z",
+		"2025-03-15 00:00:00.000Z",
+		"2025-03-16 00:00:00.000Z",
+		"2025-05-10 00:00:00.000Z",
+	} {
+		record, err := beszelTests.CreateRecord(f.hub, "payment_history", map[string]any{
+			"payment": payment.Id, "user": f.user.Id, "amount": 10, "currency": "USD", "paidAt": paidAt,
+		})
+		require.NoError(t, err)
+		history[paidAt[:10]] = record.Id
+	}
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id + "/reconcile",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id + "/reconcile",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid tolerance",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id + "/reconcile?tolerance=0",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"tolerance must be an integer between 1 and 31"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "missing and duplicate charges",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/" + payment.Id + "/reconcile?from=2025-01-01&to=2025-04-30",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"missing":[{"date":"2025-04-15 00:00:00.000Z","amount":10}]`,
+				`"unexpected":[{"id":"` + history["2025-03-16"] + `"`,
+			},
+			NotExpectedContent: []string{history["2025-05-10"]},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				var body struct {
+					Matched []struct {
+						Date      string `json:"date"`
+						HistoryId string `json:"historyId"`
+					} `json:"matched"`
+				}
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+				require.Len(t, body.Matched, 3)
+				assert.Equal(t, "2025-01-15 00:00:00.000Z", body.Matched[0].Date)
+				assert.Equal(t, history["2025-01-17"], body.Matched[0].HistoryId)
+				assert.Equal(t, history["2025-02-15"], body.Matched[1].HistoryId)
+				assert.Equal(t, history["2025-03-15"], body.Matched[2].HistoryId)
+			},
+		},
+		{
+			Name:            "a wider tolerance matches early charges",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id + "/reconcile?from=2025-05-01&to=2025-05-31&tolerance=7",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"historyId":"` + history["2025-05-10"] + `"`, `"missing":[]`, `"unexpected":[]`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
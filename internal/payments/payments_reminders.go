@@ -0,0 +1,223 @@
+package payments
+
+import (
+	"cmp"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// reminder lead time used for new payments that don't set reminderDays,
+// unless the user has set a defaultReminderDays preference
+const defaultReminderDays = 3
+
+// Reminder channels a payment can select
+const (
+	ChannelInApp   = "inApp"
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+)
+
+// reminderChannels returns the channels the payment is reminded on, the in-app inbox if none are selected
+func reminderChannels(record *core.Record) []string {
+	if channels := record.GetStringSlice("reminderChannels"); len(channels) > 0 {
+		return channels
+	}
+	return []string{ChannelInApp}
+}
+
+// reminderSchedule holds the fields of a payment that decide whether it is due for a reminder
+type reminderSchedule struct {
+	NextPayment        time.Time
+	ReminderDays       int
+	LastReminderSentAt time.Time
+	SnoozeUntil        time.Time
+	TrialEndsAt        time.Time
+	// archived, paused and cancelled payments aren't reminded of
+	Inactive bool
+}
+
+// newReminderSchedule reads the reminder schedule of a payment
+func newReminderSchedule(record *core.Record) reminderSchedule {
+	return reminderSchedule{
+		NextPayment:        record.GetDateTime("nextPayment").Time(),
+		ReminderDays:       record.GetInt("reminderDays"),
+		LastReminderSentAt: record.GetDateTime("lastReminderSentAt").Time(),
+		SnoozeUntil:        record.GetDateTime("snoozeUntil").Time(),
+		TrialEndsAt:        record.GetDateTime("trialEndsAt").Time(),
+		Inactive:           isArchived(record) || isPaused(record),
+	}
+}
+
+// reminderStart returns the day from which the current cycle should be reminded, in loc
+func (s reminderSchedule) reminderStart(loc *time.Location) time.Time {
+	return startOfDay(s.NextPayment.In(loc)).AddDate(0, 0, -s.ReminderDays)
+}
+
+// reminderDue reports whether a reminder should be sent for the schedule's current cycle.
+// A payment is due when nextPayment - reminderDays <= today and no reminder was sent since then.
+// Days start at midnight in the timezone of now. Payments in a trial or snoozed at now are not due.
+func reminderDue(s reminderSchedule, now time.Time) bool {
+	if s.Inactive || s.TrialEndsAt.After(now) || s.SnoozeUntil.After(now) {
+		return false
+	}
+	if s.NextPayment.IsZero() || s.NextPayment.Before(startOfDay(now)) {
+		return false
+	}
+	start := s.reminderStart(now.Location())
+	if start.After(now) {
+		return false
+	}
+	return s.LastReminderSentAt.IsZero() || s.LastReminderSentAt.Before(start)
+}
+
+// dueForReminder reports whether a reminder should be sent for the payment's current cycle, see reminderDue
+func dueForReminder(record *core.Record, now time.Time) bool {
+	return reminderDue(newReminderSchedule(record), now)
+}
+
+// dueReminder is a payment that is due for a reminder and where it would be sent
+type dueReminder struct {
+	record *core.Record
+	// timezone and locale of the payment's user
+	loc    *time.Location
+	locale string
+	// selected channels the reminder can be sent on. The webhook channel is left
+	// out if the user has no enabled webhooks, falling back to the in-app inbox if no
+	// other channel is selected, so the reminder isn't lost.
+	channels []string
+	webhooks []*core.Record
+}
+
+// findDueReminders returns the payments that are due for a reminder relative to now, soonest first,
+// optionally only the ones of the user with userID. Provider and system are expanded.
+// Users with an active digest get their payments in the digest instead, so none of theirs are returned.
+// Nothing is changed, so the caller is the one that sends the reminders and records them.
+func findDueReminders(app core.App, logger *slog.Logger, now time.Time, userID string) ([]dueReminder, error) {
+	webhookFilter := dbx.HashExp{"enabled": true}
+	if userID != "" {
+		webhookFilter["user"] = userID
+	}
+	webhooks, err := app.FindAllRecords("webhooks", webhookFilter)
+	if err != nil {
+		return nil, err
+	}
+	userWebhooks := make(map[string][]*core.Record)
+	for _, webhook := range webhooks {
+		userWebhooks[webhook.GetString("user")] = append(userWebhooks[webhook.GetString("user")], webhook)
+	}
+
+	// a day earlier than today in UTC covers the start of today in every timezone
+	startStr, err := types.ParseDateTime(startOfDay(now).AddDate(0, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	exprs := []dbx.Expression{
+		dbx.NewExp("nextPayment >= {:start} AND archivedAt = '' AND status NOT IN ('paused', 'cancelled')", dbx.Params{"start": startStr.String()}),
+	}
+	if userID != "" {
+		exprs = append(exprs, dbx.HashExp{"user": userID})
+	}
+	records, err := app.FindAllRecords("payments", exprs...)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(records, func(a, b *core.Record) int {
+		return cmp.Or(a.GetDateTime("nextPayment").Compare(b.GetDateTime("nextPayment")), cmp.Compare(a.Id, b.Id))
+	})
+
+	// each user's payments are checked against the day in their own timezone
+	userSettings := make(map[string]userPaymentSettings)
+	var reminders []dueReminder
+	for _, record := range records {
+		userID := record.GetString("user")
+		settings, ok := userSettings[userID]
+		if !ok {
+			settings = loadPaymentSettings(app, userID)
+			userSettings[userID] = settings
+		}
+		loc := settings.location()
+		if settings.digestActive() || !dueForReminder(record, now.In(loc)) {
+			continue
+		}
+		reminder := dueReminder{record: record, loc: loc, locale: settings.Locale, channels: []string{}}
+		for _, channel := range reminderChannels(record) {
+			if channel == ChannelWebhook {
+				if len(userWebhooks[userID]) == 0 {
+					continue
+				}
+				reminder.webhooks = userWebhooks[userID]
+			}
+			reminder.channels = append(reminder.channels, channel)
+		}
+		if len(reminder.channels) == 0 {
+			reminder.channels = []string{ChannelInApp}
+		}
+		reminders = append(reminders, reminder)
+	}
+
+	due := make([]*core.Record, len(reminders))
+	for i, reminder := range reminders {
+		due[i] = reminder.record
+	}
+	if errs := app.ExpandRecords(due, []string{"provider", "system"}, nil); len(errs) > 0 {
+		logger.Warn("Failed to expand payment relations", "errs", errs)
+	}
+	return reminders, nil
+}
+
+// reminderPreview is a payment that would be reminded of and the channels it would be sent on
+type reminderPreview struct {
+	Id           string         `json:"id"`
+	User         string         `json:"user"`
+	Provider     string         `json:"provider"`
+	ProviderName string         `json:"providerName"`
+	System       string         `json:"system"`
+	NextPayment  types.DateTime `json:"nextPayment"`
+	Channels     []string       `json:"channels"`
+	// ids of the enabled webhooks the reminder would be posted to
+	Webhooks []string `json:"webhooks"`
+}
+
+// PreviewReminders handles POST /api/beszel/reminders/preview requests (superuser only).
+// Runs the reminder job's selection for the current time without sending or recording anything,
+// and returns the payments that would be reminded, optionally only the ones of the userId query parameter.
+func (pm *PaymentManager) PreviewReminders(e *core.RequestEvent) error {
+	userID := e.Request.URL.Query().Get("userId")
+	if userID != "" {
+		if _, err := e.App.FindRecordById("users", userID); err != nil {
+			return e.JSON(http.StatusBadRequest, map[string]string{"error": "user not found"})
+		}
+	}
+	reminders, err := findDueReminders(e.App, requestid.Logger(e), time.Now().UTC(), userID)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	previews := make([]reminderPreview, len(reminders))
+	for i, reminder := range reminders {
+		record := reminder.record
+		previews[i] = reminderPreview{
+			Id:          record.Id,
+			User:        record.GetString("user"),
+			Provider:    record.GetString("provider"),
+			System:      record.GetString("system"),
+			NextPayment: record.GetDateTime("nextPayment"),
+			Channels:    reminder.channels,
+			Webhooks:    make([]string, len(reminder.webhooks)),
+		}
+		if provider := record.ExpandedOne("provider"); provider != nil {
+			previews[i].ProviderName = provider.GetString("name")
+		}
+		for j, webhook := range reminder.webhooks {
+			previews[i].Webhooks[j] = webhook.Id
+		}
+	}
+	return e.JSON(http.StatusOK, previews)
+}
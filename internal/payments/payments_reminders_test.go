@@ -0,0 +1,182 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/henrygd/beszel/internal/payments"
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReminderDue(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	schedule := func(next time.Time, days int) payments.ReminderSchedule {
+		return payments.ReminderSchedule{NextPayment: next, ReminderDays: days}
+	}
+	with := func(s payments.ReminderSchedule, change func(*payments.ReminderSchedule)) payments.ReminderSchedule {
+		change(&s)
+		return s
+	}
+	tests := []struct {
+		name     string
+		schedule payments.ReminderSchedule
+		now      time.Time
+		expected bool
+	}{
+		{"before the lead time", schedule(date(2030, 1, 20), 3), date(2030, 1, 16).Add(23 * time.Hour), false},
+		{"first day of the lead time", schedule(date(2030, 1, 20), 3), date(2030, 1, 17), true},
+		{"on the due day", schedule(date(2030, 1, 20).Add(10*time.Hour), 0), date(2030, 1, 20).Add(time.Hour), true},
+		{"due date passed", schedule(date(2030, 1, 20), 3), date(2030, 1, 21), false},
+		{"lead time across month end", schedule(date(2030, 3, 1), 2), date(2030, 2, 27), true},
+		{"lead time across leap day", schedule(date(2028, 3, 1), 1), date(2028, 2, 29), true},
+		{"before the lead time across leap day", schedule(date(2028, 3, 1), 1), date(2028, 2, 28), false},
+		{"annual lead time", schedule(date(2030, 12, 31), 30), date(2030, 12, 1), true},
+		{"already reminded this cycle", with(schedule(date(2030, 1, 20), 3), func(s *payments.ReminderSchedule) {
+			s.LastReminderSentAt = date(2030, 1, 17).Add(time.Hour)
+		}), date(2030, 1, 18), false},
+		{"reminded in the previous cycle", with(schedule(date(2030, 1, 20), 3), func(s *payments.ReminderSchedule) {
+			s.LastReminderSentAt = date(2029, 12, 17)
+		}), date(2030, 1, 18), true},
+		{"in trial", with(schedule(date(2030, 1, 20), 3), func(s *payments.ReminderSchedule) {
+			s.TrialEndsAt = date(2030, 1, 19)
+		}), date(2030, 1, 18), false},
+		{"after the trial", with(schedule(date(2030, 1, 20), 3), func(s *payments.ReminderSchedule) {
+			s.TrialEndsAt = date(2030, 1, 18)
+		}), date(2030, 1, 18).Add(time.Hour), true},
+		{"snoozed", with(schedule(date(2030, 1, 20), 3), func(s *payments.ReminderSchedule) {
+			s.SnoozeUntil = date(2030, 1, 19)
+		}), date(2030, 1, 18), false},
+		{"snooze ended", with(schedule(date(2030, 1, 20), 3), func(s *payments.ReminderSchedule) {
+			s.SnoozeUntil = date(2030, 1, 19)
+		}), date(2030, 1, 19), true},
+		{"inactive", with(schedule(date(2030, 1, 20), 3), func(s *payments.ReminderSchedule) {
+			s.Inactive = true
+		}), date(2030, 1, 18), false},
+		{"without a next payment", schedule(time.Time{}, 3), date(2030, 1, 18), false},
+		// 21:00 UTC on Jan 16 is already Jan 17 in Moscow
+		{"day starts in the timezone of now", schedule(date(2030, 1, 20), 3), date(2030, 1, 16).Add(21 * time.Hour).In(moscow), true},
+		{"timezone not yet at the lead time", schedule(date(2030, 1, 20), 3), date(2030, 1, 16).Add(20 * time.Hour).In(moscow), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, payments.ReminderDue(tt.schedule, tt.now))
+		})
+	}
+}
+
+func TestPreviewReminders(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	superuser, err := beszelTests.CreateRecord(f.hub, core.CollectionNameSuperusers, map[string]any{
+		"email":    "superuser@example.com",
+		"password": "password123",
+	})
+	require.NoError(t, err)
+	superuserToken, err := superuser.NewAuthToken()
+	require.NoError(t, err)
+
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1)
+	inApp := f.createPayment(t, map[string]any{"nextPayment": tomorrow, "reminderDays": 3})
+	webhook := f.createPayment(t, map[string]any{"nextPayment": tomorrow.Add(time.Minute), "reminderDays": 3, "reminderChannels": []string{"webhook", "email"}})
+	// not yet within its lead time
+	notDue := f.createPayment(t, map[string]any{"nextPayment": tomorrow.AddDate(0, 0, 10), "reminderDays": 3})
+	hook, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": "https://example.com/hook", "enabled": true})
+	require.NoError(t, err)
+
+	otherUser, _ := createUserWithToken(t, f.hub, "other@example.com")
+	// the other user has no webhooks, so only the in-app channel is left
+	_, err = beszelTests.CreateRecord(f.hub, "payments", map[string]any{
+		"user":             otherUser.Id,
+		"system":           createSystem(t, f.hub, otherUser, "other").Id,
+		"provider":         createProvider(t, f.hub, otherUser, "Other").Id,
+		"period":           "monthly",
+		"nextPayment":      tomorrow,
+		"amount":           1,
+		"currency":         "USD",
+		"reminderDays":     3,
+		"reminderChannels": []string{"inApp", "webhook"},
+	})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/reminders/preview",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "regular user",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/reminders/preview",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  403,
+			ExpectedContent: []string{"not allowed"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "unknown user",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/reminders/preview?userId=missing",
+			Headers:         map[string]string{"Authorization": superuserToken},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"user not found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "payments of a user with their channels",
+			Method:         http.MethodPost,
+			URL:            "/api/beszel/reminders/preview?userId=" + f.user.Id,
+			Headers:        map[string]string{"Authorization": superuserToken},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`[{"id":"` + inApp.Id + `","user":"` + f.user.Id + `","provider":"` + f.provider.Id + `","providerName":"Hetzner"`,
+				`"channels":["inApp"],"webhooks":[]},{"id":"` + webhook.Id + `"`,
+				`"channels":["webhook","email"],"webhooks":["` + hook.Id + `"]}]`,
+			},
+			NotExpectedContent: []string{notDue.Id, otherUser.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "payments of all users",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/reminders/preview",
+			Headers:         map[string]string{"Authorization": superuserToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"user":"` + otherUser.Id + `","provider":`, `"providerName":"Other"`, `"channels":["inApp"],"webhooks":[]`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				// nothing is sent or recorded
+				notifications, err := app.FindAllRecords("notifications", dbx.HashExp{"user": f.user.Id})
+				require.NoError(t, err)
+				assert.Empty(t, notifications)
+				record, err := app.FindRecordById("payments", inApp.Id)
+				require.NoError(t, err)
+				assert.True(t, record.GetDateTime("lastReminderSentAt").IsZero())
+				assert.Zero(t, f.hub.TestMailer.TotalSend())
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,325 @@
+package payments
+
+import (
+	"cmp"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// number of most expensive payments listed in the annual report
+const reportTopPayments = 5
+
+// reportCharge is a charge of a payment counted in a report, either recorded in
+// payment_history or projected from the payment's schedule
+type reportCharge struct {
+	payment   *core.Record
+	month     time.Month
+	amount    float64
+	currency  string
+	projected bool
+}
+
+// annualReportMonth is the spend of one month of the report year
+type annualReportMonth struct {
+	Month     string  `json:"month"`
+	Total     float64 `json:"total"`
+	Actual    float64 `json:"actual"`
+	Projected float64 `json:"projected"`
+}
+
+// annualReportProvider is the spend with one provider over the report year
+type annualReportProvider struct {
+	Id    string  `json:"id"`
+	Name  string  `json:"name"`
+	Total float64 `json:"total"`
+}
+
+// annualReportPayment is the spend of one payment over the report year
+type annualReportPayment struct {
+	Id       string  `json:"id"`
+	Provider string  `json:"provider"`
+	System   string  `json:"system"`
+	Total    float64 `json:"total"`
+}
+
+// annualReport is the spend over a year, converted to currency
+type annualReport struct {
+	Year      int                    `json:"year"`
+	Currency  string                 `json:"currency"`
+	Total     float64                `json:"total"`
+	Months    []annualReportMonth    `json:"months"`
+	Providers []annualReportProvider `json:"providers"`
+	// totals of the charges in their own currency, before conversion
+	Currencies map[string]float64    `json:"currencies"`
+	Top        []annualReportPayment `json:"top"`
+}
+
+// annualCharges returns the user's charges in the year. Charges recorded in payment_history
+// are used where a payment has any in a month, and the other months are projected from the
+// schedule of the payments that are neither archived nor paused.
+func annualCharges(app core.App, logger *slog.Logger, userID string, year int) ([]reportCharge, error) {
+	start := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(1, 0, 0)
+
+	records, err := findUserPayments(app, userID)
+	if err != nil {
+		return nil, err
+	}
+	if errs := app.ExpandRecords(records, []string{"provider", "system"}, nil); len(errs) > 0 {
+		logger.Warn("Failed to expand payment relations", "errs", errs)
+	}
+	payments := make(map[string]*core.Record, len(records))
+	for _, record := range records {
+		payments[record.Id] = record
+	}
+
+	history, err := app.FindAllRecords("payment_history",
+		dbx.HashExp{"user": userID},
+		dbx.NewExp("paidAt >= {:start} AND paidAt < {:end}", dbx.Params{
+			"start": start.Format(types.DefaultDateLayout),
+			"end":   end.Format(types.DefaultDateLayout),
+		}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var charges []reportCharge
+	charged := make(map[string]map[time.Month]bool)
+	for _, entry := range history {
+		payment, ok := payments[entry.GetString("payment")]
+		if !ok {
+			continue
+		}
+		month := entry.GetDateTime("paidAt").Time().Month()
+		if charged[payment.Id] == nil {
+			charged[payment.Id] = make(map[time.Month]bool)
+		}
+		charged[payment.Id][month] = true
+		charges = append(charges, reportCharge{
+			payment:  payment,
+			month:    month,
+			amount:   entry.GetFloat("amount"),
+			currency: entry.GetString("currency"),
+		})
+	}
+
+	for _, record := range records {
+		if isArchived(record) || isPaused(record) {
+			continue
+		}
+		dates, err := projectCharges(record, start, end)
+		if err != nil {
+			logger.Warn("Failed to project payment", "id", record.Id, "err", err)
+			continue
+		}
+		for _, date := range dates {
+			month := date.UTC().Month()
+			if charged[record.Id][month] {
+				continue
+			}
+			charges = append(charges, reportCharge{
+				payment:   record,
+				month:     month,
+				amount:    effectiveAmount(record, date),
+				currency:  record.GetString("currency"),
+				projected: true,
+			})
+		}
+	}
+	return charges, nil
+}
+
+// buildAnnualReport converts the charges into base and sums them by month, provider and payment.
+// Charges that couldn't be converted are left out and their rates recorded by conv.
+func buildAnnualReport(charges []reportCharge, year int, base string, conv *converter) annualReport {
+	report := annualReport{
+		Year:       year,
+		Currency:   base,
+		Months:     make([]annualReportMonth, 12),
+		Providers:  []annualReportProvider{},
+		Currencies: make(map[string]float64),
+		Top:        []annualReportPayment{},
+	}
+	for i := range report.Months {
+		report.Months[i].Month = time.Date(year, time.Month(i+1), 1, 0, 0, 0, 0, time.UTC).Format(cashflowMonthLayout)
+	}
+
+	providers := make(map[string]*annualReportProvider)
+	payments := make(map[string]*annualReportPayment)
+	for _, charge := range charges {
+		report.Currencies[charge.currency] += charge.amount
+		amount, ok := conv.convert(charge.amount, charge.currency, base)
+		if !ok {
+			continue
+		}
+		report.Total += amount
+		month := &report.Months[charge.month-1]
+		month.Total += amount
+		if charge.projected {
+			month.Projected += amount
+		} else {
+			month.Actual += amount
+		}
+
+		providerID := charge.payment.GetString("provider")
+		if providers[providerID] == nil {
+			providers[providerID] = &annualReportProvider{Id: providerID}
+			if provider := charge.payment.ExpandedOne("provider"); provider != nil {
+				providers[providerID].Name = provider.GetString("name")
+			}
+		}
+		providers[providerID].Total += amount
+
+		if payments[charge.payment.Id] == nil {
+			payments[charge.payment.Id] = &annualReportPayment{Id: charge.payment.Id, Provider: providers[providerID].Name}
+			if system := charge.payment.ExpandedOne("system"); system != nil {
+				payments[charge.payment.Id].System = system.GetString("name")
+			}
+		}
+		payments[charge.payment.Id].Total += amount
+	}
+	report.Total = roundAmount(report.Total, base)
+	for i := range report.Months {
+		month := &report.Months[i]
+		month.Total = roundAmount(month.Total, base)
+		month.Actual = roundAmount(month.Actual, base)
+		month.Projected = roundAmount(month.Projected, base)
+	}
+	roundTotals(report.Currencies)
+	for _, provider := range providers {
+		provider.Total = roundAmount(provider.Total, base)
+		report.Providers = append(report.Providers, *provider)
+	}
+	slices.SortFunc(report.Providers, func(a, b annualReportProvider) int {
+		return cmp.Or(cmp.Compare(b.Total, a.Total), cmp.Compare(a.Name, b.Name), cmp.Compare(a.Id, b.Id))
+	})
+	for _, payment := range payments {
+		payment.Total = roundAmount(payment.Total, base)
+		report.Top = append(report.Top, *payment)
+	}
+	slices.SortFunc(report.Top, func(a, b annualReportPayment) int {
+		return cmp.Or(cmp.Compare(b.Total, a.Total), cmp.Compare(a.Id, b.Id))
+	})
+	report.Top = report.Top[:min(len(report.Top), reportTopPayments)]
+	return report
+}
+
+// GetAnnualReport handles GET /api/beszel/reports/annual requests.
+// Returns the user's spend over the year in the year query parameter (the current year if absent),
+// by month, by provider and by currency, with the most expensive payments. Charges recorded in
+// payment_history are used where available and the remaining ones are projected from the schedule.
+// Amounts are converted to the currency in the base query parameter, the user's default currency if absent.
+func (pm *PaymentManager) GetAnnualReport(e *core.RequestEvent) error {
+	year, err := parseIntParam(e, "year", time.Now().UTC().Year(), 9999)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	base := requestBase(e)
+	if base == "" {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "base currency is required"})
+	}
+	if !isCurrency(base) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid base currency"})
+	}
+
+	charges, err := annualCharges(e.App, requestid.Logger(e), e.Auth.Id, year)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	report := buildAnnualReport(charges, year, base, conv)
+	if err := conv.err(); err != nil {
+		return convertError(e, err)
+	}
+	return e.JSON(http.StatusOK, report)
+}
+
+// yearSpend is the spend over one year of a comparison
+type yearSpend struct {
+	Year  int     `json:"year"`
+	Total float64 `json:"total"`
+	// totals of the charges in their own currency, before conversion
+	Currencies map[string]float64 `json:"currencies"`
+}
+
+// spendDelta is the change in spend from one year to another
+type spendDelta struct {
+	Amount float64 `json:"amount"`
+	// change relative to the earlier year, zero if the earlier year has no spend
+	Percent float64 `json:"percent"`
+}
+
+// newSpendDelta returns the change from one amount to another, rounded to currency
+func newSpendDelta(from, to float64, currency string) spendDelta {
+	delta := spendDelta{Amount: roundAmount(to-from, currency)}
+	if from != 0 {
+		delta.Percent = roundHalfUp((to-from)/from*100, 2)
+	}
+	return delta
+}
+
+// GetCompareReport handles GET /api/beszel/reports/compare requests.
+// Compares the user's spend in the year in the to query parameter (the current year if absent)
+// with the year in from (the year before to if absent). Charges are counted the same way as in
+// the annual report. Returns each year's total converted to base, the user's default currency if
+// absent, and per currency, with the change between them. The percent change is zero when the
+// from year has no spend.
+func (pm *PaymentManager) GetCompareReport(e *core.RequestEvent) error {
+	to, err := parseIntParam(e, "to", time.Now().UTC().Year(), 9999)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	from, err := parseIntParam(e, "from", max(to-1, 1), 9999)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	base := requestBase(e)
+	if base == "" {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "base currency is required"})
+	}
+	if !isCurrency(base) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid base currency"})
+	}
+
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	var years [2]yearSpend
+	for i, year := range []int{from, to} {
+		charges, err := annualCharges(e.App, requestid.Logger(e), e.Auth.Id, year)
+		if err != nil {
+			return e.InternalServerError("", err)
+		}
+		report := buildAnnualReport(charges, year, base, conv)
+		years[i] = yearSpend{Year: year, Total: report.Total, Currencies: report.Currencies}
+	}
+	if err := conv.err(); err != nil {
+		return convertError(e, err)
+	}
+
+	currencies := make(map[string]spendDelta)
+	for _, spend := range years {
+		for currency := range spend.Currencies {
+			currencies[currency] = newSpendDelta(years[0].Currencies[currency], years[1].Currencies[currency], currency)
+		}
+	}
+	return e.JSON(http.StatusOK, map[string]any{
+		"currency":   base,
+		"from":       years[0],
+		"to":         years[1],
+		"delta":      newSpendDelta(years[0].Total, years[1].Total, base),
+		"currencies": currencies,
+	})
+}
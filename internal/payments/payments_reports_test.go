@@ -0,0 +1,250 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnualReport(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	web := createSystem(t, f.hub, f.user, "web-1")
+	monthly := f.createPayment(t, map[string]any{"system": web.Id, "amount": 10, "nextPayment": "2030-02-15 00:00:00.000Z"})
+	ovh := createProvider(t, f.hub, f.user, "OVH")
+	annual := f.createPayment(t, map[string]any{
+		"provider":    ovh.Id,
+		"amount":      120,
+		"currency":    "EUR",
+		"period":      "annual",
+		"nextPayment": "2030-06-01 00:00:00.000Z",
+	})
+	// paused payments are only counted by their history
+	f.createPayment(t, map[string]any{"amount": 1000, "status": "paused", "nextPayment": "2030-03-01 00:00:00.000Z"})
+	for paidAt, amount := range map[string]float64{
+		"2029-12-15 00:00:00.000Z": 50,
+		"2030-01-15 00:00:00.000Z": 9,
+	} {
+		_, err := beszelTests.CreateRecord(f.hub, "payment_history", map[string]any{
+			"payment":  monthly.Id,
+			"user":     f.user.Id,
+			"amount":   amount,
+			"currency": "USD",
+			"paidAt":   paidAt,
+		})
+		require.NoError(t, err)
+	}
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/annual?year=2030&base=USD",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "base required without a default currency",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/annual?year=2030",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"base currency is required"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "missing rate",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/annual?year=2030&base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"missing":[{"base":"EUR","quote":"USD"}]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "history with projected months",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/annual?year=2030&base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"year":2030`, `"currency":"USD"`, `"total":299`, `"currencies":{"EUR":120,"USD":119}`},
+			TestAppFactory:  testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "EUR", "USD", 1.5)
+			},
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				body, err := io.ReadAll(res.Body)
+				require.NoError(t, err)
+				var report struct {
+					Months []struct {
+						Month     string  `json:"month"`
+						Total     float64 `json:"total"`
+						Actual    float64 `json:"actual"`
+						Projected float64 `json:"projected"`
+					} `json:"months"`
+					Providers []struct {
+						Id    string  `json:"id"`
+						Name  string  `json:"name"`
+						Total float64 `json:"total"`
+					} `json:"providers"`
+					Top []struct {
+						Id       string  `json:"id"`
+						Provider string  `json:"provider"`
+						System   string  `json:"system"`
+						Total    float64 `json:"total"`
+					} `json:"top"`
+				}
+				require.NoError(t, json.Unmarshal(body, &report))
+
+				require.Len(t, report.Months, 12)
+				assert.Equal(t, "2030-01", report.Months[0].Month)
+				assert.Equal(t, 9.0, report.Months[0].Actual)
+				assert.Equal(t, 0.0, report.Months[0].Projected)
+				assert.Equal(t, 10.0, report.Months[1].Projected)
+				assert.Equal(t, 190.0, report.Months[5].Total)
+				assert.Equal(t, "2030-12", report.Months[11].Month)
+
+				require.Len(t, report.Providers, 2)
+				assert.Equal(t, "OVH", report.Providers[0].Name)
+				assert.Equal(t, 180.0, report.Providers[0].Total)
+				assert.Equal(t, f.provider.Id, report.Providers[1].Id)
+				assert.Equal(t, 119.0, report.Providers[1].Total)
+
+				require.Len(t, report.Top, 2)
+				assert.Equal(t, annual.Id, report.Top[0].Id)
+				assert.Equal(t, monthly.Id, report.Top[1].Id)
+				assert.Equal(t, "Hetzner", report.Top[1].Provider)
+				assert.Equal(t, "web-1", report.Top[1].System)
+			},
+		},
+		{
+			Name:            "year without charges",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/annual?year=2020&base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"total":0`, `"providers":[]`, `"currencies":{}`, `"top":[]`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestCompareReport(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	// charged next in 2031, so the compared years only count history
+	payment := f.createPayment(t, map[string]any{"amount": 50, "nextPayment": "2031-01-01 00:00:00.000Z"})
+	for _, entry := range []struct {
+		paidAt   string
+		amount   float64
+		currency string
+	}{
+		{"2028-03-01 00:00:00.000Z", 100, "USD"},
+		{"2029-03-01 00:00:00.000Z", 150, "USD"},
+		{"2029-04-01 00:00:00.000Z", 50, "EUR"},
+	} {
+		_, err := beszelTests.CreateRecord(f.hub, "payment_history", map[string]any{
+			"payment":  payment.Id,
+			"user":     f.user.Id,
+			"amount":   entry.amount,
+			"currency": entry.currency,
+			"paidAt":   entry.paidAt,
+		})
+		require.NoError(t, err)
+	}
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/compare?from=2028&to=2029&base=USD",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid year",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/compare?from=last&to=2029&base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"from must be an integer"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "missing rate",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/compare?from=2028&to=2029&base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"missing":[{"base":"EUR","quote":"USD"}]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:    "totals and change",
+			Method:  http.MethodGet,
+			URL:     "/api/beszel/reports/compare?from=2028&to=2029&base=USD",
+			Headers: map[string]string{"Authorization": f.token},
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "EUR", "USD", 1.2)
+			},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"currency":"USD"`,
+				`"from":{"year":2028,"total":100,"currencies":{"USD":100}}`,
+				`"to":{"year":2029,"total":210,"currencies":{"EUR":50,"USD":150}}`,
+				`"delta":{"amount":110,"percent":110}`,
+				`"currencies":{"EUR":{"amount":50,"percent":0},"USD":{"amount":50,"percent":50}}`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:            "year without spend",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/compare?from=2020&to=2028&base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"from":{"year":2020,"total":0,"currencies":{}}`, `"delta":{"amount":100,"percent":0}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "to defaults to the current year",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/compare?base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"to":{"year":` + strconv.Itoa(time.Now().UTC().Year())},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,92 @@
+package payments
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// decimals used for unsupported currencies
+const defaultCurrencyDecimals = 2
+
+// currencyDecimals returns the number of decimals in the minor unit of currency
+func currencyDecimals(currency string) int {
+	if c, ok := findCurrency(currency); ok {
+		return c.Decimals
+	}
+	return defaultCurrencyDecimals
+}
+
+// roundAmount rounds an amount half up to the minor unit of its currency
+func roundAmount(amount float64, currency string) float64 {
+	return roundHalfUp(amount, currencyDecimals(currency))
+}
+
+// roundHalfUp rounds value to the given decimals with halves rounded away from zero.
+// It works on the shortest decimal representation of value, so 1.005 rounds to 1.01
+// even though the nearest float64 is slightly below it.
+func roundHalfUp(value float64, decimals int) float64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return value
+	}
+	r, ok := new(big.Rat).SetString(strconv.FormatFloat(math.Abs(value), 'f', -1, 64))
+	if !ok {
+		return value
+	}
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)
+	r.Mul(r, new(big.Rat).SetInt(scale))
+	r.Add(r, big.NewRat(1, 2))
+	units := new(big.Int).Quo(r.Num(), r.Denom())
+	rounded, _ := new(big.Rat).SetFrac(units, scale).Float64()
+	if value < 0 {
+		return -rounded
+	}
+	return rounded
+}
+
+// roundTotals rounds each per currency total to its currency's minor unit
+func roundTotals(totals map[string]float64) map[string]float64 {
+	for currency, amount := range totals {
+		totals[currency] = roundAmount(amount, currency)
+	}
+	return totals
+}
+
+// roundStoredAmount rounds the amount of a payment or payment history record before it is saved
+func roundStoredAmount(e *core.RecordEvent) error {
+	e.Record.Set("amount", roundAmount(e.Record.GetFloat("amount"), e.Record.GetString("currency")))
+	return e.Next()
+}
+
+// decimalPlaces returns the number of decimals in the shortest representation of value, so 10.10 has one
+func decimalPlaces(value float64) int {
+	s := strconv.FormatFloat(value, 'f', -1, 64)
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+// checkAmountPrecision returns field errors for payment amounts with more decimals than the
+// minor unit of the payment's currency, like 9.999 USD or 100.5 JPY. It runs on API requests only:
+// amounts computed by the server, like converted or prorated ones, are rounded when saved instead.
+func checkAmountPrecision(record *core.Record) error {
+	currency := record.GetString("currency")
+	decimals := currencyDecimals(currency)
+	errs := validation.Errors{}
+	for _, field := range []string{"amount", "discountAmount"} {
+		if decimalPlaces(record.GetFloat(field)) > decimals {
+			errs[field] = validation.NewError("validation_amount_precision",
+				fmt.Sprintf("%s amounts can't have more than %d decimals.", currency, decimals))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
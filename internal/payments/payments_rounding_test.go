@@ -0,0 +1,152 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/henrygd/beszel/internal/payments"
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundAmount(t *testing.T) {
+	tests := []struct {
+		amount   float64
+		currency string
+		want     float64
+	}{
+		{499.99999997, "RUB", 500},
+		{1.005, "USD", 1.01},
+		{2.675, "EUR", 2.68},
+		{0.125, "USD", 0.13},
+		{10.004, "USD", 10},
+		{-1.005, "USD", -1.01},
+		{0, "EUR", 0},
+		{1234.5, "JPY", 1235},
+		// unknown currencies use two decimals
+		{3.14159, "XXX", 3.14},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, payments.RoundAmount(tt.amount, tt.currency), "%v %s", tt.amount, tt.currency)
+	}
+}
+
+func TestStoredAmountIsRounded(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	payment := f.createPayment(t, map[string]any{"amount": 499.99999997, "currency": "RUB"})
+	record, err := f.hub.FindRecordById("payments", payment.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 500.0, record.GetFloat("amount"))
+
+	record.Set("amount", 1.005)
+	require.NoError(t, f.hub.Save(record))
+	record, err = f.hub.FindRecordById("payments", payment.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 1.01, record.GetFloat("amount"))
+}
+
+func TestSummaryTotalsAreRounded(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	// 10 / 3 per month
+	f.createPayment(t, map[string]any{"amount": 10, "period": "quarterly"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenario := beszelTests.ApiScenario{
+		Name:            "totals rounded to cents",
+		Method:          http.MethodGet,
+		URL:             "/api/beszel/payments/summary",
+		Headers:         map[string]string{"Authorization": f.token},
+		ExpectedStatus:  200,
+		ExpectedContent: []string{`{"USD":3.33}`},
+		TestAppFactory:  testAppFactory,
+	}
+	scenario.Test(t)
+}
+
+func TestAmountPrecision(t *testing.T) {
+	f := newPaymentFixture(t)
+	payment := f.createPayment(t, map[string]any{"amount": 10, "currency": "USD"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+	newPayment := func(amount float64, currency string) map[string]any {
+		return map[string]any{
+			"user":        f.user.Id,
+			"system":      f.system.Id,
+			"provider":    f.provider.Id,
+			"period":      "monthly",
+			"nextPayment": "2030-01-15 00:00:00.000Z",
+			"amount":      amount,
+			"currency":    currency,
+		}
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "more decimals than cents",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Body:            jsonReader(newPayment(9.999, "USD")),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"amount":{"code":"validation_amount_precision","message":"USD amounts can't have more than 2 decimals."}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "decimals in a currency without a minor unit",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Body:            jsonReader(newPayment(100.5, "JPY")),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"JPY amounts can't have more than 0 decimals."},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "kopecks",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Body:            jsonReader(newPayment(499.5, "RUB")),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"amount":499.5`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "update with a precise discount",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Body:            jsonReader(map[string]any{"discountAmount": 0.125}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"discountAmount":{"code":"validation_amount_precision"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "changing the currency checks the existing amount",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Body:            jsonReader(map[string]any{"amount": 10.5, "currency": "JPY"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_amount_precision"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,54 @@
+package payments
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// scheduledCharge is an upcoming charge of a payment
+type scheduledCharge struct {
+	Date   types.DateTime `json:"date"`
+	Amount float64        `json:"amount"`
+}
+
+// GetPaymentSchedule handles GET /api/beszel/payments/{id}/schedule requests.
+// Returns the payment's next charges (count, default 6, max 100) computed from its
+// nextPayment the same way the schedule is advanced, without changing the payment.
+// One-time payments have a single charge.
+func (pm *PaymentManager) GetPaymentSchedule(e *core.RequestEvent) error {
+	count, err := parseIntParam(e, "count", 6, 100)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	payment, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+
+	charges := make([]scheduledCharge, 0, count)
+	next, anchorDay := firstCharge(payment)
+	for !next.IsZero() && len(charges) < count {
+		date, err := types.ParseDateTime(next)
+		if err != nil {
+			return e.InternalServerError("", err)
+		}
+		charges = append(charges, scheduledCharge{
+			Date:   date,
+			Amount: roundAmount(effectiveAmount(payment, next), payment.GetString("currency")),
+		})
+		if next, err = addPaymentPeriod(payment, next, anchorDay); errors.Is(err, errNotRecurring) {
+			break
+		} else if err != nil {
+			return e.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		}
+	}
+	return e.JSON(http.StatusOK, map[string]any{
+		"id":       payment.Id,
+		"period":   payment.GetString("period"),
+		"currency": payment.GetString("currency"),
+		"charges":  charges,
+	})
+}
@@ -0,0 +1,94 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentSchedule(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	monthEnd := f.createPayment(t, map[string]any{"nextPayment": "2030-01-31 00:00:00.000Z", "billingDay": 31})
+	custom := f.createPayment(t, map[string]any{
+		"period":             "custom",
+		"customIntervalDays": 28,
+		"nextPayment":        "2030-01-01 00:00:00.000Z",
+	})
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + monthEnd.Id + "/schedule",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "payment of another user",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + monthEnd.Id + "/schedule",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "count above 100",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + monthEnd.Id + "/schedule?count=101",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"count must be an integer between 1 and 100"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "month end dates clamp and return to the anchor",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/" + monthEnd.Id + "/schedule?count=3",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"period":"monthly"`,
+				`"charges":[{"date":"2030-01-31 00:00:00.000Z","amount":10},{"date":"2030-02-28 00:00:00.000Z","amount":10},{"date":"2030-03-31 00:00:00.000Z","amount":10}]`,
+			},
+			TestAppFactory: testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", monthEnd.Id)
+				require.NoError(t, err)
+				assert.Equal(t, "2030-01-31 00:00:00.000Z", record.GetDateTime("nextPayment").String())
+			},
+		},
+		{
+			Name:           "custom interval with default count",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/" + custom.Id + "/schedule",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`{"date":"2030-01-29 00:00:00.000Z"`,
+				`{"date":"2030-05-21 00:00:00.000Z"`,
+			},
+			NotExpectedContent: []string{`"2030-06-18`},
+			TestAppFactory:     testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,116 @@
+package payments
+
+import (
+	"cmp"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// weight of a match in each searched field, higher ranks first
+var searchFieldWeights = map[string]int{
+	"provider": 3,
+	"system":   2,
+	"notes":    1,
+}
+
+// searchResult is a payment matching a search with the fields that matched
+type searchResult struct {
+	Id           string         `json:"id"`
+	ProviderName string         `json:"providerName"`
+	SystemName   string         `json:"systemName"`
+	Notes        string         `json:"notes"`
+	Amount       float64        `json:"amount"`
+	Currency     string         `json:"currency"`
+	NextPayment  types.DateTime `json:"nextPayment"`
+	Matched      []string       `json:"matched"`
+	Score        int            `json:"score"`
+}
+
+// newSearchResult ranks a payment against the lowercased query.
+// A field that starts with the query scores double.
+func newSearchResult(record *core.Record, query string) searchResult {
+	result := searchResult{
+		Id:          record.Id,
+		Notes:       record.GetString("notes"),
+		Amount:      record.GetFloat("amount"),
+		Currency:    record.GetString("currency"),
+		NextPayment: record.GetDateTime("nextPayment"),
+		Matched:     []string{},
+	}
+	if provider := record.ExpandedOne("provider"); provider != nil {
+		result.ProviderName = provider.GetString("name")
+	}
+	if system := record.ExpandedOne("system"); system != nil {
+		result.SystemName = system.GetString("name")
+	}
+	fields := []struct{ name, value string }{
+		{"provider", result.ProviderName},
+		{"system", result.SystemName},
+		{"notes", result.Notes},
+	}
+	for _, field := range fields {
+		value := strings.ToLower(field.value)
+		if !strings.Contains(value, query) {
+			continue
+		}
+		result.Matched = append(result.Matched, field.name)
+		weight := searchFieldWeights[field.name]
+		if strings.HasPrefix(value, query) {
+			weight *= 2
+		}
+		result.Score += weight
+	}
+	return result
+}
+
+// SearchPayments handles GET /api/beszel/payments/search requests.
+// Matches q against the notes and the provider and system names of the user's
+// payments and returns up to limit results (default 20, max 100), best first.
+// Matching uses SQLite LIKE, which ignores case for ASCII letters only. Notes encrypted at rest
+// can't be matched, but are decrypted in the results.
+func (pm *PaymentManager) SearchPayments(e *core.RequestEvent) error {
+	query := strings.TrimSpace(e.Request.URL.Query().Get("q"))
+	if query == "" {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "q is required"})
+	}
+	limit, err := parseIntParam(e, "limit", 20, 100)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	records, err := e.App.FindRecordsByFilter("payments",
+		"user = {:user} && (notes ~ {:q} || provider.name ~ {:q} || system.name ~ {:q})",
+		"", 0, 0,
+		dbx.Params{"user": e.Auth.Id, "q": query},
+	)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	if errs := e.App.ExpandRecords(records, []string{"provider", "system"}, nil); len(errs) > 0 {
+		requestid.Logger(e).Warn("Failed to expand payment relations", "errs", errs)
+	}
+
+	pm.revealNotes(e.App, records...)
+	query = strings.ToLower(query)
+	results := make([]searchResult, 0, len(records))
+	for _, record := range records {
+		results = append(results, newSearchResult(record, query))
+	}
+	slices.SortStableFunc(results, func(a, b searchResult) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return a.NextPayment.Time().Compare(b.NextPayment.Time())
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return e.JSON(http.StatusOK, results)
+}
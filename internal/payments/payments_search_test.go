@@ -0,0 +1,114 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchPayments(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	backupProvider := createProvider(t, f.hub, f.user, "BackupCo")
+	byProvider := f.createPayment(t, map[string]any{"provider": backupProvider.Id})
+	byNotes := f.createPayment(t, map[string]any{"notes": "Nightly BACKUP storage"})
+	bySystem := f.createPayment(t, map[string]any{"system": createSystem(t, f.hub, f.user, "backup-box").Id})
+	unrelated := f.createPayment(t, map[string]any{"notes": "compute"})
+
+	otherUser, _ := createUserWithToken(t, f.hub, "other@example.com")
+	other, err := beszelTests.CreateRecord(f.hub, "payments", map[string]any{
+		"user":        otherUser.Id,
+		"system":      createSystem(t, f.hub, otherUser, "other").Id,
+		"provider":    createProvider(t, f.hub, otherUser, "Other").Id,
+		"period":      "monthly",
+		"nextPayment": "2030-01-01 00:00:00.000Z",
+		"amount":      1,
+		"currency":    "EUR",
+		"notes":       "backup",
+	})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "missing query",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/search",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"q is required"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "limit above 100",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/search?q=backup&limit=101",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"limit must be an integer between 1 and 100"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "ranked matches across fields",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/search?q=Backup",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{byProvider.Id, byNotes.Id, bySystem.Id},
+			NotExpectedContent: []string{unrelated.Id, other.Id},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				body, err := io.ReadAll(res.Body)
+				require.NoError(t, err)
+				var results []struct {
+					Id      string   `json:"id"`
+					Matched []string `json:"matched"`
+				}
+				require.NoError(t, json.Unmarshal(body, &results))
+				require.Len(t, results, 3)
+				assert.Equal(t, byProvider.Id, results[0].Id)
+				assert.Equal(t, []string{"provider"}, results[0].Matched)
+				assert.Equal(t, bySystem.Id, results[1].Id)
+				assert.Equal(t, []string{"system"}, results[1].Matched)
+				assert.Equal(t, byNotes.Id, results[2].Id)
+				assert.Equal(t, []string{"notes"}, results[2].Matched)
+			},
+		},
+		{
+			Name:               "limit",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/search?q=backup&limit=1",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{byProvider.Id},
+			NotExpectedContent: []string{byNotes.Id, bySystem.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "query is not interpreted as a filter",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/search?q=%27%20%7C%7C%201%3D1%20%7C%7C%20%27",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"[]"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,191 @@
+package payments
+
+import (
+	"net/http"
+	"time"
+	// embedded so timezones can be loaded on systems without a zoneinfo database
+	_ "time/tzdata"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// userPaymentSettings are the payment preferences stored in the user's user_settings record
+type userPaymentSettings struct {
+	// currency used for new payments and budgets without one, and for totals without a base
+	DefaultCurrency string `json:"defaultCurrency"`
+	// ISO 3166-1 alpha-2 country code used for new payments without one
+	DefaultCountry string `json:"defaultCountry"`
+	// reminder lead time used for new payments that don't set reminderDays
+	DefaultReminderDays int `json:"defaultReminderDays"`
+	// grace period used for new payments that don't set graceDays
+	DefaultGraceDays int `json:"defaultGraceDays"`
+	// IANA timezone name used for day boundaries of upcoming payments and reminders
+	Timezone string `json:"timezone"`
+	// how often a digest of the upcoming payments is sent, one of the Digest constants.
+	// Payments aren't reminded of one by one while a digest is active.
+	DigestFrequency string `json:"digestFrequency"`
+	// day of the week weekly digests are sent on, 0 is Sunday
+	DigestWeekday time.Weekday `json:"digestWeekday"`
+	// whether the daily job advances due payments. When off the user is asked to confirm each charge instead.
+	AutoAdvance bool `json:"autoAdvance"`
+	// locale tag such as ru-RU that amounts in messages and exports are formatted for.
+	// Empty formats each amount in its currency's own locale.
+	Locale string `json:"locale"`
+	// percent the normalized monthly spend has to grow by within a week to notify the user, 0 turns it off
+	SpendAlertPercent int `json:"spendAlertPercent"`
+}
+
+// location returns the user's timezone, or UTC if it isn't set or can't be loaded
+func (s userPaymentSettings) location() *time.Location {
+	if s.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// findUserSettingsRecord returns the user's user_settings record
+func findUserSettingsRecord(app core.App, userID string) (*core.Record, error) {
+	return app.FindFirstRecordByFilter("user_settings", "user = {:user}", dbx.Params{"user": userID})
+}
+
+// loadPaymentSettings returns the user's payment preferences, with defaults for the ones not set.
+// A user without a user_settings record gets the defaults.
+func loadPaymentSettings(app core.App, userID string) userPaymentSettings {
+	settings := userPaymentSettings{
+		DefaultReminderDays: defaultReminderDays,
+		DigestFrequency:     DigestNone,
+		DigestWeekday:       time.Monday,
+		AutoAdvance:         true,
+		SpendAlertPercent:   defaultSpendAlertPercent,
+	}
+	record, err := findUserSettingsRecord(app, userID)
+	if err != nil {
+		return settings
+	}
+	if err := record.UnmarshalJSONField("settings", &settings); err != nil {
+		app.Logger().Warn("Failed to unmarshal user settings", "user", userID, "err", err)
+	}
+	return settings
+}
+
+// GetSettings handles GET /api/beszel/settings requests.
+// Returns the user's payment preferences.
+func (pm *PaymentManager) GetSettings(e *core.RequestEvent) error {
+	return e.JSON(http.StatusOK, loadPaymentSettings(e.App, e.Auth.Id))
+}
+
+// UpdateSettings handles PATCH /api/beszel/settings requests.
+// Updates the preferences present in the body and keeps the other user settings as they are.
+// An empty defaultCurrency, defaultCountry, timezone or locale clears it.
+func (pm *PaymentManager) UpdateSettings(e *core.RequestEvent) error {
+	var body struct {
+		DefaultCurrency     *string `json:"defaultCurrency"`
+		DefaultCountry      *string `json:"defaultCountry"`
+		DefaultReminderDays *int    `json:"defaultReminderDays"`
+		DefaultGraceDays    *int    `json:"defaultGraceDays"`
+		Timezone            *string `json:"timezone"`
+		DigestFrequency     *string `json:"digestFrequency"`
+		DigestWeekday       *int    `json:"digestWeekday"`
+		AutoAdvance         *bool   `json:"autoAdvance"`
+		Locale              *string `json:"locale"`
+		SpendAlertPercent   *int    `json:"spendAlertPercent"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	errs := validation.Errors{}
+	if body.DefaultCurrency != nil && *body.DefaultCurrency != "" && !isCurrency(*body.DefaultCurrency) {
+		errs["defaultCurrency"] = validation.NewError("validation_invalid_currency", "Invalid currency.")
+	}
+	if body.DefaultCountry != nil && *body.DefaultCountry != "" && !isCountry(*body.DefaultCountry) {
+		errs["defaultCountry"] = validation.NewError("validation_unknown_country", "Unknown ISO 3166-1 alpha-2 country code.")
+	}
+	if body.DefaultReminderDays != nil && (*body.DefaultReminderDays < 0 || *body.DefaultReminderDays > 365) {
+		errs["defaultReminderDays"] = validation.NewError("validation_invalid_reminder_days", "Must be between 0 and 365.")
+	}
+	if body.DefaultGraceDays != nil && (*body.DefaultGraceDays < 0 || *body.DefaultGraceDays > 90) {
+		errs["defaultGraceDays"] = validation.NewError("validation_invalid_grace_days", "Must be between 0 and 90.")
+	}
+	if body.SpendAlertPercent != nil && (*body.SpendAlertPercent < 0 || *body.SpendAlertPercent > 1000) {
+		errs["spendAlertPercent"] = validation.NewError("validation_invalid_spend_alert_percent", "Must be between 0 and 1000.")
+	}
+	if body.Timezone != nil && *body.Timezone != "" {
+		if _, err := time.LoadLocation(*body.Timezone); err != nil {
+			errs["timezone"] = validation.NewError("validation_invalid_timezone", "Invalid IANA timezone.")
+		}
+	}
+	if body.DigestFrequency != nil && !isDigestFrequency(*body.DigestFrequency) {
+		errs["digestFrequency"] = validation.NewError("validation_invalid_digest_frequency", "Must be none, daily or weekly.")
+	}
+	if body.DigestWeekday != nil && (*body.DigestWeekday < 0 || *body.DigestWeekday > 6) {
+		errs["digestWeekday"] = validation.NewError("validation_invalid_digest_weekday", "Must be between 0 (Sunday) and 6 (Saturday).")
+	}
+	if body.Locale != nil && *body.Locale != "" && !isLocale(*body.Locale) {
+		errs["locale"] = validation.NewError("validation_invalid_locale", "Must be an English, Russian, German or French locale such as en-US or ru-RU.")
+	}
+	if len(errs) > 0 {
+		return e.BadRequestError("Failed to update settings.", errs)
+	}
+
+	record, err := findUserSettingsRecord(e.App, e.Auth.Id)
+	if err != nil {
+		collection, err := e.App.FindCachedCollectionByNameOrId("user_settings")
+		if err != nil {
+			return e.InternalServerError("", err)
+		}
+		// new user settings are filled with defaults on create, so they are saved before adding the preferences
+		record = core.NewRecord(collection)
+		record.Set("user", e.Auth.Id)
+		if err := e.App.Save(record); err != nil {
+			return e.BadRequestError("Failed to update settings.", err)
+		}
+	}
+	// the settings are shared with the rest of the app, so only the payment keys are replaced
+	settings := map[string]any{}
+	if err := record.UnmarshalJSONField("settings", &settings); err != nil {
+		requestid.Logger(e).Warn("Failed to unmarshal user settings", "user", e.Auth.Id, "err", err)
+	}
+	if body.DefaultCurrency != nil {
+		settings["defaultCurrency"] = *body.DefaultCurrency
+	}
+	if body.DefaultCountry != nil {
+		settings["defaultCountry"] = *body.DefaultCountry
+	}
+	if body.DefaultReminderDays != nil {
+		settings["defaultReminderDays"] = *body.DefaultReminderDays
+	}
+	if body.DefaultGraceDays != nil {
+		settings["defaultGraceDays"] = *body.DefaultGraceDays
+	}
+	if body.Timezone != nil {
+		settings["timezone"] = *body.Timezone
+	}
+	if body.DigestFrequency != nil {
+		settings["digestFrequency"] = *body.DigestFrequency
+	}
+	if body.DigestWeekday != nil {
+		settings["digestWeekday"] = *body.DigestWeekday
+	}
+	if body.AutoAdvance != nil {
+		settings["autoAdvance"] = *body.AutoAdvance
+	}
+	if body.Locale != nil {
+		settings["locale"] = *body.Locale
+	}
+	if body.SpendAlertPercent != nil {
+		settings["spendAlertPercent"] = *body.SpendAlertPercent
+	}
+	record.Set("settings", settings)
+	if err := e.App.Save(record); err != nil {
+		return e.BadRequestError("Failed to update settings.", err)
+	}
+	return e.JSON(http.StatusOK, loadPaymentSettings(e.App, e.Auth.Id))
+}
@@ -0,0 +1,151 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentSettings(t *testing.T) {
+	f := newPaymentFixture(t)
+	f.createPayment(t, map[string]any{"amount": 10, "currency": "USD"})
+	setRate(t, f.hub, "USD", "EUR", 0.5)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/settings",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "defaults",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/settings",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"defaultCurrency":"","defaultCountry":"","defaultReminderDays":3,"defaultGraceDays":0,"timezone":"","digestFrequency":"none","digestWeekday":1,"autoAdvance":true,"locale":"","spendAlertPercent":20}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid values",
+			Method:          http.MethodPatch,
+			URL:             "/api/beszel/settings",
+			Body:            jsonReader(map[string]any{"defaultCurrency": "XXX", "defaultCountry": "XX", "defaultReminderDays": 400, "defaultGraceDays": 91, "timezone": "Mars/Olympus_Mons", "digestFrequency": "monthly", "digestWeekday": 7, "locale": "xx-XX", "spendAlertPercent": -1}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_invalid_currency", "validation_unknown_country", "validation_invalid_reminder_days", "validation_invalid_grace_days", "validation_invalid_timezone", "validation_invalid_digest_frequency", "validation_invalid_digest_weekday", "validation_invalid_locale", "validation_invalid_spend_alert_percent"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "update",
+			Method:          http.MethodPatch,
+			URL:             "/api/beszel/settings",
+			Body:            jsonReader(map[string]any{"defaultCurrency": "EUR", "defaultCountry": "DE", "defaultReminderDays": 7, "defaultGraceDays": 2, "timezone": "Europe/Moscow", "digestFrequency": "weekly", "digestWeekday": 5, "autoAdvance": false, "locale": "ru-RU", "spendAlertPercent": 50}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"defaultCurrency":"EUR","defaultCountry":"DE","defaultReminderDays":7,"defaultGraceDays":2,"timezone":"Europe/Moscow","digestFrequency":"weekly","digestWeekday":5,"autoAdvance":false,"locale":"ru-RU","spendAlertPercent":50}`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindFirstRecordByFilter("user_settings", "user = {:user}", dbx.Params{"user": f.user.Id})
+				require.NoError(t, err)
+				var settings map[string]any
+				require.NoError(t, record.UnmarshalJSONField("settings", &settings))
+				// the defaults of a new user_settings record are kept
+				assert.Equal(t, "1h", settings["chartTime"])
+			},
+		},
+		{
+			Name:            "partial update keeps other preferences",
+			Method:          http.MethodPatch,
+			URL:             "/api/beszel/settings",
+			Body:            jsonReader(map[string]any{"defaultReminderDays": 0}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"defaultCurrency":"EUR","defaultCountry":"DE","defaultReminderDays":0,"defaultGraceDays":2,"timezone":"Europe/Moscow","digestFrequency":"weekly","digestWeekday":5,"autoAdvance":false,"locale":"ru-RU","spendAlertPercent":50}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "summary uses the default currency without base",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"base":"EUR"`, `"total":5`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "summary with an empty base",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?base=",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"USD":10}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:   "new payments use the defaults",
+			Method: http.MethodPost,
+			URL:    "/api/collections/payments/records",
+			Body: jsonReader(map[string]any{
+				"user":        f.user.Id,
+				"system":      f.system.Id,
+				"provider":    f.provider.Id,
+				"period":      "monthly",
+				"nextPayment": "2030-01-15 00:00:00.000Z",
+				"amount":      10,
+			}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"currency":"EUR"`, `"country":"DE"`, `"reminderDays":0`, `"graceDays":2`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:   "a payment country overrides the default",
+			Method: http.MethodPost,
+			URL:    "/api/collections/payments/records",
+			Body: jsonReader(map[string]any{
+				"user":        f.user.Id,
+				"system":      f.system.Id,
+				"provider":    f.provider.Id,
+				"period":      "monthly",
+				"nextPayment": "2030-01-15 00:00:00.000Z",
+				"amount":      10,
+				"country":     "FR",
+			}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"country":"FR"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "new budgets use the default currency",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/budgets/records",
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "name": "hosting", "limit": 100, "period": "monthly"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"currency":"EUR"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
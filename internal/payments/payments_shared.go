@@ -0,0 +1,52 @@
+package payments
+
+import (
+	"slices"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/dbutils"
+)
+
+// findOwnAndSharedPayments returns the payments owned by the user and the ones shared with them
+func findOwnAndSharedPayments(app core.App, userID string) ([]*core.Record, error) {
+	return app.FindAllRecords("payments", dbx.Or(
+		dbx.HashExp{"user": userID},
+		dbx.NewExp("EXISTS (SELECT 1 FROM "+dbutils.JSONEach("sharedWith")+" WHERE value = {:user})", dbx.Params{"user": userID}),
+	))
+}
+
+// findViewablePayment returns the payment with the id if the user owns it or it is shared with them,
+// like the view rule of the payments collection
+func findViewablePayment(app core.App, userID, id string) (*core.Record, error) {
+	return app.FindFirstRecordByFilter("payments", "id = {:id} && (user = {:user} || sharedWith.id ?= {:user})",
+		dbx.Params{"id": id, "user": userID})
+}
+
+// fullShare counts every payment in full
+func fullShare(record *core.Record) float64 {
+	return 1
+}
+
+// userShare returns the part of a payment paid by the user, between 0 and 1.
+// The owner pays splitPercent of a shared payment and the rest is split evenly
+// between the users it is shared with. Without splitPercent everyone pays the same.
+func userShare(userID string) func(record *core.Record) float64 {
+	return func(record *core.Record) float64 {
+		sharedWith := record.GetStringSlice("sharedWith")
+		if len(sharedWith) == 0 {
+			return 1
+		}
+		split := record.GetFloat("splitPercent")
+		if split == 0 {
+			return 1 / float64(len(sharedWith)+1)
+		}
+		if record.GetString("user") == userID {
+			return split / 100
+		}
+		if slices.Contains(sharedWith, userID) {
+			return (100 - split) / 100 / float64(len(sharedWith))
+		}
+		return 0
+	}
+}
@@ -0,0 +1,126 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedPayments(t *testing.T) {
+	f := newPaymentFixture(t)
+	partner, partnerToken := createUserWithToken(t, f.hub, "partner@example.com")
+	friend, _ := createUserWithToken(t, f.hub, "friend@example.com")
+	_, strangerToken := createUserWithToken(t, f.hub, "stranger@example.com")
+
+	shared := f.createPayment(t, map[string]any{"amount": 30, "currency": "EUR", "sharedWith": []string{partner.Id}, "splitPercent": 60})
+	// split evenly between the three users
+	f.createPayment(t, map[string]any{"amount": 9, "currency": "EUR", "sharedWith": []string{partner.Id, friend.Id}})
+	f.createPayment(t, map[string]any{"amount": 10, "currency": "USD"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "owner can view",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/payments/records/" + shared.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"splitPercent":60`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "shared user can view",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/payments/records/" + shared.Id,
+			Headers:         map[string]string{"Authorization": partnerToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"sharedWith":["` + partner.Id + `"]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other users can't view",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/payments/records/" + shared.Id,
+			Headers:         map[string]string{"Authorization": strangerToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "shared user can't edit",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + shared.Id,
+			Body:            jsonReader(map[string]any{"amount": 1}),
+			Headers:         map[string]string{"Authorization": partnerToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", shared.Id)
+				require.NoError(t, err)
+				assert.Equal(t, 30.0, record.GetFloat("amount"))
+			},
+		},
+		{
+			Name:            "shared user can't delete",
+			Method:          http.MethodDelete,
+			URL:             "/api/collections/payments/records/" + shared.Id,
+			Headers:         map[string]string{"Authorization": partnerToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "can't be shared with its owner",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + shared.Id,
+			Body:            jsonReader(map[string]any{"sharedWith": []string{partner.Id, f.user.Id}}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{`"sharedWith":{"code":"validation_shared_with_owner"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "owner summary counts the owner's share",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"EUR":21,"USD":10}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "shared user summary counts the shared user's share",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?groupBy=system",
+			Headers:         map[string]string{"Authorization": partnerToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totals":{"EUR":15}`, `{"EUR":12}`, `{"EUR":3}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other users' summary is empty",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			Headers:         map[string]string{"Authorization": strangerToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{}`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,55 @@
+package payments
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// maximum number of days a reminder can be snoozed for
+const maxSnoozeDays = 365
+
+// isSnoozed reports whether the payment's reminders are snoozed at now
+func isSnoozed(record *core.Record, now time.Time) bool {
+	return record.GetDateTime("snoozeUntil").Time().After(now)
+}
+
+// clearExpiredSnoozes empties snoozeUntil on every payment whose snooze has ended by now
+func clearExpiredSnoozes(app core.App, now time.Time) error {
+	nowStr, err := types.ParseDateTime(now)
+	if err != nil {
+		return err
+	}
+	_, err = app.DB().Update("payments",
+		dbx.Params{"snoozeUntil": ""},
+		dbx.NewExp("snoozeUntil != '' AND snoozeUntil <= {:now}", dbx.Params{"now": nowStr.String()}),
+	).Execute()
+	return err
+}
+
+// SnoozePayment handles POST /api/beszel/payments/{id}/snooze requests.
+// Holds back reminders for the number of days in the body (1 to 365) without
+// changing nextPayment or the advance schedule.
+func (pm *PaymentManager) SnoozePayment(e *core.RequestEvent) error {
+	var body struct {
+		Days int `json:"days"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if body.Days < 1 || body.Days > maxSnoozeDays {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "days must be an integer between 1 and 365"})
+	}
+	record, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	record.Set("snoozeUntil", time.Now().UTC().AddDate(0, 0, body.Days))
+	if err := e.App.SaveWithContext(withActor(e), record); err != nil {
+		return e.BadRequestError("Failed to update payment", err)
+	}
+	return e.JSON(http.StatusOK, record)
+}
@@ -0,0 +1,94 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnoozeApi(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	payment := f.createPayment(t, nil)
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "invalid days",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/snooze",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"days": 0}),
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"days must be an integer between 1 and 365"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/snooze",
+			Headers:         map[string]string{"Authorization": otherToken},
+			Body:            jsonReader(map[string]any{"days": 3}),
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "snooze",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + "/snooze",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"days": 3}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"nextPayment":"2030-01-15 00:00:00.000Z"`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", payment.Id)
+				require.NoError(t, err)
+				assert.WithinDuration(t, time.Now().UTC().AddDate(0, 0, 3), record.GetDateTime("snoozeUntil").Time(), time.Minute)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestNotifySkipsSnoozedPayments(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Date(2030, 1, 10, 0, 15, 0, 0, time.UTC)
+	snoozed := f.createPayment(t, map[string]any{
+		"nextPayment":  now.AddDate(0, 0, 3),
+		"reminderDays": 3,
+		"snoozeUntil":  now.AddDate(0, 0, 1),
+	})
+
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	// once the snooze has passed it is cleared and the reminder goes out
+	sent, err = f.hub.GetPaymentManager().NotifyDuePaymentsAt(now.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	record, err := f.hub.FindRecordById("payments", snoozed.Id)
+	require.NoError(t, err)
+	assert.True(t, record.GetDateTime("snoozeUntil").IsZero())
+	assert.Equal(t, now.AddDate(0, 0, 3), record.GetDateTime("nextPayment").Time())
+}
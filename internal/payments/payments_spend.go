@@ -0,0 +1,249 @@
+package payments
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// NotificationSpendIncrease is the type of the notification sent when the monthly spend jumps
+const NotificationSpendIncrease = "spend_increase"
+
+// percent increase of the monthly spend that notifies users who haven't set spendAlertPercent
+const defaultSpendAlertPercent = 20
+
+// days between the snapshots compared to detect a jump in spend
+const spendComparisonDays = 7
+
+// how long spend snapshots are kept, long enough to chart spend over a couple of years
+const spendSnapshotRetention = 2 * 365 * 24 * time.Hour
+
+// SnapshotSpend records the normalized monthly spend of every user with payments and notifies the
+// users whose spend grew beyond their spendAlertPercent over the past week. Runs once a day as a cron job.
+func (pm *PaymentManager) SnapshotSpend() {
+	logger := requestid.RunLogger(pm.app, jobSnapshotSpend)
+	count, err := pm.snapshotSpend(logger, time.Now().UTC())
+	pm.health.jobRan(jobSnapshotSpend, time.Now().UTC(), err)
+	if err != nil {
+		logger.Error("Failed to snapshot spend", "err", err)
+		return
+	}
+	if count > 0 {
+		logger.Info("Took spend snapshots", "count", count)
+	}
+}
+
+// snapshotSpend takes the day's spend snapshot of each user with payments who doesn't have one
+// yet and returns the number taken. Snapshots older than spendSnapshotRetention are deleted.
+func (pm *PaymentManager) snapshotSpend(logger *slog.Logger, now time.Time) (int, error) {
+	var users []string
+	err := pm.app.DB().Select("user").Distinct(true).From("payments").Column(&users)
+	if err != nil {
+		return 0, err
+	}
+	rates, err := pm.rates.get(pm.app)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, userID := range users {
+		taken, err := pm.snapshotUserSpend(logger, newConverter(rates), userID, now)
+		if err != nil {
+			logger.Error("Failed to snapshot spend", "user", userID, "err", err)
+			continue
+		}
+		if taken {
+			count++
+		}
+	}
+
+	cutoff, _ := types.ParseDateTime(now.Add(-spendSnapshotRetention))
+	_, err = pm.app.DB().Delete("spend_snapshots", dbx.NewExp("takenAt < {:cutoff}", dbx.Params{"cutoff": cutoff.String()})).Execute()
+	return count, err
+}
+
+// snapshotUserSpend saves the user's monthly spend in their default currency, or the only currency
+// of their payments if they have no default. No snapshot is taken if one was already taken that day,
+// the currency can't be chosen or some totals can't be converted, since an incomplete total would
+// look like a drop in spend. Returns whether a snapshot was taken.
+func (pm *PaymentManager) snapshotUserSpend(logger *slog.Logger, conv *converter, userID string, now time.Time) (bool, error) {
+	dayStart, _ := types.ParseDateTime(startOfDay(now))
+	taken, err := pm.app.CountRecords("spend_snapshots",
+		dbx.HashExp{"user": userID}, dbx.NewExp("takenAt >= {:start}", dbx.Params{"start": dayStart.String()}))
+	if err != nil || taken > 0 {
+		return false, err
+	}
+
+	records, err := findUserPayments(pm.app, userID)
+	if err != nil {
+		return false, err
+	}
+	settings := loadPaymentSettings(pm.app, userID)
+	totals := monthlyTotals(withoutArchived(records), now, loadNormalization(pm.app, now))
+	base := settings.DefaultCurrency
+	if base == "" && len(totals) == 1 {
+		for currency := range totals {
+			base = currency
+		}
+	}
+	if base == "" {
+		return false, nil
+	}
+	total := roundAmount(conv.convertTotals(totals, base), base)
+	if err := conv.err(); err != nil {
+		logger.Warn("Spend snapshot skipped", "user", userID, "err", err)
+		return false, nil
+	}
+
+	collection, err := pm.app.FindCachedCollectionByNameOrId("spend_snapshots")
+	if err != nil {
+		return false, err
+	}
+	snapshot := core.NewRecord(collection)
+	snapshot.Set("user", userID)
+	snapshot.Set("total", total)
+	snapshot.Set("currency", base)
+	snapshot.Set("takenAt", now)
+	if err := pm.app.Save(snapshot); err != nil {
+		return false, err
+	}
+
+	if err := notifySpendIncrease(pm.app, settings, snapshot, now); err != nil {
+		logger.Error("Failed to notify spend increase", "user", userID, "err", err)
+	}
+	return true, nil
+}
+
+// notifySpendIncrease compares a new snapshot with the latest one taken at least spendComparisonDays
+// before it, in the same currency, and notifies the user if the spend grew by spendAlertPercent or
+// more. Users are notified at most once per comparison window, so a single jump isn't reported every day,
+// and the snapshot that notified them is marked as alerted.
+func notifySpendIncrease(app core.App, settings userPaymentSettings, snapshot *core.Record, now time.Time) error {
+	if settings.SpendAlertPercent <= 0 {
+		return nil
+	}
+	userID := snapshot.GetString("user")
+	windowStart, _ := types.ParseDateTime(now.AddDate(0, 0, -spendComparisonDays))
+	previous, err := app.FindRecordsByFilter("spend_snapshots",
+		"user = {:user} && currency = {:currency} && takenAt <= {:start}", "-takenAt", 1, 0,
+		dbx.Params{"user": userID, "currency": snapshot.GetString("currency"), "start": windowStart.String()})
+	if err != nil || len(previous) == 0 {
+		return err
+	}
+	before, after := previous[0].GetFloat("total"), snapshot.GetFloat("total")
+	if before <= 0 || (after-before)/before*100 < float64(settings.SpendAlertPercent) {
+		return nil
+	}
+	alerted, err := app.CountRecords("spend_snapshots", dbx.HashExp{"user": userID, "alerted": true},
+		dbx.NewExp("takenAt > {:start}", dbx.Params{"start": windowStart.String()}))
+	if err != nil || alerted > 0 {
+		return err
+	}
+
+	collection, err := app.FindCachedCollectionByNameOrId("notifications")
+	if err != nil {
+		return err
+	}
+	currency := snapshot.GetString("currency")
+	notification := core.NewRecord(collection)
+	notification.Set("user", userID)
+	notification.Set("type", NotificationSpendIncrease)
+	notification.Set("title", fmt.Sprintf("Monthly spend up %.0f%%", (after-before)/before*100))
+	notification.Set("body", fmt.Sprintf("Your monthly spend grew from %s to %s over the last %d days.",
+		formatAmount(before, currency, settings.Locale), formatAmount(after, currency, settings.Locale), spendComparisonDays))
+	if err := app.Save(notification); err != nil {
+		return err
+	}
+	snapshot.Set("alerted", true)
+	return app.Save(snapshot)
+}
+
+// spendTrendDay is the monthly spend snapshot of one day, with a null total on days without a snapshot
+type spendTrendDay struct {
+	Date  string   `json:"date"`
+	Total *float64 `json:"total"`
+}
+
+// spendTrend is the user's monthly spend over the past days, converted into currency
+type spendTrend struct {
+	Currency string          `json:"currency"`
+	From     string          `json:"from"`
+	To       string          `json:"to"`
+	Days     []spendTrendDay `json:"days"`
+}
+
+// buildSpendTrend lists one day for each of the days up to and including the day of now, with the
+// total of the day's snapshot converted into base. Days are UTC days, like the snapshots.
+// Snapshots whose currency couldn't be converted are recorded by conv.
+func buildSpendTrend(snapshots []*core.Record, now time.Time, days int, base string, conv *converter) spendTrend {
+	byDay := make(map[string]*core.Record, len(snapshots))
+	for _, snapshot := range snapshots {
+		// snapshots are sorted by takenAt, so the latest of a day wins
+		byDay[snapshot.GetDateTime("takenAt").Time().Format(time.DateOnly)] = snapshot
+	}
+	start := startOfDay(now).AddDate(0, 0, 1-days)
+	trend := spendTrend{
+		Currency: base,
+		From:     start.Format(time.DateOnly),
+		To:       startOfDay(now).Format(time.DateOnly),
+		Days:     make([]spendTrendDay, days),
+	}
+	for i := range trend.Days {
+		day := start.AddDate(0, 0, i).Format(time.DateOnly)
+		trend.Days[i].Date = day
+		snapshot, ok := byDay[day]
+		if !ok {
+			continue
+		}
+		total, ok := conv.convert(snapshot.GetFloat("total"), snapshot.GetString("currency"), base)
+		if !ok {
+			continue
+		}
+		total = roundAmount(total, base)
+		trend.Days[i].Total = &total
+	}
+	return trend
+}
+
+// GetSpendTrend handles GET /api/beszel/reports/spend-trend requests.
+// Returns the user's daily monthly spend snapshots over the past days (default 90, max 730), converted
+// into base or the user's default currency, for charting spend over time. Days without a snapshot are
+// listed with a null total rather than filled in.
+func (pm *PaymentManager) GetSpendTrend(e *core.RequestEvent) error {
+	days, err := parseIntParam(e, "days", 90, 730)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	base := requestBase(e)
+	if base == "" {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "base currency is required"})
+	}
+	if !isCurrency(base) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid base currency"})
+	}
+
+	now := time.Now().UTC()
+	start, _ := types.ParseDateTime(startOfDay(now).AddDate(0, 0, 1-days))
+	snapshots, err := e.App.FindRecordsByFilter("spend_snapshots", "user = {:user} && takenAt >= {:start}", "takenAt", 0, 0,
+		dbx.Params{"user": e.Auth.Id, "start": start.String()})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	trend := buildSpendTrend(snapshots, now, days, base, conv)
+	if err := conv.err(); err != nil {
+		return convertError(e, err)
+	}
+	return e.JSON(http.StatusOK, trend)
+}
@@ -0,0 +1,179 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpendSnapshots(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+	f.createPayment(t, map[string]any{"amount": 100})
+	// a user who turned spend alerts off
+	quiet, quietToken := createUserWithToken(t, f.hub, "quiet@example.com")
+	q := &paymentFixture{hub: f.hub, user: quiet, token: quietToken, provider: createProvider(t, f.hub, quiet, "Quiet")}
+	q.system = createSystem(t, f.hub, quiet, "quiet-server")
+	setPaymentSettings(t, q, map[string]any{"spendAlertPercent": 0})
+	q.createPayment(t, map[string]any{"system": q.system.Id, "amount": 100})
+
+	day := time.Date(2030, 1, 1, 0, 30, 0, 0, time.UTC)
+	count, err := pm.SnapshotSpendAt(day)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// a snapshot is only taken once a day
+	count, err = pm.SnapshotSpendAt(day.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	snapshot, err := f.hub.FindFirstRecordByFilter("spend_snapshots", "user = {:user}", dbx.Params{"user": f.user.Id})
+	require.NoError(t, err)
+	assert.Equal(t, 100.0, snapshot.GetFloat("total"))
+	assert.Equal(t, "USD", snapshot.GetString("currency"))
+
+	// a smaller increase within the week isn't reported
+	f.createPayment(t, map[string]any{"amount": 10})
+	_, err = pm.SnapshotSpendAt(day.AddDate(0, 0, 1))
+	require.NoError(t, err)
+
+	// a new expensive subscription is compared with the spend a week earlier
+	f.createPayment(t, map[string]any{"amount": 40})
+	q.createPayment(t, map[string]any{"system": q.system.Id, "amount": 100})
+	for i := 2; i <= 9; i++ {
+		_, err = pm.SnapshotSpendAt(day.AddDate(0, 0, i))
+		require.NoError(t, err)
+	}
+
+	notifications, err := f.hub.FindAllRecords("notifications", dbx.HashExp{"type": "spend_increase"})
+	require.NoError(t, err)
+	require.Len(t, notifications, 1, "a jump should be reported once and not for users who turned alerts off")
+	assert.Equal(t, f.user.Id, notifications[0].GetString("user"))
+	assert.Equal(t, "Monthly spend up 50%", notifications[0].GetString("title"))
+	assert.Equal(t, "Your monthly spend grew from $100.00 to $150.00 over the last 7 days.", notifications[0].GetString("body"))
+
+	alerted, err := f.hub.FindAllRecords("spend_snapshots", dbx.HashExp{"alerted": true})
+	require.NoError(t, err)
+	require.Len(t, alerted, 1)
+	assert.Equal(t, day.AddDate(0, 0, 7), alerted[0].GetDateTime("takenAt").Time())
+
+	// snapshots older than two years are pruned
+	_, err = pm.SnapshotSpendAt(day.AddDate(3, 0, 0))
+	require.NoError(t, err)
+	snapshots, err := f.hub.FindAllRecords("spend_snapshots")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 2)
+	for _, snapshot := range snapshots {
+		assert.Equal(t, day.AddDate(3, 0, 0), snapshot.GetDateTime("takenAt").Time())
+	}
+}
+
+func TestSpendTrend(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+	setRate(t, f.hub, "USD", "EUR", 0.5)
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	// snapshots two days ago and today, none yesterday
+	now := time.Now().UTC()
+	today := now.Format(time.DateOnly)
+	yesterday := now.AddDate(0, 0, -1).Format(time.DateOnly)
+	twoDaysAgo := now.AddDate(0, 0, -2).Format(time.DateOnly)
+	f.createPayment(t, map[string]any{"amount": 100})
+	_, err := pm.SnapshotSpendAt(now.AddDate(0, 0, -2))
+	require.NoError(t, err)
+	f.createPayment(t, map[string]any{"amount": 20})
+	_, err = pm.SnapshotSpendAt(now)
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/spend-trend",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "base is required",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/spend-trend",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"base currency is required"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid days",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/spend-trend?base=USD&days=731",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"days must be an integer between 1 and 730"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "days without a snapshot are gaps",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/reports/spend-trend?base=USD&days=4",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"currency":"USD"`,
+				`"to":"` + today + `"`,
+				`{"date":"` + now.AddDate(0, 0, -3).Format(time.DateOnly) + `","total":null}`,
+				`{"date":"` + twoDaysAgo + `","total":100}`,
+				`{"date":"` + yesterday + `","total":null}`,
+				`{"date":"` + today + `","total":120}`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:            "converted into base",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/spend-trend?base=EUR&days=3",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"currency":"EUR"`, `"from":"` + twoDaysAgo + `"`, `{"date":"` + twoDaysAgo + `","total":50}`, `{"date":"` + today + `","total":60}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "missing rate",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/reports/spend-trend?base=GBP",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{"missing exchange rates", `"base":"USD","quote":"GBP"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "other user",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/reports/spend-trend?base=USD&days=1",
+			Headers:            map[string]string{"Authorization": otherToken},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"days":[{"date":"` + today + `","total":null}]`},
+			NotExpectedContent: []string{`"total":120`},
+			TestAppFactory:     testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
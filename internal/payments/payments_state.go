@@ -0,0 +1,65 @@
+package payments
+
+import (
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// inTrial reports whether the payment's free trial has not ended yet
+func inTrial(record *core.Record, now time.Time) bool {
+	trialEnds := record.GetDateTime("trialEndsAt")
+	return !trialEnds.IsZero() && trialEnds.Time().After(now)
+}
+
+// isOverdue reports whether the payment's charge is overdue at now: its nextPayment plus its
+// graceDays is before the day of now, in the timezone of now
+func isOverdue(record *core.Record, now time.Time) bool {
+	next := record.GetDateTime("nextPayment").Time()
+	return !next.IsZero() && next.AddDate(0, 0, record.GetInt("graceDays")).Before(startOfDay(now))
+}
+
+// isFree reports whether the payment is a free tier, which costs nothing but is still renewed
+func isFree(record *core.Record) bool {
+	return record.GetBool("isFree")
+}
+
+// isArchived reports whether the payment has been archived or cancelled
+func isArchived(record *core.Record) bool {
+	return !record.GetDateTime("archivedAt").IsZero() || paymentStatus(record) == StatusCancelled
+}
+
+// withoutArchived returns the records that are not archived
+func withoutArchived(records []*core.Record) []*core.Record {
+	active := make([]*core.Record, 0, len(records))
+	for _, record := range records {
+		if !isArchived(record) {
+			active = append(active, record)
+		}
+	}
+	return active
+}
+
+// discountActive reports whether the payment's discount applies at t.
+// A discount without an end date applies indefinitely.
+func discountActive(record *core.Record, t time.Time) bool {
+	if record.GetFloat("discountAmount") <= 0 {
+		return false
+	}
+	ends := record.GetDateTime("discountEndsAt")
+	return ends.IsZero() || ends.Time().After(t)
+}
+
+// effectiveAmount returns the amount charged for the payment at t, with any active discount subtracted
+func effectiveAmount(record *core.Record, t time.Time) float64 {
+	amount := record.GetFloat("amount")
+	if discountActive(record, t) {
+		amount = max(amount-record.GetFloat("discountAmount"), 0)
+	}
+	return amount
+}
+
+// withTax returns the gross of a net amount using the payment's taxRate percentage
+func withTax(record *core.Record, net float64) float64 {
+	return net * (1 + record.GetFloat("taxRate")/100)
+}
@@ -0,0 +1,186 @@
+package payments
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Payment statuses
+const (
+	StatusActive    = "active"
+	StatusPaused    = "paused"
+	StatusCancelled = "cancelled"
+)
+
+// statusTransitions lists the statuses each status can change to.
+// A cancelled payment can only become active again through ReactivatePayment.
+var statusTransitions = map[string][]string{
+	StatusActive:    {StatusPaused, StatusCancelled},
+	StatusPaused:    {StatusActive, StatusCancelled},
+	StatusCancelled: {},
+}
+
+// paymentStatus returns the payment's status, treating an empty one as active
+func paymentStatus(record *core.Record) string {
+	if status := record.GetString("status"); status != "" {
+		return status
+	}
+	return StatusActive
+}
+
+// isPaused reports whether the payment is paused
+func isPaused(record *core.Record) bool {
+	return paymentStatus(record) == StatusPaused
+}
+
+// checkStatusTransition returns a field error if a payment can't change from one status to another.
+// Keeping the same status is always allowed.
+func checkStatusTransition(from, to string, reactivate bool) error {
+	if from == to {
+		return nil
+	}
+	if _, ok := statusTransitions[to]; !ok {
+		return validation.Errors{"status": validation.NewError("validation_invalid_status", "Invalid status.")}
+	}
+	if from == StatusCancelled && to == StatusActive && reactivate {
+		return nil
+	}
+	if from == StatusCancelled && to == StatusActive {
+		return validation.Errors{"status": validation.NewError("validation_reactivation_required", "Cancelled payments must be reactivated.")}
+	}
+	if !slices.Contains(statusTransitions[from], to) {
+		return validation.Errors{"status": validation.NewError("validation_invalid_status_transition",
+			fmt.Sprintf("Status can't change from %s to %s.", from, to))}
+	}
+	return nil
+}
+
+// applyStatusChange prepares a payment whose status changed from the given status.
+// A payment resuming from paused or cancelled skips the due dates that passed in the
+// meantime, so they aren't charged when the schedule is advanced. One-time payments keep
+// their date, so their only charge is still recorded. A payment leaving paused loses its pauseUntil.
+func applyStatusChange(record *core.Record, from string, now time.Time) error {
+	if from == StatusPaused && !isPaused(record) {
+		record.Set("pauseUntil", "")
+	}
+	if paymentStatus(record) != StatusActive || from == StatusActive || record.GetString("period") == PeriodOnce {
+		return nil
+	}
+	next := record.GetDateTime("nextPayment").Time()
+	if next.IsZero() || next.After(now) {
+		return nil
+	}
+	anchorDay := record.GetInt("billingDay")
+	if anchorDay == 0 {
+		anchorDay = next.Day()
+	}
+	for periods := 0; !next.After(now); periods++ {
+		if periods == maxAdvancePeriods {
+			return errTooManyPeriods
+		}
+		var err error
+		if next, err = addPaymentPeriod(record, next, anchorDay); err != nil {
+			return err
+		}
+	}
+	record.Set("nextPayment", next)
+	record.Set("billingDay", anchorDay)
+	record.Set("lastReminderSentAt", "")
+	return nil
+}
+
+// resumePausedPayments makes the paused payments whose pauseUntil has passed active again,
+// skipping the due dates that passed during the pause, and returns the number resumed
+func resumePausedPayments(app core.App, logger *slog.Logger, now time.Time) (int, error) {
+	nowStr, err := types.ParseDateTime(now)
+	if err != nil {
+		return 0, err
+	}
+	records, err := app.FindAllRecords("payments",
+		dbx.NewExp("status = 'paused' AND pauseUntil != '' AND pauseUntil <= {:now} AND archivedAt = ''", dbx.Params{"now": nowStr.String()}))
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, record := range records {
+		record.Set("status", StatusActive)
+		if err := applyStatusChange(record, StatusPaused, now); err != nil {
+			logger.Error("Failed to resume payment", "id", record.Id, "err", err)
+			continue
+		}
+		if err := app.SaveNoValidate(record); err != nil {
+			logger.Error("Failed to save resumed payment", "id", record.Id, "err", err)
+			continue
+		}
+		count++
+	}
+	if count > 0 {
+		logger.Info("Resumed paused payments", "count", count)
+	}
+	return count, nil
+}
+
+// SetPaymentStatus handles POST /api/beszel/payments/{id}/status requests.
+// Changes the payment to the status in the body if the transition is allowed.
+// A payment paused with a future pauseUntil is resumed by the daily advance job once that date
+// passes, and one paused without it stays paused until changed again.
+func (pm *PaymentManager) SetPaymentStatus(e *core.RequestEvent) error {
+	var body struct {
+		Status     string         `json:"status"`
+		PauseUntil types.DateTime `json:"pauseUntil"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+	if !body.PauseUntil.IsZero() {
+		if body.Status != StatusPaused {
+			return e.BadRequestError("Failed to update payment",
+				validation.Errors{"pauseUntil": validation.NewError("validation_pause_until_not_paused", "Only paused payments can have a pause end date.")})
+		}
+		if !body.PauseUntil.Time().After(time.Now().UTC()) {
+			return e.BadRequestError("Failed to update payment",
+				validation.Errors{"pauseUntil": validation.NewError("validation_pause_until_past", "Pause end date must be in the future.")})
+		}
+	}
+	return changeStatus(e, body.Status, body.PauseUntil, false)
+}
+
+// ReactivatePayment handles POST /api/beszel/payments/{id}/reactivate requests.
+// Makes a cancelled payment active again.
+func (pm *PaymentManager) ReactivatePayment(e *core.RequestEvent) error {
+	return changeStatus(e, StatusActive, types.DateTime{}, true)
+}
+
+func changeStatus(e *core.RequestEvent, status string, pauseUntil types.DateTime, reactivate bool) error {
+	record, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	from := paymentStatus(record)
+	if reactivate && from != StatusCancelled {
+		return e.BadRequestError("Failed to update payment",
+			validation.Errors{"status": validation.NewError("validation_not_cancelled", "Only cancelled payments can be reactivated.")})
+	}
+	if err := checkStatusTransition(from, status, reactivate); err != nil {
+		return e.BadRequestError("Failed to update payment", err)
+	}
+	record.Set("status", status)
+	if status == StatusPaused {
+		record.Set("pauseUntil", pauseUntil)
+	}
+	if err := applyStatusChange(record, from, time.Now().UTC()); err != nil {
+		return e.BadRequestError("Failed to update payment", err)
+	}
+	if err := e.App.SaveWithContext(withActor(e), record); err != nil {
+		return e.BadRequestError("Failed to update payment", err)
+	}
+	return e.JSON(http.StatusOK, record)
+}
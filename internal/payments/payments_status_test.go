@@ -0,0 +1,309 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentStatusTransitions(t *testing.T) {
+	f := newPaymentFixture(t)
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	tests := []struct {
+		name           string
+		from           string
+		url            string
+		body           map[string]any
+		token          string
+		expectedStatus int
+		expectedError  string
+		// expected payment status after the request
+		expected string
+	}{
+		{"pause", "active", "/status", map[string]any{"status": "paused"}, f.token, 200, "", "paused"},
+		{"resume", "paused", "/status", map[string]any{"status": "active"}, f.token, 200, "", "active"},
+		{"cancel active", "active", "/status", map[string]any{"status": "cancelled"}, f.token, 200, "", "cancelled"},
+		{"cancel paused", "paused", "/status", map[string]any{"status": "cancelled"}, f.token, 200, "", "cancelled"},
+		{"unchanged", "paused", "/status", map[string]any{"status": "paused"}, f.token, 200, "", "paused"},
+		{"pause until a date", "active", "/status", map[string]any{"status": "paused", "pauseUntil": "2099-01-01 00:00:00.000Z"}, f.token, 200, "", "paused"},
+		{"pause end date without pausing", "paused", "/status", map[string]any{"status": "active", "pauseUntil": "2099-01-01 00:00:00.000Z"}, f.token, 400, "validation_pause_until_not_paused", "paused"},
+		{"pause end date in the past", "active", "/status", map[string]any{"status": "paused", "pauseUntil": "2020-01-01 00:00:00.000Z"}, f.token, 400, "validation_pause_until_past", "active"},
+		{"cancelled to active needs reactivation", "cancelled", "/status", map[string]any{"status": "active"}, f.token, 400, "validation_reactivation_required", "cancelled"},
+		{"cancelled to paused", "cancelled", "/status", map[string]any{"status": "paused"}, f.token, 400, "validation_invalid_status_transition", "cancelled"},
+		{"invalid status", "active", "/status", map[string]any{"status": "deleted"}, f.token, 400, "validation_invalid_status", "active"},
+		{"reactivate", "cancelled", "/reactivate", nil, f.token, 200, "", "active"},
+		{"reactivate active", "active", "/reactivate", nil, f.token, 400, "validation_not_cancelled", "active"},
+		{"other user", "active", "/status", map[string]any{"status": "paused"}, otherToken, 404, "wasn't found", "active"},
+	}
+
+	for _, test := range tests {
+		payment := f.createPayment(t, map[string]any{"status": test.from})
+		expectedContent := []string{`"status":"` + test.expected + `"`}
+		if test.expectedError != "" {
+			expectedContent = []string{test.expectedError}
+		}
+		scenario := beszelTests.ApiScenario{
+			Name:            test.name,
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/payments/" + payment.Id + test.url,
+			Body:            jsonReader(test.body),
+			Headers:         map[string]string{"Authorization": test.token},
+			ExpectedStatus:  test.expectedStatus,
+			ExpectedContent: expectedContent,
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				record, err := app.FindRecordById("payments", payment.Id)
+				require.NoError(t, err)
+				assert.Equal(t, test.expected, record.GetString("status"))
+			},
+		}
+		scenario.Test(t)
+	}
+}
+
+func TestPaymentStatusRecordUpdate(t *testing.T) {
+	f := newPaymentFixture(t)
+	cancelled := f.createPayment(t, map[string]any{"status": "cancelled"})
+	paused := f.createPayment(t, map[string]any{"status": "paused"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "cancelled to active is rejected on record update",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + cancelled.Id,
+			Body:            jsonReader(map[string]any{"status": "active"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_reactivation_required"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "paused to active is allowed on record update",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + paused.Id,
+			Body:            jsonReader(map[string]any{"status": "active"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"active"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "new payments are active",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Body:            jsonReader(map[string]any{"user": f.user.Id, "system": f.system.Id, "provider": f.provider.Id, "period": "monthly", "nextPayment": "2030-01-15 00:00:00.000Z", "amount": 10, "currency": "USD"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"active"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestPausedAndCancelledPayments(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Now().UTC()
+	active := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 2), "amount": 10})
+	paused := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 2), "amount": 20, "status": "paused"})
+	cancelled := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 2), "amount": 40, "status": "cancelled"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "summary leaves out paused and cancelled payments",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`{"USD":10}`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "upcoming leaves out paused and cancelled payments",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/upcoming",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{active.Id},
+			NotExpectedContent: []string{paused.Id, cancelled.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:               "list shows paused but not cancelled payments",
+			Method:             http.MethodGet,
+			URL:                "/api/collections/payments/records",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{active.Id, paused.Id},
+			NotExpectedContent: []string{cancelled.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "cancelled payments are listed with includeArchived",
+			Method:          http.MethodGet,
+			URL:             "/api/collections/payments/records?includeArchived=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{cancelled.Id},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestAdvanceSkipsPaused(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	due := time.Date(2030, 1, 15, 0, 0, 0, 0, time.UTC)
+	paused := f.createPayment(t, map[string]any{"nextPayment": due, "status": "paused"})
+	cancelled := f.createPayment(t, map[string]any{"nextPayment": due, "status": "cancelled"})
+
+	count, err := f.hub.GetPaymentManager().AdvanceDuePayments(due.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	for _, id := range []string{paused.Id, cancelled.Id} {
+		record, err := f.hub.FindRecordById("payments", id)
+		require.NoError(t, err)
+		assert.Equal(t, due, record.GetDateTime("nextPayment").Time())
+	}
+}
+
+func TestResumeSkipsDueDatesWhilePaused(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Now().UTC()
+	paused := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, -3, 0), "status": "paused"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenario := beszelTests.ApiScenario{
+		Name:            "resume",
+		Method:          http.MethodPost,
+		URL:             "/api/beszel/payments/" + paused.Id + "/status",
+		Body:            jsonReader(map[string]any{"status": "active"}),
+		Headers:         map[string]string{"Authorization": f.token},
+		ExpectedStatus:  200,
+		ExpectedContent: []string{`"status":"active"`},
+		TestAppFactory:  testAppFactory,
+		AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+			record, err := app.FindRecordById("payments", paused.Id)
+			require.NoError(t, err)
+			next := record.GetDateTime("nextPayment").Time()
+			assert.True(t, next.After(now))
+			assert.True(t, next.Before(now.AddDate(0, 1, 1)))
+
+			history, err := app.FindAllRecords("payment_history")
+			require.NoError(t, err)
+			assert.Empty(t, history)
+		},
+	}
+	scenario.Test(t)
+}
+
+func TestPauseUntilResumesPayment(t *testing.T) {
+	f := newPaymentFixture(t)
+	pm := f.hub.GetPaymentManager()
+
+	// an annual payment paused over its renewal from February to June
+	annual := f.createPayment(t, map[string]any{
+		"period":             "annual",
+		"nextPayment":        "2030-03-01 00:00:00.000Z",
+		"billingDay":         1,
+		"status":             "paused",
+		"pauseUntil":         "2030-06-01 00:00:00.000Z",
+		"lastReminderSentAt": "2030-02-20 00:00:00.000Z",
+	})
+	manual := f.createPayment(t, map[string]any{"nextPayment": "2030-03-01 00:00:00.000Z", "status": "paused"})
+
+	_, err := pm.AdvanceDuePayments(time.Date(2030, 5, 31, 0, 5, 0, 0, time.UTC))
+	require.NoError(t, err)
+	record, err := f.hub.FindRecordById("payments", annual.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "paused", record.GetString("status"), "the payment should stay paused until pauseUntil")
+	assert.Equal(t, date(2030, 3, 1), record.GetDateTime("nextPayment").Time())
+
+	_, err = pm.AdvanceDuePayments(time.Date(2030, 6, 1, 0, 5, 0, 0, time.UTC))
+	require.NoError(t, err)
+	record, err = f.hub.FindRecordById("payments", annual.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "active", record.GetString("status"))
+	assert.True(t, record.GetDateTime("pauseUntil").IsZero())
+	assert.Equal(t, date(2031, 3, 1), record.GetDateTime("nextPayment").Time(), "the renewal during the pause should be skipped")
+	assert.True(t, record.GetDateTime("lastReminderSentAt").IsZero())
+
+	history, err := f.hub.FindAllRecords("payment_history")
+	require.NoError(t, err)
+	assert.Empty(t, history, "nothing should be charged for the pause")
+
+	// a payment paused without an end date stays paused
+	record, err = f.hub.FindRecordById("payments", manual.Id)
+	require.NoError(t, err)
+	assert.Equal(t, "paused", record.GetString("status"))
+
+	// resuming by hand clears the pause end date
+	record.Set("pauseUntil", "2031-01-01 00:00:00.000Z")
+	require.NoError(t, f.hub.Save(record))
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "pause end date on an active payment",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + annual.Id,
+			Body:            jsonReader(map[string]any{"pauseUntil": "2031-01-01 00:00:00.000Z"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"validation_pause_until_not_paused"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "resume by hand",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + manual.Id,
+			Body:            jsonReader(map[string]any{"status": "active"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"status":"active"`, `"pauseUntil":""`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,279 @@
+package payments
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// findUserPayments returns all payments owned by the user
+func findUserPayments(app core.App, userID string) ([]*core.Record, error) {
+	return app.FindAllRecords("payments", dbx.HashExp{"user": userID})
+}
+
+// monthlyTotals sums the monthly equivalent of each payment grouped by currency,
+// using the amount with any discount active at now, normalized with norm.
+// Payments still in their free trial, free payments and paused payments are not included.
+func monthlyTotals(records []*core.Record, now time.Time, norm normalization) map[string]float64 {
+	totals, _ := monthlyNetAndGross(records, now, fullShare, norm)
+	return totals
+}
+
+// monthlyNetAndGross returns the monthly totals by currency before and after tax,
+// counting the part of each payment returned by share, normalized with norm
+func monthlyNetAndGross(records []*core.Record, now time.Time, share func(record *core.Record) float64, norm normalization) (map[string]float64, map[string]float64) {
+	net := make(map[string]float64)
+	gross := make(map[string]float64)
+	for _, record := range records {
+		if inTrial(record, now) || isFree(record) || isPaused(record) {
+			continue
+		}
+		monthly, ok := norm.monthlyAmount(record, effectiveAmount(record, now))
+		if !ok {
+			continue
+		}
+		monthly *= share(record)
+		net[record.GetString("currency")] += monthly
+		gross[record.GetString("currency")] += withTax(record, monthly)
+	}
+	return net, gross
+}
+
+// key used for payments without a category or payment method in grouped summaries
+const noGroup = "__none__"
+
+// key used for payments without a country in grouped summaries
+const unknownCountry = "__unknown__"
+
+// summaryGroup describes a supported groupBy value of the summary
+type summaryGroup struct {
+	// keys returns the keys a payment is counted under
+	keys func(record *core.Record) []string
+	// collection of the records the keys refer to, used to resolve their names.
+	// Empty if the keys are names themselves.
+	collection string
+	// field of the collection holding the name
+	nameField string
+	// filter matching the user's records of the collection, with the user id bound to {:user}
+	ownerFilter string
+}
+
+var summaryGroups = map[string]summaryGroup{
+	"category": {keys: categoryKeys, collection: "categories", nameField: "name", ownerFilter: "user = {:user}"},
+	"system":   {keys: systemKeys, collection: "systems", nameField: "name", ownerFilter: "users.id ?= {:user}"},
+	"method":   {keys: methodKeys, collection: "payment_methods", nameField: "label", ownerFilter: "user = {:user}"},
+	"country":  {keys: countryKeys},
+}
+
+// categoryKeys returns the payment's category ids, or noGroup if it has none
+func categoryKeys(record *core.Record) []string {
+	if ids := record.GetStringSlice("categories"); len(ids) > 0 {
+		return ids
+	}
+	return []string{noGroup}
+}
+
+// methodKeys returns the payment's payment method id, or noGroup if it has none
+func methodKeys(record *core.Record) []string {
+	if id := record.GetString("paymentMethod"); id != "" {
+		return []string{id}
+	}
+	return []string{noGroup}
+}
+
+// countryKeys returns the payment's country code, or unknownCountry if it has none
+func countryKeys(record *core.Record) []string {
+	if country := record.GetString("country"); country != "" {
+		return []string{country}
+	}
+	return []string{unknownCountry}
+}
+
+// systemKeys returns the payment's system id
+func systemKeys(record *core.Record) []string {
+	if id := record.GetString("system"); id != "" {
+		return []string{id}
+	}
+	return nil
+}
+
+// groupNames returns the name of each group key found among the user's records of the group's
+// collection. Keys referring to records of other users are left without a name.
+func groupNames(e *core.RequestEvent, group summaryGroup, groups map[string]map[string]float64) map[string]string {
+	names := make(map[string]string, len(groups))
+	records, err := e.App.FindRecordsByFilter(group.collection, group.ownerFilter, "", 0, 0, dbx.Params{"user": e.Auth.Id})
+	if err != nil {
+		requestid.Logger(e).Warn("Failed to resolve summary group names", "collection", group.collection, "err", err)
+		return names
+	}
+	for _, record := range records {
+		if _, ok := groups[record.Id]; ok {
+			names[record.Id] = record.GetString(group.nameField)
+		}
+	}
+	return names
+}
+
+// scaleTotals multiplies each per currency total by factor
+func scaleTotals(totals map[string]float64, factor float64) map[string]float64 {
+	for currency, amount := range totals {
+		totals[currency] = amount * factor
+	}
+	return totals
+}
+
+// groupedMonthlyTotals sums the monthly equivalent of the part of each payment returned by share
+// by group and currency, normalized with norm, and counts the payments in each group.
+// A payment with several keys is counted in full under each of them.
+func groupedMonthlyTotals(records []*core.Record, now time.Time, keys func(record *core.Record) []string, share func(record *core.Record) float64, norm normalization) (map[string]map[string]float64, map[string]int) {
+	groups := make(map[string]map[string]float64)
+	counts := make(map[string]int)
+	for _, record := range records {
+		if inTrial(record, now) || isFree(record) || isPaused(record) {
+			continue
+		}
+		monthly, ok := norm.monthlyAmount(record, effectiveAmount(record, now))
+		if !ok {
+			continue
+		}
+		monthly *= share(record)
+		for _, key := range keys(record) {
+			if groups[key] == nil {
+				groups[key] = make(map[string]float64)
+			}
+			groups[key][record.GetString("currency")] += monthly
+			counts[key]++
+		}
+	}
+	return groups, counts
+}
+
+// countInTrial returns the number of payments currently in a free trial
+func countInTrial(records []*core.Record, now time.Time) int {
+	var count int
+	for _, record := range records {
+		if inTrial(record, now) {
+			count++
+		}
+	}
+	return count
+}
+
+// GetSummary handles GET /api/beszel/payments/summary requests.
+// Returns the user's monthly equivalent spend grouped by currency, or a single
+// total converted to the currency in the optional base query parameter.
+// Without base the user's default currency is used if set; pass an empty base
+// to get the per currency totals regardless.
+// The number of payments excluded because they are in a free trial is sent
+// in the X-Payments-In-Trial header. Free payments are left out, and archived
+// payments are too unless includeArchived=true is passed.
+//
+// Payments shared with the user are included, and only the user's share of
+// shared payments is counted.
+//
+// With annualize=true yearly equivalents are returned instead of monthly ones.
+// Daily and weekly amounts are normalized with the mode chosen in the payment config.
+//
+// With gross=true the totals including tax are added under "gross", per currency
+// or converted to base. Without groupBy the per currency net totals then move
+// under "totals".
+//
+// With groupBy=category, groupBy=system, groupBy=method or groupBy=country the
+// response holds the per currency totals under "totals", the spend of each group
+// under "groups", the number of payments in each group under "counts", and the
+// group names under "names" (except for countries, which are keyed by code).
+// Payments without a category or payment method are grouped under "__none__",
+// payments without a country under "__unknown__". Systems without payments are
+// left out.
+func (pm *PaymentManager) GetSummary(e *core.RequestEvent) error {
+	base := requestBase(e)
+	if base != "" && !isCurrency(base) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid base currency"})
+	}
+	groupBy := e.Request.URL.Query().Get("groupBy")
+	group, ok := summaryGroups[groupBy]
+	if groupBy != "" && !ok {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid groupBy"})
+	}
+
+	records, err := findOwnAndSharedPayments(e.App, e.Auth.Id)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	if !includeArchived(e) {
+		records = withoutArchived(records)
+	}
+	now := time.Now().UTC()
+	share := userShare(e.Auth.Id)
+	norm := loadNormalization(e.App, now)
+	totals, gross := monthlyNetAndGross(records, now, share, norm)
+	withGross := e.Request.URL.Query().Get("gross") == "true"
+	e.Response.Header().Set("X-Payments-In-Trial", strconv.Itoa(countInTrial(records, now)))
+	var groups map[string]map[string]float64
+	var names map[string]string
+	var counts map[string]int
+	if ok {
+		groups, counts = groupedMonthlyTotals(records, now, group.keys, share, norm)
+		if group.collection != "" {
+			names = groupNames(e, group, groups)
+		}
+	}
+	if e.Request.URL.Query().Get("annualize") == "true" {
+		scaleTotals(totals, monthsPerYear)
+		scaleTotals(gross, monthsPerYear)
+		for _, groupTotals := range groups {
+			scaleTotals(groupTotals, monthsPerYear)
+		}
+	}
+	if base == "" {
+		if groups == nil && !withGross {
+			return e.JSON(http.StatusOK, roundTotals(totals))
+		}
+		response := map[string]any{"totals": roundTotals(totals)}
+		if groups != nil {
+			for _, groupTotals := range groups {
+				roundTotals(groupTotals)
+			}
+			response["groups"] = groups
+			response["counts"] = counts
+			if names != nil {
+				response["names"] = names
+			}
+		}
+		if withGross {
+			response["gross"] = roundTotals(gross)
+		}
+		return e.JSON(http.StatusOK, response)
+	}
+
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	total := roundAmount(conv.convertTotals(totals, base), base)
+	if err := conv.err(); err != nil {
+		return convertError(e, err)
+	}
+	response := map[string]any{"total": total, "base": base}
+	// the gross and group totals are made of the same currencies, so these conversions can't fail
+	if withGross {
+		response["gross"] = roundAmount(conv.convertTotals(gross, base), base)
+	}
+	if groups != nil {
+		converted := make(map[string]float64, len(groups))
+		for key, groupTotals := range groups {
+			converted[key] = roundAmount(conv.convertTotals(groupTotals, base), base)
+		}
+		response["groups"] = converted
+		response["counts"] = counts
+		if names != nil {
+			response["names"] = names
+		}
+	}
+	return e.JSON(http.StatusOK, response)
+}
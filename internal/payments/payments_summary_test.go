@@ -0,0 +1,291 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummaryApi(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.createPayment(t, map[string]any{"amount": 12, "currency": "USD", "period": "annual"})
+	f.createPayment(t, map[string]any{"amount": 5, "currency": "USD", "period": "monthly"})
+	f.createPayment(t, map[string]any{"amount": 300, "currency": "RUB", "period": "quarterly"})
+	// trial payments are excluded from the totals
+	f.createPayment(t, map[string]any{"amount": 50, "currency": "USD", "trialEndsAt": "2099-01-01 00:00:00.000Z"})
+
+	// payments of another user must not be included
+	otherUser, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+	otherProvider := createProvider(t, f.hub, otherUser, "Other")
+	_, err := beszelTests.CreateRecord(f.hub, "payments", map[string]any{
+		"user":        otherUser.Id,
+		"system":      createSystem(t, f.hub, otherUser, "other").Id,
+		"provider":    otherProvider.Id,
+		"period":      "monthly",
+		"nextPayment": "2030-01-01 00:00:00.000Z",
+		"amount":      999,
+		"currency":    "EUR",
+	})
+	require.NoError(t, err)
+	_, emptyToken := createUserWithToken(t, f.hub, "empty@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "totals grouped by currency",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/summary",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"USD":6`, `"RUB":100`},
+			NotExpectedContent: []string{"EUR"},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Equal(t, "1", res.Header.Get("X-Payments-In-Trial"))
+			},
+		},
+		{
+			Name:               "other user only sees own payments",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/summary",
+			Headers:            map[string]string{"Authorization": otherToken},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`{"EUR":999}`},
+			NotExpectedContent: []string{"USD", "RUB"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "no payments returns empty object",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary",
+			Headers:         map[string]string{"Authorization": emptyToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{"{}"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid base currency",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?base=XYZ",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"invalid base currency"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "missing rate returns 422 with pairs",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/summary?base=USD",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     422,
+			ExpectedContent:    []string{`"missing":[{"base":"RUB","quote":"USD"}]`},
+			NotExpectedContent: []string{"USD/USD"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "converted total using inverse rate",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"base":"USD"`, `"total":7`},
+			TestAppFactory:  testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "USD", "RUB", 100)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestSummaryAnnualizeAndGroupBySystem(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	web := createSystem(t, f.hub, f.user, "web-1")
+	db := createSystem(t, f.hub, f.user, "db-1")
+	idle := createSystem(t, f.hub, f.user, "idle")
+	f.createPayment(t, map[string]any{"system": web.Id, "amount": 10, "currency": "USD"})
+	f.createPayment(t, map[string]any{"system": db.Id, "amount": 120, "currency": "EUR", "period": "annual"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:               "default shape is unchanged",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/summary",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"USD":10`, `"EUR":10`},
+			NotExpectedContent: []string{"totals", "groups"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "annualized totals",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?annualize=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"USD":120`, `"EUR":120`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "grouped by system with names",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/summary?groupBy=system",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"` + web.Id + `":{"USD":10}`,
+				`"` + db.Id + `":{"EUR":10}`,
+				`"` + web.Id + `":"web-1"`,
+				`"` + db.Id + `":"db-1"`,
+			},
+			NotExpectedContent: []string{idle.Id},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:           "annualized groups converted to base",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/summary?groupBy=system&annualize=true&base=USD",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"total":264`,
+				`"` + web.Id + `":120`,
+				`"` + db.Id + `":144`,
+			},
+			TestAppFactory: testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "EUR", "USD", 1.2)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestSummaryGross(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.createPayment(t, map[string]any{"amount": 100, "currency": "EUR", "taxRate": 20})
+	f.createPayment(t, map[string]any{"amount": 10, "currency": "USD"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:               "net only by default",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/summary",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"EUR":100`, `"USD":10`},
+			NotExpectedContent: []string{"gross", "totals"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "gross per currency",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?gross=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"totals":{`, `"gross":{`, `"EUR":120`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "gross converted to base",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/summary?gross=true&base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"total":110`, `"gross":130`},
+			TestAppFactory:  testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "EUR", "USD", 1)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestSummaryGroupByCountry(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.createPayment(t, map[string]any{"amount": 10, "country": "DE"})
+	f.createPayment(t, map[string]any{"amount": 5, "currency": "EUR", "country": "DE"})
+	f.createPayment(t, map[string]any{"amount": 20, "country": "US"})
+	f.createPayment(t, map[string]any{"amount": 7})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:           "grouped by country with counts",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/summary?groupBy=country",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"groups":{"DE":{"EUR":5,"USD":10},"US":{"USD":20},"__unknown__":{"USD":7}}`,
+				`"counts":{"DE":2,"US":1,"__unknown__":1}`,
+			},
+			NotExpectedContent: []string{`"names"`},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:           "grouped by country converted to base",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/summary?groupBy=country&base=USD",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"groups":{"DE":16,"US":20,"__unknown__":7}`,
+				`"counts":{"DE":2,"US":1,"__unknown__":1}`,
+			},
+			TestAppFactory: testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "EUR", "USD", 1.2)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,62 @@
+package payments
+
+import (
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// GetSystemCost handles GET /api/beszel/systems/{id}/cost requests.
+// Returns the monthly and yearly equivalent cost of the system's active payments,
+// converted to the currency in the optional base query parameter, or the user's
+// default currency if absent. Without either the costs are returned per currency.
+// Archived, cancelled and paused payments and free trials are not counted.
+func (pm *PaymentManager) GetSystemCost(e *core.RequestEvent) error {
+	system, err := e.App.FindFirstRecordByFilter("systems", "id = {:id} && users.id ?= {:user}",
+		dbx.Params{"id": e.Request.PathValue("id"), "user": e.Auth.Id})
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	base := requestBase(e)
+	if base != "" && !isCurrency(base) {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": "invalid base currency"})
+	}
+
+	records, err := e.App.FindAllRecords("payments", dbx.HashExp{"user": e.Auth.Id, "system": system.Id})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	records = slices.DeleteFunc(withoutArchived(records), isPaused)
+	now := time.Now().UTC()
+	monthly := monthlyTotals(records, now, loadNormalization(e.App, now))
+	response := map[string]any{
+		"id":    system.Id,
+		"name":  system.GetString("name"),
+		"count": len(records),
+	}
+	if base == "" {
+		annual := make(map[string]float64, len(monthly))
+		for currency, amount := range monthly {
+			annual[currency] = amount * monthsPerYear
+		}
+		response["monthly"] = roundTotals(monthly)
+		response["annual"] = roundTotals(annual)
+		return e.JSON(http.StatusOK, response)
+	}
+
+	conv, err := pm.newConverter(e.App)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	total := conv.convertTotals(monthly, base)
+	if err := conv.err(); err != nil {
+		return convertError(e, err)
+	}
+	response["currency"] = base
+	response["monthly"] = roundAmount(total, base)
+	response["annual"] = roundAmount(total*monthsPerYear, base)
+	return e.JSON(http.StatusOK, response)
+}
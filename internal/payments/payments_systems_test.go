@@ -0,0 +1,85 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+)
+
+func TestSystemCost(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 10})
+	f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 120, "currency": "EUR", "period": "annual"})
+	f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 50, "status": "paused"})
+	f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 50, "status": "cancelled"})
+	f.createPayment(t, map[string]any{"amount": 99})
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "other user's system is not found",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/systems/" + f.system.Id + "/cost",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "per currency without a default currency",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/systems/" + f.system.Id + "/cost",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"count":2`, `"monthly":{"EUR":10,"USD":10}`, `"annual":{"EUR":120,"USD":120}`, `"name":"server-1"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "missing rates",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/systems/" + f.system.Id + "/cost?base=USD",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{`"missing":[{"base":"EUR","quote":"USD"}]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "set a default currency",
+			Method:          http.MethodPatch,
+			URL:             "/api/beszel/settings",
+			Body:            jsonReader(map[string]any{"defaultCurrency": "USD"}),
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"defaultCurrency":"USD"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "converted to the default currency",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/systems/" + f.system.Id + "/cost",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"currency":"USD"`, `"monthly":22`, `"annual":264`},
+			TestAppFactory:  testAppFactory,
+			BeforeTestFunc: func(t testing.TB, app *pbTests.TestApp, e *core.ServeEvent) {
+				setRate(t, app, "EUR", "USD", 1.2)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
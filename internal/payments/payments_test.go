@@ -0,0 +1,120 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/require"
+)
+
+// marshal to json and return an io.Reader (for use in ApiScenario.Body)
+func jsonReader(v any) io.Reader {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return bytes.NewReader(data)
+}
+
+// paymentFixture holds records shared by most payment tests
+type paymentFixture struct {
+	hub      *beszelTests.TestHub
+	user     *core.Record
+	token    string
+	system   *core.Record
+	provider *core.Record
+	// number of extra systems created by createPayment
+	systemCount int
+}
+
+// newPaymentFixture creates a started test hub with a user, system and provider
+func newPaymentFixture(t *testing.T) *paymentFixture {
+	hub, err := beszelTests.NewTestHub(t.TempDir())
+	require.NoError(t, err)
+	t.Cleanup(hub.Cleanup)
+	hub.StartHub()
+
+	f := &paymentFixture{hub: hub}
+	f.user, f.token = createUserWithToken(t, hub, "payments@example.com")
+	f.system = createSystem(t, hub, f.user, "server-1")
+	f.provider = createProvider(t, hub, f.user, "Hetzner")
+	return f
+}
+
+func createUserWithToken(t *testing.T, hub *beszelTests.TestHub, email string) (*core.Record, string) {
+	user, err := beszelTests.CreateUser(hub, email, "password123")
+	require.NoError(t, err)
+	token, err := user.NewAuthToken()
+	require.NoError(t, err)
+	return user, token
+}
+
+// createSystem creates a paused system for the user.
+// Paused systems aren't picked up when an api scenario re-runs the hub's serve hooks,
+// which would otherwise start monitoring them after the test hub is cleaned up.
+func createSystem(t *testing.T, hub *beszelTests.TestHub, user *core.Record, name string) *core.Record {
+	system, err := beszelTests.CreateRecord(hub, "systems", map[string]any{
+		"name":  name,
+		"host":  "127.0.0.1",
+		"users": []string{user.Id},
+	})
+	require.NoError(t, err)
+	system.Set("status", "paused")
+	require.NoError(t, hub.SaveNoValidate(system))
+	return system
+}
+
+func createProvider(t *testing.T, hub *beszelTests.TestHub, user *core.Record, name string) *core.Record {
+	provider, err := beszelTests.CreateRecord(hub, "providers", map[string]any{
+		"user": user.Id,
+		"name": name,
+		"url":  "https://example.com",
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+// createPayment creates a monthly payment for the fixture user, overriding defaults with fields.
+// A new system is created for each payment unless one is provided.
+func (f *paymentFixture) createPayment(t *testing.T, fields map[string]any) *core.Record {
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	if _, ok := fields["system"]; !ok {
+		f.systemCount++
+		fields["system"] = createSystem(t, f.hub, f.user, fmt.Sprintf("server-%d", f.systemCount+1)).Id
+	}
+	data := map[string]any{
+		"user":        f.user.Id,
+		"provider":    f.provider.Id,
+		"period":      "monthly",
+		"nextPayment": "2030-01-15 00:00:00.000Z",
+		"amount":      10,
+		"currency":    "USD",
+	}
+	for k, v := range fields {
+		data[k] = v
+	}
+	payment, err := beszelTests.CreateRecord(f.hub, "payments", data)
+	require.NoError(t, err)
+	return payment
+}
+
+// setRate stores an exchange rate for the pair
+func setRate(t testing.TB, hub core.App, base, quote string, rate float64) {
+	_, err := beszelTests.CreateRecord(hub, "exchange_rates", map[string]any{
+		"base":  base,
+		"quote": quote,
+		"rate":  rate,
+	})
+	require.NoError(t, err)
+}
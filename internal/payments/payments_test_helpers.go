@@ -0,0 +1,69 @@
+//go:build testing
+// +build testing
+
+package payments
+
+import (
+	"time"
+)
+
+// TESTING ONLY: AddPeriod exposes addPeriod
+func AddPeriod(t time.Time, period string, anchorDay int) (time.Time, error) {
+	return addPeriod(t, period, anchorDay)
+}
+
+// TESTING ONLY: AdvanceDuePayments advances payments relative to the provided time
+func (pm *PaymentManager) AdvanceDuePayments(now time.Time) (int, error) {
+	return pm.advanceDuePayments(pm.app.Logger(), now)
+}
+
+// TESTING ONLY: NotifyDuePaymentsAt sends due payment reminders relative to the provided time
+func (pm *PaymentManager) NotifyDuePaymentsAt(now time.Time) (int, error) {
+	return pm.notifyDuePayments(pm.app.Logger(), now)
+}
+
+// TESTING ONLY: SendDigestsAt sends the payment digests due at the provided time
+func (pm *PaymentManager) SendDigestsAt(now time.Time) (int, error) {
+	return pm.sendDigests(pm.app.Logger(), now)
+}
+
+// TESTING ONLY: SnapshotSpendAt takes the spend snapshots due at the provided time
+func (pm *PaymentManager) SnapshotSpendAt(now time.Time) (int, error) {
+	return pm.snapshotSpend(pm.app.Logger(), now)
+}
+
+// TESTING ONLY: SetWebhookBackoff sets the delay before the first webhook retry
+func SetWebhookBackoff(d time.Duration) {
+	webhookBackoff = d
+}
+
+// TESTING ONLY: RoundAmount exposes roundAmount
+func RoundAmount(amount float64, currency string) float64 {
+	return roundAmount(amount, currency)
+}
+
+// TESTING ONLY: FetchRatesAt fetches exchange rates, stamping them with the provided time
+func (pm *PaymentManager) FetchRatesAt(now time.Time) error {
+	return pm.fetchRates(pm.app.Logger(), now)
+}
+
+// TESTING ONLY: FormatAmount exposes formatAmount
+func FormatAmount(amount float64, currency, locale string) string {
+	return formatAmount(amount, currency, locale)
+}
+
+// TESTING ONLY: ReminderSchedule exposes reminderSchedule
+type ReminderSchedule = reminderSchedule
+
+// TESTING ONLY: ReminderDue exposes reminderDue
+func ReminderDue(s ReminderSchedule, now time.Time) bool {
+	return reminderDue(s, now)
+}
+
+// TESTING ONLY: PaymentSchedule exposes paymentSchedule
+type PaymentSchedule = paymentSchedule
+
+// TESTING ONLY: AdvanceSchedule exposes advanceSchedule
+func AdvanceSchedule(s PaymentSchedule, now time.Time) (PaymentSchedule, []time.Time, error) {
+	return advanceSchedule(s, now)
+}
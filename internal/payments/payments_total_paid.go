@@ -0,0 +1,96 @@
+package payments
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// paidTotal is an amount paid for a payment over a number of charges
+type paidTotal struct {
+	Amount  float64 `json:"amount"`
+	Charges int     `json:"charges"`
+}
+
+// estimateCharges returns the number of charges of the payment from its startedAt until now,
+// counting one on startedAt. Zero is returned for payments without a startedAt. Payments more
+// than maxAdvancePeriods charges in return errTooManyPeriods, like when advancing them.
+func estimateCharges(record *core.Record, now time.Time) (int, error) {
+	next := record.GetDateTime("startedAt").Time()
+	if next.IsZero() {
+		return 0, nil
+	}
+	anchorDay := next.Day()
+	charges := 0
+	for !next.After(now) {
+		if charges == maxAdvancePeriods {
+			return 0, errTooManyPeriods
+		}
+		charges++
+		var err error
+		if next, err = addPaymentPeriod(record, next, anchorDay); errors.Is(err, errNotRecurring) {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return charges, nil
+}
+
+// GetTotalPaid handles GET /api/beszel/payments/{id}/total-paid requests.
+// Returns how much was paid for the payment so far, in its currency, as two figures:
+// actual sums the charges in its payment history, converted from the currency they were
+// recorded in, and estimated multiplies the amount by the periods elapsed since startedAt.
+// Actual is null for payments without history and estimated for payments without a startedAt.
+func (pm *PaymentManager) GetTotalPaid(e *core.RequestEvent) error {
+	payment, err := findUserPayment(e.App, e.Auth.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	currency := payment.GetString("currency")
+
+	history, err := e.App.FindAllRecords("payment_history", dbx.HashExp{"payment": payment.Id})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	var actual *paidTotal
+	if len(history) > 0 {
+		conv, err := pm.newConverter(e.App)
+		if err != nil {
+			return e.InternalServerError("", err)
+		}
+		totals := make(map[string]float64)
+		for _, charge := range history {
+			totals[charge.GetString("currency")] += charge.GetFloat("amount")
+		}
+		total := conv.convertTotals(totals, currency)
+		if err := conv.err(); err != nil {
+			return convertError(e, err)
+		}
+		actual = &paidTotal{Amount: roundAmount(total, currency), Charges: len(history)}
+	}
+
+	var estimated *paidTotal
+	if !payment.GetDateTime("startedAt").IsZero() {
+		charges, err := estimateCharges(payment, time.Now().UTC())
+		if err != nil {
+			return e.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		}
+		amount := payment.GetFloat("amount")
+		if isFree(payment) {
+			amount = 0
+		}
+		estimated = &paidTotal{Amount: roundAmount(amount*float64(charges), currency), Charges: charges}
+	}
+
+	return e.JSON(http.StatusOK, map[string]any{
+		"id":        payment.Id,
+		"currency":  currency,
+		"startedAt": payment.GetDateTime("startedAt"),
+		"actual":    actual,
+		"estimated": estimated,
+	})
+}
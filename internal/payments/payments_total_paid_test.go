@@ -0,0 +1,106 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTotalPaid(t *testing.T) {
+	f := newPaymentFixture(t)
+	setRate(t, f.hub, "EUR", "USD", 1.1)
+
+	// started three years and a day ago, so four annual charges have elapsed
+	startedAt := time.Now().UTC().AddDate(-3, 0, -1)
+	payment := f.createPayment(t, map[string]any{"period": "annual", "amount": 180, "startedAt": startedAt})
+	for _, charge := range []map[string]any{
+		{"amount": 180, "currency": "USD", "paidAt": startedAt.AddDate(2, 0, 0)},
+		{"amount": 100, "currency": "EUR", "paidAt": startedAt.AddDate(3, 0, 0)},
+	} {
+		charge["payment"] = payment.Id
+		charge["user"] = f.user.Id
+		_, err := beszelTests.CreateRecord(f.hub, "payment_history", charge)
+		require.NoError(t, err)
+	}
+	unstarted := f.createPayment(t, nil)
+	once := f.createPayment(t, map[string]any{"period": "once", "amount": 50, "startedAt": startedAt})
+	// more daily charges than the estimate steps through
+	longRunning := f.createPayment(t, map[string]any{"period": "daily", "amount": 1, "startedAt": time.Now().UTC().AddDate(-30, 0, 0)})
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id + "/total-paid",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + payment.Id + "/total-paid",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "actual and estimated totals",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/" + payment.Id + "/total-paid",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"currency":"USD"`,
+				`"actual":{"amount":290,"charges":2}`,
+				`"estimated":{"amount":720,"charges":4}`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+		{
+			Name:            "too many charges to estimate",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + longRunning.Id + "/total-paid",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  422,
+			ExpectedContent: []string{"too many periods behind"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "without history or start date",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + unstarted.Id + "/total-paid",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"actual":null`, `"estimated":null`, `"startedAt":""`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "one-time payments are estimated once",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/" + once.Id + "/total-paid",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"estimated":{"amount":50,"charges":1}`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,168 @@
+package payments
+
+import (
+	"cmp"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// upcomingPayment is a payment due within the requested window
+type upcomingPayment struct {
+	Id           string         `json:"id"`
+	Provider     string         `json:"provider"`
+	ProviderName string         `json:"providerName"`
+	System       string         `json:"system"`
+	Amount       float64        `json:"amount"`
+	Currency     string         `json:"currency"`
+	Period       string         `json:"period"`
+	NextPayment  types.DateTime `json:"nextPayment"`
+	DaysUntil    int            `json:"daysUntil"`
+	// set when a discount ends within the requested window
+	PriceIncreasingOn *types.DateTime `json:"priceIncreasingOn,omitempty"`
+}
+
+// findPaymentsDueBetween returns the user's payments with nextPayment in [start, end], soonest first
+func findPaymentsDueBetween(app core.App, logger *slog.Logger, userID string, start, end time.Time) ([]*core.Record, error) {
+	startStr, _ := types.ParseDateTime(start)
+	endStr, _ := types.ParseDateTime(end)
+	records, err := app.FindRecordsByFilter("payments",
+		"user = {:user} && nextPayment >= {:start} && nextPayment <= {:end}",
+		"nextPayment", 0, 0,
+		dbx.Params{"user": userID, "start": startStr.String(), "end": endStr.String()},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if errs := app.ExpandRecords(records, []string{"provider"}, nil); len(errs) > 0 {
+		logger.Warn("Failed to expand payment providers", "errs", errs)
+	}
+	return records, nil
+}
+
+// daysBetween returns the number of calendar days from from to to, in the timezone of from
+func daysBetween(from, to time.Time) int {
+	return int(math.Round(startOfDay(to.In(from.Location())).Sub(startOfDay(from)).Hours() / 24))
+}
+
+// GetUpcoming handles GET /api/beszel/payments/upcoming requests.
+// Returns payments due within the next days (default 7, max 365), soonest first.
+// Archived payments are left out unless includeArchived=true is passed, paused ones always are.
+// Amounts are what will be charged on nextPayment, after any active discount.
+// Days start at midnight in the user's timezone setting.
+func (pm *PaymentManager) GetUpcoming(e *core.RequestEvent) error {
+	days, err := parseIntParam(e, "days", 7, 365)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	now := time.Now().In(loadPaymentSettings(e.App, e.Auth.Id).location())
+	upcoming, err := findUpcoming(e.App, requestid.Logger(e), e.Auth.Id, now, days, includeArchived(e))
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, upcoming)
+}
+
+// findUpcoming returns the user's payments due from the start of the day of now until the end of
+// the day in days, soonest first. Days start at midnight in the timezone of now.
+// Payments in a free trial and paused ones are left out, archived ones unless withArchived is set.
+func findUpcoming(app core.App, logger *slog.Logger, userID string, now time.Time, days int, withArchived bool) ([]upcomingPayment, error) {
+	start := startOfDay(now)
+	end := start.AddDate(0, 0, days+1).Add(-time.Millisecond)
+	records, err := findPaymentsDueBetween(app, logger, userID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if !withArchived {
+		records = withoutArchived(records)
+	}
+
+	upcoming := make([]upcomingPayment, 0, len(records))
+	for _, record := range records {
+		if inTrial(record, now) || isPaused(record) {
+			continue
+		}
+		nextPayment := record.GetDateTime("nextPayment")
+		item := upcomingPayment{
+			Id:          record.Id,
+			Provider:    record.GetString("provider"),
+			System:      record.GetString("system"),
+			Amount:      effectiveAmount(record, nextPayment.Time()),
+			Currency:    record.GetString("currency"),
+			Period:      record.GetString("period"),
+			NextPayment: nextPayment,
+			DaysUntil:   daysBetween(now, nextPayment.Time()),
+		}
+		if provider := record.ExpandedOne("provider"); provider != nil {
+			item.ProviderName = provider.GetString("name")
+		}
+		if discountEnds := record.GetDateTime("discountEndsAt"); record.GetFloat("discountAmount") > 0 &&
+			!discountEnds.IsZero() && !discountEnds.Time().Before(start) && !discountEnds.Time().After(end) {
+			item.PriceIncreasingOn = &discountEnds
+		}
+		upcoming = append(upcoming, item)
+	}
+	return upcoming, nil
+}
+
+// urgentPayment is a payment in the by-urgency list
+type urgentPayment struct {
+	upcomingPayment
+	GraceDays int `json:"graceDays"`
+	// set once the due date and grace days have passed
+	Overdue bool `json:"overdue"`
+}
+
+// GetByUrgency handles GET /api/beszel/payments/by-urgency requests.
+// Returns all of the user's active payments, however far off, as an action list: overdue
+// payments first, most overdue first, followed by the soonest due. daysUntil is negative once
+// the due date has passed, and overdue is set when the payment's grace days have too.
+// Archived, cancelled, paused and trial payments are left out.
+// Days start at midnight in the user's timezone setting.
+func (pm *PaymentManager) GetByUrgency(e *core.RequestEvent) error {
+	now := time.Now().In(loadPaymentSettings(e.App, e.Auth.Id).location())
+	records, err := findUserPayments(e.App, e.Auth.Id)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	records = slices.DeleteFunc(withoutArchived(records), func(record *core.Record) bool {
+		return isPaused(record) || inTrial(record, now) || record.GetDateTime("nextPayment").IsZero()
+	})
+	if errs := e.App.ExpandRecords(records, []string{"provider"}, nil); len(errs) > 0 {
+		requestid.Logger(e).Warn("Failed to expand payment providers", "errs", errs)
+	}
+
+	payments := make([]urgentPayment, len(records))
+	for i, record := range records {
+		nextPayment := record.GetDateTime("nextPayment")
+		payments[i].GraceDays = record.GetInt("graceDays")
+		payments[i].Overdue = isOverdue(record, now)
+		payments[i].upcomingPayment = upcomingPayment{
+			Id:          record.Id,
+			Provider:    record.GetString("provider"),
+			System:      record.GetString("system"),
+			Amount:      effectiveAmount(record, nextPayment.Time()),
+			Currency:    record.GetString("currency"),
+			Period:      record.GetString("period"),
+			NextPayment: nextPayment,
+			DaysUntil:   daysBetween(now, nextPayment.Time()),
+		}
+		if provider := record.ExpandedOne("provider"); provider != nil {
+			payments[i].ProviderName = provider.GetString("name")
+		}
+	}
+	// the earliest due date is the most overdue, so sorting by it puts overdue payments first
+	slices.SortFunc(payments, func(a, b urgentPayment) int {
+		return cmp.Or(a.NextPayment.Compare(b.NextPayment), cmp.Compare(a.Id, b.Id))
+	})
+	return e.JSON(http.StatusOK, payments)
+}
@@ -0,0 +1,174 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpcomingApi(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Now().UTC()
+	soon := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 2), "amount": 7})
+	later := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 10), "amount": 9})
+	f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 30)})
+	trial := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 1), "trialEndsAt": now.AddDate(0, 1, 0)})
+
+	otherUser, _ := createUserWithToken(t, f.hub, "other@example.com")
+	_, err := beszelTests.CreateRecord(f.hub, "payments", map[string]any{
+		"user":        otherUser.Id,
+		"system":      createSystem(t, f.hub, otherUser, "other").Id,
+		"provider":    createProvider(t, f.hub, otherUser, "Other").Id,
+		"period":      "monthly",
+		"nextPayment": now.AddDate(0, 0, 1),
+		"amount":      1,
+		"currency":    "EUR",
+	})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/upcoming",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "default window is 7 days",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/upcoming",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"id":"` + soon.Id + `"`, `"providerName":"Hetzner"`, `"daysUntil":2`, `"amount":7`},
+			NotExpectedContent: []string{later.Id, trial.Id, "EUR"},
+			TestAppFactory:     testAppFactory,
+		},
+		{
+			Name:            "custom window sorted by date",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/upcoming?days=14",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"id":"` + soon.Id + `"`, `"daysUntil":2},{"id":"` + later.Id + `"`, `"daysUntil":10}]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "zero days",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/upcoming?days=0",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"days must be an integer"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "non numeric days",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/upcoming?days=abc",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"days must be an integer"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "days over maximum",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/upcoming?days=366",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"between 1 and 365"},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestByUrgencyApi(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Now().UTC()
+	farOff := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(1, 0, 0)})
+	soon := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 3)})
+	overdue := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, -2)})
+	mostOverdue := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, -10)})
+	inGrace := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, -3), "graceDays": 5})
+	paused := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, -20), "status": "paused"})
+	archived := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, -20), "archivedAt": now})
+	trial := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, -20), "trialEndsAt": now.AddDate(0, 1, 0)})
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/by-urgency",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "overdue first, then soonest due",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/by-urgency",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"daysUntil":-10`, `"daysUntil":-3`, `"daysUntil":-2`, `"daysUntil":3`, `"providerName":"Hetzner"`},
+			NotExpectedContent: []string{paused.Id, archived.Id, trial.Id},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				var payments []struct {
+					Id      string `json:"id"`
+					Overdue bool   `json:"overdue"`
+				}
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&payments))
+				ids := make([]string, len(payments))
+				overdueIds := []string{}
+				for i, payment := range payments {
+					ids[i] = payment.Id
+					if payment.Overdue {
+						overdueIds = append(overdueIds, payment.Id)
+					}
+				}
+				require.Equal(t, []string{mostOverdue.Id, inGrace.Id, overdue.Id, soon.Id, farOff.Id}, ids)
+				// the payment still within its grace days isn't overdue yet
+				require.Equal(t, []string{mostOverdue.Id, overdue.Id}, overdueIds)
+			},
+		},
+		{
+			Name:            "other user",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/by-urgency",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`[]`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
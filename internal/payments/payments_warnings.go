@@ -0,0 +1,41 @@
+package payments
+
+import (
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// paymentWarning is an advisory note about a saved payment, returned next to its fields
+type paymentWarning struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// paymentWarnings returns the warnings about a payment, like a currency other than its provider's default.
+// Unlike validation errors they don't prevent the payment from being saved.
+func paymentWarnings(app core.App, record *core.Record) []paymentWarning {
+	warnings := []paymentWarning{}
+	if providerID := record.GetString("provider"); providerID != "" {
+		provider, err := app.FindRecordById("providers", providerID)
+		if err == nil {
+			expected := provider.GetString("currencyDefault")
+			if currency := record.GetString("currency"); expected != "" && currency != expected {
+				warnings = append(warnings, paymentWarning{
+					Field:   "currency",
+					Code:    "currency_mismatch",
+					Message: fmt.Sprintf("%s usually bills in %s, not %s.", provider.GetString("name"), expected, currency),
+				})
+			}
+		}
+	}
+	return warnings
+}
+
+// addPaymentWarnings adds the payment's warnings to the response of a create or update request
+// under "warnings". They aren't stored with the payment.
+func addPaymentWarnings(app core.App, record *core.Record) {
+	record.WithCustomData(true)
+	record.Set("warnings", paymentWarnings(app, record))
+}
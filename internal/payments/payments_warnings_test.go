@@ -0,0 +1,110 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentWarnings(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	f.provider.Set("currencyDefault", "EUR")
+	require.NoError(t, f.hub.Save(f.provider))
+	noDefaultProvider := createProvider(t, f.hub, f.user, "No default")
+	payment := f.createPayment(t, map[string]any{"currency": "EUR"})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	paymentBody := func(fields map[string]any) map[string]any {
+		body := map[string]any{
+			"user":        f.user.Id,
+			"system":      f.system.Id,
+			"provider":    f.provider.Id,
+			"period":      "monthly",
+			"nextPayment": "2030-01-15 00:00:00.000Z",
+			"amount":      10,
+		}
+		for k, v := range fields {
+			body[k] = v
+		}
+		return body
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:           "currency other than the provider's is saved with a warning",
+			Method:         http.MethodPost,
+			URL:            "/api/collections/payments/records",
+			Headers:        map[string]string{"Authorization": f.token},
+			Body:           jsonReader(paymentBody(map[string]any{"currency": "USD"})),
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"currency":"USD"`,
+				`"warnings":[{"field":"currency","code":"currency_mismatch","message":"Hetzner usually bills in EUR, not USD."}]`,
+			},
+			TestAppFactory: testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				records, err := app.FindAllRecords("payments", dbx.HashExp{"currency": "USD"})
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Nil(t, records[0].Get("warnings"))
+			},
+		},
+		{
+			Name:            "provider's currency",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(nil)),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"currency":"EUR"`, `"warnings":[]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "provider without a default currency",
+			Method:          http.MethodPost,
+			URL:             "/api/collections/payments/records",
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(paymentBody(map[string]any{"provider": noDefaultProvider.Id, "currency": "RUB"})),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"warnings":[]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "update to another currency",
+			Method:          http.MethodPatch,
+			URL:             "/api/collections/payments/records/" + payment.Id,
+			Headers:         map[string]string{"Authorization": f.token},
+			Body:            jsonReader(map[string]any{"currency": "RUB"}),
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"currency":"RUB"`, `"code":"currency_mismatch"`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "viewing a payment has no warnings",
+			Method:             http.MethodGet,
+			URL:                "/api/collections/payments/records/" + payment.Id,
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"currency":"RUB"`},
+			NotExpectedContent: []string{"warnings"},
+			TestAppFactory:     testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -0,0 +1,294 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// header holding the hex HMAC-SHA256 of the request body, keyed with the webhook secret
+const webhookSignatureHeader = "X-Beszel-Signature"
+
+// number of times a failed delivery is retried before giving up
+const webhookRetries = 3
+
+var (
+	// only connects to public addresses, so users can't make the hub post to its own network
+	webhookClient = &http.Client{Timeout: 10 * time.Second, Transport: newPublicTransport()}
+	// delay before the first retry, doubled after each attempt
+	webhookBackoff = 2 * time.Second
+)
+
+// webhookPayload is the JSON body sent to webhooks for a payment that is due soon
+type webhookPayload struct {
+	Id           string  `json:"id"`
+	ProviderName string  `json:"providerName"`
+	Amount       float64 `json:"amount"`
+	Currency     string  `json:"currency"`
+	// amount formatted for display in its currency, e.g. "1 234,50 ₽"
+	Formatted   string         `json:"formatted"`
+	NextPayment types.DateTime `json:"nextPayment"`
+	// set on sample payloads sent with the test endpoint, which aren't about a real payment
+	Test bool `json:"test,omitempty"`
+}
+
+// NotifyDuePayments sends reminders for payments that reached their reminder lead time.
+// Runs every hour as a cron job, so reminders go out soon after midnight in each user's timezone.
+func (pm *PaymentManager) NotifyDuePayments() {
+	logger := requestid.RunLogger(pm.app, jobNotifyDuePayments)
+	sent, err := pm.notifyDuePayments(logger, time.Now().UTC())
+	pm.health.jobRan(jobNotifyDuePayments, time.Now().UTC(), err)
+	if err != nil {
+		logger.Error("Failed to notify due payments", "err", err)
+		return
+	}
+	if sent > 0 {
+		logger.Info("Sent payment reminders", "count", sent)
+	}
+}
+
+// notifyDuePayments reminds users of their payments that are due for one relative to now,
+// on the channels selected by each payment, and returns the number of payments reminded.
+// Payments reminded by email are batched into one email per user and run.
+// A reminder counts as sent once its notification is stored, even if no webhook or email could be sent.
+func (pm *PaymentManager) notifyDuePayments(logger *slog.Logger, now time.Time) (int, error) {
+	if err := clearExpiredSnoozes(pm.app, now); err != nil {
+		return 0, err
+	}
+	reminders, err := findDueReminders(pm.app, logger, now, "")
+	if err != nil {
+		return 0, err
+	}
+
+	userEmails := make(map[string][]dueReminder)
+	var sent int
+	for _, reminder := range reminders {
+		record := reminder.record
+		if slices.Contains(reminder.channels, ChannelInApp) {
+			if err := createDueNotification(pm.app, record, reminder.loc, reminder.locale); err != nil {
+				// without a notification the reminder is tried again on the next run
+				logger.Error("Failed to create payment notification", "payment", record.Id, "err", err)
+				continue
+			}
+		}
+		if len(reminder.webhooks) > 0 {
+			body, err := json.Marshal(newWebhookPayload(record, reminder.locale))
+			if err != nil {
+				return sent, err
+			}
+			for _, webhook := range reminder.webhooks {
+				webhookLogger := logger.With("webhook", webhook.Id, "payment", record.Id)
+				if err := deliverWebhook(pm.app, webhookLogger, webhook, record.Id, body); err != nil {
+					webhookLogger.Warn("Failed to deliver payment webhook", "err", err)
+					pm.health.webhookFailed(now)
+				}
+			}
+		}
+		if slices.Contains(reminder.channels, ChannelEmail) {
+			userID := record.GetString("user")
+			userEmails[userID] = append(userEmails[userID], reminder)
+		}
+		sent++
+		record.Set("lastReminderSentAt", now)
+		if err := pm.app.SaveNoValidate(record); err != nil {
+			logger.Error("Failed to save reminder time", "payment", record.Id, "err", err)
+		}
+	}
+	for userID, emailed := range userEmails {
+		records := make([]*core.Record, len(emailed))
+		for i, reminder := range emailed {
+			records[i] = reminder.record
+		}
+		if err := sendReminderEmail(pm.app, userID, records, emailed[0].loc, emailed[0].locale); err != nil {
+			logger.Warn("Failed to send reminder email", "user", userID, "err", err)
+		}
+	}
+	return sent, nil
+}
+
+// newWebhookPayload builds the webhook body for a payment with its provider expanded,
+// with the amount formatted for locale, the user's locale setting
+func newWebhookPayload(record *core.Record, locale string) webhookPayload {
+	payload := webhookPayload{
+		Id:          record.Id,
+		Amount:      effectiveAmount(record, record.GetDateTime("nextPayment").Time()),
+		Currency:    record.GetString("currency"),
+		NextPayment: record.GetDateTime("nextPayment"),
+	}
+	payload.Formatted = formatAmount(payload.Amount, payload.Currency, locale)
+	if provider := record.ExpandedOne("provider"); provider != nil {
+		payload.ProviderName = provider.GetString("name")
+	}
+	return payload
+}
+
+// signPayload returns the hex HMAC-SHA256 of body keyed with secret
+func signPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook posts body to the webhook, retrying failed attempts with exponential backoff.
+// Each attempt is recorded in webhook_deliveries, linked to the payment with paymentID if set.
+func deliverWebhook(app core.App, logger *slog.Logger, webhook *core.Record, paymentID string, body []byte) error {
+	backoff := webhookBackoff
+	var err error
+	for attempt := 1; attempt <= webhookRetries+1; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var result webhookAttempt
+		result, err = postWebhook(webhook.GetString("url"), webhook.GetString("secret"), body)
+		if saveErr := saveDelivery(app, webhook, paymentID, attempt, result, err); saveErr != nil {
+			logger.Warn("Failed to record webhook delivery", "attempt", attempt, "err", saveErr)
+		}
+		if err == nil {
+			return nil
+		}
+		logger.Debug("Webhook delivery attempt failed", "attempt", attempt, "err", err)
+	}
+	return err
+}
+
+// webhookAttempt is the response to a single delivery attempt
+type webhookAttempt struct {
+	// zero if no response was received
+	StatusCode int
+	// start of the response body, at most maxResponseSnippet bytes
+	Snippet string
+}
+
+// maximum number of bytes of a webhook response kept with its delivery
+const maxResponseSnippet = 1000
+
+// postWebhook makes a single delivery attempt
+func postWebhook(url, secret string, body []byte) (webhookAttempt, error) {
+	var result webhookAttempt
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, signPayload(secret, body))
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer res.Body.Close()
+
+	result.StatusCode = res.StatusCode
+	snippet, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseSnippet))
+	result.Snippet = strings.ToValidUTF8(string(snippet), "")
+	// http.Client doesn't treat non 2xx responses as error
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return result, fmt.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+	return result, nil
+}
+
+// saveDelivery records a delivery attempt of the webhook. Without a response the
+// error is kept as the snippet instead.
+func saveDelivery(app core.App, webhook *core.Record, paymentID string, attempt int, result webhookAttempt, err error) error {
+	collection, findErr := app.FindCachedCollectionByNameOrId("webhook_deliveries")
+	if findErr != nil {
+		return findErr
+	}
+	snippet := result.Snippet
+	if result.StatusCode == 0 && err != nil {
+		snippet = err.Error()
+	}
+	if len(snippet) > maxResponseSnippet {
+		snippet = strings.ToValidUTF8(snippet[:maxResponseSnippet], "")
+	}
+	delivery := core.NewRecord(collection)
+	delivery.Set("webhook", webhook.Id)
+	delivery.Set("payment", paymentID)
+	delivery.Set("statusCode", result.StatusCode)
+	delivery.Set("success", err == nil)
+	delivery.Set("attempt", attempt)
+	delivery.Set("responseSnippet", snippet)
+	delivery.Set("sentAt", time.Now().UTC())
+	return app.Save(delivery)
+}
+
+// GetWebhookDeliveries handles GET /api/beszel/webhooks/{id}/deliveries requests.
+// Returns the latest delivery attempts of one of the user's webhooks (limit, default 50, max 200), newest first.
+func (pm *PaymentManager) GetWebhookDeliveries(e *core.RequestEvent) error {
+	limit, err := parseIntParam(e, "limit", 50, 200)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	webhook, err := e.App.FindFirstRecordByFilter("webhooks", "id = {:id} && user = {:user}",
+		dbx.Params{"id": e.Request.PathValue("id"), "user": e.Auth.Id})
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	records, err := e.App.FindRecordsByFilter("webhook_deliveries", "webhook = {:webhook}", "-sentAt,-attempt", limit, 0,
+		dbx.Params{"webhook": webhook.Id})
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, records)
+}
+
+// TestWebhook handles POST /api/beszel/webhooks/{id}/test requests.
+// Sends a sample payload marked with test=true to one of the user's webhooks, signed like a
+// real reminder, and returns the status of the attempt. The response body isn't returned.
+// Disabled webhooks can be tested too. The attempt is recorded with the webhook's deliveries
+// but isn't retried.
+func (pm *PaymentManager) TestWebhook(e *core.RequestEvent) error {
+	webhook, err := e.App.FindFirstRecordByFilter("webhooks", "id = {:id} && user = {:user}",
+		dbx.Params{"id": e.Request.PathValue("id"), "user": e.Auth.Id})
+	if err != nil {
+		return e.NotFoundError("", err)
+	}
+	settings := loadPaymentSettings(e.App, e.Auth.Id)
+	currency := settings.DefaultCurrency
+	if currency == "" {
+		currency = "USD"
+	}
+	payload := webhookPayload{
+		Id:           "test",
+		ProviderName: "Example provider",
+		Amount:       9.99,
+		Currency:     currency,
+		Test:         true,
+	}
+	payload.Formatted = formatAmount(payload.Amount, payload.Currency, settings.Locale)
+	payload.NextPayment, _ = types.ParseDateTime(time.Now().UTC().AddDate(0, 0, 3))
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+
+	result, err := postWebhook(webhook.GetString("url"), webhook.GetString("secret"), body)
+	if saveErr := saveDelivery(e.App, webhook, "", 1, result, err); saveErr != nil {
+		requestid.Logger(e).Warn("Failed to record webhook delivery", "webhook", webhook.Id, "err", saveErr)
+	}
+	response := map[string]any{
+		"success":    err == nil,
+		"statusCode": result.StatusCode,
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	return e.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,469 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/henrygd/beszel/internal/payments"
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reminder channels of payments that are reminded by webhook as well as in-app
+var webhookChannels = []string{"inApp", "webhook"}
+
+func TestNotifyDuePayments(t *testing.T) {
+	// the test servers listen on loopback
+	t.Setenv("BESZEL_HUB_ALLOW_PRIVATE_DESTINATIONS", "true")
+	f := newPaymentFixture(t)
+	payments.SetWebhookBackoff(time.Millisecond)
+
+	now := time.Date(2030, 1, 10, 0, 5, 0, 0, time.UTC)
+	due := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 2), "amount": 9.5, "currency": "EUR", "reminderDays": 3, "reminderChannels": webhookChannels})
+	// outside of its own lead time
+	f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 10), "reminderDays": 3})
+	f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 1), "reminderDays": 3, "archivedAt": now})
+
+	type delivery struct {
+		signature string
+		body      []byte
+	}
+	deliveries := make(chan delivery, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{r.Header.Get("X-Beszel-Signature"), body}
+	}))
+	defer server.Close()
+
+	_, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{
+		"user":    f.user.Id,
+		"url":     server.URL,
+		"enabled": true,
+		"secret":  "s3cret",
+	})
+	require.NoError(t, err)
+	// disabled webhooks are not called
+	_, err = beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{
+		"user": f.user.Id,
+		"url":  server.URL + "/disabled",
+	})
+	require.NoError(t, err)
+
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	require.Len(t, deliveries, 1)
+
+	got := <-deliveries
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write(got.body)
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), got.signature)
+
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal(got.body, &payload))
+	assert.Equal(t, due.Id, payload["id"])
+	assert.Equal(t, "Hetzner", payload["providerName"])
+	assert.Equal(t, 9.5, payload["amount"])
+	assert.Equal(t, "EUR", payload["currency"])
+	assert.Equal(t, "9,50 €", payload["formatted"])
+	assert.Equal(t, "2030-01-12 00:05:00.000Z", payload["nextPayment"])
+
+	// the same cycle is only reminded once
+	sent, err = f.hub.GetPaymentManager().NotifyDuePaymentsAt(now.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+}
+
+func TestNotifyDuePaymentsLeadTimePerPayment(t *testing.T) {
+	// the test servers listen on loopback
+	t.Setenv("BESZEL_HUB_ALLOW_PRIVATE_DESTINATIONS", "true")
+	f := newPaymentFixture(t)
+	payments.SetWebhookBackoff(time.Millisecond)
+
+	now := time.Date(2030, 1, 10, 0, 5, 0, 0, time.UTC)
+	annual := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 30), "period": "annual", "reminderDays": 30, "reminderChannels": webhookChannels})
+	monthly := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 3), "reminderDays": 2, "reminderChannels": webhookChannels})
+	onTheDay := f.createPayment(t, map[string]any{"nextPayment": now.Add(time.Hour), "reminderDays": 0, "reminderChannels": webhookChannels})
+
+	ids := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		ids <- payload["id"].(string)
+	}))
+	defer server.Close()
+
+	_, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": server.URL, "enabled": true})
+	require.NoError(t, err)
+
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.ElementsMatch(t, []string{annual.Id, onTheDay.Id}, []string{<-ids, <-ids})
+
+	// the monthly payment is reminded once it is within two days
+	sent, err = f.hub.GetPaymentManager().NotifyDuePaymentsAt(now.AddDate(0, 0, 1))
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, monthly.Id, <-ids)
+
+	record, err := f.hub.FindRecordById("payments", monthly.Id)
+	require.NoError(t, err)
+	assert.Equal(t, now.AddDate(0, 0, 1), record.GetDateTime("lastReminderSentAt").Time())
+}
+
+func TestNotifyDuePaymentsRetries(t *testing.T) {
+	// the test servers listen on loopback
+	t.Setenv("BESZEL_HUB_ALLOW_PRIVATE_DESTINATIONS", "true")
+	f := newPaymentFixture(t)
+	payments.SetWebhookBackoff(time.Millisecond)
+
+	now := time.Date(2030, 1, 10, 0, 5, 0, 0, time.UTC)
+	payment := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 1), "reminderDays": 3, "reminderChannels": webhookChannels})
+
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("database is down"))
+	}))
+	defer server.Close()
+
+	webhook, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{
+		"user":    f.user.Id,
+		"url":     server.URL,
+		"enabled": true,
+	})
+	require.NoError(t, err)
+
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	// the first attempt plus three retries
+	assert.EqualValues(t, 4, attempts.Load())
+
+	// each attempt is recorded
+	deliveries, err := f.hub.FindRecordsByFilter("webhook_deliveries", "webhook = {:webhook}", "attempt", 0, 0, dbx.Params{"webhook": webhook.Id})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 4)
+	for i, delivery := range deliveries {
+		assert.Equal(t, i+1, delivery.GetInt("attempt"))
+		assert.Equal(t, payment.Id, delivery.GetString("payment"))
+		assert.Equal(t, 500, delivery.GetInt("statusCode"))
+		assert.False(t, delivery.GetBool("success"))
+		assert.Equal(t, "database is down", delivery.GetString("responseSnippet"))
+	}
+
+	// the user was still notified in-app, so the reminder isn't sent again
+	record, err := f.hub.FindRecordById("payments", payment.Id)
+	require.NoError(t, err)
+	assert.Equal(t, now, record.GetDateTime("lastReminderSentAt").Time())
+	notifications, err := f.hub.FindAllRecords("notifications", dbx.HashExp{"relatedPayment": payment.Id})
+	require.NoError(t, err)
+	assert.Len(t, notifications, 1)
+}
+
+func TestNotifyDuePaymentsInUserTimezone(t *testing.T) {
+	// the test servers listen on loopback
+	t.Setenv("BESZEL_HUB_ALLOW_PRIVATE_DESTINATIONS", "true")
+	f := newPaymentFixture(t)
+	payments.SetWebhookBackoff(time.Millisecond)
+
+	// settings of a new record are reset to defaults on create, so the timezone is set afterwards
+	settings, err := beszelTests.CreateRecord(f.hub, "user_settings", map[string]any{"user": f.user.Id})
+	require.NoError(t, err)
+	settings.Set("settings", map[string]any{"timezone": "Europe/Moscow"})
+	require.NoError(t, f.hub.Save(settings))
+
+	// 00:15 on Jan 11 in Moscow, UTC+3, but still Jan 10 in UTC
+	now := time.Date(2030, 1, 10, 21, 15, 0, 0, time.UTC)
+	today := f.createPayment(t, map[string]any{"nextPayment": time.Date(2030, 1, 11, 20, 0, 0, 0, time.UTC), "reminderDays": 0, "reminderChannels": webhookChannels})
+
+	ids := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		ids <- payload["id"].(string)
+	}))
+	defer server.Close()
+
+	_, err = beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": server.URL, "enabled": true})
+	require.NoError(t, err)
+
+	// an hour earlier it is still Jan 10 in Moscow too
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+
+	sent, err = f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Equal(t, today.Id, <-ids)
+}
+
+func TestNotifyDuePaymentsChannels(t *testing.T) {
+	// the test servers listen on loopback
+	t.Setenv("BESZEL_HUB_ALLOW_PRIVATE_DESTINATIONS", "true")
+	f := newPaymentFixture(t)
+	payments.SetWebhookBackoff(time.Millisecond)
+
+	now := time.Date(2030, 1, 10, 0, 5, 0, 0, time.UTC)
+	inApp := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 1), "reminderDays": 3})
+	webhook := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 1), "reminderDays": 3, "reminderChannels": []string{"webhook"}})
+
+	ids := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		ids <- payload["id"].(string)
+	}))
+	defer server.Close()
+
+	_, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": server.URL, "enabled": true})
+	require.NoError(t, err)
+
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	// without channels only the in-app inbox is used
+	require.Len(t, ids, 1)
+	assert.Equal(t, webhook.Id, <-ids)
+
+	notifications, err := f.hub.FindAllRecords("notifications", dbx.HashExp{"user": f.user.Id})
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, inApp.Id, notifications[0].GetString("relatedPayment"))
+
+	// both are marked as reminded
+	for _, payment := range []string{inApp.Id, webhook.Id} {
+		record, err := f.hub.FindRecordById("payments", payment)
+		require.NoError(t, err)
+		assert.Equal(t, now, record.GetDateTime("lastReminderSentAt").Time())
+	}
+}
+
+func TestNotifyDuePaymentsWebhookWithoutWebhooks(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Date(2030, 1, 10, 0, 5, 0, 0, time.UTC)
+	payment := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 1), "reminderDays": 3, "reminderChannels": []string{"webhook"}})
+	// a disabled webhook doesn't count
+	_, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": "https://example.com/hook"})
+	require.NoError(t, err)
+
+	sent, err := f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	// the reminder falls back to the in-app inbox instead of being lost
+	notifications, err := f.hub.FindAllRecords("notifications", dbx.HashExp{"user": f.user.Id})
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, payment.Id, notifications[0].GetString("relatedPayment"))
+	deliveries, err := f.hub.CountRecords("webhook_deliveries")
+	require.NoError(t, err)
+	assert.Zero(t, deliveries)
+}
+
+func TestWebhookDeliveriesApi(t *testing.T) {
+	// the test servers listen on loopback
+	t.Setenv("BESZEL_HUB_ALLOW_PRIVATE_DESTINATIONS", "true")
+	f := newPaymentFixture(t)
+	payments.SetWebhookBackoff(time.Millisecond)
+
+	now := time.Date(2030, 1, 10, 0, 5, 0, 0, time.UTC)
+	payment := f.createPayment(t, map[string]any{"nextPayment": now.AddDate(0, 0, 1), "reminderDays": 3, "reminderChannels": webhookChannels})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	webhook, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": server.URL, "enabled": true})
+	require.NoError(t, err)
+	_, err = f.hub.GetPaymentManager().NotifyDuePaymentsAt(now)
+	require.NoError(t, err)
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/webhooks/" + webhook.Id + "/deliveries",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user's webhook",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/webhooks/" + webhook.Id + "/deliveries",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "deliveries",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/webhooks/" + webhook.Id + "/deliveries",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"payment":"` + payment.Id + `"`,
+				`"statusCode":200`,
+				`"success":true`,
+				`"attempt":1`,
+				`"responseSnippet":"{\"ok\":true}"`,
+			},
+			TestAppFactory: testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestTestWebhook(t *testing.T) {
+	// the test servers listen on loopback
+	t.Setenv("BESZEL_HUB_ALLOW_PRIVATE_DESTINATIONS", "true")
+	f := newPaymentFixture(t)
+	setPaymentSettings(t, f, map[string]any{"defaultCurrency": "EUR"})
+
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan []byte, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+		if r.URL.Path == "/broken" {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("no_service"))
+		}
+	}))
+	defer server.Close()
+
+	// disabled webhooks can be tested before turning them on
+	webhook, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": server.URL, "secret": "s3cret"})
+	require.NoError(t, err)
+	broken, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": server.URL + "/broken", "enabled": true})
+	require.NoError(t, err)
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/webhooks/" + webhook.Id + "/test",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user's webhook",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/webhooks/" + webhook.Id + "/test",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  404,
+			ExpectedContent: []string{"wasn't found"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "sample payload is delivered",
+			Method:          http.MethodPost,
+			URL:             "/api/beszel/webhooks/" + webhook.Id + "/test",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"success":true`, `"statusCode":200`},
+			TestAppFactory:  testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				require.Len(t, bodies, 1)
+				r, body := <-requests, <-bodies
+				mac := hmac.New(sha256.New, []byte("s3cret"))
+				mac.Write(body)
+				assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), r.Header.Get("X-Beszel-Signature"))
+
+				var payload map[string]any
+				require.NoError(t, json.Unmarshal(body, &payload))
+				assert.Equal(t, true, payload["test"])
+				assert.Equal(t, "EUR", payload["currency"])
+				assert.Equal(t, "9,99 €", payload["formatted"])
+
+				count, err := app.CountRecords("webhook_deliveries", dbx.HashExp{"webhook": webhook.Id, "success": true})
+				require.NoError(t, err)
+				assert.EqualValues(t, 1, count)
+			},
+		},
+		{
+			Name:               "failed delivery is reported without retrying",
+			Method:             http.MethodPost,
+			URL:                "/api/beszel/webhooks/" + broken.Id + "/test",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"success":false`, `"statusCode":404`, `"error":"webhook responded with status 404"`},
+			NotExpectedContent: []string{"no_service", "responseSnippet"},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				assert.Len(t, bodies, 1)
+			},
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
+
+func TestWebhookPrivateDestination(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	requests := make(chan *http.Request, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer server.Close()
+	webhook, err := beszelTests.CreateRecord(f.hub, "webhooks", map[string]any{"user": f.user.Id, "url": server.URL, "enabled": true})
+	require.NoError(t, err)
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenario := beszelTests.ApiScenario{
+		Name:            "loopback webhook isn't posted to",
+		Method:          http.MethodPost,
+		URL:             "/api/beszel/webhooks/" + webhook.Id + "/test",
+		Headers:         map[string]string{"Authorization": f.token},
+		ExpectedStatus:  200,
+		ExpectedContent: []string{`"success":false`, `"statusCode":0`, "private, loopback or link-local address"},
+		TestAppFactory:  testAppFactory,
+		AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+			assert.Empty(t, requests)
+		},
+	}
+	scenario.Test(t)
+}
@@ -0,0 +1,100 @@
+package payments
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// weekDay holds the payments charged on one day of the week
+type weekDay struct {
+	// date in the user's timezone, as YYYY-MM-DD
+	Date     string        `json:"date"`
+	Weekday  string        `json:"weekday"`
+	Payments []weekPayment `json:"payments"`
+}
+
+// weekPayment is a charge of a payment on a day of the week
+type weekPayment struct {
+	Id           string         `json:"id"`
+	Provider     string         `json:"provider"`
+	ProviderName string         `json:"providerName"`
+	System       string         `json:"system"`
+	Amount       float64        `json:"amount"`
+	Currency     string         `json:"currency"`
+	Period       string         `json:"period"`
+	DueAt        types.DateTime `json:"dueAt"`
+	// set for charges still not advanced after their due date and the payment's grace days
+	Overdue bool `json:"overdue"`
+}
+
+// startOfWeek returns the start of the Monday of the week containing t, in the timezone of t
+func startOfWeek(t time.Time) time.Time {
+	return startOfDay(t).AddDate(0, 0, -(int(t.Weekday())+6)%7)
+}
+
+// GetWeek handles GET /api/beszel/payments/week requests.
+// Returns the seven days of the current week, Monday to Sunday in the user's timezone,
+// each with the charges of the user's payments due that day. Payments billed more than
+// once a week appear on each day they are charged. Charges due before today, after the
+// payment's grace days, are flagged as overdue. Archived payments are left out unless includeArchived=true is passed,
+// paused ones always are.
+func (pm *PaymentManager) GetWeek(e *core.RequestEvent) error {
+	now := time.Now().In(loadPaymentSettings(e.App, e.Auth.Id).location())
+	today := startOfDay(now)
+	start := startOfWeek(now)
+	end := start.AddDate(0, 0, 7)
+	records, err := findPaymentsDueBetween(e.App, requestid.Logger(e), e.Auth.Id, start, end.Add(-time.Millisecond))
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	if !includeArchived(e) {
+		records = withoutArchived(records)
+	}
+
+	days := make([]weekDay, 7)
+	for i := range days {
+		date := start.AddDate(0, 0, i)
+		days[i] = weekDay{
+			Date:     date.Format(time.DateOnly),
+			Weekday:  strings.ToLower(date.Weekday().String()),
+			Payments: []weekPayment{},
+		}
+	}
+	for _, record := range records {
+		if isPaused(record) {
+			continue
+		}
+		charges, err := projectCharges(record, start, end)
+		if err != nil {
+			return e.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		}
+		for _, charge := range charges {
+			dueAt, err := types.ParseDateTime(charge)
+			if err != nil {
+				return e.InternalServerError("", err)
+			}
+			item := weekPayment{
+				Id:       record.Id,
+				Provider: record.GetString("provider"),
+				System:   record.GetString("system"),
+				Amount:   roundAmount(effectiveAmount(record, charge), record.GetString("currency")),
+				Currency: record.GetString("currency"),
+				Period:   record.GetString("period"),
+				DueAt:    dueAt,
+				Overdue:  charge.AddDate(0, 0, record.GetInt("graceDays")).Before(today),
+			}
+			if provider := record.ExpandedOne("provider"); provider != nil {
+				item.ProviderName = provider.GetString("name")
+			}
+			day := &days[daysBetween(start, charge)]
+			day.Payments = append(day.Payments, item)
+		}
+	}
+	return e.JSON(http.StatusOK, days)
+}
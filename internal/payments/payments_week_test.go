@@ -0,0 +1,106 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeekApi(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	now := time.Now().UTC()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	todayIndex := (int(now.Weekday()) + 6) % 7
+	monday := today.AddDate(0, 0, -todayIndex)
+
+	first := f.createPayment(t, map[string]any{"nextPayment": monday.Add(12 * time.Hour), "amount": 7})
+	daily := f.createPayment(t, map[string]any{"nextPayment": today.Add(12 * time.Hour), "period": "daily", "amount": 1})
+	paused := f.createPayment(t, map[string]any{"nextPayment": today.Add(12 * time.Hour), "status": "paused"})
+	archived := f.createPayment(t, map[string]any{"nextPayment": today.Add(12 * time.Hour), "archivedAt": now})
+	nextWeek := f.createPayment(t, map[string]any{"nextPayment": monday.AddDate(0, 0, 7)})
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	type weekDay struct {
+		Date     string `json:"date"`
+		Weekday  string `json:"weekday"`
+		Payments []struct {
+			Id           string  `json:"id"`
+			ProviderName string  `json:"providerName"`
+			Amount       float64 `json:"amount"`
+			Overdue      bool    `json:"overdue"`
+		} `json:"payments"`
+	}
+	decodeWeek := func(t testing.TB, res *http.Response) []weekDay {
+		var days []weekDay
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&days))
+		require.Len(t, days, 7)
+		return days
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/week",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:               "charges grouped by day",
+			Method:             http.MethodGet,
+			URL:                "/api/beszel/payments/week",
+			Headers:            map[string]string{"Authorization": f.token},
+			ExpectedStatus:     200,
+			ExpectedContent:    []string{`"date":"` + monday.Format(time.DateOnly) + `","weekday":"monday"`, `"weekday":"sunday"`},
+			NotExpectedContent: []string{paused.Id, archived.Id, nextWeek.Id},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				days := decodeWeek(t, res)
+				require.NotEmpty(t, days[0].Payments)
+				assert.Equal(t, first.Id, days[0].Payments[0].Id)
+				assert.Equal(t, "Hetzner", days[0].Payments[0].ProviderName)
+				assert.Equal(t, 7.0, days[0].Payments[0].Amount)
+				// overdue unless today is Monday
+				assert.Equal(t, todayIndex > 0, days[0].Payments[0].Overdue)
+				for i, day := range days {
+					var charged bool
+					for _, payment := range day.Payments {
+						if payment.Id == daily.Id {
+							charged = true
+							assert.False(t, payment.Overdue)
+						}
+					}
+					assert.Equal(t, i >= todayIndex, charged, day.Weekday)
+				}
+			},
+		},
+		{
+			Name:            "includeArchived",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/week?includeArchived=true",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`"id":"` + archived.Id + `"`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
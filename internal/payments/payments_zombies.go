@@ -0,0 +1,99 @@
+package payments
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/henrygd/beszel/internal/hub/requestid"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// zombiePayment is a payment still charged for a system that stopped reporting
+type zombiePayment struct {
+	Id           string         `json:"id"`
+	Provider     string         `json:"provider"`
+	ProviderName string         `json:"providerName"`
+	System       string         `json:"system"`
+	SystemName   string         `json:"systemName"`
+	SystemStatus string         `json:"systemStatus"`
+	Amount       float64        `json:"amount"`
+	Currency     string         `json:"currency"`
+	Period       string         `json:"period"`
+	NextPayment  types.DateTime `json:"nextPayment"`
+	// when the system last reported, taken from the system record's updated time
+	LastActive   types.DateTime `json:"lastActive"`
+	InactiveDays int            `json:"inactiveDays"`
+	// set when the payment is also overdue, past its due date and grace days
+	Overdue bool `json:"overdue"`
+}
+
+// findZombiePayments returns the user's payments whose system hasn't been updated since before
+// now minus days, longest inactive first. Only payments that are still charged are checked,
+// so archived, paused, cancelled and free payments are left out.
+func findZombiePayments(app core.App, logger *slog.Logger, userID string, now time.Time, days int) ([]zombiePayment, error) {
+	records, err := app.FindAllRecords("payments", dbx.HashExp{"user": userID}, dbx.Not(dbx.HashExp{"system": ""}))
+	if err != nil {
+		return nil, err
+	}
+	records = slices.DeleteFunc(withoutArchived(records), func(record *core.Record) bool {
+		return isPaused(record) || isFree(record)
+	})
+	if errs := app.ExpandRecords(records, []string{"provider", "system"}, nil); len(errs) > 0 {
+		logger.Warn("Failed to expand payment providers and systems", "errs", errs)
+	}
+
+	cutoff := now.AddDate(0, 0, -days)
+	zombies := []zombiePayment{}
+	for _, record := range records {
+		system := record.ExpandedOne("system")
+		if system == nil {
+			continue
+		}
+		lastActive := system.GetDateTime("updated")
+		if !lastActive.Time().Before(cutoff) {
+			continue
+		}
+		item := zombiePayment{
+			Id:           record.Id,
+			Provider:     record.GetString("provider"),
+			System:       system.Id,
+			SystemName:   system.GetString("name"),
+			SystemStatus: system.GetString("status"),
+			Amount:       effectiveAmount(record, now),
+			Currency:     record.GetString("currency"),
+			Period:       record.GetString("period"),
+			NextPayment:  record.GetDateTime("nextPayment"),
+			LastActive:   lastActive,
+			InactiveDays: int(now.Sub(lastActive.Time()).Hours() / 24),
+			Overdue:      isOverdue(record, now),
+		}
+		if provider := record.ExpandedOne("provider"); provider != nil {
+			item.ProviderName = provider.GetString("name")
+		}
+		zombies = append(zombies, item)
+	}
+	slices.SortStableFunc(zombies, func(a, b zombiePayment) int {
+		return a.LastActive.Time().Compare(b.LastActive.Time())
+	})
+	return zombies, nil
+}
+
+// GetZombiePayments handles GET /api/beszel/payments/zombies requests.
+// Flags payments that are likely forgotten: still charged for a system that hasn't reported
+// for more than days (default 30, max 3650), which may have been decommissioned.
+func (pm *PaymentManager) GetZombiePayments(e *core.RequestEvent) error {
+	days, err := parseIntParam(e, "days", 30, 3650)
+	if err != nil {
+		return e.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	zombies, err := findZombiePayments(e.App, requestid.Logger(e), e.Auth.Id, time.Now().UTC(), days)
+	if err != nil {
+		return e.InternalServerError("", err)
+	}
+	return e.JSON(http.StatusOK, zombies)
+}
@@ -0,0 +1,99 @@
+//go:build testing
+// +build testing
+
+package payments_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	beszelTests "github.com/henrygd/beszel/internal/tests"
+
+	"github.com/pocketbase/dbx"
+	pbTests "github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestZombiePayments(t *testing.T) {
+	f := newPaymentFixture(t)
+
+	// server-1 last reported 60 days ago, the other systems just now
+	lastActive, _ := types.ParseDateTime(time.Now().UTC().AddDate(0, 0, -60))
+	_, err := f.hub.DB().Update("systems", dbx.Params{"updated": lastActive.String()}, dbx.HashExp{"id": f.system.Id}).Execute()
+	require.NoError(t, err)
+
+	zombie := f.createPayment(t, map[string]any{"system": f.system.Id, "amount": 10})
+	paused := f.createPayment(t, map[string]any{"system": f.system.Id, "status": "paused"})
+	free := f.createPayment(t, map[string]any{"system": f.system.Id, "isFree": true, "amount": 0})
+	archived := f.createPayment(t, map[string]any{"system": f.system.Id, "archivedAt": "2030-01-01 00:00:00.000Z"})
+	active := f.createPayment(t, nil)
+	_, otherToken := createUserWithToken(t, f.hub, "other@example.com")
+
+	testAppFactory := func(t testing.TB) *pbTests.TestApp {
+		return f.hub.TestApp
+	}
+
+	scenarios := []beszelTests.ApiScenario{
+		{
+			Name:            "no auth",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/zombies",
+			ExpectedStatus:  401,
+			ExpectedContent: []string{"requires valid"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "invalid days",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/zombies?days=0",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  400,
+			ExpectedContent: []string{"days must be an integer between 1 and 3650"},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:           "payments of inactive systems are flagged",
+			Method:         http.MethodGet,
+			URL:            "/api/beszel/payments/zombies",
+			Headers:        map[string]string{"Authorization": f.token},
+			ExpectedStatus: 200,
+			ExpectedContent: []string{
+				`"id":"` + zombie.Id + `"`,
+				`"systemName":"server-1"`,
+				`"lastActive":"` + lastActive.String() + `"`,
+				`"inactiveDays":60`,
+			},
+			NotExpectedContent: []string{active.Id, paused.Id, free.Id, archived.Id},
+			TestAppFactory:     testAppFactory,
+			AfterTestFunc: func(t testing.TB, app *pbTests.TestApp, res *http.Response) {
+				count, err := app.CountRecords("payments", dbx.HashExp{"system": f.system.Id})
+				require.NoError(t, err)
+				require.EqualValues(t, 4, count, "payments should only be flagged, not changed")
+			},
+		},
+		{
+			Name:            "longer threshold",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/zombies?days=90",
+			Headers:         map[string]string{"Authorization": f.token},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`[]`},
+			TestAppFactory:  testAppFactory,
+		},
+		{
+			Name:            "other user",
+			Method:          http.MethodGet,
+			URL:             "/api/beszel/payments/zombies",
+			Headers:         map[string]string{"Authorization": otherToken},
+			ExpectedStatus:  200,
+			ExpectedContent: []string{`[]`},
+			TestAppFactory:  testAppFactory,
+		},
+	}
+
+	for _, scenario := range scenarios {
+		scenario.Test(t)
+	}
+}
@@ -53,6 +53,10 @@ func NewTestHubWithConfig(config core.BaseAppConfig) (*TestHub, error) {
 		return nil, err
 	}
 
+	// don't start the cron ticker when api scenarios trigger the serve hooks, since scheduled
+	// jobs such as the payment reminders would otherwise run against the app after its cleanup
+	testApp.OnServe().Unbind("__pbCronStart__")
+
 	hub := hub.NewHub(testApp)
 
 	t := &TestHub{